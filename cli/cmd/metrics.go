@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/linkerd/linkerd2/controller/api/util"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
 	"github.com/linkerd/linkerd2/pkg/k8s"
 	"github.com/spf13/cobra"
 	corev1 "k8s.io/api/core/v1"
@@ -205,6 +206,29 @@ func getPodsFor(clientset kubernetes.Interface, namespace string, resource strin
 		return []corev1.Pod{*pod}, nil
 	}
 
+	matchLabels, err := getPodSelectorFor(clientset, namespace, res)
+	if err != nil {
+		return nil, err
+	}
+
+	podList, err := clientset.
+		CoreV1().
+		Pods(namespace).
+		List(
+			metav1.ListOptions{
+				LabelSelector: labels.Set(matchLabels).AsSelector().String(),
+			},
+		)
+	if err != nil {
+		return nil, err
+	}
+
+	return podList.Items, nil
+}
+
+// getPodSelectorFor returns the labels selecting the pods that belong to a
+// resource other than a pod.
+func getPodSelectorFor(clientset kubernetes.Interface, namespace string, res *pb.Resource) (map[string]string, error) {
 	var matchLabels map[string]string
 	switch res.GetType() {
 	case k8s.DaemonSet:
@@ -253,17 +277,5 @@ func getPodsFor(clientset kubernetes.Interface, namespace string, resource strin
 		return nil, fmt.Errorf("unsupported resource type: %s", res.GetType())
 	}
 
-	podList, err := clientset.
-		CoreV1().
-		Pods(namespace).
-		List(
-			metav1.ListOptions{
-				LabelSelector: labels.Set(matchLabels).AsSelector().String(),
-			},
-		)
-	if err != nil {
-		return nil, err
-	}
-
-	return podList.Items, nil
+	return matchLabels, nil
 }
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"github.com/linkerd/linkerd2/pkg/k8s"
+)
+
+func TestGetPodsFor(t *testing.T) {
+	k8sAPI, err := k8s.NewFakeAPI(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: books
+  namespace: default
+spec:
+  selector:
+    matchLabels:
+      app: books`, `
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: authors
+  namespace: default
+spec:
+  selector:
+    matchLabels:
+      app: authors`, `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: books-1
+  namespace: default
+  labels:
+    app: books`, `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: books-2
+  namespace: default
+  labels:
+    app: books`, `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: authors-0
+  namespace: default
+  labels:
+    app: authors`,
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		resource     string
+		expectedPods []string
+	}{
+		{"deploy/books", []string{"books-1", "books-2"}},
+		{"sts/authors", []string{"authors-0"}},
+		{"po/books-2", []string{"books-2"}},
+	}
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(tc.resource, func(t *testing.T) {
+			pods, err := getPodsFor(k8sAPI, "default", tc.resource)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var names []string
+			for _, pod := range pods {
+				names = append(names, pod.Name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tc.expectedPods) {
+				t.Fatalf("Expecting pods %v, got %v", tc.expectedPods, names)
+			}
+		})
+	}
+
+	t.Run("Returns the selector of a resource", func(t *testing.T) {
+		res := &pb.Resource{Namespace: "default", Type: k8s.StatefulSet, Name: "authors"}
+		matchLabels, err := getPodSelectorFor(k8sAPI, "default", res)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if expected := map[string]string{"app": "authors"}; !reflect.DeepEqual(matchLabels, expected) {
+			t.Fatalf("Expecting selector %v, got %v", expected, matchLabels)
+		}
+	})
+
+	errorCases := []string{"deploy", "deploy/missing", "ns/default"}
+	for _, resource := range errorCases {
+		resource := resource // pin
+		t.Run(fmt.Sprintf("Fails for %s", resource), func(t *testing.T) {
+			if _, err := getPodsFor(k8sAPI, "default", resource); err == nil {
+				t.Fatal("Expected error, got nothing")
+			}
+		})
+	}
+}
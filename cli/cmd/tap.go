@@ -2,36 +2,192 @@ package cmd
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/fatih/color"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/duration"
 	"github.com/linkerd/linkerd2/controller/api/util"
 	pb "github.com/linkerd/linkerd2/controller/gen/public"
 	"github.com/linkerd/linkerd2/pkg/addr"
 	"github.com/linkerd/linkerd2/pkg/k8s"
-	"github.com/linkerd/linkerd2/pkg/protohttp"
 	"github.com/linkerd/linkerd2/pkg/tap"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc/codes"
+	"k8s.io/client-go/util/jsonpath"
 )
 
-type renderTapEventFunc func(*pb.TapEvent, string) string
+const (
+	widePlusOutput  = "wide-plus"
+	protoJSONOutput = "protojson"
+)
+
+const (
+	httpVersion11      = "HTTP/1.1"
+	httpVersion2       = "HTTP/2"
+	httpVersionUnknown = "unknown"
+)
+
+type renderTapEventFunc func(*pb.TapEvent, string, *tapOptions) string
 
 type tapOptions struct {
-	namespace   string
-	toResource  string
-	toNamespace string
-	maxRps      float32
-	scheme      string
-	method      string
-	authority   string
-	path        string
-	output      string
+	namespace          string
+	toResource         string
+	toNamespace        string
+	maxRps             float32
+	scheme             string
+	method             string
+	authority          string
+	path               string
+	output             string
+	showRoute          bool
+	showVersion        bool
+	dedup              bool
+	srcResource        string
+	dstResource        string
+	printSchema        bool
+	color              bool
+	sizeHistogram      bool
+	tlsSummary         bool
+	grpcStatusSummary  bool
+	grpcOnly           bool
+	httpOnly           bool
+	onlyErrors         bool
+	onlyReset          bool
+	dstWeight          bool
+	printRequest       bool
+	startOn            string
+	stopOn             string
+	filter             string
+	jsonPath           string
+	minLatency         time.Duration
+	maxLatency         time.Duration
+	latencyBuckets     bool
+	latencyThresholds  string
+	traceIDFrom        string
+	dumpRaw            string
+	replay             string
+	contextPath        string
+	resolveGRPCMethod  bool
+	maxEventsPerStream int
+	resolvePorts       bool
+	strictResource     bool
+	showRequestBytes   bool
+	mergeStreams       bool
+	utc                bool
+	routeOnly          bool
+	rawPaths           bool
+	fieldsFromLabels   string
+	summaryInterval    time.Duration
+	longThreshold      time.Duration
+	idleTimeout        time.Duration
+	escapeJSONHTML     bool
+	showReporter       bool
+	flattenLabels      bool
+	firstByteLatency   bool
+	noFlow             bool
+	authorityCanonical string
+	address            string
+	schemeRegex        string
+	collapseRetries    bool
+	sort               string
+	maxEvents          int
+	highlight          string
+	maxPathLength      int
+	pairingBuffer      int
+	colors             string
+	onlyNewConnections bool
+	dedupDirections    bool
+	summaryJSON        bool
+	pathTemplate       string
+	showPathTemplate   bool
+	warnUnmeshed       bool
+	socket             string
+	shortAuthority     string
+	groupBySrcDst      bool
+	asciiOnly          bool
+	hasAuthority       bool
+	noAuthority        bool
+	collapseSuccess    bool
+	durationsAsMicros  bool
+	resolveTTL         time.Duration
+	countBytes         bool
+	topLevelStreamID   bool
+	watch              bool
+	outputDir          string
+	rpsByStatus        bool
+	includeUnknown     bool
+	timePrecision      string
+	pausable           bool
+
+	// Resources parsed from srcResource, dstResource and toResource by
+	// validate().
+	srcResourceMatch  *pb.Resource
+	dstResourceMatch  *pb.Resource
+	toResourceMatches []*pb.Resource
+
+	// Expression and template parsed from filter and jsonPath by validate().
+	filterExpr       filterExpr
+	jsonPathTemplate *jsonpath.JSONPath
+
+	// Upper bounds of the latency buckets, parsed from latencyThresholds by
+	// validate().
+	latencyBounds []time.Duration
+
+	// Rewrite of displayed paths, parsed from contextPath by validate().
+	contextPathRewrite *contextPath
+
+	// Templates of grouped paths, parsed from pathTemplate by validate().
+	pathTemplates *pathTemplates
+
+	// Labels displayed as columns, parsed from fieldsFromLabels by validate().
+	labelFields []labelField
+
+	// HTTP status `--stop-on` waits for, parsed from stopOn by validate(), or 0
+	// if it waits for a path.
+	stopOnStatus uint32
+
+	// Canonical forms of displayed authorities, parsed from authorityCanonical
+	// by validate().
+	authorityCanonicalizer *authorityCanonicalizer
+
+	// Pattern displayed schemes must match, parsed from schemeRegex by
+	// validate().
+	schemeRE *regexp.Regexp
+
+	// Tally of the transactions hidden by collapseSuccess, set up by
+	// validate().
+	successTally *successTally
+
+	// Pattern highlighted in displayed paths and authorities, parsed from
+	// highlight by validate().
+	highlightRE *regexp.Regexp
+
+	// Colors of the lines of each status class, parsed from colors by
+	// validate().
+	statusColors map[string]*color.Color
+
+	// Resolver of destination port names, set up when resolvePorts is set and
+	// a Kubernetes API is available.
+	portNames *portNameResolver
+
+	// Clock the current time is read from: time.Now, unless replaced by tests.
+	now func() time.Time
 }
 
 type endpoint struct {
@@ -64,29 +220,38 @@ type metadataBin struct {
 func (*metadataBin) isMetadata() {}
 
 type requestInitEvent struct {
-	ID        *streamID  `json:"id"`
-	Method    string     `json:"method"`
-	Scheme    string     `json:"scheme"`
-	Authority string     `json:"authority"`
-	Path      string     `json:"path"`
-	Headers   []metadata `json:"headers"`
+	ID           *streamID  `json:"id"`
+	Method       string     `json:"method"`
+	Scheme       string     `json:"scheme"`
+	Authority    string     `json:"authority"`
+	Path         string     `json:"path"`
+	Headers      []metadata `json:"headers"`
+	Version      string     `json:"version,omitempty"`
+	RawPath      string     `json:"rawPath,omitempty"`
+	GRPCService  string     `json:"grpcService,omitempty"`
+	GRPCMethod   string     `json:"grpcMethod,omitempty"`
+	RequestBytes *uint64    `json:"requestBytes,omitempty"`
 }
 
 type responseInitEvent struct {
-	ID               *streamID          `json:"id"`
-	SinceRequestInit *duration.Duration `json:"sinceRequestInit"`
-	HTTPStatus       uint32             `json:"httpStatus"`
-	Headers          []metadata         `json:"headers"`
+	ID                     *streamID          `json:"id"`
+	SinceRequestInit       *duration.Duration `json:"sinceRequestInit"`
+	SinceRequestInitMicros *int64             `json:"sinceRequestInitMicros,omitempty"`
+	HTTPStatus             uint32             `json:"httpStatus"`
+	Headers                []metadata         `json:"headers"`
+	TTFBMicros             *int64             `json:"ttfbMicros,omitempty"`
 }
 
 type responseEndEvent struct {
-	ID                *streamID          `json:"id"`
-	SinceRequestInit  *duration.Duration `json:"sinceRequestInit"`
-	SinceResponseInit *duration.Duration `json:"sinceResponseInit"`
-	ResponseBytes     uint64             `json:"responseBytes"`
-	Trailers          []metadata         `json:"trailers"`
-	GrpcStatusCode    uint32             `json:"grpcStatusCode"`
-	ResetErrorCode    uint32             `json:"resetErrorCode,omitempty"`
+	ID                      *streamID          `json:"id"`
+	SinceRequestInit        *duration.Duration `json:"sinceRequestInit"`
+	SinceRequestInitMicros  *int64             `json:"sinceRequestInitMicros,omitempty"`
+	SinceResponseInit       *duration.Duration `json:"sinceResponseInit"`
+	SinceResponseInitMicros *int64             `json:"sinceResponseInitMicros,omitempty"`
+	ResponseBytes           uint64             `json:"responseBytes"`
+	Trailers                []metadata         `json:"trailers"`
+	GrpcStatusCode          uint32             `json:"grpcStatusCode"`
+	ResetErrorCode          uint32             `json:"resetErrorCode,omitempty"`
 }
 
 // Private type used for displaying JSON encoded tap events
@@ -98,28 +263,592 @@ type tapEvent struct {
 	RequestInitEvent  *requestInitEvent  `json:"requestInitEvent,omitempty"`
 	ResponseInitEvent *responseInitEvent `json:"responseInitEvent,omitempty"`
 	ResponseEndEvent  *responseEndEvent  `json:"responseEndEvent,omitempty"`
+	TraceID           string             `json:"traceId,omitempty"`
+	Reporter          string             `json:"reporter,omitempty"`
+	// StreamBase and StreamNumber repeat the ID of whichever sub-event is
+	// set, and are only rendered with `--top-level-stream-id`.
+	StreamBase   *uint32 `json:"streamBase,omitempty"`
+	StreamNumber *uint64 `json:"streamNumber,omitempty"`
 }
 
 func newTapOptions() *tapOptions {
 	return &tapOptions{
-		namespace:   "default",
-		toResource:  "",
-		toNamespace: "",
-		maxRps:      100.0,
-		scheme:      "",
-		method:      "",
-		authority:   "",
-		path:        "",
-		output:      "",
+		namespace:          "default",
+		toResource:         "",
+		toNamespace:        "",
+		maxRps:             100.0,
+		scheme:             "",
+		method:             "",
+		authority:          "",
+		path:               "",
+		output:             "",
+		showRoute:          false,
+		showVersion:        false,
+		dedup:              false,
+		srcResource:        "",
+		dstResource:        "",
+		printSchema:        false,
+		color:              false,
+		sizeHistogram:      false,
+		tlsSummary:         false,
+		grpcStatusSummary:  false,
+		grpcOnly:           false,
+		httpOnly:           false,
+		onlyErrors:         false,
+		onlyReset:          false,
+		dstWeight:          false,
+		printRequest:       false,
+		startOn:            "",
+		stopOn:             "",
+		filter:             "",
+		jsonPath:           "",
+		minLatency:         0,
+		maxLatency:         0,
+		latencyBuckets:     false,
+		latencyThresholds:  "10ms,100ms,1s",
+		traceIDFrom:        "",
+		dumpRaw:            "",
+		replay:             "",
+		contextPath:        "",
+		resolveGRPCMethod:  false,
+		maxEventsPerStream: 0,
+		resolvePorts:       false,
+		strictResource:     false,
+		showRequestBytes:   false,
+		mergeStreams:       false,
+		utc:                false,
+		routeOnly:          false,
+		rawPaths:           false,
+		fieldsFromLabels:   "",
+		summaryInterval:    0,
+		longThreshold:      0,
+		idleTimeout:        0,
+		escapeJSONHTML:     true,
+		showReporter:       false,
+		flattenLabels:      false,
+		firstByteLatency:   false,
+		noFlow:             false,
+		authorityCanonical: "",
+		address:            "",
+		schemeRegex:        "",
+		collapseRetries:    false,
+		sort:               "",
+		maxEvents:          10000,
+		highlight:          "",
+		maxPathLength:      0,
+		pairingBuffer:      10000,
+		colors:             "",
+		onlyNewConnections: false,
+		dedupDirections:    false,
+		summaryJSON:        false,
+		pathTemplate:       "",
+		showPathTemplate:   false,
+		warnUnmeshed:       false,
+		socket:             "",
+		shortAuthority:     "",
+		groupBySrcDst:      false,
+		asciiOnly:          false,
+		hasAuthority:       false,
+		noAuthority:        false,
+		collapseSuccess:    false,
+		durationsAsMicros:  false,
+		resolveTTL:         defaultResolveTTL,
+		countBytes:         false,
+		topLevelStreamID:   false,
+		watch:              false,
+		outputDir:          "",
+		rpsByStatus:        false,
+		includeUnknown:     false,
+		timePrecision:      "",
+		pausable:           false,
+		now:                time.Now,
+	}
+}
+
+// applyDefaults sets the options implied by others that weren't set, before
+// they're validated: events are streamed to `--socket` as NDJSON, so its
+// output is "json" unless another one is given.
+func (o *tapOptions) applyDefaults() {
+	if o.socket != "" && o.output == "" {
+		o.output = jsonOutput
 	}
 }
 
 func (o *tapOptions) validate() error {
-	if o.output == "" || o.output == wideOutput || o.output == jsonOutput {
-		return nil
+	switch o.output {
+	case "", wideOutput, widePlusOutput, jsonOutput, jsonArrayOutput, protoJSONOutput, harOutput, sseOutput, logfmtOutput, tuiOutput:
+	default:
+		return fmt.Errorf("output format \"%s\" not recognized", o.output)
+	}
+	if o.socket != "" {
+		// Events are streamed to the socket as NDJSON, so the restrictions of
+		// "json" output apply; see applyDefaults.
+		if o.output != jsonOutput {
+			return fmt.Errorf("--socket is not supported with \"%s\" output", o.output)
+		}
+		switch {
+		case o.jsonPath != "":
+			return errors.New("--socket is not supported with --jsonpath")
+		case o.summaryInterval != 0 || o.longThreshold != 0:
+			return errors.New("--socket only streams events, it isn't supported with --summary-interval or --long-request-threshold")
+		case o.sizeHistogram || o.tlsSummary || o.grpcStatusSummary || o.summaryJSON || o.dstWeight || o.groupBySrcDst || o.warnUnmeshed || o.countBytes:
+			return errors.New("--socket only streams events, it isn't supported with summaries")
+		}
+	}
+
+	if o.dedup && (o.output == jsonOutput || o.output == jsonArrayOutput || o.output == protoJSONOutput || o.output == harOutput || o.output == sseOutput || o.output == tuiOutput) {
+		return fmt.Errorf("--dedup is not supported with \"%s\" output", o.output)
+	}
+
+	if o.output == harOutput || o.output == sseOutput || o.output == jsonArrayOutput || o.output == tuiOutput {
+		if o.summaryInterval != 0 {
+			return fmt.Errorf("--summary-interval is not supported with \"%s\" output", o.output)
+		}
+		if o.longThreshold != 0 {
+			return fmt.Errorf("--long-request-threshold is not supported with \"%s\" output", o.output)
+		}
+		if o.sizeHistogram {
+			return fmt.Errorf("--size-histogram is not supported with \"%s\" output", o.output)
+		}
+		if o.tlsSummary {
+			return fmt.Errorf("--tls-summary is not supported with \"%s\" output", o.output)
+		}
+		if o.grpcStatusSummary {
+			return fmt.Errorf("--grpc-status-summary is not supported with \"%s\" output", o.output)
+		}
+		if o.summaryJSON {
+			return fmt.Errorf("--summary-json is not supported with \"%s\" output", o.output)
+		}
+		if o.dstWeight {
+			return fmt.Errorf("--dst-weight is not supported with \"%s\" output", o.output)
+		}
+		if o.groupBySrcDst {
+			return fmt.Errorf("--group-by-src-dst is not supported with \"%s\" output", o.output)
+		}
+		if o.countBytes {
+			return fmt.Errorf("--count-bytes-both-directions is not supported with \"%s\" output", o.output)
+		}
+		if o.outputDir != "" {
+			return fmt.Errorf("--output-dir is not supported with \"%s\" output", o.output)
+		}
+	}
+
+	if o.grpcOnly && o.httpOnly {
+		return errors.New("--grpc-only and --http-only are mutually exclusive")
+	}
+	if o.hasAuthority && o.noAuthority {
+		return errors.New("--has-authority and --no-authority are mutually exclusive")
+	}
+
+	if o.minLatency < 0 || o.maxLatency < 0 {
+		return errors.New("--min-latency and --max-latency must not be negative")
+	}
+	if o.maxLatency > 0 && o.maxLatency < o.minLatency {
+		return fmt.Errorf("--max-latency (%s) must not be lower than --min-latency (%s)", o.maxLatency, o.minLatency)
+	}
+
+	if o.collapseRetries {
+		switch {
+		case o.output != "" && o.output != wideOutput && o.output != widePlusOutput:
+			return fmt.Errorf("--collapse-retries is not supported with \"%s\" output", o.output)
+		case o.jsonPath != "":
+			return errors.New("--collapse-retries is not supported with --jsonpath")
+		case o.dedup:
+			return errors.New("--collapse-retries is not supported with --dedup")
+		case o.mergeStreams:
+			return errors.New("--collapse-retries is not supported with --merge-streams")
+		}
+	}
+
+	if o.dedupDirections {
+		switch {
+		case o.output != "" && o.output != wideOutput && o.output != widePlusOutput:
+			return fmt.Errorf("--dedup-directions is not supported with \"%s\" output", o.output)
+		case o.jsonPath != "":
+			return errors.New("--dedup-directions is not supported with --jsonpath")
+		case o.dedup:
+			return errors.New("--dedup-directions is not supported with --dedup")
+		case o.collapseRetries:
+			return errors.New("--dedup-directions is not supported with --collapse-retries")
+		case o.sort != "":
+			return errors.New("--dedup-directions is not supported with --sort")
+		case o.mergeStreams:
+			return errors.New("--dedup-directions is not supported with --merge-streams")
+		}
+	}
+
+	if o.sort != "" {
+		switch {
+		case o.sort != sortLatency:
+			return fmt.Errorf("--sort must be \"%s\", got \"%s\"", sortLatency, o.sort)
+		case o.output == harOutput || o.output == sseOutput || o.output == jsonArrayOutput || o.output == tuiOutput:
+			return fmt.Errorf("--sort is not supported with \"%s\" output", o.output)
+		case o.dedup:
+			return errors.New("--sort is not supported with --dedup")
+		case o.collapseRetries:
+			return errors.New("--sort is not supported with --collapse-retries")
+		case o.mergeStreams:
+			return errors.New("--sort is not supported with --merge-streams")
+		}
+	}
+	if o.maxEvents <= 0 {
+		return errors.New("--max-events must be positive")
+	}
+
+	if o.mergeStreams {
+		switch {
+		case o.output != "" && o.output != wideOutput && o.output != widePlusOutput:
+			return fmt.Errorf("--merge-streams is not supported with \"%s\" output", o.output)
+		case o.jsonPath != "":
+			return errors.New("--merge-streams is not supported with --jsonpath")
+		case o.dedup:
+			return errors.New("--merge-streams is not supported with --dedup")
+		}
+	}
+
+	if o.fieldsFromLabels != "" && o.output != wideOutput && o.output != widePlusOutput {
+		return fmt.Errorf("--fields-from-labels is only supported with \"%s\" and \"%s\" output", wideOutput, widePlusOutput)
+	}
+
+	if o.flattenLabels && ((o.output != jsonOutput && o.output != jsonArrayOutput) || o.jsonPath != "") {
+		return fmt.Errorf("--flatten-labels is only supported with \"%s\" and \"%s\" output", jsonOutput, jsonArrayOutput)
+	}
+
+	if o.durationsAsMicros && o.output != jsonOutput && o.output != jsonArrayOutput && o.jsonPath == "" {
+		return fmt.Errorf("--durations-as-micros is only supported with \"%s\" and \"%s\" output, and with --jsonpath", jsonOutput, jsonArrayOutput)
+	}
+	if o.firstByteLatency && o.output != jsonOutput && o.output != jsonArrayOutput && o.jsonPath == "" {
+		return fmt.Errorf("--first-byte-latency is only supported with \"%s\" and \"%s\" output, and with --jsonpath; the latency of response lines is their time to first byte", jsonOutput, jsonArrayOutput)
+	}
+	if o.topLevelStreamID && o.output != jsonOutput && o.output != jsonArrayOutput && o.jsonPath == "" {
+		return fmt.Errorf("--top-level-stream-id is only supported with \"%s\" and \"%s\" output, and with --jsonpath", jsonOutput, jsonArrayOutput)
+	}
+
+	if o.pausable && o.output == tuiOutput {
+		return fmt.Errorf("--pausable is not supported with \"%s\" output", o.output)
+	}
+
+	if _, ok := timePrecisionLayouts[o.timePrecision]; !ok && o.timePrecision != "" {
+		return fmt.Errorf("--time-precision must be one of s, ms, us or ns, got %q", o.timePrecision)
+	}
+
+	if o.summaryInterval < 0 {
+		return errors.New("--summary-interval must not be negative")
+	}
+	if o.rpsByStatus && o.summaryInterval == 0 {
+		return errors.New("--rps-by-status requires --summary-interval")
+	}
+
+	if o.longThreshold < 0 {
+		return errors.New("--long-request-threshold must not be negative")
+	}
+
+	if o.idleTimeout < 0 {
+		return errors.New("--idle-timeout must not be negative")
+	}
+
+	if o.maxEventsPerStream < 0 {
+		return errors.New("--max-events-per-stream must not be negative")
+	}
+
+	if o.warnUnmeshed {
+		switch {
+		case o.output != "" && o.output != wideOutput && o.output != widePlusOutput:
+			return fmt.Errorf("--warn-unmeshed is not supported with \"%s\" output", o.output)
+		case o.jsonPath != "":
+			return errors.New("--warn-unmeshed is not supported with --jsonpath")
+		}
 	}
 
-	return fmt.Errorf("output format \"%s\" not recognized", o.output)
+	if o.onlyNewConnections && (o.output == harOutput || o.output == sseOutput || o.output == jsonArrayOutput || o.output == tuiOutput) {
+		return fmt.Errorf("--only-new-connections is not supported with \"%s\" output", o.output)
+	}
+
+	if o.startOn != "" && !strings.HasPrefix(o.startOn, "/") {
+		return errors.New("--start-on must start with /")
+	}
+	o.stopOnStatus = 0
+	if o.stopOn != "" && !strings.HasPrefix(o.stopOn, "/") {
+		status, err := strconv.ParseUint(o.stopOn, 10, 32)
+		if err != nil || status < 100 || status > 599 {
+			return fmt.Errorf("--stop-on must be a path prefix starting with / or an HTTP status, got \"%s\"", o.stopOn)
+		}
+		o.stopOnStatus = uint32(status)
+	}
+
+	if o.maxPathLength < 0 {
+		return errors.New("--max-path-length must not be negative")
+	}
+	if o.asciiOnly {
+		switch {
+		case o.rawPaths:
+			return errors.New("--ascii-only is not supported with --raw-paths")
+		case o.output == tuiOutput:
+			return fmt.Errorf("--ascii-only is not supported with \"%s\" output", o.output)
+		}
+	}
+
+	if o.pairingBuffer < 0 {
+		return errors.New("--pairing-buffer must not be negative")
+	}
+
+	if o.dumpRaw != "" && o.replay != "" {
+		return errors.New("--dump-raw is not supported with --replay")
+	}
+	if o.resolvePorts && o.replay != "" {
+		return errors.New("--resolve-ports is not supported with --replay")
+	}
+	if o.watch && o.replay != "" {
+		return errors.New("--watch is not supported with --replay")
+	}
+	if o.resolveTTL < 0 {
+		return errors.New("--resolve-ttl must not be negative")
+	}
+	if o.address != "" {
+		switch {
+		case o.replay != "":
+			return errors.New("--address is not supported with --replay")
+		case o.resolvePorts:
+			return errors.New("--resolve-ports requires the Kubernetes API, which isn't used with --address")
+		case o.watch:
+			return errors.New("--watch requires the Kubernetes API, which isn't used with --address")
+		case kubeconfigPath != "" || kubeContext != "" || impersonate != "":
+			return errors.New("--kubeconfig, --context and --as don't apply to the tap API at --address")
+		}
+	}
+
+	var err error
+	if o.srcResourceMatch, err = parseResourceMatch(o.srcResource); err != nil {
+		return fmt.Errorf("--src-resource is invalid: %s", err)
+	}
+	if o.dstResourceMatch, err = parseResourceMatch(o.dstResource); err != nil {
+		return fmt.Errorf("--dst-resource is invalid: %s", err)
+	}
+	if o.latencyBounds, err = parseLatencyThresholds(o.latencyThresholds); err != nil {
+		return fmt.Errorf("--latency-thresholds is invalid: %s", err)
+	}
+	if o.contextPathRewrite, err = parseContextPath(o.contextPath); err != nil {
+		return fmt.Errorf("--context-path is invalid: %s", err)
+	}
+	if o.pathTemplates, err = parsePathTemplates(o.pathTemplate); err != nil {
+		return fmt.Errorf("--path-template is invalid: %s", err)
+	}
+	if o.showPathTemplate && o.pathTemplates == nil {
+		return errors.New("--show-path-template requires --path-template")
+	}
+	if o.labelFields, err = parseLabelFields(o.fieldsFromLabels); err != nil {
+		return fmt.Errorf("--fields-from-labels is invalid: %s", err)
+	}
+	if o.authorityCanonicalizer, err = parseAuthorityCanonical(o.authorityCanonical); err != nil {
+		return fmt.Errorf("--authority-canonical is invalid: %s", err)
+	}
+	switch o.shortAuthority {
+	case "", shortAuthoritySvc, shortAuthorityNamespace:
+	default:
+		return fmt.Errorf("--short-authority must be \"%s\" or \"%s\", got \"%s\"", shortAuthoritySvc, shortAuthorityNamespace, o.shortAuthority)
+	}
+	o.successTally = nil
+	if o.collapseSuccess {
+		switch {
+		case o.output != "" && o.output != wideOutput && o.output != widePlusOutput:
+			return fmt.Errorf("--collapse-success is not supported with \"%s\" output", o.output)
+		case o.jsonPath != "":
+			return errors.New("--collapse-success is not supported with --jsonpath")
+		case o.onlyErrors:
+			return errors.New("--collapse-success and --only-errors are mutually exclusive")
+		}
+		o.successTally = &successTally{}
+	}
+	o.schemeRE = nil
+	if o.schemeRegex != "" {
+		if o.schemeRE, err = regexp.Compile(o.schemeRegex); err != nil {
+			return fmt.Errorf("--scheme-regex is invalid: %s", err)
+		}
+	}
+	o.highlightRE = nil
+	if o.highlight != "" {
+		if o.output != "" && o.output != wideOutput && o.output != widePlusOutput {
+			return fmt.Errorf("--highlight is not supported with \"%s\" output", o.output)
+		}
+		o.highlightRE = parseHighlight(o.highlight)
+	}
+	if o.colors != "" {
+		switch {
+		case o.output != "" && o.output != wideOutput && o.output != widePlusOutput:
+			return fmt.Errorf("--colors is not supported with \"%s\" output", o.output)
+		case o.jsonPath != "":
+			return errors.New("--colors is not supported with --jsonpath")
+		}
+	}
+	if o.statusColors, err = parseStatusColors(o.colors); err != nil {
+		return fmt.Errorf("--colors is invalid: %s", err)
+	}
+
+	o.filterExpr = nil
+	if o.filter != "" {
+		if o.filterExpr, err = parseFilter(o.filter); err != nil {
+			return fmt.Errorf("--filter is invalid: %s", err)
+		}
+	}
+
+	o.jsonPathTemplate = nil
+	if o.jsonPath != "" {
+		if o.output == harOutput || o.output == sseOutput || o.output == jsonArrayOutput || o.output == tuiOutput {
+			return fmt.Errorf("--jsonpath is not supported with \"%s\" output", o.output)
+		}
+		if o.jsonPathTemplate, err = parseTapJSONPath(o.jsonPath); err != nil {
+			return fmt.Errorf("--jsonpath is invalid: %s", err)
+		}
+	}
+
+	o.toResourceMatches = nil
+	if toResources := strings.Split(o.toResource, ","); len(toResources) > 1 {
+		namespace := o.toNamespace
+		if namespace == "" {
+			namespace = o.namespace
+		}
+		for _, toResource := range toResources {
+			res, err := util.BuildResource(namespace, toResource)
+			if err != nil {
+				return fmt.Errorf("--to resource invalid: %s", err)
+			}
+			if !isValidTapDestination(res.GetType()) {
+				return fmt.Errorf("unsupported --to resource type [%s]", res.GetType())
+			}
+			o.toResourceMatches = append(o.toResourceMatches, &res)
+		}
+	}
+
+	return nil
+}
+
+func isValidTapDestination(resourceType string) bool {
+	for _, t := range util.ValidTapDestinations {
+		if t == resourceType {
+			return true
+		}
+	}
+	return false
+}
+
+// extractHeaders returns true if request and response headers need to be
+// extracted by the tap API to render events.
+func (o *tapOptions) extractHeaders() bool {
+	switch o.output {
+	case jsonOutput, jsonArrayOutput, protoJSONOutput, harOutput, sseOutput:
+		return true
+	}
+	if o.jsonPath != "" {
+		return true
+	}
+	// Request headers are needed to infer the HTTP version and the size of
+	// requests, and help telling gRPC requests apart before their response
+	// ends.
+	return o.showVersion || o.showRequestBytes || o.grpcOnly || o.httpOnly
+}
+
+// timestamp returns t in the time zone timestamps are rendered in: UTC with
+// `--utc`, local time otherwise.
+func (o *tapOptions) timestamp(t time.Time) time.Time {
+	if o.utc {
+		return t.UTC()
+	}
+	return t.Local()
+}
+
+// timePrecisionLayouts holds the RFC 3339 layout of each `--time-precision`,
+// which always renders that many fractional digits.
+var timePrecisionLayouts = map[string]string{
+	"s":  time.RFC3339,
+	"ms": "2006-01-02T15:04:05.000Z07:00",
+	"us": "2006-01-02T15:04:05.000000Z07:00",
+	"ns": "2006-01-02T15:04:05.000000000Z07:00",
+}
+
+// timestampLayout returns the layout timestamps are rendered with: that of
+// `--time-precision`, or RFC 3339 with as many fractional digits as needed
+// without it.
+func (o *tapOptions) timestampLayout() string {
+	if layout, ok := timePrecisionLayouts[o.timePrecision]; ok {
+		return layout
+	}
+	return time.RFC3339Nano
+}
+
+// requestedToResource returns the `--to` resource sent to the tap API. The
+// API only accepts a single destination, so when several are given they are
+// matched client-side instead.
+func (o *tapOptions) requestedToResource() string {
+	if len(o.toResourceMatches) > 0 {
+		return ""
+	}
+	return o.toResource
+}
+
+// printTapRequest writes the request sent to the tap API to w, in the canonical
+// JSON encoding of protobuf messages, so that the server-side filters it holds
+// can be checked.
+func printTapRequest(w io.Writer, req *pb.TapByResourceRequest) error {
+	m := jsonpb.Marshaler{Indent: "  "}
+	s, err := m.MarshalToString(req)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, s)
+	return err
+}
+
+// parseResourceMatch parses a TYPE[/NAME] resource used for client-side
+// filtering. An empty string yields no resource.
+func parseResourceMatch(resource string) (*pb.Resource, error) {
+	if resource == "" {
+		return nil, nil
+	}
+	res, err := util.BuildResource("", resource)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// matches returns true if the event should be displayed, according to the
+// client-side filters. These filters apply to every event of a stream in the
+// same way, so either all of a request's events are displayed or none are.
+func (o *tapOptions) matches(event *pb.TapEvent) bool {
+	if !o.includeUnknown && eventStreamID(event) == nil {
+		// Events this version of the CLI doesn't know about, e.g. those of
+		// newer proxies, are only displayed with `--include-unknown`.
+		return false
+	}
+	if o.routeOnly && event.GetRouteMeta().GetLabels()["route"] == "" {
+		return false
+	}
+	if o.srcResourceMatch != nil {
+		src := src(event)
+		if !src.matchesResource(o.srcResourceMatch) {
+			return false
+		}
+	}
+	if o.dstResourceMatch != nil {
+		dst := dst(event)
+		if !dst.matchesResource(o.dstResourceMatch) {
+			return false
+		}
+	}
+	if len(o.toResourceMatches) > 0 {
+		dst := dst(event)
+		matched := false
+		for _, res := range o.toResourceMatches {
+			if dst.matchesResource(res) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
 }
 
 func newCmdTap() *cobra.Command {
@@ -151,7 +880,10 @@ func newCmdTap() *cobra.Command {
   * pods
   * replicationcontrollers
   * statefulsets
-  * services (only supported as a --to resource)`,
+  * services (only supported as a --to resource)
+
+  Each event is rendered with the direction of the proxy that observed it:
+  "in" or "out", or "unk" when the proxy doesn't report it.`,
 		Example: `  # tap the web deployment in the default namespace
   linkerd tap deploy/web
 
@@ -160,25 +892,54 @@ func newCmdTap() *cobra.Command {
 
   # tap the test namespace, filter by request to prod namespace
   linkerd tap ns/test --to ns/prod`,
-		Args:      cobra.RangeArgs(1, 2),
+		Args: func(cmd *cobra.Command, args []string) error {
+			if options.printSchema {
+				return nil
+			}
+			if options.replay != "" {
+				return cobra.MaximumNArgs(2)(cmd, args)
+			}
+			return cobra.RangeArgs(1, 2)(cmd, args)
+		},
 		ValidArgs: util.ValidTargets,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if options.printSchema {
+				return printTapEventSchema(os.Stdout)
+			}
+			// Keep the output plain when it isn't going to a terminal.
+			options.color = (options.color || options.highlight != "" || options.colors != "") && !color.NoColor
+
+			options.applyDefaults()
+			err := options.validate()
+			if err != nil {
+				return fmt.Errorf("validation error when executing tap command: %v", err)
+			}
+
 			requestParams := util.TapRequestParams{
 				Resource:    strings.Join(args, "/"),
 				Namespace:   options.namespace,
-				ToResource:  options.toResource,
+				ToResource:  options.requestedToResource(),
 				ToNamespace: options.toNamespace,
 				MaxRps:      options.maxRps,
 				Scheme:      options.scheme,
 				Method:      options.method,
 				Authority:   options.authority,
 				Path:        options.path,
-				Extract:     options.output == jsonOutput,
+				Extract:     options.extractHeaders(),
 			}
 
-			err := options.validate()
-			if err != nil {
-				return fmt.Errorf("validation error when executing tap command: %v", err)
+			var out io.Writer = os.Stdout
+			if options.socket != "" {
+				socket, err := dialTapSocket(options.socket)
+				if err != nil {
+					return err
+				}
+				defer socket.Close()
+				out = socket
+			}
+
+			if options.replay != "" && len(args) == 0 {
+				return replayTapEvents(out, options.replay, &pb.TapByResourceRequest{}, options)
 			}
 
 			req, err := util.BuildTapByResourceRequest(requestParams)
@@ -186,25 +947,61 @@ func newCmdTap() *cobra.Command {
 				return err
 			}
 
+			if options.replay != "" {
+				return replayTapEvents(out, options.replay, req, options)
+			}
+			if options.printRequest {
+				if err := printTapRequest(os.Stderr, req); err != nil {
+					return err
+				}
+			}
+
+			// Stop tapping on SIGINT or SIGTERM rather than exiting right away,
+			// so that the output, including any summary, is completed.
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			sigCh := make(chan os.Signal, 1)
+			signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+			defer signal.Stop(sigCh)
+			go func() {
+				select {
+				case <-sigCh:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+
+			if options.address != "" {
+				return requestTapByResourceFromAddress(ctx, out, options.address, req, options)
+			}
+
 			k8sAPI, err := k8s.NewAPI(kubeconfigPath, kubeContext, impersonate, 0)
 			if err != nil {
 				return err
 			}
+			if options.resolvePorts {
+				options.portNames, err = newPortNameResolver(ctx, k8sAPI, options.resolveTTL, options.now)
+				if err != nil {
+					return err
+				}
+			}
 
-			return requestTapByResourceFromAPI(os.Stdout, k8sAPI, req, options)
+			return requestTapByResourceFromAPI(ctx, out, k8sAPI, req, options)
 		},
 	}
 
 	cmd.PersistentFlags().StringVarP(&options.namespace, "namespace", "n", options.namespace,
 		"Namespace of the specified resource")
 	cmd.PersistentFlags().StringVar(&options.toResource, "to", options.toResource,
-		"Display requests to this resource")
+		"Display requests to this resource; a comma-separated list displays requests to any of them")
 	cmd.PersistentFlags().StringVar(&options.toNamespace, "to-namespace", options.toNamespace,
 		"Sets the namespace used to lookup the \"--to\" resource; by default the current \"--namespace\" is used")
 	cmd.PersistentFlags().Float32Var(&options.maxRps, "max-rps", options.maxRps,
 		"Maximum requests per second to tap.")
 	cmd.PersistentFlags().StringVar(&options.scheme, "scheme", options.scheme,
 		"Display requests with this scheme")
+	cmd.PersistentFlags().StringVar(&options.schemeRegex, "scheme-regex", options.schemeRegex,
+		"Display requests whose lowercase scheme matches this regular expression, e.g. '^https?$'; unlike --scheme, it is applied by the CLI")
 	cmd.PersistentFlags().StringVar(&options.method, "method", options.method,
 		"Display requests with this HTTP method")
 	cmd.PersistentFlags().StringVar(&options.authority, "authority", options.authority,
@@ -212,31 +1009,255 @@ func newCmdTap() *cobra.Command {
 	cmd.PersistentFlags().StringVar(&options.path, "path", options.path,
 		"Display requests with paths that start with this prefix")
 	cmd.PersistentFlags().StringVarP(&options.output, "output", "o", options.output,
-		fmt.Sprintf("Output format. One of: \"%s\", \"%s\"", wideOutput, jsonOutput))
+		fmt.Sprintf("Output format. One of: \"%s\", \"%s\", \"%s\", \"%s\", \"%s\", \"%s\", \"%s\", \"%s\", \"%s\"", wideOutput, widePlusOutput, jsonOutput, jsonArrayOutput, protoJSONOutput, harOutput, sseOutput, logfmtOutput, tuiOutput))
+	cmd.PersistentFlags().BoolVar(&options.showRoute, "show-route", options.showRoute,
+		"Display the labels of the matched ServiceProfile route, even when not using wide output")
+	cmd.PersistentFlags().BoolVar(&options.showVersion, "show-version", options.showVersion,
+		"Display the HTTP version of requests, when it can be inferred")
+	cmd.PersistentFlags().BoolVar(&options.dedup, "dedup", options.dedup,
+		"Collapse identical consecutive events into a single line with an (xN) count")
+	cmd.PersistentFlags().BoolVar(&options.collapseRetries, "collapse-retries", options.collapseRetries,
+		fmt.Sprintf("Only display the last attempt of requests retried within %s of a failed response (5xx status, non-OK gRPC status or reset), with the same method, path, authority and source; it is annotated with retry=N. Attempts whose response starts with a status below 500 are displayed right away", retryWindow))
+	cmd.PersistentFlags().BoolVar(&options.dedupDirections, "dedup-directions", options.dedupDirections,
+		fmt.Sprintf("Display requests observed by both the client's outbound proxy and the server's inbound proxy, with the same method, authority, path and peers, less than %s apart, once; they are annotated with directions=X,Y, the first one observed first", directionsWindow))
+	cmd.PersistentFlags().StringVar(&options.sort, "sort", options.sort,
+		fmt.Sprintf("Hold back events until the stream ends, then display them sorted; \"%s\" displays the slowest requests first", sortLatency))
+	cmd.PersistentFlags().IntVar(&options.maxEvents, "max-events", options.maxEvents,
+		"With --sort, the maximum number of events held back; later events are dropped")
+	cmd.PersistentFlags().StringVar(&options.highlight, "highlight", options.highlight,
+		"Highlight the parts of displayed paths and authorities matching this regular expression, or substring if it isn't one, when writing to a terminal; turns on --color")
+	cmd.PersistentFlags().StringVar(&options.colors, "colors", options.colors,
+		fmt.Sprintf("Color the response and end lines of these status classes when writing to a terminal, e.g. '2xx=green,5xx=red,grpc-err=magenta'. Classes are: %s; colors are: %s. Turns on --color", strings.Join(statusColorClasses, ", "), strings.Join(colorNames(), ", ")))
+	cmd.PersistentFlags().StringVar(&options.srcResource, "src-resource", options.srcResource,
+		"Only display requests from this resource (TYPE[/NAME]); filtered client-side")
+	cmd.PersistentFlags().StringVar(&options.dstResource, "dst-resource", options.dstResource,
+		"Only display requests to this resource (TYPE[/NAME]); filtered client-side")
+	cmd.PersistentFlags().BoolVar(&options.printRequest, "print-request", options.printRequest,
+		"Print the request sent to the tap API, as JSON, to stderr before tapping")
+	cmd.PersistentFlags().BoolVar(&options.printSchema, "print-schema", options.printSchema,
+		fmt.Sprintf("Print the JSON Schema of events rendered with \"-o %s\" and exit", jsonOutput))
+	cmd.PersistentFlags().MarkHidden("print-schema")
+	cmd.PersistentFlags().BoolVar(&options.color, "color", options.color,
+		fmt.Sprintf("Syntax highlight \"%s\" output, and --highlight matches, when writing to a terminal", jsonOutput))
+	cmd.PersistentFlags().BoolVar(&options.sizeHistogram, "size-histogram", options.sizeHistogram,
+		"Print a histogram of response sizes once the stream ends")
+	cmd.PersistentFlags().BoolVar(&options.tlsSummary, "tls-summary", options.tlsSummary,
+		"Print the percentage of inbound and outbound events on mTLS connections once the stream ends")
+	cmd.PersistentFlags().BoolVar(&options.warnUnmeshed, "warn-unmeshed", options.warnUnmeshed,
+		"Mark the events of connections that aren't mTLS'd with UNMESHED, and print the peers of these connections once the stream ends")
+	cmd.PersistentFlags().BoolVar(&options.summaryJSON, "summary-json", options.summaryJSON,
+		"Print a JSON object with the request and error counts, latency percentiles, and the --size-histogram, --tls-summary and --grpc-status-summary statistics once the stream ends, instead of the human-readable summary")
+	cmd.PersistentFlags().BoolVar(&options.dstWeight, "dst-weight", options.dstWeight,
+		"Print each destination's share of requests once the stream ends, e.g. to check the split of a TrafficSplit; destinations are identified by their workload, or pod or IP if it is unknown")
+	cmd.PersistentFlags().BoolVar(&options.groupBySrcDst, "group-by-src-dst", options.groupBySrcDst,
+		"Print the requests, error rate and p50 and p99 latencies between each pair of source and destination once the stream ends, identified as with --dst-weight")
+	cmd.PersistentFlags().BoolVar(&options.countBytes, "count-bytes-both-directions", options.countBytes,
+		"Print the total request and response bytes, and their sum, once the stream ends; request bytes are only known from a content-length header")
+	cmd.PersistentFlags().BoolVar(&options.grpcStatusSummary, "grpc-status-summary", options.grpcStatusSummary,
+		"Print the count of gRPC responses by status class (ok, retriable, fatal) once the stream ends")
+	cmd.PersistentFlags().BoolVar(&options.grpcOnly, "grpc-only", options.grpcOnly,
+		"Only display gRPC requests; requests are held back until they are known to be gRPC")
+	cmd.PersistentFlags().BoolVar(&options.httpOnly, "http-only", options.httpOnly,
+		"Only display non-gRPC requests; requests are held back until they are known not to be gRPC")
+	cmd.PersistentFlags().BoolVar(&options.hasAuthority, "has-authority", options.hasAuthority,
+		"Only display requests with an :authority, e.g. routed by DNS name, and their response")
+	cmd.PersistentFlags().BoolVar(&options.noAuthority, "no-authority", options.noAuthority,
+		"Only display requests without an :authority, e.g. made directly to an IP, and their response")
+	cmd.PersistentFlags().BoolVar(&options.onlyReset, "only-reset", options.onlyReset,
+		"Only display requests whose stream was reset, and their response if any; requests are held back until their response ends")
+	cmd.PersistentFlags().BoolVar(&options.onlyErrors, "only-errors", options.onlyErrors,
+		"Only display requests whose HTTP status is 400 or higher, whose gRPC status isn't OK, or whose stream was reset; requests are held back until their response ends")
+	cmd.PersistentFlags().BoolVar(&options.collapseSuccess, "collapse-success", options.collapseSuccess,
+		fmt.Sprintf("Only display failed requests, as with --only-errors, and print how many successful requests were hidden every %s and once the stream ends", successTallyInterval))
+	cmd.PersistentFlags().DurationVar(&options.minLatency, "min-latency", options.minLatency,
+		"Only display requests whose response took at least this long to start")
+	cmd.PersistentFlags().DurationVar(&options.maxLatency, "max-latency", options.maxLatency,
+		"Only display requests whose response took at most this long to start; can be combined with --min-latency")
+	cmd.PersistentFlags().BoolVar(&options.latencyBuckets, "latency-buckets", options.latencyBuckets,
+		fmt.Sprintf("Tag response lines with a latency bucket: %s", strings.Join(latencyBucketNames, ", ")))
+	cmd.PersistentFlags().StringVar(&options.latencyThresholds, "latency-thresholds", options.latencyThresholds,
+		"Comma-separated upper bounds of the --latency-buckets buckets, from fastest to slowest")
+	cmd.PersistentFlags().StringVar(&options.jsonPath, "jsonpath", options.jsonPath,
+		fmt.Sprintf("Display the values a JSONPath template extracts from the \"%s\" rendering of each event, e.g. '{.requestInitEvent.path}'", jsonOutput))
+	cmd.PersistentFlags().StringVar(&options.filter, "filter", options.filter,
+		fmt.Sprintf("Only display requests matching this expression, e.g. 'method == POST && status =~ \"^5\"'; fields are: %s. Requests are held back until their response ends", strings.Join(filterFields, ", ")))
+	cmd.PersistentFlags().StringVar(&options.traceIDFrom, "trace-id-from", options.traceIDFrom,
+		"Display a trace ID taken from this route or peer label; events without it get an ID derived from their stream")
+	cmd.PersistentFlags().StringVar(&options.dumpRaw, "dump-raw", options.dumpRaw,
+		"Write the raw tap stream received from the tap API to this file, for use with --replay")
+	cmd.PersistentFlags().StringVar(&options.replay, "replay", options.replay,
+		"Render the events of a file written by --dump-raw instead of tapping a live resource; only client-side filters apply")
+	cmd.PersistentFlags().StringVar(&options.socket, "socket", options.socket,
+		"Stream the events as NDJSON to the listener of this Unix domain socket, e.g. a local agent, instead of stdout; tap stops if the connection is lost")
+	cmd.PersistentFlags().StringVar(&options.contextPath, "context-path", options.contextPath,
+		"Strip this prefix from displayed paths, or add it if preceded by a '+', e.g. '+/svc'; --path still matches the paths received by the proxy")
+	cmd.PersistentFlags().StringVar(&options.pathTemplate, "path-template", options.pathTemplate,
+		"Comma-separated templates of parameterized routes, e.g. '/users/:id', whose matching paths are deduplicated and collapsed as one; segments starting with ':' match any segment")
+	cmd.PersistentFlags().BoolVar(&options.showPathTemplate, "show-path-template", options.showPathTemplate,
+		"Display the --path-template matching paths instead of the paths")
+	cmd.PersistentFlags().BoolVar(&options.resolveGRPCMethod, "resolve-grpc-method", options.resolveGRPCMethod,
+		"Display the gRPC service and method of requests whose path has the shape of a gRPC path, instead of the path")
+	cmd.PersistentFlags().IntVar(&options.maxEventsPerStream, "max-events-per-stream", options.maxEventsPerStream,
+		"Stop displaying the events of a connection's streams, which share a base stream ID, after this many events; 0 means no limit")
+	cmd.PersistentFlags().StringVar(&options.startOn, "start-on", options.startOn,
+		"Ignore events until a request whose path starts with this prefix is observed, then display it and all the following events")
+	cmd.PersistentFlags().StringVar(&options.stopOn, "stop-on", options.stopOn,
+		"Stop tapping once a request whose path starts with this prefix, or a response with this HTTP status, e.g. 503, is observed; can be combined with --start-on")
+	cmd.PersistentFlags().BoolVar(&options.onlyNewConnections, "only-new-connections", options.onlyNewConnections,
+		"Only display the first request of each connection, identified by its base stream ID, and its response; connections whose first request was missed aren't displayed")
+	cmd.PersistentFlags().IntVar(&options.maxPathLength, "max-path-length", options.maxPathLength,
+		"Truncate displayed paths longer than this many characters, ending them with an ellipsis; 0 means no limit. JSON output keeps the full path")
+	cmd.PersistentFlags().StringVar(&options.outputDir, "output-dir", options.outputDir,
+		"Also write the events of each stream to their own file in this directory, named after the stream's peers and ID, e.g. 1.2.3.4_5555-2.3.4.5_6666-7-1.ndjson, as NDJSON; the directory must not hold stream files already")
+	cmd.PersistentFlags().BoolVar(&options.watch, "watch", options.watch,
+		"Tap each pod of the resource separately, and keep tapping its new pods as they come and go, e.g. across a rollout; their events are merged into one output. --max-rps applies to each pod's tap")
+	cmd.PersistentFlags().BoolVar(&options.resolvePorts, "resolve-ports", options.resolvePorts,
+		"Display the name of destination ports, as declared by their endpoints or services")
+	cmd.PersistentFlags().DurationVar(&options.resolveTTL, "resolve-ttl", options.resolveTTL,
+		"How long the port names looked up by --resolve-ports are cached before being looked up again, as IPs get reused by recreated pods; 0 caches them forever")
+	cmd.PersistentFlags().BoolVar(&options.strictResource, "strict-resource", options.strictResource,
+		fmt.Sprintf("In \"%s\" output, display <none> for peers that don't belong to a resource of the tapped type, instead of their pod", wideOutput))
+	cmd.PersistentFlags().BoolVar(&options.showRequestBytes, "show-request-bytes", options.showRequestBytes,
+		"Display the size of request bodies, when requests declare it with a content-length header")
+	cmd.PersistentFlags().BoolVar(&options.mergeStreams, "merge-streams", options.mergeStreams,
+		"Group consecutive events of the same connection, which share a base stream ID, under a connection header")
+	cmd.PersistentFlags().StringVar(&options.timePrecision, "time-precision", options.timePrecision,
+		"Render the fractional seconds of timestamps with this precision: s, ms, us or ns; by default, as many digits as needed are rendered")
+	cmd.PersistentFlags().BoolVar(&options.pausable, "pausable", options.pausable,
+		fmt.Sprintf("When writing to a terminal, press space to pause and resume the output; up to %d events are held back while it is paused, later ones are dropped", pauseBufferSize))
+	cmd.PersistentFlags().BoolVar(&options.utc, "utc", options.utc,
+		fmt.Sprintf("Render timestamps, as in \"%s\" and \"%s\" output, in UTC instead of local time", harOutput, logfmtOutput))
+	cmd.PersistentFlags().BoolVar(&options.routeOnly, "route-only", options.routeOnly,
+		"Only display requests that matched a ServiceProfile route")
+	cmd.PersistentFlags().BoolVar(&options.asciiOnly, "ascii-only", options.asciiOnly,
+		"Only display ASCII characters, e.g. for log pipelines: durations are in us rather than µs, truncated paths end with ..., and other characters of authorities and paths are escaped as \\uNNNN")
+	cmd.PersistentFlags().BoolVar(&options.rawPaths, "raw-paths", options.rawPaths,
+		"Display the authority and path of requests as is, without escaping non-printable characters and invalid UTF-8")
+	cmd.PersistentFlags().StringVar(&options.fieldsFromLabels, "fields-from-labels", options.fieldsFromLabels,
+		fmt.Sprintf("In \"%s\" output, display these comma-separated labels of the destination as columns; prefix a label with 'src:' to take it from the source instead", wideOutput))
+	cmd.PersistentFlags().DurationVar(&options.summaryInterval, "summary-interval", options.summaryInterval,
+		"Print the request rate, error rate and p99 latency of the requests that completed during each interval of this duration, e.g. 10s")
+	cmd.PersistentFlags().BoolVar(&options.rpsByStatus, "rps-by-status", options.rpsByStatus,
+		"With --summary-interval, also print the rate of responses of each status class during each interval, e.g. status_rps=2xx:40.0/s,5xx:2.0/s")
+	cmd.PersistentFlags().DurationVar(&options.longThreshold, "long-request-threshold", options.longThreshold,
+		"Print a still-open notice, every this long, for each request waiting for its response for longer than this, e.g. 30s")
+	cmd.PersistentFlags().IntVar(&options.pairingBuffer, "pairing-buffer", options.pairingBuffer,
+		"Maximum number of incomplete requests tracked while waiting for their response: by client-side filters such as --grpc-only or --filter, which hold them back to decide whether to display them, and by --dedup, --collapse-retries, --dedup-directions, --sort, -o har and -o tui; the oldest ones are dropped once it's reached. 0 means no limit")
+	cmd.PersistentFlags().DurationVar(&options.idleTimeout, "idle-timeout", options.idleTimeout,
+		"Stop tapping once no matching event arrived for this long, e.g. 5s")
+	cmd.PersistentFlags().BoolVar(&options.escapeJSONHTML, "escape-json-html", options.escapeJSONHTML,
+		fmt.Sprintf("Escape <, > and & in \"%s\" output; set to false to render paths such as /search?q=a&b literally", jsonOutput))
+	cmd.PersistentFlags().BoolVar(&options.includeUnknown, "include-unknown", options.includeUnknown,
+		"Display events of types this version of the CLI doesn't know about, e.g. those of newer proxies, along with their Go type, instead of hiding them")
+	cmd.PersistentFlags().BoolVar(&options.showReporter, "show-reporter", options.showReporter,
+		"Display the pod/namespace of the proxy that reported each event; \"unk\" if the proxy direction is unknown")
+	cmd.PersistentFlags().BoolVar(&options.flattenLabels, "flatten-labels", options.flattenLabels,
+		fmt.Sprintf("In \"%s\" output, render source and destination labels as top-level fields such as source_label_app, instead of nested metadata maps", jsonOutput))
+	cmd.PersistentFlags().BoolVar(&options.firstByteLatency, "first-byte-latency", options.firstByteLatency,
+		fmt.Sprintf("In \"%s\" output, also render the time to first byte of responses, i.e. how long they took to start, as ttfbMicros, apart from how long they took to complete", jsonOutput))
+	cmd.PersistentFlags().BoolVar(&options.durationsAsMicros, "durations-as-micros", options.durationsAsMicros,
+		fmt.Sprintf("In \"%s\" output, also render the durations of responses as integer microseconds, e.g. sinceRequestInitMicros, alongside their nested {seconds, nanos} form", jsonOutput))
+	cmd.PersistentFlags().BoolVar(&options.topLevelStreamID, "top-level-stream-id", options.topLevelStreamID,
+		fmt.Sprintf("In \"%s\" output, also render the stream ID of each event as top-level streamBase and streamNumber fields, instead of only under its requestInitEvent, responseInitEvent or responseEndEvent", jsonOutput))
+	cmd.PersistentFlags().BoolVar(&options.noFlow, "no-flow", options.noFlow,
+		"Don't display the proxy, source, destination and TLS status of each event, only its HTTP details")
+	cmd.PersistentFlags().StringVar(&options.authorityCanonical, "authority-canonical", options.authorityCanonical,
+		fmt.Sprintf("Display, and deduplicate, the authorities a backend is reached under as one, using comma-separated FROM=TO rules, e.g. 10.3.2.1=books.default; 'auto' qualifies cluster DNS names with %s. JSON output keeps the raw authority", clusterDomain))
+	cmd.PersistentFlags().StringVar(&options.shortAuthority, "short-authority", options.shortAuthority,
+		fmt.Sprintf("Shorten the displayed authorities of cluster DNS names: \"%s\" strips .svc.%s, \"%s\" strips their namespace as well. JSON output keeps the raw authority", shortAuthoritySvc, clusterDomain, shortAuthorityNamespace))
+	cmd.PersistentFlags().StringVar(&options.address, "address", options.address,
+		"Connect to the tap API at this host:port or URL, e.g. one exposed by \"kubectl proxy\" or a port-forward, instead of going through the Kubernetes API")
+
+	cmd.AddCommand(newCmdTapProbe(options))
 
 	return cmd
 }
 
-func requestTapByResourceFromAPI(w io.Writer, k8sAPI *k8s.KubernetesAPI, req *pb.TapByResourceRequest, options *tapOptions) error {
-	reader, body, err := tap.Reader(k8sAPI, req, 0)
+func requestTapByResourceFromAPI(ctx context.Context, w io.Writer, k8sAPI *k8s.KubernetesAPI, req *pb.TapByResourceRequest, options *tapOptions) error {
+	open := func() (*bufio.Reader, io.ReadCloser, error) { return tap.Reader(k8sAPI, req, 0) }
+	if options.watch {
+		open = func() (*bufio.Reader, io.ReadCloser, error) { return watchTapReader(k8sAPI, req) }
+	}
+	reader, body, err := open()
 	if err != nil {
 		return err
 	}
+	return writeTapStream(ctx, w, reader, body, req, options)
+}
+
+// requestTapByResourceFromAddress requests the tap stream from the tap
+// APIService at address rather than through the Kubernetes API.
+func requestTapByResourceFromAddress(ctx context.Context, w io.Writer, address string, req *pb.TapByResourceRequest, options *tapOptions) error {
+	reader, body, err := tap.AddressReader(address, req, 0)
+	if err != nil {
+		return err
+	}
+	return writeTapStream(ctx, w, reader, body, req, options)
+}
+
+// writeTapStream renders the tap stream read from body until it ends or ctx
+// is done, and closes body.
+func writeTapStream(ctx context.Context, w io.Writer, reader *bufio.Reader, body io.ReadCloser, req *pb.TapByResourceRequest, options *tapOptions) error {
 	defer body.Close()
 
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if options.dumpRaw != "" {
+		// Writes to the file aren't buffered, so everything received is kept
+		// even if tap is interrupted.
+		file, err := os.Create(options.dumpRaw)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		reader = dumpRawTapStream(reader, file)
+	}
+	reader = interruptTapStream(ctx, reader, body)
+
+	if options.pausable {
+		var restore func()
+		w, restore = pausableOutput(w)
+		defer restore()
+	}
+
 	return writeTapEventsToBuffer(w, reader, req, options)
 }
 
 func writeTapEventsToBuffer(w io.Writer, tapByteStream *bufio.Reader, req *pb.TapByResourceRequest, options *tapOptions) error {
+	if options.jsonPathTemplate != nil {
+		return renderTapEvents(tapByteStream, w, renderTapEventJSONPath, "", options)
+	}
+
 	var err error
 	switch options.output {
 	case "":
-		err = renderTapEvents(tapByteStream, w, renderTapEvent, "")
+		err = renderTapEvents(tapByteStream, w, renderTapEvent, "", options)
 	case wideOutput:
 		resource := req.GetTarget().GetResource().GetType()
-		err = renderTapEvents(tapByteStream, w, renderTapEvent, resource)
+		err = renderTapEvents(tapByteStream, w, renderTapEvent, resource, options)
+	case widePlusOutput:
+		resource := req.GetTarget().GetResource().GetType()
+		err = renderTapEvents(tapByteStream, w, renderTapEventWidePlus, resource, options)
 	case jsonOutput:
-		err = renderTapEvents(tapByteStream, w, renderTapEventJSON, "")
+		render := renderTapEventJSON
+		if options.socket != "" {
+			render = renderTapEventNDJSON
+		}
+		err = renderTapEvents(tapByteStream, w, render, "", options)
+	case jsonArrayOutput:
+		err = renderTapEventsJSONArray(tapByteStream, w, options)
+	case protoJSONOutput:
+		err = renderTapEvents(tapByteStream, w, renderTapEventProtoJSON, "", options)
+	case harOutput:
+		err = renderTapEventsHAR(tapByteStream, w, options)
+	case sseOutput:
+		err = renderTapEventsSSE(tapByteStream, w, options)
+	case logfmtOutput:
+		err = renderTapEvents(tapByteStream, w, renderTapEventLogfmt, "", options)
+	case tuiOutput:
+		resource := req.GetTarget().GetResource().GetType()
+		err = renderTapEventsTUI(tapByteStream, resource, options)
 	}
 	if err != nil {
 		return err
@@ -245,11 +1266,263 @@ func writeTapEventsToBuffer(w io.Writer, tapByteStream *bufio.Reader, req *pb.Ta
 	return nil
 }
 
-func renderTapEvents(tapByteStream *bufio.Reader, w io.Writer, render renderTapEventFunc, resource string) error {
+func renderTapEvents(tapByteStream *bufio.Reader, w io.Writer, render renderTapEventFunc, resource string, options *tapOptions) error {
+	var dedup *eventDeduper
+	if options.dedup {
+		dedup = newEventDeduper(dedupRingSize, dedupWindow, options.pairingBuffer)
+		dedup.authorities = options.authorityCanonicalizer
+		dedup.paths = options.pathTemplates
+	}
+	var retries *retryCollapser
+	if options.collapseRetries {
+		retries = newRetryCollapser(retryWindow, options.pairingBuffer)
+		retries.authorities = options.authorityCanonicalizer
+		retries.paths = options.pathTemplates
+	}
+	var directions *directionDeduper
+	if options.dedupDirections {
+		directions = newDirectionDeduper(directionsWindow, options.pairingBuffer)
+		directions.authorities = options.authorityCanonicalizer
+		directions.paths = options.pathTemplates
+	}
+	var sorter *latencySorter
+	if options.sort == sortLatency {
+		sorter = newLatencySorter(options.maxEvents, options.pairingBuffer)
+	}
+	summary := newTapSummary(options)
+	if options.summaryInterval > 0 || options.longThreshold > 0 || options.successTally != nil || dedup != nil || retries != nil || directions != nil {
+		// Rollups, still-open notices, tallies of suppressed successes and
+		// lines held back by --dedup, --collapse-retries or
+		// --dedup-directions are written concurrently with events.
+		w = &syncWriter{w: w}
+	}
+	var rollup *intervalRollup
+	stopRollup := func() {}
+	if options.summaryInterval > 0 {
+		rollup = newIntervalRollup(options.summaryInterval)
+		rollup.asciiOnly = options.asciiOnly
+		if options.rpsByStatus {
+			rollup.rateStatuses(options.now)
+		}
+		stopRollup = rollup.start(w)
+	}
+	var longRequests *longRequestWatcher
+	stopLongRequests := func() {}
+	if options.longThreshold > 0 {
+		longRequests = newLongRequestWatcher(options.longThreshold, options.now)
+		stopLongRequests = longRequests.start(w)
+	}
+	stopSuccessTally := func() {}
+	if options.successTally != nil {
+		stopSuccessTally = options.successTally.start(w)
+	}
+	stopDedup := func() {}
+	if dedup != nil {
+		stopDedup = dedup.start(w, options.now)
+	}
+	stopRetries := func() {}
+	if retries != nil {
+		stopRetries = retries.start(w, options.now)
+	}
+	stopDirections := func() {}
+	if directions != nil {
+		stopDirections = directions.start(w, options.now)
+	}
+	// Number of events rendered for each base stream ID, when they're limited
+	// by `--max-events-per-stream`.
+	eventsPerBase := make(map[uint32]int)
+	// Base stream ID of the connection whose events were rendered last, when
+	// they're grouped by `--merge-streams`.
+	lastBase, merging := uint32(0), false
+	// Stream displayed for each base stream ID seen, when only the first
+	// request of connections is displayed by `--only-new-connections`. It is
+	// nil for connections whose first request was missed.
+	firstStreams := make(map[uint32]*uint64)
+	var streamFiles *streamFileWriter
+	if options.outputDir != "" {
+		var err error
+		if streamFiles, err = newStreamFileWriter(options.outputDir, maxOpenStreamFiles); err != nil {
+			return err
+		}
+		defer streamFiles.close()
+	}
+
+	err := forEachTapEvent(tapByteStream, options, func(event *pb.TapEvent) error {
+		summary.add(event)
+		if streamFiles != nil {
+			if err := streamFiles.write(event, renderTapEventNDJSON(event, "", options)); err != nil {
+				return err
+			}
+		}
+		if rollup != nil {
+			rollup.add(event)
+		}
+		if longRequests != nil {
+			longRequests.add(event)
+		}
+
+		if options.onlyNewConnections {
+			id := eventStreamID(event)
+			first, seen := firstStreams[id.GetBase()]
+			if !seen {
+				if event.GetHttp().GetRequestInit() != nil {
+					stream := id.GetStream()
+					first = &stream
+				}
+				firstStreams[id.GetBase()] = first
+			}
+			if first == nil || *first != id.GetStream() {
+				return nil
+			}
+		}
+
+		if options.maxEventsPerStream > 0 {
+			base := eventStreamID(event).GetBase()
+			eventsPerBase[base]++
+			switch count := eventsPerBase[base]; {
+			case count == options.maxEventsPerStream+1:
+				return writeLines(w, []string{fmt.Sprintf("stream %d truncated after %d events", base, options.maxEventsPerStream)})
+			case count > options.maxEventsPerStream:
+				return nil
+			}
+		}
+
+		line := render(event, resource, options)
+		if options.warnUnmeshed {
+			if _, unmeshed := unmeshedPeer(event); unmeshed {
+				line += " UNMESHED"
+			}
+		}
+		lines := []string{options.statusColored(event, line)}
+		if dedup != nil {
+			lines = dedup.add(event, lines[0], options.now())
+		}
+		if retries != nil {
+			lines = retries.add(event, lines[0], options.now())
+		}
+		if directions != nil {
+			lines = directions.add(event, lines[0], options.now())
+		}
+		if sorter != nil {
+			sorter.add(event, lines[0])
+			return nil
+		}
+		if options.mergeStreams {
+			// A header is written whenever the events of another connection
+			// are rendered, so that indented lines always belong to the
+			// connection right above them.
+			lines[0] = "  " + lines[0]
+			if base := eventStreamID(event).GetBase(); !merging || base != lastBase {
+				lastBase, merging = base, true
+				lines = append([]string{formatConnHeader(event)}, lines...)
+			}
+		}
+		return writeLines(w, lines)
+	})
+	stopRollup()
+	stopLongRequests()
+	stopSuccessTally()
+	stopDedup()
+	stopRetries()
+	stopDirections()
+	if err != nil {
+		return err
+	}
+
+	if dedup != nil {
+		dedup.bound.warn("--dedup")
+		err := writeLines(w, dedup.flush())
+		if err != nil {
+			return err
+		}
+	}
+	if retries != nil {
+		retries.bound.warn("--collapse-retries")
+		err := writeLines(w, retries.flush())
+		if err != nil {
+			return err
+		}
+	}
+	if directions != nil {
+		directions.bound.warn("--dedup-directions")
+		err := writeLines(w, directions.flush())
+		if err != nil {
+			return err
+		}
+	}
+	if sorter != nil {
+		sorter.bound.warn("--sort")
+		err := writeLines(w, sorter.flush())
+		if err != nil {
+			return err
+		}
+	}
+	if options.successTally != nil {
+		if err := options.successTally.write(w); err != nil {
+			return err
+		}
+	}
+
+	return summary.write(w)
+}
+
+// formatConnHeader renders the header of the connection an event belongs to,
+// under which `--merge-streams` groups its events.
+func formatConnHeader(event *pb.TapEvent) string {
+	return fmt.Sprintf("conn base=%d %s->%s",
+		eventStreamID(event).GetBase(),
+		addr.PublicAddressToString(event.GetSource()),
+		addr.PublicAddressToString(event.GetDestination()),
+	)
+}
+
+// renderTapEventsHAR correlates the events of a tap stream into a HAR document,
+// which is written once the stream ends.
+func renderTapEventsHAR(tapByteStream *bufio.Reader, w io.Writer, options *tapOptions) error {
+	har := newHarRecorder(options.pairingBuffer)
+	har.timeLayout = options.timestampLayout()
+
+	err := forEachTapEvent(tapByteStream, options, func(event *pb.TapEvent) error {
+		har.add(event, options.timestamp(options.now()))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	har.bound.warn("-o har")
+	return har.write(w)
+}
+
+// forEachTapEvent decodes events from a tap stream until it ends, calling
+// handle for each event that passes the client-side filters.
+func forEachTapEvent(tapByteStream *bufio.Reader, options *tapOptions, handle func(*pb.TapEvent) error) error {
+	done := make(chan struct{})
+	defer close(done)
+	decoded := decodeTapEvents(tap.NewDecoder(tapByteStream), done)
+	idle := newIdleTimer(options.idleTimeout)
+	defer idle.stop()
+
+	// Whether the request `--start-on` waits for was observed.
+	started := options.startOn == ""
+	filter := options.newStreamFilter()
+	if filter != nil {
+		defer func() {
+			if filter.bound.evicted > 0 {
+				fmt.Fprintf(os.Stderr, "%d incomplete requests dropped after holding back --pairing-buffer=%d\n", filter.bound.evicted, options.pairingBuffer)
+			}
+		}()
+	}
 	for {
 		log.Debug("Waiting for data...")
-		event := pb.TapEvent{}
-		err := protohttp.FromByteStreamToProtocolBuffers(tapByteStream, &event)
+		var d decodedTapEvent
+		select {
+		case d = <-decoded:
+		case <-idle.expired():
+			fmt.Fprintf(os.Stderr, "No matching event for %s, stopping\n", options.idleTimeout)
+			return nil
+		}
+		event, err := d.event, d.err
 		if err == io.EOF {
 			break
 		}
@@ -257,125 +1530,570 @@ func renderTapEvents(tapByteStream *bufio.Reader, w io.Writer, render renderTapE
 			fmt.Fprintln(os.Stderr, err)
 			break
 		}
-		_, err = fmt.Fprintln(w, render(&event, resource))
+		if !options.matches(event) {
+			continue
+		}
+		idle.reset()
+		if !started {
+			if !hasPathPrefix(event, options.startOn) {
+				continue
+			}
+			started = true
+		}
+
+		events := []*pb.TapEvent{event}
+		if filter != nil {
+			events = filter.add(event)
+		}
+		stop := false
+		for _, e := range events {
+			err = handle(e)
+			if err != nil {
+				return err
+			}
+			stop = stop || options.stopsOn(e)
+		}
+		if stop {
+			return nil
+		}
+	}
+	return nil
+}
+
+// hasPathPrefix returns true if the event is a request whose path starts with
+// prefix.
+func hasPathPrefix(event *pb.TapEvent, prefix string) bool {
+	reqI := event.GetHttp().GetRequestInit()
+	return reqI != nil && strings.HasPrefix(reqI.GetPath(), prefix)
+}
+
+// stopsOn returns true if the event is the one `--stop-on` waits for: a
+// request whose path starts with the given prefix, or a response with the
+// given HTTP status.
+func (o *tapOptions) stopsOn(event *pb.TapEvent) bool {
+	switch {
+	case o.stopOnStatus != 0:
+		return event.GetHttp().GetResponseInit().GetHttpStatus() == o.stopOnStatus
+	case o.stopOn != "":
+		return hasPathPrefix(event, o.stopOn)
+	default:
+		return false
+	}
+}
+
+func writeLines(w io.Writer, lines []string) error {
+	for _, line := range lines {
+		_, err := fmt.Fprintln(w, line)
 		if err != nil {
 			return err
 		}
 	}
+	return nil
+}
+
+const (
+	// dedupRingSize is the number of distinct recent events `--dedup` tracks.
+	// It must be large enough to hold a full request/response/end cycle so
+	// that retried transactions collapse as a whole.
+	dedupRingSize = 8
+	// dedupWindow is how long after its last occurrence an event can still be
+	// collapsed with an identical one.
+	dedupWindow = time.Second
+)
+
+type dedupEntry struct {
+	fingerprint string
+	line        string
+	count       int
+	lastSeen    time.Time
+}
+
+// eventDeduper collapses identical events arriving close together. It keeps a
+// small ring of recently rendered lines; an event identical to one still in
+// the ring only increments that entry's count. Lines are emitted, with a
+// trailing `(xN)` when N > 1, once they fall out of the ring or the window;
+// see start.
+type eventDeduper struct {
+	size   int
+	window time.Duration
+
+	sync.Mutex
+	entries []*dedupEntry
+	// requests maps in-flight streams to the fingerprint of their request, so
+	// that responses are only collapsed with responses to identical requests.
+	requests map[streamKey]string
+	bound    *streamBound
+	// authorities canonicalizes the authorities of requests, so that requests
+	// to the same backend under different authorities are collapsed.
+	authorities *authorityCanonicalizer
+	// paths maps the paths of requests to their template, so that requests
+	// to the same parameterized route are collapsed.
+	paths *pathTemplates
+}
+
+func newEventDeduper(size int, window time.Duration, pairingBuffer int) *eventDeduper {
+	return &eventDeduper{
+		size:     size,
+		window:   window,
+		requests: make(map[streamKey]string),
+		bound:    newStreamBound(pairingBuffer),
+	}
+}
+
+// add records an event and its rendered line, returning the lines that are
+// ready to be written.
+func (d *eventDeduper) add(event *pb.TapEvent, line string, now time.Time) []string {
+	d.Lock()
+	defer d.Unlock()
+	fingerprint := d.fingerprint(event)
+	out := d.expired(now)
+
+	for _, e := range d.entries {
+		if e.fingerprint == fingerprint && now.Sub(e.lastSeen) <= d.window {
+			e.count++
+			e.lastSeen = now
+			return out
+		}
+	}
+
+	d.entries = append(d.entries, &dedupEntry{
+		fingerprint: fingerprint,
+		line:        line,
+		count:       1,
+		lastSeen:    now,
+	})
+	if len(d.entries) > d.size {
+		out = append(out, d.entries[0].String())
+		d.entries = d.entries[1:]
+	}
+	return out
+}
+
+// expired returns the lines of the entries at the front of the ring that
+// fell out of the window, and forgets them. Entries are emitted in the order
+// they were first seen, so later expired entries wait for those. d must be
+// locked.
+func (d *eventDeduper) expired(now time.Time) []string {
+	var out []string
+	for len(d.entries) > 0 && now.Sub(d.entries[0].lastSeen) > d.window {
+		out = append(out, d.entries[0].String())
+		d.entries = d.entries[1:]
+	}
+	return out
+}
+
+// start writes the lines of expired entries to w on every tick of the window,
+// until the returned function is called, so that they're not held back until
+// another event is received.
+func (d *eventDeduper) start(w io.Writer, now func() time.Time) (stop func()) {
+	return writeOnTicks(d.window, func() error {
+		d.Lock()
+		lines := d.expired(now())
+		d.Unlock()
+		return writeLines(w, lines)
+	})
+}
+
+// flush returns the lines of all pending entries, oldest first.
+func (d *eventDeduper) flush() []string {
+	d.Lock()
+	defer d.Unlock()
+	out := make([]string, len(d.entries))
+	for i, e := range d.entries {
+		out[i] = e.String()
+	}
+	d.entries = nil
+	return out
+}
+
+// fingerprint identifies an event by the fields `--dedup` compares: the
+// source, destination, method, path, authority and status.
+func (d *eventDeduper) fingerprint(event *pb.TapEvent) string {
+	src := src(event)
+	dst := dst(event)
+	key := newStreamKey(event)
+	flow := fmt.Sprintf("%s %s", src.formatAddr(), dst.formatAddr())
+
+	switch ev := event.GetHttp().GetEvent().(type) {
+	case *pb.TapEvent_Http_RequestInit_:
+		fingerprint := fmt.Sprintf("req %s %s %s %s",
+			flow,
+			formatMethod(ev.RequestInit.GetMethod()),
+			d.authorities.canonical(ev.RequestInit.GetAuthority()),
+			d.paths.template(ev.RequestInit.GetPath()),
+		)
+		if oldest, evict := d.bound.track(key); evict {
+			delete(d.requests, oldest)
+		}
+		d.requests[key] = fingerprint
+		return fingerprint
+
+	case *pb.TapEvent_Http_ResponseInit_:
+		return fmt.Sprintf("rsp %s %d", d.requests[key], ev.ResponseInit.GetHttpStatus())
+
+	case *pb.TapEvent_Http_ResponseEnd_:
+		eos := ""
+		switch e := ev.ResponseEnd.GetEos().GetEnd().(type) {
+		case *pb.Eos_GrpcStatusCode:
+			eos = fmt.Sprintf("grpc-status=%d", e.GrpcStatusCode)
+		case *pb.Eos_ResetErrorCode:
+			eos = fmt.Sprintf("reset-error=%d", e.ResetErrorCode)
+		}
+		fingerprint := fmt.Sprintf("end %s %s", d.requests[key], eos)
+		delete(d.requests, key)
+		d.bound.forget(key)
+		return fingerprint
+
+	default:
+		return fmt.Sprintf("unknown %s", flow)
+	}
+}
 
+func (e *dedupEntry) String() string {
+	if e.count > 1 {
+		return fmt.Sprintf("%s (x%d)", e.line, e.count)
+	}
+	return e.line
+}
+
+// streamKey identifies a single request stream as seen by a proxy.
+type streamKey struct {
+	src    string
+	dst    string
+	base   uint32
+	stream uint64
+}
+
+func newStreamKey(event *pb.TapEvent) streamKey {
+	id := eventStreamID(event)
+	return streamKey{
+		src:    addr.PublicAddressToString(event.GetSource()),
+		dst:    addr.PublicAddressToString(event.GetDestination()),
+		base:   id.GetBase(),
+		stream: id.GetStream(),
+	}
+}
+
+// eventStreamID returns the stream ID of whichever HTTP event is present.
+func eventStreamID(event *pb.TapEvent) *pb.TapEvent_Http_StreamId {
+	switch ev := event.GetHttp().GetEvent().(type) {
+	case *pb.TapEvent_Http_RequestInit_:
+		return ev.RequestInit.GetId()
+	case *pb.TapEvent_Http_ResponseInit_:
+		return ev.ResponseInit.GetId()
+	case *pb.TapEvent_Http_ResponseEnd_:
+		return ev.ResponseEnd.GetId()
+	}
 	return nil
 }
 
 // renderTapEvent renders a Public API TapEvent to a string.
-func renderTapEvent(event *pb.TapEvent, resource string) string {
+func renderTapEvent(event *pb.TapEvent, resource string, options *tapOptions) string {
 	dst := dst(event)
 	src := src(event)
 
-	proxy := "???"
-	tls := ""
+	// Proxies too old to report their direction are rendered as `unk`.
+	proxy := "unk"
 	switch event.GetProxyDirection() {
 	case pb.TapEvent_INBOUND:
 		proxy = "in " // A space is added so it aligns with `out`.
-		tls = src.tlsStatus()
 	case pb.TapEvent_OUTBOUND:
 		proxy = "out"
-		tls = dst.tlsStatus()
-	default:
-		// Too old for TLS.
 	}
-
-	flow := fmt.Sprintf("proxy=%s %s %s tls=%s",
-		proxy,
-		src.formatAddr(),
-		dst.formatAddr(),
-		tls,
-	)
+	tls := tlsStatus(event)
+
+	// flow is empty or starts with a space.
+	flow := ""
+	if !options.noFlow {
+		flow = fmt.Sprintf(" proxy=%s %s %s tls=%s",
+			proxy,
+			src.formatAddr(),
+			dst.formatAddr(),
+			tls,
+		)
+	}
+	if options.portNames != nil {
+		flow = fmt.Sprintf("%s dst_port=%s", flow, options.portNames.format(event))
+	}
+	if options.traceIDFrom != "" {
+		flow = fmt.Sprintf("%s trace=%s", flow, traceID(event, options.traceIDFrom))
+	}
+	if options.showReporter {
+		r := reporter(event)
+		if r == "" {
+			r = "unk"
+		}
+		flow = fmt.Sprintf("%s reporter=%s", flow, r)
+	}
 
 	// If `resource` is non-empty, then
 	resources := ""
 	if resource != "" {
 		resources = fmt.Sprintf(
-			"%s%s%s",
-			src.formatResource(resource),
-			dst.formatResource(resource),
+			"%s%s%s%s",
+			src.formatResource(resource, options.strictResource),
+			dst.formatResource(resource, options.strictResource),
+			formatLabelFields(event, options.labelFields),
 			routeLabels(event),
 		)
+	} else if options.showRoute {
+		resources = routeLabels(event)
+	}
+
+	bucket := ""
+	if options.latencyBuckets {
+		bucket = latencyBucketTag(event, options.latencyBounds)
 	}
 
 	switch ev := event.GetHttp().GetEvent().(type) {
 	case *pb.TapEvent_Http_RequestInit_:
-		return fmt.Sprintf("req id=%d:%d %s :method=%s :authority=%s :path=%s%s",
+		version := ""
+		if options.showVersion {
+			version = fmt.Sprintf(" version=%s", httpVersion(ev.RequestInit))
+		}
+		if options.showRequestBytes {
+			if size, ok := requestBytes(ev.RequestInit); ok {
+				version = fmt.Sprintf("%s req-bytes=%d", version, size)
+			}
+		}
+		displayedPath := ev.RequestInit.GetPath()
+		if options.showPathTemplate {
+			displayedPath = options.pathTemplates.template(displayedPath)
+		}
+		path := fmt.Sprintf(":path=%s", options.highlighted(options.truncatePath(options.displayString(options.contextPathRewrite.rewrite(displayedPath)))))
+		if options.resolveGRPCMethod {
+			if service, method, ok := parseGRPCPath(ev.RequestInit.GetPath()); ok {
+				path = fmt.Sprintf("grpc-service=%s grpc-method=%s", service, method)
+			}
+		}
+		return fmt.Sprintf("req id=%d:%d%s :method=%s :authority=%s %s%s%s",
 			ev.RequestInit.GetId().GetBase(),
 			ev.RequestInit.GetId().GetStream(),
 			flow,
 			ev.RequestInit.GetMethod().GetRegistered().String(),
-			ev.RequestInit.GetAuthority(),
-			ev.RequestInit.GetPath(),
+			options.highlighted(options.displayString(shortenAuthority(options.authorityCanonicalizer.canonical(ev.RequestInit.GetAuthority()), options.shortAuthority))),
+			path,
+			version,
 			resources,
 		)
 
 	case *pb.TapEvent_Http_ResponseInit_:
-		return fmt.Sprintf("rsp id=%d:%d %s :status=%d latency=%dµs%s",
+		return fmt.Sprintf("rsp id=%d:%d%s :status=%d latency=%d%s%s%s",
 			ev.ResponseInit.GetId().GetBase(),
 			ev.ResponseInit.GetId().GetStream(),
 			flow,
 			ev.ResponseInit.GetHttpStatus(),
-			ev.ResponseInit.GetSinceRequestInit().GetNanos()/1000,
+			durationMicros(ev.ResponseInit.GetSinceRequestInit()),
+			options.microsUnit(),
+			bucket,
 			resources,
 		)
 
 	case *pb.TapEvent_Http_ResponseEnd_:
+		// The duration is how long the response took, and the total how long
+		// the whole request took, from the request to the end of the response.
+		total := fmt.Sprintf(" total=%d%s", durationMicros(ev.ResponseEnd.GetSinceRequestInit()), options.microsUnit())
 		switch eos := ev.ResponseEnd.GetEos().GetEnd().(type) {
 		case *pb.Eos_GrpcStatusCode:
 			return fmt.Sprintf(
-				"end id=%d:%d %s grpc-status=%s duration=%dµs response-length=%dB%s",
+				"end id=%d:%d%s grpc-status=%s duration=%d%s%s response-length=%dB%s%s",
 				ev.ResponseEnd.GetId().GetBase(),
 				ev.ResponseEnd.GetId().GetStream(),
 				flow,
 				codes.Code(eos.GrpcStatusCode),
-				ev.ResponseEnd.GetSinceResponseInit().GetNanos()/1000,
+				durationMicros(ev.ResponseEnd.GetSinceResponseInit()),
+				options.microsUnit(),
+				total,
 				ev.ResponseEnd.GetResponseBytes(),
+				bucket,
 				resources,
 			)
 
 		case *pb.Eos_ResetErrorCode:
 			return fmt.Sprintf(
-				"end id=%d:%d %s reset-error=%+v duration=%dµs response-length=%dB%s",
+				"end id=%d:%d%s reset-error=%+v duration=%d%s%s response-length=%dB%s%s",
 				ev.ResponseEnd.GetId().GetBase(),
 				ev.ResponseEnd.GetId().GetStream(),
 				flow,
 				eos.ResetErrorCode,
-				ev.ResponseEnd.GetSinceResponseInit().GetNanos()/1000,
+				durationMicros(ev.ResponseEnd.GetSinceResponseInit()),
+				options.microsUnit(),
+				total,
 				ev.ResponseEnd.GetResponseBytes(),
+				bucket,
 				resources,
 			)
 
 		default:
-			return fmt.Sprintf("end id=%d:%d %s duration=%dµs response-length=%dB%s",
+			return fmt.Sprintf("end id=%d:%d%s duration=%d%s%s response-length=%dB%s%s",
 				ev.ResponseEnd.GetId().GetBase(),
 				ev.ResponseEnd.GetId().GetStream(),
 				flow,
-				ev.ResponseEnd.GetSinceResponseInit().GetNanos()/1000,
+				durationMicros(ev.ResponseEnd.GetSinceResponseInit()),
+				options.microsUnit(),
+				total,
 				ev.ResponseEnd.GetResponseBytes(),
+				bucket,
 				resources,
 			)
 		}
 
 	default:
-		return fmt.Sprintf("unknown %s", flow)
+		// The event is either malformed or of a type this version of the CLI
+		// doesn't know about, so tell which protocol it belongs to, if any.
+		protocol := "none"
+		if event.GetHttp() != nil {
+			protocol = "http"
+		}
+		var eventType interface{} = event.GetEvent()
+		if protocol == "http" {
+			eventType = event.GetHttp().GetEvent()
+		}
+		return fmt.Sprintf("unknown%s protocol=%s type=%T (not supported by this version of the CLI)", flow, protocol, eventType)
 	}
 }
 
+// renderTapEventWidePlus renders a Public API TapEvent like wide output does,
+// followed by the proxy direction, the TLS identity of both peers and the
+// matched route as explicit columns.
+func renderTapEventWidePlus(event *pb.TapEvent, resource string, options *tapOptions) string {
+	src := src(event)
+	dst := dst(event)
+
+	route := event.GetRouteMeta().GetLabels()["route"]
+	if route == "" {
+		route = "-"
+	}
+
+	return fmt.Sprintf("%s direction=%s src_id=%s dst_id=%s route=%s",
+		renderTapEvent(event, resource, options),
+		strings.ToLower(event.GetProxyDirection().String()),
+		src.identity(tlsStatus(event)),
+		dst.identity(tlsStatus(event)),
+		route,
+	)
+}
+
 // renderTapEventJSON renders a Public API TapEvent to a string in JSON format.
-func renderTapEventJSON(event *pb.TapEvent, _ string) string {
-	m := mapPublicToDisplayTapEvent(event)
-	e, err := json.MarshalIndent(m, "", "  ")
+func renderTapEventJSON(event *pb.TapEvent, _ string, options *tapOptions) string {
+	e, err := marshalTapEventJSON(event, options, "  ")
 	if err != nil {
 		return fmt.Sprintf("{\"error marshalling JSON\": \"%s\"}", err)
 	}
+	if options.color {
+		return colorizeJSON(e)
+	}
 	return fmt.Sprintf("%s", e)
 }
 
+// renderTapEventNDJSON renders an event as in "json" output, but on a single
+// line and without colors, so that a sequence of events is valid NDJSON.
+func renderTapEventNDJSON(event *pb.TapEvent, _ string, options *tapOptions) string {
+	e, err := marshalTapEventJSON(event, options, "")
+	if err != nil {
+		return fmt.Sprintf("{\"error marshalling JSON\": \"%s\"}", err)
+	}
+	return string(e)
+}
+
+// marshalTapEventJSON encodes the JSON rendering of an event, indenting
+// nested fields with indent unless it is empty.
+func marshalTapEventJSON(event *pb.TapEvent, options *tapOptions, indent string) ([]byte, error) {
+	var m interface{} = mapTapEventWithOptions(event, options)
+	if options.flattenLabels {
+		flat, err := flattenLabels(m.(*tapEvent))
+		if err != nil {
+			return nil, err
+		}
+		m = flat
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(options.escapeJSONHTML)
+	encoder.SetIndent("", indent)
+	if err := encoder.Encode(m); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// renderTapEventProtoJSON renders a Public API TapEvent to a string in the
+// canonical JSON encoding of protobuf messages. Unlike renderTapEventJSON, it
+// reflects every field of the event as defined in the proto.
+func renderTapEventProtoJSON(event *pb.TapEvent, _ string, options *tapOptions) string {
+	m := jsonpb.Marshaler{EmitDefaults: true, Indent: "  "}
+	e, err := m.MarshalToString(event)
+	if err != nil {
+		return fmt.Sprintf("{\"error marshalling JSON\": \"%s\"}", err)
+	}
+	if options.color {
+		return colorizeJSON([]byte(e))
+	}
+	return e
+}
+
+// mapTapEventWithOptions maps a public API `TapEvent` to a `tapEvent`,
+// including the optional fields enabled by the tap options.
+func mapTapEventWithOptions(event *pb.TapEvent, options *tapOptions) *tapEvent {
+	m := mapPublicToDisplayTapEvent(event)
+	if options.showVersion && m.RequestInitEvent != nil {
+		m.RequestInitEvent.Version = httpVersion(event.GetHttp().GetRequestInit())
+	}
+	if options.traceIDFrom != "" {
+		m.TraceID = traceID(event, options.traceIDFrom)
+	}
+	if options.showReporter {
+		m.Reporter = reporter(event)
+	}
+	if options.topLevelStreamID {
+		if id := eventStreamID(event); id != nil {
+			base, stream := id.GetBase(), id.GetStream()
+			m.StreamBase = &base
+			m.StreamNumber = &stream
+		}
+	}
+	if options.firstByteLatency && m.ResponseInitEvent != nil {
+		ttfb := durationMicros(event.GetHttp().GetResponseInit().GetSinceRequestInit())
+		m.ResponseInitEvent.TTFBMicros = &ttfb
+	}
+	if options.durationsAsMicros {
+		microsOf := func(d *duration.Duration) *int64 {
+			if d == nil {
+				return nil
+			}
+			micros := durationMicros(d)
+			return &micros
+		}
+		if m.ResponseInitEvent != nil {
+			m.ResponseInitEvent.SinceRequestInitMicros = microsOf(m.ResponseInitEvent.SinceRequestInit)
+		}
+		if m.ResponseEndEvent != nil {
+			m.ResponseEndEvent.SinceRequestInitMicros = microsOf(m.ResponseEndEvent.SinceRequestInit)
+			m.ResponseEndEvent.SinceResponseInitMicros = microsOf(m.ResponseEndEvent.SinceResponseInit)
+		}
+	}
+	if options.contextPathRewrite != nil && m.RequestInitEvent != nil {
+		m.RequestInitEvent.RawPath = m.RequestInitEvent.Path
+		m.RequestInitEvent.Path = options.contextPathRewrite.rewrite(m.RequestInitEvent.Path)
+	}
+	if options.showRequestBytes && m.RequestInitEvent != nil {
+		if size, ok := requestBytes(event.GetHttp().GetRequestInit()); ok {
+			m.RequestInitEvent.RequestBytes = &size
+		}
+	}
+	if options.resolveGRPCMethod && m.RequestInitEvent != nil {
+		service, method, _ := parseGRPCPath(event.GetHttp().GetRequestInit().GetPath())
+		m.RequestInitEvent.GRPCService = service
+		m.RequestInitEvent.GRPCMethod = method
+	}
+	if m.RequestInitEvent != nil {
+		m.RequestInitEvent.Authority = options.displayString(m.RequestInitEvent.Authority)
+		m.RequestInitEvent.Path = options.displayString(m.RequestInitEvent.Path)
+		m.RequestInitEvent.RawPath = options.displayString(m.RequestInitEvent.RawPath)
+	}
+	return m
+}
+
 // Map public API `TapEvent`s to `displayTapEvent`s
 func mapPublicToDisplayTapEvent(event *pb.TapEvent) *tapEvent {
 	// Map source endpoint
@@ -484,6 +2202,39 @@ func getResponseEndEvent(pubEv *pb.TapEvent_Http) *responseEndEvent {
 	}
 }
 
+// httpVersion infers the HTTP version of a request from its headers. HTTP/1.1
+// requests must carry a `host` header, whereas HTTP/2 requests (including all
+// gRPC requests) use the `:authority` pseudo-header instead. If no headers were
+// extracted, the version is reported as unknown.
+func httpVersion(reqI *pb.TapEvent_Http_RequestInit) string {
+	headers := reqI.GetHeaders().GetHeaders()
+	if len(headers) == 0 {
+		return httpVersionUnknown
+	}
+
+	if hasGRPCContentType(reqI.GetHeaders()) {
+		return httpVersion2
+	}
+	for _, h := range headers {
+		if strings.ToLower(h.GetName()) == "host" {
+			return httpVersion11
+		}
+	}
+	return httpVersion2
+}
+
+// requestBytes returns the size of a request's body, as declared by its
+// `content-length` header. Tap events don't otherwise report it.
+func requestBytes(reqI *pb.TapEvent_Http_RequestInit) (uint64, bool) {
+	for _, h := range reqI.GetHeaders().GetHeaders() {
+		if strings.ToLower(h.GetName()) == "content-length" {
+			size, err := strconv.ParseUint(strings.TrimSpace(h.GetValueStr()), 10, 64)
+			return size, err == nil
+		}
+	}
+	return 0, false
+}
+
 func formatHeadersTrailers(hs *pb.Headers) []metadata {
 	var fm []metadata
 	for _, h := range hs.GetHeaders() {
@@ -538,21 +2289,15 @@ func (p *peer) formatAddr() string {
 // formatResource returns a label describing what Kubernetes resources the peer
 // belongs to. If the peer belongs to a resource of kind `resourceKind`, it will
 // return a label for that resource; otherwise, it will fall back to the peer's
-// pod name. Additionally, if the resource is not of type `namespace`, it will
+// pod name, unless strict is set, in which case the resource is rendered as
+// `<none>`. Additionally, if the resource is not of type `namespace`, it will
 // also add a label describing the peer's resource.
-func (p *peer) formatResource(resourceKind string) string {
+func (p *peer) formatResource(resourceKind string, strict bool) string {
 	var s string
-	if resourceName, exists := p.labels[resourceKind]; exists {
-		kind := resourceKind
-		if short := k8s.ShortNameFromCanonicalResourceName(resourceKind); short != "" {
-			kind = short
-		}
-		s = fmt.Sprintf(
-			" %s_res=%s/%s",
-			p.direction,
-			kind,
-			resourceName,
-		)
+	if name, exists := p.resourceName(resourceKind); exists {
+		s = fmt.Sprintf(" %s_res=%s", p.direction, name)
+	} else if strict {
+		s = fmt.Sprintf(" %s_res=<none>", p.direction)
 	} else if pod, hasPod := p.labels[k8s.Pod]; hasPod {
 		s = fmt.Sprintf(" %s_pod=%s", p.direction, pod)
 	}
@@ -564,10 +2309,120 @@ func (p *peer) formatResource(resourceKind string) string {
 	return s
 }
 
+// resourceName returns the TYPE/NAME of the resource of kind `resourceKind`
+// the peer belongs to, with the short name of the kind, if it belongs to one.
+func (p *peer) resourceName(resourceKind string) (string, bool) {
+	name, exists := p.labels[resourceKind]
+	if !exists {
+		return "", false
+	}
+	kind := resourceKind
+	if short := k8s.ShortNameFromCanonicalResourceName(resourceKind); short != "" {
+		kind = short
+	}
+	return fmt.Sprintf("%s/%s", kind, name), true
+}
+
+// matchesResource returns true if the peer belongs to the given resource. If
+// the resource has no name, belonging to any resource of its type matches.
+// The peer's namespace is only checked if the resource has one.
+func (p *peer) matchesResource(res *pb.Resource) bool {
+	name, exists := p.labels[res.GetType()]
+	if !exists || (res.GetName() != "" && res.GetName() != name) {
+		return false
+	}
+	return res.GetNamespace() == "" || res.GetNamespace() == p.labels[k8s.Namespace]
+}
+
 func (p *peer) tlsStatus() string {
 	return p.labels["tls"]
 }
 
+// identity returns the service account based identity of a peer, or "-" if
+// it isn't known to have one: the peers of connections that aren't mTLS'd, as
+// told by tls, aren't authenticated.
+func (p *peer) identity(tls string) string {
+	sa, hasSA := p.labels["serviceaccount"]
+	ns, hasNs := p.labels[k8s.Namespace]
+	if tls != "true" || !hasSA || !hasNs {
+		return "-"
+	}
+	return fmt.Sprintf("%s.%s.serviceaccount", sa, ns)
+}
+
+// durationMicros returns a duration in microseconds, accounting for both its
+// seconds and nanoseconds, or 0 if it's invalid.
+func durationMicros(d *duration.Duration) int64 {
+	td, err := ptypes.Duration(d)
+	if err != nil {
+		return 0
+	}
+	return int64(td / time.Microsecond)
+}
+
+// tlsStatus returns the TLS status of the connection an event was observed
+// on, as reported by the meshed end of the connection.
+func tlsStatus(event *pb.TapEvent) string {
+	p, ok := tlsPeer(event)
+	if !ok {
+		// Too old for TLS.
+		return ""
+	}
+	return p.tlsStatus()
+}
+
+// tlsPeer returns the peer the proxy that reported an event reports the TLS
+// status of: the source of inbound events and the destination of outbound
+// ones. It returns false if the direction is unknown.
+func tlsPeer(event *pb.TapEvent) (peer, bool) {
+	switch event.GetProxyDirection() {
+	case pb.TapEvent_INBOUND:
+		return src(event), true
+	case pb.TapEvent_OUTBOUND:
+		return dst(event), true
+	default:
+		return peer{}, false
+	}
+}
+
+// unmeshedPeer returns the pod/namespace, or IP if its pod is unknown, of the
+// peer of an event whose connection isn't mTLS'd, for `--warn-unmeshed`. It
+// returns false if the connection is mTLS'd or its direction is unknown.
+func unmeshedPeer(event *pb.TapEvent) (string, bool) {
+	p, ok := tlsPeer(event)
+	if !ok || p.tlsStatus() == "true" {
+		return "", false
+	}
+	if pod := p.labels[k8s.Pod]; pod != "" {
+		if ns := p.labels[k8s.Namespace]; ns != "" {
+			return pod + "/" + ns, true
+		}
+		return pod, true
+	}
+	return addr.PublicIPToString(p.address.GetIp()), true
+}
+
+// reporter returns the pod/namespace of the proxy that reported an event: the
+// source of outbound events and the destination of inbound ones. It returns
+// "" if the direction or the pod is unknown.
+func reporter(event *pb.TapEvent) string {
+	var labels map[string]string
+	switch event.GetProxyDirection() {
+	case pb.TapEvent_INBOUND:
+		labels = event.GetDestinationMeta().GetLabels()
+	case pb.TapEvent_OUTBOUND:
+		labels = event.GetSourceMeta().GetLabels()
+	}
+	pod := labels[k8s.Pod]
+	if pod == "" {
+		return ""
+	}
+	if ns := labels[k8s.Namespace]; ns != "" {
+		return pod + "/" + ns
+	}
+	return pod
+}
+
 func routeLabels(event *pb.TapEvent) string {
 	out := ""
 	for key, val := range event.GetRouteMeta().GetLabels() {
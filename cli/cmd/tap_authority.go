@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// clusterDomain is the domain cluster DNS names are canonicalized under by
+// `--authority-canonical=auto`.
+const clusterDomain = "cluster.local"
+
+const (
+	// shortAuthoritySvc is the `--short-authority` value stripping the
+	// `.svc.cluster.local` suffix of displayed authorities.
+	shortAuthoritySvc = "svc"
+	// shortAuthorityNamespace is the `--short-authority` value stripping
+	// their namespace as well.
+	shortAuthorityNamespace = "namespace"
+)
+
+// authorityCanonicalizer maps the authorities a backend is reached under,
+// such as its IP, cluster DNS names or an external DNS name, to a single
+// canonical one, so that they're displayed and deduplicated as one.
+type authorityCanonicalizer struct {
+	// auto canonicalizes the short forms of cluster DNS names, e.g.
+	// `books.default` and `books.default.svc`, to their fully qualified form.
+	auto bool
+	// rules maps authorities, or hosts regardless of their port, to their
+	// canonical form.
+	rules map[string]string
+}
+
+// parseAuthorityCanonical parses an `--authority-canonical` value: a
+// comma-separated list of `FROM=TO` rules, optionally including `auto`. An
+// empty string yields no canonicalization.
+func parseAuthorityCanonical(value string) (*authorityCanonicalizer, error) {
+	if value == "" {
+		return nil, nil
+	}
+	c := &authorityCanonicalizer{rules: make(map[string]string)}
+	for _, rule := range strings.Split(value, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "auto" {
+			c.auto = true
+			continue
+		}
+		parts := strings.SplitN(rule, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("expected auto or FROM=TO, got %q", rule)
+		}
+		c.rules[parts[0]] = parts[1]
+	}
+	return c, nil
+}
+
+// canonical returns the authority to display. A rule matching the whole
+// authority takes precedence over one matching its host, in which case the
+// port is kept.
+func (c *authorityCanonicalizer) canonical(authority string) string {
+	if c == nil {
+		return authority
+	}
+	if to, ok := c.rules[authority]; ok {
+		return to
+	}
+
+	host, port, err := net.SplitHostPort(authority)
+	if err != nil {
+		host, port = authority, ""
+	}
+	if to, ok := c.rules[host]; ok {
+		host = to
+	} else if c.auto {
+		host = canonicalClusterHost(host)
+	}
+	if port == "" {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// canonicalClusterHost qualifies `SERVICE.NAMESPACE` and
+// `SERVICE.NAMESPACE.svc` with the cluster domain. Other hosts, including
+// bare service names whose namespace isn't known, are returned as is.
+func canonicalClusterHost(host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	labels := strings.Split(strings.TrimSuffix(host, "."), ".")
+	switch {
+	case len(labels) == 2:
+		return host + ".svc." + clusterDomain
+	case len(labels) == 3 && labels[2] == "svc":
+		return host + "." + clusterDomain
+	}
+	return host
+}
+
+// shortenAuthority returns the authority to display under `--short-authority`
+// mode: the host of cluster DNS names is stripped of `.svc.cluster.local`,
+// e.g. `books.default:7000`, and of its namespace as well in namespace mode,
+// e.g. `books:7000`. Other authorities, including IPs and external names, are
+// returned as is.
+func shortenAuthority(authority, mode string) string {
+	if mode == "" {
+		return authority
+	}
+	host, port, err := net.SplitHostPort(authority)
+	if err != nil {
+		host, port = authority, ""
+	}
+	short := strings.TrimSuffix(strings.TrimSuffix(host, "."), ".svc."+clusterDomain)
+	if short == strings.TrimSuffix(host, ".") || !strings.Contains(short, ".") {
+		return authority
+	}
+	if mode == shortAuthorityNamespace {
+		short = short[:strings.LastIndex(short, ".")]
+	}
+	if port == "" {
+		return short
+	}
+	return net.JoinHostPort(short, port)
+}
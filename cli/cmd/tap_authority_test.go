@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+func TestAuthorityCanonical(t *testing.T) {
+	t.Run("Canonicalizes authorities", func(t *testing.T) {
+		rules := "auto,10.3.2.1=books.default.svc.cluster.local,books.example.com:443=books.default.svc.cluster.local:7000"
+		testCases := []struct {
+			rules     string
+			authority string
+			expected  string
+		}{
+			{"", "books.default:7000", "books.default:7000"},
+			// Every form the books service can be reached under maps to
+			// the same authority.
+			{rules, "books.default.svc.cluster.local:7000", "books.default.svc.cluster.local:7000"},
+			{rules, "books.default.svc:7000", "books.default.svc.cluster.local:7000"},
+			{rules, "books.default:7000", "books.default.svc.cluster.local:7000"},
+			{rules, "10.3.2.1:7000", "books.default.svc.cluster.local:7000"},
+			{rules, "books.example.com:443", "books.default.svc.cluster.local:7000"},
+			{rules, "books.default", "books.default.svc.cluster.local"},
+			// Rules on a whole authority don't apply to other ports.
+			{rules, "books.example.com:80", "books.example.com:80"},
+			{rules, "books:7000", "books:7000"},
+			{rules, "10.3.2.2:7000", "10.3.2.2:7000"},
+			{rules, "[fd00::1]:7000", "[fd00::1]:7000"},
+			{"books.default=books.default.svc.cluster.local", "books.default.svc:7000", "books.default.svc:7000"},
+		}
+		for i, tc := range testCases {
+			tc := tc // pin
+			t.Run(fmt.Sprintf("%d: %s", i, tc.authority), func(t *testing.T) {
+				c, err := parseAuthorityCanonical(tc.rules)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if authority := c.canonical(tc.authority); authority != tc.expected {
+					t.Fatalf("Expecting authority [%s], got [%s]", tc.expected, authority)
+				}
+			})
+		}
+	})
+
+	t.Run("Rejects invalid rules", func(t *testing.T) {
+		for _, rules := range []string{"books", "=books", "books=", "auto,books"} {
+			if _, err := parseAuthorityCanonical(rules); err == nil {
+				t.Fatalf("Expecting an error for [%s]", rules)
+			}
+		}
+	})
+
+	t.Run("Displays and deduplicates canonical authorities", func(t *testing.T) {
+		var events []*pb.TapEvent
+		for i, authority := range []string{"books.default:7000", "books.default.svc.cluster.local:7000"} {
+			stream := uint64(i + 1)
+			req := tapTestRequest(stream, pb.HttpMethod_GET, "/books")
+			req.GetHttp().GetRequestInit().Authority = authority
+			events = append(events,
+				req,
+				tapTestResponse(stream, http.StatusOK, &duration.Duration{Nanos: 1000}),
+				tapTestEnd(stream, &pb.Eos{}, 10),
+			)
+		}
+
+		options := newTapOptions()
+		options.authorityCanonical = "auto"
+		options.dedup = true
+		if err := options.validate(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		output := renderTestTapEvents(t, options, events...)
+		if strings.Count(output, ":authority=books.default.svc.cluster.local:7000") != 1 {
+			t.Fatalf("Expecting requests to be collapsed under the canonical authority, got [%s]", output)
+		}
+
+		m := mapTapEventWithOptions(events[0], options)
+		if m.RequestInitEvent.Authority != "books.default:7000" {
+			t.Fatalf("Expecting the raw authority in JSON, got [%s]", m.RequestInitEvent.Authority)
+		}
+	})
+}
+
+func TestShortAuthority(t *testing.T) {
+	t.Run("Shortens cluster DNS names", func(t *testing.T) {
+		testCases := []struct {
+			mode      string
+			authority string
+			expected  string
+		}{
+			{"", "books.default.svc.cluster.local:7000", "books.default.svc.cluster.local:7000"},
+			{shortAuthoritySvc, "books.default.svc.cluster.local:7000", "books.default:7000"},
+			{shortAuthoritySvc, "books.default.svc.cluster.local.:7000", "books.default:7000"},
+			{shortAuthoritySvc, "books.default.svc.cluster.local", "books.default"},
+			{shortAuthoritySvc, "books-0.books.default.svc.cluster.local:7000", "books-0.books.default:7000"},
+			{shortAuthorityNamespace, "books.default.svc.cluster.local:7000", "books:7000"},
+			{shortAuthorityNamespace, "books-0.books.default.svc.cluster.local:7000", "books-0.books:7000"},
+			// Other authorities are left untouched.
+			{shortAuthorityNamespace, "books.default:7000", "books.default:7000"},
+			{shortAuthorityNamespace, "books.example.com:443", "books.example.com:443"},
+			{shortAuthorityNamespace, "10-1-2-3.default.pod.cluster.local:7000", "10-1-2-3.default.pod.cluster.local:7000"},
+			{shortAuthorityNamespace, "10.3.2.1:7000", "10.3.2.1:7000"},
+			{shortAuthorityNamespace, "[fd00::1]:7000", "[fd00::1]:7000"},
+		}
+		for i, tc := range testCases {
+			tc := tc // pin
+			t.Run(fmt.Sprintf("%d: %s", i, tc.authority), func(t *testing.T) {
+				if authority := shortenAuthority(tc.authority, tc.mode); authority != tc.expected {
+					t.Fatalf("Expecting authority [%s], got [%s]", tc.expected, authority)
+				}
+			})
+		}
+	})
+
+	t.Run("Rejects invalid modes", func(t *testing.T) {
+		options := newTapOptions()
+		options.shortAuthority = "cluster"
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+
+	t.Run("Displays short authorities", func(t *testing.T) {
+		req := tapTestRequest(1, pb.HttpMethod_GET, "/books")
+		req.GetHttp().GetRequestInit().Authority = "books.default.svc.cluster.local:7000"
+
+		options := newTapOptions()
+		options.shortAuthority = shortAuthoritySvc
+		if err := options.validate(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		output := renderTestTapEvents(t, options, req)
+		if !strings.Contains(output, ":authority=books.default:7000 ") {
+			t.Fatalf("Expecting the short authority to be displayed, got [%s]", output)
+		}
+
+		m := mapTapEventWithOptions(req, options)
+		if m.RequestInitEvent.Authority != "books.default.svc.cluster.local:7000" {
+			t.Fatalf("Expecting the raw authority in JSON, got [%s]", m.RequestInitEvent.Authority)
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+// successTallyInterval is how often `--collapse-success` writes the number of
+// successful transactions it suppressed.
+const successTallyInterval = 10 * time.Second
+
+// successTally counts the successful transactions hidden by
+// `--collapse-success`, which are tallied on every tick and once the stream
+// ends.
+type successTally struct {
+	sync.Mutex
+	// suppressed counts the transactions hidden since the last tally.
+	suppressed uint64
+}
+
+// classify keeps the streams classifyErrors keeps, and counts the others.
+func (t *successTally) classify(events []*pb.TapEvent) (bool, bool) {
+	keep, decided := classifyErrors(events)
+	if decided && !keep {
+		t.Lock()
+		t.suppressed++
+		t.Unlock()
+	}
+	return keep, decided
+}
+
+// write renders the number of transactions suppressed since the last tally,
+// e.g. `(12 successful requests suppressed)`, unless there are none.
+func (t *successTally) write(w io.Writer) error {
+	t.Lock()
+	suppressed := t.suppressed
+	t.suppressed = 0
+	t.Unlock()
+
+	if suppressed == 0 {
+		return nil
+	}
+	requests := "requests"
+	if suppressed == 1 {
+		requests = "request"
+	}
+	_, err := fmt.Fprintf(w, "(%d successful %s suppressed)\n", suppressed, requests)
+	return err
+}
+
+// start writes the tally to w on every tick, until the returned function is
+// called.
+func (t *successTally) start(w io.Writer) (stop func()) {
+	return writeOnTicks(successTallyInterval, func() error { return t.write(w) })
+}
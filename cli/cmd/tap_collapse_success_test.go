@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+func TestRenderTapEventsCollapseSuccess(t *testing.T) {
+	transaction := func(stream uint64, status uint32) []*pb.TapEvent {
+		return []*pb.TapEvent{
+			tapTestRequest(stream, pb.HttpMethod_GET, "/books"),
+			tapTestResponse(stream, status, &duration.Duration{Nanos: 1000}),
+			tapTestEnd(stream, &pb.Eos{}, 0),
+		}
+	}
+
+	t.Run("Tallies suppressed successes once the stream ends", func(t *testing.T) {
+		var events []*pb.TapEvent
+		events = append(events, transaction(1, http.StatusOK)...)
+		events = append(events, transaction(2, http.StatusServiceUnavailable)...)
+		events = append(events, transaction(3, http.StatusNoContent)...)
+		events = append(events, transaction(4, http.StatusOK)...)
+
+		options := newTapOptions()
+		options.collapseSuccess = true
+		if err := options.validate(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		output := renderTestTapEvents(t, options, events...)
+		ids := renderedIDs(output)
+		expectedIDs := []string{"req id=7:2", "rsp id=7:2", "end id=7:2", "(3 successful"}
+		if fmt.Sprint(ids) != fmt.Sprint(expectedIDs) {
+			t.Fatalf("Expecting %v, got %v", expectedIDs, ids)
+		}
+		expected := "(3 successful requests suppressed)\n"
+		if output[len(output)-len(expected):] != expected {
+			t.Fatalf("Expecting output to end with [%s], got [%s]", expected, output)
+		}
+	})
+
+	t.Run("Tallies suppressed successes on every tick", func(t *testing.T) {
+		ticks := make(chan time.Time)
+		defer func(newTicker func(time.Duration) (<-chan time.Time, func())) {
+			newSummaryTicker = newTicker
+		}(newSummaryTicker)
+		newSummaryTicker = func(time.Duration) (<-chan time.Time, func()) {
+			return ticks, func() {}
+		}
+
+		encode := func(events ...*pb.TapEvent) []byte {
+			stream, err := ioutil.ReadAll(tapEventStream(t, events...))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			return stream
+		}
+		input, inputWriter := io.Pipe()
+		output, outputWriter := io.Pipe()
+		go func() {
+			options := newTapOptions()
+			options.collapseSuccess = true
+			err := options.validate()
+			if err == nil {
+				err = renderTapEvents(bufio.NewReader(input), outputWriter, renderTapEvent, "", options)
+			}
+			outputWriter.CloseWithError(err)
+		}()
+
+		lines := bufio.NewScanner(output)
+		expectLine := func(expected string) {
+			if !lines.Scan() {
+				t.Fatalf("Expecting [%s], got the end of the output: %v", expected, lines.Err())
+			}
+			if line := lines.Text(); line != expected {
+				t.Fatalf("Expecting [%s], got [%s]", expected, line)
+			}
+		}
+
+		// The failed transaction is rendered once the successful ones
+		// before it were suppressed.
+		var events []*pb.TapEvent
+		events = append(events, transaction(1, http.StatusOK)...)
+		events = append(events, transaction(2, http.StatusOK)...)
+		events = append(events, transaction(3, http.StatusInternalServerError)...)
+		go inputWriter.Write(encode(events...))
+		for i := 0; i < 3; i++ {
+			if !lines.Scan() {
+				t.Fatalf("Expecting the events of stream 3, got the end of the output: %v", lines.Err())
+			}
+		}
+		ticks <- time.Now()
+		expectLine("(2 successful requests suppressed)")
+
+		// Only the successes suppressed since the last tally are counted.
+		go func() {
+			inputWriter.Write(encode(transaction(4, http.StatusOK)...))
+			inputWriter.Close()
+		}()
+		expectLine("(1 successful request suppressed)")
+		if lines.Scan() {
+			t.Fatalf("Expecting the output to end, got [%s]", lines.Text())
+		}
+		if err := lines.Err(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Rejects --collapse-success with JSON output", func(t *testing.T) {
+		options := newTapOptions()
+		options.collapseSuccess = true
+		options.output = jsonOutput
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
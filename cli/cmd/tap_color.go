@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"google.golang.org/grpc/codes"
+)
+
+// Colors used when syntax highlighting JSON. Whether to colorize is decided
+// by the `--color` flag and whether stdout is a terminal, so these ignore
+// `color.NoColor`.
+var (
+	jsonKeyColor     = newTapColor(color.FgBlue, color.Bold)
+	jsonStringColor  = newTapColor(color.FgGreen)
+	jsonNumberColor  = newTapColor(color.FgCyan)
+	jsonLiteralColor = newTapColor(color.FgMagenta)
+)
+
+// highlightColor marks the matches of `--highlight`.
+var highlightColor = newTapColor(color.FgBlack, color.BgYellow)
+
+func newTapColor(value ...color.Attribute) *color.Color {
+	c := color.New(value...)
+	c.EnableColor()
+	return c
+}
+
+// colorizeJSON syntax highlights a JSON document, such as the output of
+// `json.MarshalIndent`. Only tokens are wrapped in escape sequences, so
+// stripping them yields the original document.
+func colorizeJSON(in []byte) string {
+	var out strings.Builder
+	for i := 0; i < len(in); {
+		switch c := in[i]; {
+		case c == '"':
+			end := endOfJSONString(in, i)
+			next := end
+			for next < len(in) && strings.IndexByte(" \t\r\n", in[next]) >= 0 {
+				next++
+			}
+			if next < len(in) && in[next] == ':' {
+				out.WriteString(jsonKeyColor.Sprint(string(in[i:end])))
+			} else {
+				out.WriteString(jsonStringColor.Sprint(string(in[i:end])))
+			}
+			i = end
+
+		case c == '-' || (c >= '0' && c <= '9'):
+			end := i
+			for end < len(in) && strings.IndexByte("+-.0123456789eE", in[end]) >= 0 {
+				end++
+			}
+			out.WriteString(jsonNumberColor.Sprint(string(in[i:end])))
+			i = end
+
+		case c >= 'a' && c <= 'z':
+			// true, false or null
+			end := i
+			for end < len(in) && in[end] >= 'a' && in[end] <= 'z' {
+				end++
+			}
+			out.WriteString(jsonLiteralColor.Sprint(string(in[i:end])))
+			i = end
+
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return out.String()
+}
+
+// endOfJSONString returns the index just past the closing quote of the JSON
+// string starting at in[start].
+func endOfJSONString(in []byte, start int) int {
+	for i := start + 1; i < len(in); i++ {
+		switch in[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return len(in)
+}
+
+// parseHighlight compiles a `--highlight` pattern. A pattern that isn't a valid
+// regular expression, such as "/books(", matches as a substring.
+func parseHighlight(pattern string) *regexp.Regexp {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		re = regexp.MustCompile(regexp.QuoteMeta(pattern))
+	}
+	return re
+}
+
+// highlighted wraps the matches of `--highlight` in s with highlightColor,
+// when colors are on.
+func (o *tapOptions) highlighted(s string) string {
+	if o.highlightRE == nil || !o.color {
+		return s
+	}
+	return o.highlightRE.ReplaceAllStringFunc(s, func(match string) string {
+		if match == "" {
+			return match
+		}
+		return highlightColor.Sprint(match)
+	})
+}
+
+// statusColorClasses lists the status classes `--colors` can color: response
+// lines by the class of their HTTP status, and end lines with a gRPC status
+// other than OK.
+var statusColorClasses = []string{"1xx", "2xx", "3xx", "4xx", "5xx", "grpc-err"}
+
+// namedColors are the colors `--colors` accepts.
+var namedColors = map[string]color.Attribute{
+	"black":   color.FgBlack,
+	"red":     color.FgRed,
+	"green":   color.FgGreen,
+	"yellow":  color.FgYellow,
+	"blue":    color.FgBlue,
+	"magenta": color.FgMagenta,
+	"cyan":    color.FgCyan,
+	"white":   color.FgWhite,
+}
+
+func colorNames() []string {
+	names := make([]string, 0, len(namedColors))
+	for name := range namedColors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseStatusColors parses a `--colors` value, such as
+// `2xx=green,5xx=red,grpc-err=magenta`, into the color of each status class.
+func parseStatusColors(s string) (map[string]*color.Color, error) {
+	if s == "" {
+		return nil, nil
+	}
+	colors := map[string]*color.Color{}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("expected CLASS=COLOR, got %q", pair)
+		}
+		class, name := strings.TrimSpace(kv[0]), strings.ToLower(strings.TrimSpace(kv[1]))
+		if !isStatusColorClass(class) {
+			return nil, fmt.Errorf("unknown status class %q; must be one of: %s", class, strings.Join(statusColorClasses, ", "))
+		}
+		attr, ok := namedColors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown color %q; must be one of: %s", name, strings.Join(colorNames(), ", "))
+		}
+		colors[class] = newTapColor(attr)
+	}
+	return colors, nil
+}
+
+func isStatusColorClass(class string) bool {
+	for _, c := range statusColorClasses {
+		if class == c {
+			return true
+		}
+	}
+	return false
+}
+
+// statusColorClass returns the `--colors` class of an event, or "" if it has
+// none.
+func statusColorClass(event *pb.TapEvent) string {
+	switch ev := event.GetHttp().GetEvent().(type) {
+	case *pb.TapEvent_Http_ResponseInit_:
+		if status := ev.ResponseInit.GetHttpStatus(); status >= 100 && status < 600 {
+			return fmt.Sprintf("%dxx", status/100)
+		}
+	case *pb.TapEvent_Http_ResponseEnd_:
+		if eos, ok := ev.ResponseEnd.GetEos().GetEnd().(*pb.Eos_GrpcStatusCode); ok && codes.Code(eos.GrpcStatusCode) != codes.OK {
+			return "grpc-err"
+		}
+	}
+	return ""
+}
+
+// statusColored wraps the rendered line of an event in the color `--colors`
+// assigns to its status class, when colors are on.
+func (o *tapOptions) statusColored(event *pb.TapEvent, line string) string {
+	if !o.color || o.statusColors == nil {
+		return line
+	}
+	if c, ok := o.statusColors[statusColorClass(event)]; ok {
+		return c.Sprint(line)
+	}
+	return line
+}
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"google.golang.org/grpc/codes"
+)
+
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+func TestColorizeJSON(t *testing.T) {
+	t.Run("Preserves the document when escapes are stripped", func(t *testing.T) {
+		in := `{
+  "key": "value with \"quotes\": and colons",
+  "number": -1.5e3,
+  "list": [true, false, null],
+  "nested": {"empty": ""}
+}`
+		output := colorizeJSON([]byte(in))
+		if !ansiEscape.MatchString(output) {
+			t.Fatalf("Expecting output to be colorized, got [%s]", output)
+		}
+		if stripped := ansiEscape.ReplaceAllString(output, ""); stripped != in {
+			t.Fatalf("Expecting stripped output to be [%s], got [%s]", in, stripped)
+		}
+	})
+
+	t.Run("Colors keys differently from string values", func(t *testing.T) {
+		output := colorizeJSON([]byte(`{"key": "value"}`))
+		expectedKey := jsonKeyColor.Sprint(`"key"`)
+		expectedValue := jsonStringColor.Sprint(`"value"`)
+		if !strings.Contains(output, expectedKey) || !strings.Contains(output, expectedValue) {
+			t.Fatalf("Expecting output to contain [%q] and [%q], got [%q]", expectedKey, expectedValue, output)
+		}
+	})
+}
+
+func TestRenderTapEventJSONColor(t *testing.T) {
+	event := tapTestResponse(1, 200, &duration.Duration{Nanos: 1000})
+	event.ProxyDirection = pb.TapEvent_INBOUND
+
+	options := newTapOptions()
+	output := renderTapEventJSON(event, "", options)
+	if ansiEscape.MatchString(output) {
+		t.Fatalf("Expecting no escapes when color is disabled, got [%q]", output)
+	}
+	if !json.Valid([]byte(output)) {
+		t.Fatalf("Expecting valid JSON, got [%s]", output)
+	}
+
+	options.color = true
+	colored := renderTapEventJSON(event, "", options)
+	if !ansiEscape.MatchString(colored) {
+		t.Fatalf("Expecting escapes when color is enabled, got [%q]", colored)
+	}
+	if stripped := ansiEscape.ReplaceAllString(colored, ""); stripped != output {
+		t.Fatalf("Expecting stripped output to be [%s], got [%s]", output, stripped)
+	}
+}
+
+func TestRenderTapEventHighlight(t *testing.T) {
+	event := tapTestRequest(1, pb.HttpMethod_GET, "/books/1/books(2)")
+
+	testCases := []struct {
+		highlight string
+		expected  string
+	}{
+		{"books", fmt.Sprintf(":authority=%s.default:7000 :path=/%s/1/%s(2)",
+			highlightColor.Sprint("books"), highlightColor.Sprint("books"), highlightColor.Sprint("books"))},
+		{"/[0-9]+", fmt.Sprintf(":path=/books%s/books(2)", highlightColor.Sprint("/1"))},
+		// Invalid regular expressions match as substrings.
+		{"s(2", fmt.Sprintf(":path=/books/1/book%s)", highlightColor.Sprint("s(2"))},
+		{"default:7", fmt.Sprintf(":authority=books.%s000", highlightColor.Sprint("default:7"))},
+	}
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(tc.highlight, func(t *testing.T) {
+			options := newTapOptions()
+			options.highlight = tc.highlight
+			options.color = true
+			if err := options.validate(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			output := renderTapEvent(event, "", options)
+			if !strings.Contains(output, tc.expected) {
+				t.Fatalf("Expecting output to contain [%q], got [%q]", tc.expected, output)
+			}
+
+			options.color = false
+			output = renderTapEvent(event, "", options)
+			if ansiEscape.MatchString(output) {
+				t.Fatalf("Expecting no escapes when color is disabled, got [%q]", output)
+			}
+		})
+	}
+
+	t.Run("Rejects --highlight with JSON output", func(t *testing.T) {
+		options := newTapOptions()
+		options.highlight = "books"
+		options.output = jsonOutput
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+func TestRenderTapEventsStatusColors(t *testing.T) {
+	grpcEnd := func(code codes.Code) *pb.Eos {
+		return &pb.Eos{End: &pb.Eos_GrpcStatusCode{GrpcStatusCode: uint32(code)}}
+	}
+	latency := &duration.Duration{Nanos: 1000}
+	events := []*pb.TapEvent{
+		tapTestRequest(1, pb.HttpMethod_GET, "/books"),
+		tapTestResponse(1, http.StatusOK, latency),
+		tapTestEnd(1, grpcEnd(codes.OK), 0),
+		tapTestRequest(2, pb.HttpMethod_GET, "/books"),
+		tapTestResponse(2, http.StatusServiceUnavailable, latency),
+		tapTestEnd(2, grpcEnd(codes.Unavailable), 0),
+		tapTestRequest(3, pb.HttpMethod_GET, "/books"),
+		tapTestResponse(3, http.StatusNotFound, latency),
+		tapTestEnd(3, &pb.Eos{}, 0),
+	}
+
+	options := newTapOptions()
+	options.colors = "2xx=green, 5xx=Red,grpc-err=magenta"
+	options.color = true
+	if err := options.validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(renderTestTapEvents(t, options, events...), "\n"), "\n")
+
+	plain := newTapOptions()
+	expectedColors := []*color.Color{
+		nil, newTapColor(color.FgGreen), nil,
+		nil, newTapColor(color.FgRed), newTapColor(color.FgMagenta),
+		// 4xx isn't colored.
+		nil, nil, nil,
+	}
+	for i, event := range events {
+		expected := renderTapEvent(event, "", plain)
+		if c := expectedColors[i]; c != nil {
+			expected = c.Sprint(expected)
+		}
+		if lines[i] != expected {
+			t.Fatalf("Line %d: expecting [%q], got [%q]", i, expected, lines[i])
+		}
+	}
+
+	t.Run("Doesn't color lines when color is off", func(t *testing.T) {
+		options.color = false
+		if output := renderTestTapEvents(t, options, events...); ansiEscape.MatchString(output) {
+			t.Fatalf("Expecting no escapes when color is disabled, got [%q]", output)
+		}
+	})
+
+	t.Run("Rejects invalid colors", func(t *testing.T) {
+		for _, colors := range []string{"2xx", "6xx=red", "2xx=pink", "grpc=red"} {
+			options := newTapOptions()
+			options.colors = colors
+			if err := options.validate(); err == nil {
+				t.Fatalf("Expected error for %q, got nothing", colors)
+			}
+		}
+	})
+}
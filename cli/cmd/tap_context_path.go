@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"errors"
+	"strings"
+)
+
+// contextPath rewrites the paths displayed by tap, for services that are
+// reached under a path prefix, e.g. one stripped by an ingress.
+type contextPath struct {
+	prefix string
+	add    bool
+}
+
+// parseContextPath parses a `--context-path` value: a prefix that's stripped
+// from displayed paths, or added to them if preceded by a `+`. An empty
+// string yields no rewrite.
+func parseContextPath(value string) (*contextPath, error) {
+	if value == "" {
+		return nil, nil
+	}
+	cp := &contextPath{prefix: value}
+	if strings.HasPrefix(value, "+") {
+		cp.prefix = value[1:]
+		cp.add = true
+	}
+	if !strings.HasPrefix(cp.prefix, "/") {
+		return nil, errors.New("the prefix must start with /")
+	}
+	cp.prefix = strings.TrimSuffix(cp.prefix, "/")
+	if cp.prefix == "" {
+		return nil, errors.New("the prefix must not be /")
+	}
+	return cp, nil
+}
+
+// rewrite returns the path to display. Only whole segments are stripped, so
+// that `/svc` is stripped from `/svc/books` but not from `/svcs`; paths
+// without the prefix are displayed as is.
+func (cp *contextPath) rewrite(path string) string {
+	if cp == nil {
+		return path
+	}
+	if cp.add {
+		return cp.prefix + path
+	}
+	if !strings.HasPrefix(path, cp.prefix) {
+		return path
+	}
+	rest := path[len(cp.prefix):]
+	switch {
+	case rest == "":
+		return "/"
+	case rest[0] == '/':
+		return rest
+	case rest[0] == '?':
+		return "/" + rest
+	}
+	return path
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+func TestContextPath(t *testing.T) {
+	t.Run("Rewrites paths", func(t *testing.T) {
+		testCases := []struct {
+			contextPath string
+			path        string
+			expected    string
+		}{
+			{"", "/svc/books", "/svc/books"},
+			{"/svc", "/svc/books", "/books"},
+			{"/svc/", "/svc/books", "/books"},
+			{"/svc", "/svc", "/"},
+			{"/svc", "/svc?page=2", "/?page=2"},
+			{"/svc", "/svcs/books", "/svcs/books"},
+			{"/svc", "/books", "/books"},
+			{"+/svc", "/books", "/svc/books"},
+			{"+/svc/", "/", "/svc/"},
+		}
+		for i, tc := range testCases {
+			tc := tc // pin
+			t.Run(fmt.Sprintf("%d: %s %s", i, tc.contextPath, tc.path), func(t *testing.T) {
+				cp, err := parseContextPath(tc.contextPath)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if path := cp.rewrite(tc.path); path != tc.expected {
+					t.Fatalf("Expecting path [%s], got [%s]", tc.expected, path)
+				}
+			})
+		}
+	})
+
+	t.Run("Rejects invalid prefixes", func(t *testing.T) {
+		for _, contextPath := range []string{"svc", "+svc", "/", "+"} {
+			if _, err := parseContextPath(contextPath); err == nil {
+				t.Fatalf("Expecting an error for [%s]", contextPath)
+			}
+		}
+	})
+
+	t.Run("Strips the prefix from text output", func(t *testing.T) {
+		options := newTapOptions()
+		options.contextPath = "/svc"
+		if err := options.validate(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		output := renderTestTapEvents(t, options, tapTestRequest(1, pb.HttpMethod_GET, "/svc/books"))
+		if !strings.Contains(output, " :path=/books\n") {
+			t.Fatalf("Expecting path [/books], got [%s]", output)
+		}
+	})
+
+	t.Run("Adds the prefix to JSON output and keeps the original path", func(t *testing.T) {
+		options := newTapOptions()
+		options.contextPath = "+/svc"
+		if err := options.validate(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		output := renderTapEventJSON(tapTestRequest(1, pb.HttpMethod_GET, "/books"), "", options)
+
+		var event tapEvent
+		if err := json.Unmarshal([]byte(output), &event); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if event.RequestInitEvent.Path != "/svc/books" {
+			t.Fatalf("Expecting path [/svc/books], got [%s]", event.RequestInitEvent.Path)
+		}
+		if event.RequestInitEvent.RawPath != "/books" {
+			t.Fatalf("Expecting rawPath [/books], got [%s]", event.RequestInitEvent.RawPath)
+		}
+	})
+
+	t.Run("Omits the raw path without --context-path", func(t *testing.T) {
+		output := renderTapEventJSON(tapTestRequest(1, pb.HttpMethod_GET, "/books"), "", newTapOptions())
+		if strings.Contains(output, "rawPath") {
+			t.Fatalf("Expecting no rawPath, got [%s]", output)
+		}
+	})
+}
@@ -0,0 +1,262 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"github.com/linkerd/linkerd2/pkg/addr"
+)
+
+// directionsWindow is how long after a request is observed by one proxy the
+// same request can be observed by the proxy on the other end, for
+// `--dedup-directions`.
+const directionsWindow = time.Second
+
+// directionDeduper collapses the events of a request observed by both the
+// client's outbound proxy and the server's inbound proxy, as detected by
+// `--dedup-directions`: requests with the same method, authority, path and
+// source and destination IPs, and destination port, observed in opposite
+// directions less than window apart. Only the events of the first stream
+// observed are rendered, with each of its lines annotated with
+// `directions=X,Y`; those of the other stream are dropped.
+//
+// Events are therefore held back, in the order they were observed, until
+// their request is paired or window elapses without a match; see start.
+type directionDeduper struct {
+	window time.Duration
+
+	sync.Mutex
+	// streams maps the streams in flight to their request. Streams not
+	// paired within window are forgotten; see prune.
+	streams map[streamKey]*directionStream
+	bound   *streamBound
+	// candidates indexes the streams in flight not paired yet by their
+	// fingerprint and direction, oldest first.
+	candidates map[directionKey][]*directionStream
+	// recent holds the candidates in the order they were observed, until
+	// they're pruned.
+	recent []*directionStream
+	// held holds the events not rendered yet, oldest first.
+	held []heldDirectionEvent
+	// authorities canonicalizes the authorities of requests, so that a
+	// request is matched even if the proxies report different authorities.
+	authorities *authorityCanonicalizer
+	// paths maps the paths of requests to their template.
+	paths *pathTemplates
+}
+
+type directionKey struct {
+	fingerprint string
+	direction   pb.TapEvent_ProxyDirection
+}
+
+type directionStream struct {
+	key         streamKey
+	fingerprint string
+	direction   pb.TapEvent_ProxyDirection
+	startedAt   time.Time
+	// mirror is the stream of the same request observed by the other proxy.
+	mirror *directionStream
+	// mirrored is true if the stream is the mirror of an earlier stream, so
+	// that its events are dropped.
+	mirrored bool
+}
+
+type heldDirectionEvent struct {
+	stream *directionStream
+	line   string
+}
+
+func newDirectionDeduper(window time.Duration, pairingBuffer int) *directionDeduper {
+	return &directionDeduper{
+		window:     window,
+		streams:    make(map[streamKey]*directionStream),
+		bound:      newStreamBound(pairingBuffer),
+		candidates: make(map[directionKey][]*directionStream),
+	}
+}
+
+// add records an event and its rendered line, returning the lines that are
+// ready to be written.
+func (d *directionDeduper) add(event *pb.TapEvent, line string, now time.Time) []string {
+	d.Lock()
+	defer d.Unlock()
+	d.prune(now)
+	key := newStreamKey(event)
+	// stream is nil if the request of the stream was missed, in which case
+	// it can't be paired.
+	stream := d.streams[key]
+	if reqI := event.GetHttp().GetRequestInit(); reqI != nil {
+		stream = &directionStream{
+			key:         key,
+			fingerprint: d.fingerprint(event, reqI),
+			direction:   event.GetProxyDirection(),
+			startedAt:   now,
+		}
+		if mirror := d.unpaired(stream); mirror != nil {
+			mirror.mirror, stream.mirror = stream, mirror
+			stream.mirrored = true
+		} else if stream.direction != pb.TapEvent_UNKNOWN {
+			k := directionKey{stream.fingerprint, stream.direction}
+			d.candidates[k] = append(d.candidates[k], stream)
+			d.recent = append(d.recent, stream)
+		}
+		if oldest, evict := d.bound.track(key); evict {
+			// The held events of the evicted stream are still written
+			// once window elapses.
+			d.removeCandidate(d.streams[oldest])
+			delete(d.streams, oldest)
+		}
+		d.streams[key] = stream
+	}
+	if event.GetHttp().GetResponseEnd() != nil {
+		if stream != nil {
+			d.removeCandidate(stream)
+		}
+		delete(d.streams, key)
+		d.bound.forget(key)
+	}
+
+	if stream == nil || !stream.mirrored {
+		d.held = append(d.held, heldDirectionEvent{stream, line})
+	}
+	return d.ready(now)
+}
+
+// unpaired returns the oldest stream in flight whose request is the same as
+// the given stream's, observed in the opposite direction less than window ago
+// and not paired yet, and removes it from the candidates. It returns nil if
+// there is none. Streams observed longer than window ago must have been
+// pruned.
+func (d *directionDeduper) unpaired(stream *directionStream) *directionStream {
+	opposite := pb.TapEvent_INBOUND
+	switch stream.direction {
+	case pb.TapEvent_INBOUND:
+		opposite = pb.TapEvent_OUTBOUND
+	case pb.TapEvent_UNKNOWN:
+		return nil
+	}
+	candidates := d.candidates[directionKey{stream.fingerprint, opposite}]
+	if len(candidates) == 0 {
+		return nil
+	}
+	mirror := candidates[0]
+	d.removeCandidate(mirror)
+	return mirror
+}
+
+// removeCandidate removes a stream from the candidates, if it's one of them.
+func (d *directionDeduper) removeCandidate(stream *directionStream) {
+	k := directionKey{stream.fingerprint, stream.direction}
+	candidates := d.candidates[k]
+	for i, s := range candidates {
+		if s == stream {
+			candidates = append(candidates[:i], candidates[i+1:]...)
+			break
+		}
+	}
+	if len(candidates) == 0 {
+		delete(d.candidates, k)
+	} else {
+		d.candidates[k] = candidates
+	}
+}
+
+// prune forgets the streams observed longer than window ago that weren't
+// paired, as they can't be anymore. Their later events are written as those
+// of streams whose request was missed. Paired streams are kept until their
+// response ends, so that the events of mirrors are still dropped. d must be
+// locked.
+func (d *directionDeduper) prune(now time.Time) {
+	for len(d.recent) > 0 && now.Sub(d.recent[0].startedAt) > d.window {
+		stream := d.recent[0]
+		d.recent = d.recent[1:]
+		if stream.mirror != nil {
+			continue
+		}
+		d.removeCandidate(stream)
+		if d.streams[stream.key] == stream {
+			delete(d.streams, stream.key)
+			d.bound.forget(stream.key)
+		}
+	}
+}
+
+// ready returns the lines of the held events that can be written: those at
+// the head of the queue whose stream is paired, whose request couldn't be
+// paired within window, or whose request was missed. d must be locked.
+func (d *directionDeduper) ready(now time.Time) []string {
+	var out []string
+	for len(d.held) > 0 {
+		e := d.held[0]
+		if e.stream != nil && e.stream.mirror == nil && now.Sub(e.stream.startedAt) <= d.window {
+			break
+		}
+		out = append(out, e.render())
+		d.held = d.held[1:]
+	}
+	return out
+}
+
+// start writes the lines of the held events that are ready to w on every
+// tick of the window, until the returned function is called, so that events
+// whose request isn't paired are not held back until another event is
+// received.
+func (d *directionDeduper) start(w io.Writer, now func() time.Time) (stop func()) {
+	return writeOnTicks(d.window, func() error {
+		d.Lock()
+		d.prune(now())
+		lines := d.ready(now())
+		d.Unlock()
+		return writeLines(w, lines)
+	})
+}
+
+// flush returns the lines of all the held events, once the tap stream ends.
+func (d *directionDeduper) flush() []string {
+	d.Lock()
+	defer d.Unlock()
+	var out []string
+	for _, e := range d.held {
+		out = append(out, e.render())
+	}
+	d.held = nil
+	d.streams = make(map[streamKey]*directionStream)
+	d.bound.reset()
+	d.candidates = make(map[directionKey][]*directionStream)
+	d.recent = nil
+	return out
+}
+
+func (d *directionDeduper) fingerprint(event *pb.TapEvent, reqI *pb.TapEvent_Http_RequestInit) string {
+	return strings.Join([]string{
+		addr.PublicIPToString(event.GetSource().GetIp()),
+		addr.PublicIPToString(event.GetDestination().GetIp()),
+		fmt.Sprintf("%d", event.GetDestination().GetPort()),
+		formatMethod(reqI.GetMethod()),
+		d.authorities.canonical(reqI.GetAuthority()),
+		d.paths.template(reqI.GetPath()),
+	}, " ")
+}
+
+func (e heldDirectionEvent) render() string {
+	if e.stream == nil || e.stream.mirror == nil {
+		return e.line
+	}
+	return fmt.Sprintf("%s directions=%s,%s", e.line, shortDirection(e.stream.direction), shortDirection(e.stream.mirror.direction))
+}
+
+func shortDirection(direction pb.TapEvent_ProxyDirection) string {
+	switch direction {
+	case pb.TapEvent_INBOUND:
+		return "in"
+	case pb.TapEvent_OUTBOUND:
+		return "out"
+	default:
+		return "unk"
+	}
+}
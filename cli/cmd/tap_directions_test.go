@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+// tapTestInbound returns an event as observed by the server's inbound proxy,
+// whose connection from the client's outbound proxy has another base stream
+// ID.
+func tapTestInbound(event *pb.TapEvent) *pb.TapEvent {
+	event.ProxyDirection = pb.TapEvent_INBOUND
+	event.Source.Port = 4444
+	return tapTestWithBase(9, event)
+}
+
+func TestRenderTapEventsDedupDirections(t *testing.T) {
+	latency := &duration.Duration{Nanos: 1000}
+	events := []*pb.TapEvent{
+		tapTestRequest(1, pb.HttpMethod_GET, "/books"),
+		tapTestInbound(tapTestRequest(1, pb.HttpMethod_GET, "/books")),
+		// Not observed by the inbound proxy.
+		tapTestRequest(2, pb.HttpMethod_GET, "/authors"),
+		tapTestInbound(tapTestResponse(1, http.StatusOK, latency)),
+		tapTestResponse(1, http.StatusOK, latency),
+		tapTestInbound(tapTestEnd(1, &pb.Eos{}, 10)),
+		tapTestEnd(1, &pb.Eos{}, 10),
+		tapTestResponse(2, http.StatusOK, latency),
+		tapTestEnd(2, &pb.Eos{}, 10),
+	}
+
+	options := newTapOptions()
+	options.dedupDirections = true
+	options.now = tapTestClock(time.Unix(0, 0), time.Millisecond)
+	if err := options.validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	output := renderTestTapEvents(t, options, events...)
+
+	ids := renderedIDs(output)
+	expectedIDs := []string{
+		"req id=7:1", "req id=7:2", "rsp id=7:1", "end id=7:1", "rsp id=7:2", "end id=7:2",
+	}
+	if fmt.Sprint(ids) != fmt.Sprint(expectedIDs) {
+		t.Fatalf("Expecting %v, got %v", expectedIDs, ids)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		isPaired := strings.Contains(line, "id=7:1 ")
+		if strings.HasSuffix(line, " directions=out,in") != isPaired {
+			t.Fatalf("Expecting only the lines of stream 1 to end with directions=out,in, got [%s]", output)
+		}
+	}
+
+	t.Run("Rejects --dedup-directions with JSON output", func(t *testing.T) {
+		options := newTapOptions()
+		options.dedupDirections = true
+		options.output = jsonOutput
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+
+	t.Run("Rejects --dedup-directions with --collapse-retries", func(t *testing.T) {
+		options := newTapOptions()
+		options.dedupDirections = true
+		options.collapseRetries = true
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+func TestDirectionDeduper(t *testing.T) {
+	add := func(d *directionDeduper, event *pb.TapEvent, now time.Time) []string {
+		return d.add(event, renderTapEvent(event, "", newTapOptions()), now)
+	}
+	start := time.Unix(0, 0)
+
+	t.Run("Pairs inbound requests observed first", func(t *testing.T) {
+		d := newDirectionDeduper(time.Second, 0)
+		if lines := add(d, tapTestInbound(tapTestRequest(1, pb.HttpMethod_GET, "/books")), start); len(lines) != 0 {
+			t.Fatalf("Expecting the request to be held back, got %v", lines)
+		}
+		lines := add(d, tapTestRequest(1, pb.HttpMethod_GET, "/books"), start.Add(10*time.Millisecond))
+		if len(lines) != 1 || !strings.HasPrefix(lines[0], "req id=9:1 ") || !strings.HasSuffix(lines[0], " directions=in,out") {
+			t.Fatalf("Expecting the inbound request annotated with directions=in,out, got %v", lines)
+		}
+	})
+
+	t.Run("Doesn't pair requests past the window", func(t *testing.T) {
+		d := newDirectionDeduper(time.Second, 0)
+		add(d, tapTestRequest(1, pb.HttpMethod_GET, "/books"), start)
+		lines := add(d, tapTestInbound(tapTestRequest(1, pb.HttpMethod_GET, "/books")), start.Add(2*time.Second))
+		if len(lines) != 1 || !strings.HasPrefix(lines[0], "req id=7:1 ") || strings.Contains(lines[0], "directions=") {
+			t.Fatalf("Expecting the outbound request to be released unannotated, got %v", lines)
+		}
+		lines = d.flush()
+		if len(lines) != 1 || !strings.HasPrefix(lines[0], "req id=9:1 ") || strings.Contains(lines[0], "directions=") {
+			t.Fatalf("Expecting the inbound request to be flushed unannotated, got %v", lines)
+		}
+	})
+
+	t.Run("Doesn't pair different requests", func(t *testing.T) {
+		d := newDirectionDeduper(time.Second, 0)
+		add(d, tapTestRequest(1, pb.HttpMethod_GET, "/books"), start)
+		add(d, tapTestInbound(tapTestRequest(1, pb.HttpMethod_GET, "/authors")), start)
+		if lines := d.flush(); len(lines) != 2 || strings.Contains(strings.Join(lines, "\n"), "directions=") {
+			t.Fatalf("Expecting both requests unannotated, got %v", lines)
+		}
+	})
+
+	t.Run("Writes unpaired requests on every tick", func(t *testing.T) {
+		ticks := make(chan time.Time)
+		defer func(newTicker func(time.Duration) (<-chan time.Time, func())) {
+			newSummaryTicker = newTicker
+		}(newSummaryTicker)
+		newSummaryTicker = func(time.Duration) (<-chan time.Time, func()) {
+			return ticks, func() {}
+		}
+
+		now, setNow := tapTestSettableClock(start)
+		d := newDirectionDeduper(time.Second, 0)
+		add(d, tapTestRequest(1, pb.HttpMethod_GET, "/books"), now())
+		output := bytes.NewBufferString("")
+		stop := d.start(output, now)
+		ticks <- time.Now()
+		setNow(start.Add(2 * time.Second))
+		ticks <- time.Now()
+		stop()
+
+		if lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n"); len(lines) != 1 || !strings.HasPrefix(lines[0], "req id=7:1 ") || strings.Contains(lines[0], "directions=") {
+			t.Fatalf("Expecting the request to be written unannotated once the window elapses, got [%s]", output.String())
+		}
+		if lines := d.flush(); len(lines) != 0 {
+			t.Fatalf("Expecting nothing left to flush, got %v", lines)
+		}
+	})
+
+	t.Run("Pairs the oldest of identical requests", func(t *testing.T) {
+		d := newDirectionDeduper(time.Second, 0)
+		add(d, tapTestRequest(1, pb.HttpMethod_GET, "/books"), start)
+		second := tapTestRequest(2, pb.HttpMethod_GET, "/books")
+		add(d, second, start)
+		lines := add(d, tapTestInbound(tapTestRequest(1, pb.HttpMethod_GET, "/books")), start)
+		if len(lines) != 1 || !strings.HasPrefix(lines[0], "req id=7:1 ") || !strings.HasSuffix(lines[0], " directions=out,in") {
+			t.Fatalf("Expecting the first outbound request annotated with directions=out,in, got %v", lines)
+		}
+		if len(d.candidates) != 1 || d.streams[newStreamKey(second)].mirror != nil {
+			t.Fatalf("Expecting the second outbound request to be left unpaired, got %d candidates", len(d.candidates))
+		}
+	})
+
+	t.Run("Forgets requests not paired within the window", func(t *testing.T) {
+		d := newDirectionDeduper(time.Second, 0)
+		add(d, tapTestRequest(1, pb.HttpMethod_GET, "/books"), start)
+		lines := add(d, tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 1000}), start.Add(2*time.Second))
+		expectedIDs := []string{"req id=7:1", "rsp id=7:1"}
+		if ids := renderedIDs(strings.Join(lines, "\n")); fmt.Sprint(ids) != fmt.Sprint(expectedIDs) {
+			t.Fatalf("Expecting %v, got %v", expectedIDs, ids)
+		}
+		if len(d.streams) != 0 || len(d.candidates) != 0 || len(d.recent) != 0 || len(d.bound.elems) != 0 {
+			t.Fatalf("Expecting the request to be forgotten, got %d streams and %d candidates", len(d.streams), len(d.candidates))
+		}
+	})
+}
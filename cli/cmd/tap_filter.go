@@ -0,0 +1,256 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"google.golang.org/grpc/codes"
+)
+
+// filterFields lists the transaction fields a `--filter` expression can
+// refer to.
+var filterFields = []string{"method", "status", "path", "authority", "direction", "grpc_status"}
+
+// filterExpr is a node of a parsed `--filter` expression, evaluated against
+// the fields of a transaction.
+type filterExpr interface {
+	eval(fields map[string]string) bool
+}
+
+type filterAnd struct{ left, right filterExpr }
+
+func (e *filterAnd) eval(fields map[string]string) bool {
+	return e.left.eval(fields) && e.right.eval(fields)
+}
+
+type filterOr struct{ left, right filterExpr }
+
+func (e *filterOr) eval(fields map[string]string) bool {
+	return e.left.eval(fields) || e.right.eval(fields)
+}
+
+type filterEquals struct{ field, value string }
+
+func (e *filterEquals) eval(fields map[string]string) bool {
+	return strings.EqualFold(fields[e.field], e.value)
+}
+
+type filterMatches struct {
+	field string
+	re    *regexp.Regexp
+}
+
+func (e *filterMatches) eval(fields map[string]string) bool {
+	return e.re.MatchString(fields[e.field])
+}
+
+// parseFilter parses a `--filter` expression such as
+// `method == POST && (status =~ "^5" || grpc_status == Unavailable)`.
+// `==` compares case-insensitively, `=~` matches a regular expression, and
+// `&&` binds tighter than `||`. Values containing spaces or operators must be
+// double-quoted.
+func parseFilter(expr string) (filterExpr, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	return e, nil
+}
+
+type filterToken struct {
+	text   string
+	quoted bool
+}
+
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(expr); {
+		switch c := expr[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, filterToken{text: string(c)})
+			i++
+		case strings.HasPrefix(expr[i:], "&&"), strings.HasPrefix(expr[i:], "||"),
+			strings.HasPrefix(expr[i:], "=="), strings.HasPrefix(expr[i:], "=~"):
+			tokens = append(tokens, filterToken{text: expr[i : i+2]})
+			i += 2
+		case c == '"':
+			var value strings.Builder
+			j := i + 1
+			for ; j < len(expr) && expr[j] != '"'; j++ {
+				if expr[j] == '\\' && j+1 < len(expr) {
+					j++
+				}
+				value.WriteByte(expr[j])
+			}
+			if j == len(expr) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			tokens = append(tokens, filterToken{text: value.String(), quoted: true})
+			i = j + 1
+		default:
+			j := i
+			for j < len(expr) && !unicode.IsSpace(rune(expr[j])) && strings.IndexByte("()&|=\"", expr[j]) < 0 {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("unexpected %q at offset %d", c, i)
+			}
+			tokens = append(tokens, filterToken{text: expr[i:j]})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+// peek returns the next unquoted token, or "" if there are none.
+func (p *filterParser) peek() string {
+	if p.pos < len(p.tokens) && !p.tokens[p.pos].quoted {
+		return p.tokens[p.pos].text
+	}
+	return ""
+}
+
+func (p *filterParser) parseOr() (filterExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterExpr, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterAnd{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseTerm() (filterExpr, error) {
+	if p.peek() == "(" {
+		p.pos++
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return e, nil
+	}
+
+	if p.pos+3 > len(p.tokens) {
+		return nil, fmt.Errorf("expected FIELD OPERATOR VALUE at the end of the expression")
+	}
+	field, op, value := p.tokens[p.pos], p.tokens[p.pos+1], p.tokens[p.pos+2]
+	p.pos += 3
+
+	if !isFilterField(field) {
+		return nil, fmt.Errorf("unknown field %q; must be one of: %s", field.text, strings.Join(filterFields, ", "))
+	}
+	if !value.quoted && isFilterOperator(value.text) {
+		return nil, fmt.Errorf("expected a value after %q, got %q", op.text, value.text)
+	}
+	switch {
+	case op.text == "==" && !op.quoted:
+		return &filterEquals{field.text, value.text}, nil
+	case op.text == "=~" && !op.quoted:
+		re, err := regexp.Compile(value.text)
+		if err != nil {
+			return nil, err
+		}
+		return &filterMatches{field.text, re}, nil
+	default:
+		return nil, fmt.Errorf("expected == or =~ after %q, got %q", field.text, op.text)
+	}
+}
+
+func isFilterOperator(text string) bool {
+	switch text {
+	case "(", ")", "&&", "||", "==", "=~":
+		return true
+	}
+	return false
+}
+
+func isFilterField(token filterToken) bool {
+	if token.quoted {
+		return false
+	}
+	for _, f := range filterFields {
+		if token.text == f {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyFilter returns a classifier keeping the streams whose transaction
+// matches the expression. The whole transaction is known once the response
+// ends, so that's when streams are classified.
+func classifyFilter(expr filterExpr) streamClassifier {
+	return func(events []*pb.TapEvent) (bool, bool) {
+		if events[len(events)-1].GetHttp().GetResponseEnd() == nil {
+			return false, false
+		}
+		return expr.eval(transactionFields(events)), true
+	}
+}
+
+// transactionFields returns the fields of a transaction that can be referred
+// to by a `--filter` expression. Fields of events that weren't observed are
+// empty.
+func transactionFields(events []*pb.TapEvent) map[string]string {
+	fields := map[string]string{}
+	for _, event := range events {
+		fields["direction"] = strings.ToLower(event.GetProxyDirection().String())
+		switch ev := event.GetHttp().GetEvent().(type) {
+		case *pb.TapEvent_Http_RequestInit_:
+			fields["method"] = formatMethod(ev.RequestInit.GetMethod())
+			fields["path"] = ev.RequestInit.GetPath()
+			fields["authority"] = ev.RequestInit.GetAuthority()
+		case *pb.TapEvent_Http_ResponseInit_:
+			fields["status"] = fmt.Sprintf("%d", ev.ResponseInit.GetHttpStatus())
+		case *pb.TapEvent_Http_ResponseEnd_:
+			if eos, ok := ev.ResponseEnd.GetEos().GetEnd().(*pb.Eos_GrpcStatusCode); ok {
+				fields["grpc_status"] = codes.Code(eos.GrpcStatusCode).String()
+			}
+		}
+	}
+	return fields
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"google.golang.org/grpc/codes"
+)
+
+func TestParseFilter(t *testing.T) {
+	fields := map[string]string{
+		"method":      "POST",
+		"status":      "503",
+		"path":        "/books/1",
+		"authority":   "books.default:7000",
+		"direction":   "outbound",
+		"grpc_status": "",
+	}
+
+	testCases := []struct {
+		expr     string
+		expected bool
+	}{
+		{"method == POST", true},
+		{"method == post", true},
+		{"method == GET", false},
+		{`status =~ "^5"`, true},
+		{`method == POST && status =~ "^5"`, true},
+		{`method == GET && status =~ "^5"`, false},
+		{`method == GET || status =~ "^5"`, true},
+		{`method == GET || status == 200 && path =~ ^/books`, false},
+		{`(method == GET || status == 503) && path =~ ^/books`, true},
+		{`path =~ "^/books/[0-9]+$"`, true},
+		{`authority == "books.default:7000" && direction == outbound`, true},
+		{`grpc_status == ""`, true},
+	}
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(tc.expr, func(t *testing.T) {
+			expr, err := parseFilter(tc.expr)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result := expr.eval(fields); result != tc.expected {
+				t.Fatalf("Expecting %t, got %t", tc.expected, result)
+			}
+		})
+	}
+
+	t.Run("Rejects invalid expressions", func(t *testing.T) {
+		for _, expr := range []string{
+			"",
+			"method",
+			"method ==",
+			"method = POST",
+			"verb == POST",
+			"method == POST &&",
+			"(method == POST",
+			"method == POST)",
+			"method == ==",
+			`path =~ "(")`,
+			`path == "/books`,
+		} {
+			if _, err := parseFilter(expr); err == nil {
+				t.Fatalf("Expecting an error for [%s], got nothing", expr)
+			}
+		}
+	})
+}
+
+func TestRenderTapEventsFilter(t *testing.T) {
+	grpcUnavailable := &pb.Eos{End: &pb.Eos_GrpcStatusCode{GrpcStatusCode: uint32(codes.Unavailable)}}
+	latency := &duration.Duration{Nanos: 1000}
+	events := []*pb.TapEvent{
+		tapTestRequest(1, pb.HttpMethod_POST, "/books"),
+		tapTestRequest(2, pb.HttpMethod_GET, "/books"),
+		tapTestRequest(3, pb.HttpMethod_POST, "/books.Books/Get"),
+		tapTestResponse(1, http.StatusServiceUnavailable, latency),
+		tapTestResponse(2, http.StatusInternalServerError, latency),
+		tapTestResponse(3, http.StatusOK, latency),
+		tapTestEnd(1, &pb.Eos{}, 0),
+		tapTestEnd(2, &pb.Eos{}, 0),
+		tapTestEnd(3, grpcUnavailable, 0),
+	}
+
+	testCases := []struct {
+		filter      string
+		expectedIDs []string
+	}{
+		{`method == POST && status =~ "^5"`, []string{"req id=7:1", "rsp id=7:1", "end id=7:1"}},
+		{`status == 500 || grpc_status == Unavailable`, []string{
+			"req id=7:2", "rsp id=7:2", "end id=7:2",
+			"req id=7:3", "rsp id=7:3", "end id=7:3",
+		}},
+		{`path =~ "^/books$" && direction == inbound`, []string{}},
+	}
+
+	for i, tc := range testCases {
+		tc := tc // pin
+		t.Run(fmt.Sprintf("%d: %s", i, tc.filter), func(t *testing.T) {
+			options := newTapOptions()
+			options.filter = tc.filter
+			if err := options.validate(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			ids := renderedIDs(renderTestTapEvents(t, options, events...))
+			if fmt.Sprint(ids) != fmt.Sprint(tc.expectedIDs) {
+				t.Fatalf("Expecting %v, got %v", tc.expectedIDs, ids)
+			}
+		})
+	}
+
+	t.Run("Reports parse errors when validating", func(t *testing.T) {
+		options := newTapOptions()
+		options.filter = "method =="
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
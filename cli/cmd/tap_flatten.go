@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// flattenLabels maps a tapEvent to a JSON object whose source and destination
+// labels are top-level fields prefixed with `source_label_` and
+// `destination_label_`, in place of the nested `metadata` maps. This suits
+// consumers that expect flat records, such as CSV conversion or columnar
+// stores.
+func flattenLabels(m *tapEvent) (map[string]interface{}, error) {
+	e, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(e))
+	// Keep integers such as response sizes exact.
+	decoder.UseNumber()
+	var flat map[string]interface{}
+	if err := decoder.Decode(&flat); err != nil {
+		return nil, err
+	}
+
+	for prefix, ep := range map[string]*endpoint{"source": m.Source, "destination": m.Destination} {
+		if fields, ok := flat[prefix].(map[string]interface{}); ok {
+			delete(fields, "metadata")
+		}
+		if ep == nil {
+			continue
+		}
+		for k, v := range ep.Metadata {
+			flat[prefix+"_label_"+k] = v
+		}
+	}
+	return flat, nil
+}
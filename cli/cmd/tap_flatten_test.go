@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+func TestRenderTapEventJSONFlattenLabels(t *testing.T) {
+	event := tapTestRequest(1, pb.HttpMethod_GET, "/books")
+	event.SourceMeta = &pb.TapEvent_EndpointMeta{Labels: map[string]string{"app": "web", "pod": "web-dlbvj"}}
+	event.DestinationMeta = &pb.TapEvent_EndpointMeta{Labels: map[string]string{"app": "books"}}
+
+	options := newTapOptions()
+	options.output = jsonOutput
+	options.flattenLabels = true
+	if err := options.validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	output := renderTapEventJSON(event, "", options)
+
+	var rendered map[string]interface{}
+	if err := json.Unmarshal([]byte(output), &rendered); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectedLabels := map[string]string{
+		"source_label_app":      "web",
+		"source_label_pod":      "web-dlbvj",
+		"destination_label_app": "books",
+	}
+	for key, expected := range expectedLabels {
+		if rendered[key] != expected {
+			t.Fatalf("Expecting [%s] in field [%s], got [%v] in [%s]", expected, key, rendered[key], output)
+		}
+	}
+	for _, ep := range []string{"source", "destination"} {
+		fields, ok := rendered[ep].(map[string]interface{})
+		if !ok {
+			t.Fatalf("Expecting a [%s] object, got [%s]", ep, output)
+		}
+		if _, ok := fields["metadata"]; ok {
+			t.Fatalf("Expecting no nested metadata in [%s], got [%s]", ep, output)
+		}
+		if fields["ip"] == nil || fields["port"] == nil {
+			t.Fatalf("Expecting the address of [%s] to be kept, got [%s]", ep, output)
+		}
+	}
+	if rendered["requestInitEvent"].(map[string]interface{})["path"] != "/books" {
+		t.Fatalf("Expecting the request to be kept, got [%s]", output)
+	}
+
+	t.Run("Rejects --flatten-labels without JSON output", func(t *testing.T) {
+		options := newTapOptions()
+		options.flattenLabels = true
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
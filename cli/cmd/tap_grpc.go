@@ -0,0 +1,19 @@
+package cmd
+
+import (
+	"regexp"
+)
+
+// grpcPathRE matches the paths of gRPC requests: `/<service>/<method>`, where
+// the service name may be qualified with its package.
+var grpcPathRE = regexp.MustCompile(`^/([A-Za-z_][A-Za-z0-9_]*(?:\.[A-Za-z_][A-Za-z0-9_]*)*)/([A-Za-z_][A-Za-z0-9_]*)$`)
+
+// parseGRPCPath returns the service and method a path refers to, or false if
+// the path doesn't have the shape of a gRPC path.
+func parseGRPCPath(path string) (service string, method string, ok bool) {
+	match := grpcPathRE.FindStringSubmatch(path)
+	if match == nil {
+		return "", "", false
+	}
+	return match[1], match[2], true
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+func TestParseGRPCPath(t *testing.T) {
+	testCases := []struct {
+		path    string
+		service string
+		method  string
+		ok      bool
+	}{
+		{"/linkerd2.public.Api/Version", "linkerd2.public.Api", "Version", true},
+		{"/Greeter/SayHello", "Greeter", "SayHello", true},
+		{"/", "", "", false},
+		{"/books", "", "", false},
+		{"/books/1", "", "", false},
+		{"/books/1/authors", "", "", false},
+		{"/pkg.Service/Method?q=1", "", "", false},
+		{"/pkg..Service/Method", "", "", false},
+	}
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(tc.path, func(t *testing.T) {
+			service, method, ok := parseGRPCPath(tc.path)
+			if service != tc.service || method != tc.method || ok != tc.ok {
+				t.Fatalf("Expecting [%s] [%s] %t, got [%s] [%s] %t", tc.service, tc.method, tc.ok, service, method, ok)
+			}
+		})
+	}
+}
+
+func TestResolveGRPCMethod(t *testing.T) {
+	options := newTapOptions()
+	options.resolveGRPCMethod = true
+	grpcRequest := tapTestRequest(1, pb.HttpMethod_POST, "/linkerd2.public.Api/Version")
+	httpRequest := tapTestRequest(2, pb.HttpMethod_GET, "/books/1")
+
+	t.Run("Renders the service and method of gRPC requests", func(t *testing.T) {
+		line := renderTapEvent(grpcRequest, "", options)
+		if !strings.HasSuffix(line, " grpc-service=linkerd2.public.Api grpc-method=Version") {
+			t.Fatalf("Expecting the gRPC service and method, got [%s]", line)
+		}
+		if strings.Contains(line, ":path=") {
+			t.Fatalf("Expecting no path, got [%s]", line)
+		}
+	})
+
+	t.Run("Renders the path of other requests", func(t *testing.T) {
+		line := renderTapEvent(httpRequest, "", options)
+		if !strings.HasSuffix(line, " :path=/books/1") {
+			t.Fatalf("Expecting the path, got [%s]", line)
+		}
+	})
+
+	t.Run("Adds the service and method to JSON output", func(t *testing.T) {
+		var event tapEvent
+		if err := json.Unmarshal([]byte(renderTapEventJSON(grpcRequest, "", options)), &event); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		reqI := event.RequestInitEvent
+		if reqI.GRPCService != "linkerd2.public.Api" || reqI.GRPCMethod != "Version" {
+			t.Fatalf("Expecting [linkerd2.public.Api] [Version], got [%s] [%s]", reqI.GRPCService, reqI.GRPCMethod)
+		}
+		if reqI.Path != "/linkerd2.public.Api/Version" {
+			t.Fatalf("Expecting the path to be kept, got [%s]", reqI.Path)
+		}
+
+		output := renderTapEventJSON(httpRequest, "", options)
+		if strings.Contains(output, "grpcService") || strings.Contains(output, "grpcMethod") {
+			t.Fatalf("Expecting no gRPC fields, got [%s]", output)
+		}
+	})
+}
@@ -0,0 +1,262 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"github.com/linkerd/linkerd2/pkg/version"
+)
+
+const (
+	harOutput  = "har"
+	harVersion = "1.2"
+)
+
+// The types below model the subset of the HAR 1.2 format
+// (http://www.softwareishard.com/blog/har-12-spec/) that can be populated from
+// tap events. Sizes that aren't known are reported as -1, as per the spec.
+
+type harDocument struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      uint32         `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// harEntryBuilder accumulates the events of a single stream until its
+// response ends.
+type harEntryBuilder struct {
+	started time.Time
+	reqInit *pb.TapEvent_Http_RequestInit
+	rspInit *pb.TapEvent_Http_ResponseInit
+}
+
+// harRecorder correlates the events of a tap stream into HAR entries.
+type harRecorder struct {
+	streams map[streamKey]*harEntryBuilder
+	bound   *streamBound
+	entries []harEntry
+	// timeLayout is the layout entries' start times are rendered with.
+	timeLayout string
+}
+
+func newHarRecorder(pairingBuffer int) *harRecorder {
+	return &harRecorder{
+		streams:    make(map[streamKey]*harEntryBuilder),
+		bound:      newStreamBound(pairingBuffer),
+		entries:    []harEntry{},
+		timeLayout: time.RFC3339Nano,
+	}
+}
+
+// add records an event observed at the given time. Entries are completed
+// once their response ends; events of streams whose request wasn't observed
+// are ignored.
+func (r *harRecorder) add(event *pb.TapEvent, now time.Time) {
+	key := newStreamKey(event)
+	switch ev := event.GetHttp().GetEvent().(type) {
+	case *pb.TapEvent_Http_RequestInit_:
+		if oldest, evict := r.bound.track(key); evict {
+			delete(r.streams, oldest)
+		}
+		r.streams[key] = &harEntryBuilder{started: now, reqInit: ev.RequestInit}
+
+	case *pb.TapEvent_Http_ResponseInit_:
+		if b, ok := r.streams[key]; ok {
+			b.rspInit = ev.ResponseInit
+		}
+
+	case *pb.TapEvent_Http_ResponseEnd_:
+		if b, ok := r.streams[key]; ok {
+			r.entries = append(r.entries, b.entry(ev.ResponseEnd, r.timeLayout))
+			delete(r.streams, key)
+			r.bound.forget(key)
+		}
+	}
+}
+
+// write renders the completed entries as a HAR document.
+func (r *harRecorder) write(w io.Writer) error {
+	doc := harDocument{
+		Log: harLog{
+			Version: harVersion,
+			Creator: harCreator{Name: "linkerd tap", Version: version.Version},
+			Entries: r.entries,
+		},
+	}
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
+
+func (b *harEntryBuilder) entry(end *pb.TapEvent_Http_ResponseEnd, timeLayout string) harEntry {
+	version := httpVersion(b.reqInit)
+
+	// Without a response init, all of the time is attributed to waiting.
+	wait := harMillis(end.GetSinceRequestInit())
+	receive := 0.0
+	if b.rspInit != nil {
+		wait = harMillis(b.rspInit.GetSinceRequestInit())
+		receive = harMillis(end.GetSinceResponseInit())
+	}
+
+	status := b.rspInit.GetHttpStatus()
+	return harEntry{
+		StartedDateTime: b.started.Format(timeLayout),
+		Time:            wait + receive,
+		Request: harRequest{
+			Method:      formatMethod(b.reqInit.GetMethod()),
+			URL:         harURL(b.reqInit),
+			HTTPVersion: version,
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(b.reqInit.GetHeaders()),
+			QueryString: harQueryString(b.reqInit.GetPath()),
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Response: harResponse{
+			Status:      status,
+			StatusText:  http.StatusText(int(status)),
+			HTTPVersion: version,
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(b.rspInit.GetHeaders()),
+			Content: harContent{
+				Size:     int64(end.GetResponseBytes()),
+				MimeType: harContentType(b.rspInit.GetHeaders()),
+			},
+			HeadersSize: -1,
+			BodySize:    int64(end.GetResponseBytes()),
+		},
+		Timings: harTimings{
+			Send:    0,
+			Wait:    wait,
+			Receive: receive,
+		},
+	}
+}
+
+// harURL builds an absolute URL from a request's scheme, authority and path.
+func harURL(reqI *pb.TapEvent_Http_RequestInit) string {
+	scheme := strings.ToLower(formatScheme(reqI.GetScheme()))
+	if scheme == "" {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, reqI.GetAuthority(), reqI.GetPath())
+}
+
+func harQueryString(path string) []harNameValue {
+	qs := []harNameValue{}
+	u, err := url.Parse(path)
+	if err != nil {
+		return qs
+	}
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		name, _ := url.QueryUnescape(kv[0])
+		value := ""
+		if len(kv) == 2 {
+			value, _ = url.QueryUnescape(kv[1])
+		}
+		qs = append(qs, harNameValue{Name: name, Value: value})
+	}
+	return qs
+}
+
+// harHeaders converts extracted headers; binary values are not representable
+// in HAR and are skipped.
+func harHeaders(hs *pb.Headers) []harNameValue {
+	headers := []harNameValue{}
+	for _, h := range hs.GetHeaders() {
+		if _, ok := h.GetValue().(*pb.Headers_Header_ValueStr); ok {
+			headers = append(headers, harNameValue{Name: h.GetName(), Value: h.GetValueStr()})
+		}
+	}
+	return headers
+}
+
+func harContentType(hs *pb.Headers) string {
+	for _, h := range hs.GetHeaders() {
+		if strings.ToLower(h.GetName()) == "content-type" {
+			return h.GetValueStr()
+		}
+	}
+	return ""
+}
+
+func harMillis(d *duration.Duration) float64 {
+	td, err := ptypes.Duration(d)
+	if err != nil {
+		return 0
+	}
+	return float64(td) / float64(time.Millisecond)
+}
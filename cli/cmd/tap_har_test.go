@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"github.com/linkerd/linkerd2/pkg/version"
+	"google.golang.org/grpc/codes"
+)
+
+func TestRenderTapEventsHAR(t *testing.T) {
+	grpcOK := &pb.Eos{End: &pb.Eos_GrpcStatusCode{GrpcStatusCode: uint32(codes.OK)}}
+	withHost := tapTestRequest(2, pb.HttpMethod_POST, "/authors?page=2&q=a%20b")
+	withHost.GetHttp().GetRequestInit().Headers = &pb.Headers{
+		Headers: []*pb.Headers_Header{
+			{Name: "host", Value: &pb.Headers_Header_ValueStr{ValueStr: "books.default:7000"}},
+			{Name: "x-bin", Value: &pb.Headers_Header_ValueBin{ValueBin: []byte{0xff}}},
+		},
+	}
+	events := []*pb.TapEvent{
+		tapTestRequest(1, pb.HttpMethod_GET, "/books"),
+		withHost,
+		tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 111000}),
+		tapTestResponse(2, http.StatusNotFound, &duration.Duration{Nanos: 111000}),
+		tapTestEnd(2, grpcOK, 2048),
+		tapTestEnd(1, grpcOK, 42),
+		// Incomplete streams are not exported.
+		tapTestRequest(3, pb.HttpMethod_GET, "/books"),
+		// Neither are streams whose request wasn't observed.
+		tapTestEnd(4, grpcOK, 0),
+	}
+
+	writer := bytes.NewBufferString("")
+	err := renderTapEventsHAR(tapEventStream(t, events...), writer, newTapOptions())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	t.Run("Produces a valid HAR document", func(t *testing.T) {
+		var doc map[string]interface{}
+		if err := json.Unmarshal(writer.Bytes(), &doc); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		log, ok := doc["log"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("Expecting a log object, got [%v]", doc["log"])
+		}
+		if log["version"] != harVersion {
+			t.Fatalf("Expecting version [%s], got [%v]", harVersion, log["version"])
+		}
+		creator, ok := log["creator"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("Expecting a creator object, got [%v]", log["creator"])
+		}
+		if creator["version"] != version.Version {
+			t.Fatalf("Expecting the creator's version to be the CLI's, [%s], got [%v]", version.Version, creator["version"])
+		}
+		entries, ok := log["entries"].([]interface{})
+		if !ok {
+			t.Fatalf("Expecting an entries array, got [%v]", log["entries"])
+		}
+		if len(entries) != 2 {
+			t.Fatalf("Expecting 2 entries, got %d", len(entries))
+		}
+
+		required := map[string][]string{
+			"":         {"startedDateTime", "time", "request", "response", "cache", "timings"},
+			"request":  {"method", "url", "httpVersion", "cookies", "headers", "queryString", "headersSize", "bodySize"},
+			"response": {"status", "statusText", "httpVersion", "cookies", "headers", "content", "redirectURL", "headersSize", "bodySize"},
+			"timings":  {"send", "wait", "receive"},
+		}
+		for i, e := range entries {
+			entry := e.(map[string]interface{})
+			for object, fields := range required {
+				obj := entry
+				if object != "" {
+					obj = entry[object].(map[string]interface{})
+				}
+				for _, field := range fields {
+					if _, ok := obj[field]; !ok {
+						t.Fatalf("Expecting entry %d to have %s.%s, got [%v]", i, object, field, obj)
+					}
+				}
+			}
+		}
+	})
+
+	t.Run("Correlates events into entries", func(t *testing.T) {
+		var doc harDocument
+		if err := json.Unmarshal(writer.Bytes(), &doc); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		// Entries are in the order their responses ended.
+		entry := doc.Log.Entries[0]
+		if entry.Request.Method != "POST" {
+			t.Fatalf("Expecting method [POST], got [%s]", entry.Request.Method)
+		}
+		expectedURL := "http://books.default:7000/authors?page=2&q=a%20b"
+		if entry.Request.URL != expectedURL {
+			t.Fatalf("Expecting url [%s], got [%s]", expectedURL, entry.Request.URL)
+		}
+		expectedQuery := []harNameValue{{Name: "page", Value: "2"}, {Name: "q", Value: "a b"}}
+		if !reflect.DeepEqual(entry.Request.QueryString, expectedQuery) {
+			t.Fatalf("Expecting queryString %v, got %v", expectedQuery, entry.Request.QueryString)
+		}
+		expectedHeaders := []harNameValue{{Name: "host", Value: "books.default:7000"}}
+		if !reflect.DeepEqual(entry.Request.Headers, expectedHeaders) {
+			t.Fatalf("Expecting headers %v, got %v", expectedHeaders, entry.Request.Headers)
+		}
+		if entry.Request.HTTPVersion != httpVersion11 {
+			t.Fatalf("Expecting httpVersion [%s], got [%s]", httpVersion11, entry.Request.HTTPVersion)
+		}
+		if entry.Response.Status != http.StatusNotFound || entry.Response.StatusText != "Not Found" {
+			t.Fatalf("Expecting status [404 Not Found], got [%d %s]", entry.Response.Status, entry.Response.StatusText)
+		}
+		if entry.Response.BodySize != 2048 || entry.Response.Content.Size != 2048 {
+			t.Fatalf("Expecting bodySize 2048, got %d", entry.Response.BodySize)
+		}
+
+		expectedTimings := harTimings{Send: 0, Wait: 0.111, Receive: 0.888}
+		if entry.Timings != expectedTimings {
+			t.Fatalf("Expecting timings %+v, got %+v", expectedTimings, entry.Timings)
+		}
+		if entry.Time != 0.999 {
+			t.Fatalf("Expecting time 0.999, got %v", entry.Time)
+		}
+
+		if doc.Log.Entries[1].Request.URL != "http://books.default:7000/books" {
+			t.Fatalf("Expecting url [http://books.default:7000/books], got [%s]", doc.Log.Entries[1].Request.URL)
+		}
+	})
+
+	t.Run("Renders start times with --time-precision", func(t *testing.T) {
+		options := newTapOptions()
+		options.utc = true
+		options.timePrecision = "ms"
+		options.now = func() time.Time { return time.Date(2019, 10, 1, 12, 30, 15, 0, time.UTC) }
+		writer := bytes.NewBufferString("")
+		err := renderTapEventsHAR(tapEventStream(t, events...), writer, options)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var doc harDocument
+		if err := json.Unmarshal(writer.Bytes(), &doc); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		for _, entry := range doc.Log.Entries {
+			if entry.StartedDateTime != "2019-10-01T12:30:15.000Z" {
+				t.Fatalf("Expecting startedDateTime [2019-10-01T12:30:15.000Z], got [%s]", entry.StartedDateTime)
+			}
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"time"
+
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"github.com/linkerd/linkerd2/pkg/tap"
+)
+
+// idleTimer fires once no event has been displayed for `--idle-timeout`. A
+// zero timeout never fires.
+type idleTimer struct {
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+func newIdleTimer(timeout time.Duration) *idleTimer {
+	t := &idleTimer{timeout: timeout}
+	if timeout > 0 {
+		t.timer = time.NewTimer(timeout)
+	}
+	return t
+}
+
+// expired returns the channel the timer fires on, or nil if it never fires.
+func (t *idleTimer) expired() <-chan time.Time {
+	if t.timer == nil {
+		return nil
+	}
+	return t.timer.C
+}
+
+// reset restarts the timer. It must not be called once the timer fired and
+// expired() was received from.
+func (t *idleTimer) reset() {
+	if t.timer == nil {
+		return
+	}
+	if !t.timer.Stop() {
+		<-t.timer.C
+	}
+	t.timer.Reset(t.timeout)
+}
+
+func (t *idleTimer) stop() {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+}
+
+type decodedTapEvent struct {
+	event *pb.TapEvent
+	err   error
+}
+
+// decodeTapEvents decodes events from the decoder until it fails, so that
+// waiting for the next event can be interrupted. Decoding stops early once
+// done is closed.
+func decodeTapEvents(decoder *tap.Decoder, done <-chan struct{}) <-chan decodedTapEvent {
+	decoded := make(chan decodedTapEvent)
+	go func() {
+		for {
+			event, err := decoder.Decode()
+			select {
+			case decoded <- decodedTapEvent{event, err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return decoded
+}
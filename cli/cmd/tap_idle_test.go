@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+func TestRenderTapEventsIdleTimeout(t *testing.T) {
+	events := []*pb.TapEvent{
+		tapTestRequest(1, pb.HttpMethod_GET, "/books"),
+		tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 1000}),
+		tapTestEnd(1, &pb.Eos{}, 10),
+	}
+	stream, err := ioutil.ReadAll(tapEventStream(t, events...))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The stream stalls once the events were written, as if traffic stopped.
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	go writer.Write(stream)
+
+	options := newTapOptions()
+	options.idleTimeout = 50 * time.Millisecond
+	options.sizeHistogram = true
+	output := &bytes.Buffer{}
+	rendered := make(chan error, 1)
+	go func() {
+		rendered <- renderTapEvents(bufio.NewReader(reader), output, renderTapEvent, "", options)
+	}()
+
+	select {
+	case err := <-rendered:
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected tap to stop once idle, it's still running")
+	}
+
+	ids := renderedIDs(output.String())
+	if len(ids) < 3 || ids[2] != "end id=7:1" {
+		t.Fatalf("Expecting the events received before going idle, got [%s]", output)
+	}
+	if !bytes.Contains(output.Bytes(), []byte("RESPONSE SIZE")) {
+		t.Fatalf("Expecting the summary to be written, got [%s]", output)
+	}
+}
+
+func TestIdleTimer(t *testing.T) {
+	t.Run("Never fires without a timeout", func(t *testing.T) {
+		timer := newIdleTimer(0)
+		timer.reset()
+		if timer.expired() != nil {
+			t.Fatal("Expected no expiry channel, got one")
+		}
+		timer.stop()
+	})
+
+	t.Run("Restarts on reset", func(t *testing.T) {
+		timer := newIdleTimer(time.Hour)
+		defer timer.stop()
+		timer.timer.Reset(time.Nanosecond)
+		time.Sleep(time.Millisecond)
+		// The timer fired, but nothing was received from it yet.
+		timer.reset()
+		select {
+		case <-timer.expired():
+			t.Fatal("Expected the timer to be restarted, it fired")
+		case <-time.After(10 * time.Millisecond):
+		}
+	})
+}
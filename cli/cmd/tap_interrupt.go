@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"io"
+)
+
+// interruptTapStream returns a reader over tapByteStream that ends cleanly,
+// as if the stream had ended, once ctx is done. body, which tapByteStream
+// reads from, is then closed to unblock any pending read. The caller must
+// ensure ctx is eventually done.
+func interruptTapStream(ctx context.Context, tapByteStream *bufio.Reader, body io.Closer) *bufio.Reader {
+	go func() {
+		<-ctx.Done()
+		body.Close()
+	}()
+	return bufio.NewReader(&interruptibleReader{ctx: ctx, reader: tapByteStream})
+}
+
+type interruptibleReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+// Read keeps returning what was already received once ctx is done, until the
+// read fails because the body was closed.
+func (r *interruptibleReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil && r.ctx.Err() != nil {
+		return n, io.EOF
+	}
+	return n, err
+}
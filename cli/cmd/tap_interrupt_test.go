@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+func TestInterruptTapStream(t *testing.T) {
+	stream, err := ioutil.ReadAll(tapEventStream(t,
+		tapTestRequest(1, pb.HttpMethod_GET, "/books"),
+		tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 1000}),
+		tapTestEnd(1, &pb.Eos{}, 10),
+	))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The tap stream stays open, as it does until tap is interrupted.
+	body, bodyWriter := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		// Writes to the pipe return once the events have been read.
+		bodyWriter.Write(stream)
+		cancel()
+	}()
+
+	options := newTapOptions()
+	options.sizeHistogram = true
+	writer := bytes.NewBufferString("")
+	reader := interruptTapStream(ctx, bufio.NewReader(body), body)
+	err = writeTapEventsToBuffer(writer, reader, &pb.TapByResourceRequest{}, options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	output := writer.String()
+	if !strings.HasPrefix(output, "req id=7:1 ") || !strings.Contains(output, "\nend id=7:1 ") {
+		t.Fatalf("Expecting the events received before the interruption, got [%s]", output)
+	}
+	if !strings.Contains(output, "\nRESPONSE SIZE   COUNT\n0B-1KB          1\n") {
+		t.Fatalf("Expecting the histogram to be printed, got [%s]", output)
+	}
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"bufio"
+	"io"
+
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+const jsonArrayOutput = "json-array"
+
+// renderTapEventsJSONArray writes the events of a tap stream as the elements
+// of a single JSON array, rendered as in "json" output, so that the whole
+// output can be parsed at once. The array is closed once the stream ends,
+// including when tap is interrupted.
+func renderTapEventsJSONArray(tapByteStream *bufio.Reader, w io.Writer, options *tapOptions) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+
+	first := true
+	err := forEachTapEvent(tapByteStream, options, func(event *pb.TapEvent) error {
+		separator := ",\n"
+		if first {
+			separator, first = "\n", false
+		}
+		// The separator is written along with the event, so that the array
+		// never ends with a trailing comma.
+		_, err := io.WriteString(w, separator+renderTapEventJSON(event, "", options))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	closing := "\n]\n"
+	if first {
+		closing = "]\n"
+	}
+	_, err = io.WriteString(w, closing)
+	return err
+}
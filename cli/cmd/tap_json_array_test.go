@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+func TestRenderTapEventsJSONArray(t *testing.T) {
+	testCases := []struct {
+		name   string
+		events []*pb.TapEvent
+	}{
+		{"no events", nil},
+		{"one event", []*pb.TapEvent{tapTestRequest(1, pb.HttpMethod_GET, "/search?q=a&b")}},
+		{"several events", []*pb.TapEvent{
+			tapTestRequest(1, pb.HttpMethod_GET, "/books"),
+			tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 1000}),
+			tapTestEnd(1, &pb.Eos{}, 10),
+		}},
+	}
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(tc.name, func(t *testing.T) {
+			options := newTapOptions()
+			options.output = jsonArrayOutput
+			if err := options.validate(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			output := &bytes.Buffer{}
+			err := renderTapEventsJSONArray(tapEventStream(t, tc.events...), output, options)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			var rendered []map[string]interface{}
+			if err := json.Unmarshal(output.Bytes(), &rendered); err != nil {
+				t.Fatalf("Expecting a JSON array, got [%s]: %v", output, err)
+			}
+			if len(rendered) != len(tc.events) {
+				t.Fatalf("Expecting %d elements, got %d in [%s]", len(tc.events), len(rendered), output)
+			}
+			for i, event := range rendered {
+				if event["proxyDirection"] != "OUTBOUND" {
+					t.Fatalf("Expecting element %d to be an event, got [%v]", i, event)
+				}
+			}
+		})
+	}
+
+	t.Run("Rejects summaries", func(t *testing.T) {
+		options := newTapOptions()
+		options.output = jsonArrayOutput
+		options.sizeHistogram = true
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
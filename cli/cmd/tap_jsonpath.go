@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// parseTapJSONPath parses a `--jsonpath` template, with the same semantics as
+// `kubectl get -o jsonpath`. Missing fields yield no values, as most fields
+// are only present in some of the events.
+func parseTapJSONPath(template string) (*jsonpath.JSONPath, error) {
+	jp := jsonpath.New("tap").AllowMissingKeys(true)
+	if err := jp.Parse(template); err != nil {
+		return nil, err
+	}
+	return jp, nil
+}
+
+// renderTapEventJSONPath renders the values a JSONPath template extracts from
+// the JSON rendering of an event, one per line. Objects and arrays are
+// rendered as JSON.
+func renderTapEventJSONPath(event *pb.TapEvent, _ string, options *tapOptions) string {
+	m := mapTapEventWithOptions(event, options)
+
+	// The template refers to fields by their JSON names, so it is applied to
+	// the JSON rendering rather than to the struct.
+	e, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Sprintf("error marshalling JSON: %s", err)
+	}
+	// Decoding numbers as json.Number keeps them from being rendered in
+	// exponent notation.
+	decoder := json.NewDecoder(bytes.NewReader(e))
+	decoder.UseNumber()
+	var data interface{}
+	if err := decoder.Decode(&data); err != nil {
+		return fmt.Sprintf("error unmarshalling JSON: %s", err)
+	}
+
+	results, err := options.jsonPathTemplate.FindResults(data)
+	if err != nil {
+		return fmt.Sprintf("error executing JSONPath: %s", err)
+	}
+	var lines []string
+	for _, values := range results {
+		for _, v := range values {
+			lines = append(lines, formatJSONPathValue(v))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatJSONPathValue(v reflect.Value) string {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice:
+		e, err := json.Marshal(v.Interface())
+		if err != nil {
+			return fmt.Sprint(v.Interface())
+		}
+		return string(e)
+	case reflect.Invalid:
+		return "null"
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
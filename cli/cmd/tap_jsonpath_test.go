@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+func TestRenderTapEventJSONPath(t *testing.T) {
+	request := tapTestRequest(1, pb.HttpMethod_GET, "/books")
+	request.GetHttp().GetRequestInit().Headers = &pb.Headers{
+		Headers: []*pb.Headers_Header{
+			{Name: "host", Value: &pb.Headers_Header_ValueStr{ValueStr: "books.default:7000"}},
+		},
+	}
+	response := tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 1000})
+
+	testCases := []struct {
+		template string
+		event    *pb.TapEvent
+		expected string
+	}{
+		{"{.requestInitEvent.path}", request, "/books"},
+		{"{.requestInitEvent.id.stream}", request, "1"},
+		{"{.requestInitEvent.headers[0].valueStr}", request, "books.default:7000"},
+		{"{.destination.ip}", request, "2.3.4.5"},
+		{"{.requestInitEvent.id}", request, `{"base":7,"stream":1}`},
+		{"{.responseInitEvent.httpStatus}", response, "200"},
+		{"{.responseEndEvent.responseBytes}", tapTestEnd(1, &pb.Eos{}, 12345678), "12345678"},
+		// Fields missing from the event yield no values.
+		{"{.requestInitEvent.path}", response, ""},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(tc.template, func(t *testing.T) {
+			options := newTapOptions()
+			options.jsonPath = tc.template
+			if err := options.validate(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			output := renderTapEventJSONPath(tc.event, "", options)
+			if output != tc.expected {
+				t.Fatalf("Expecting [%s], got [%s]", tc.expected, output)
+			}
+		})
+	}
+
+	t.Run("Renders events with --jsonpath regardless of the output format", func(t *testing.T) {
+		options := newTapOptions()
+		options.output = jsonOutput
+		options.jsonPath = "{.requestInitEvent.path}"
+		if err := options.validate(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		writer := &bytes.Buffer{}
+		err := writeTapEventsToBuffer(writer, tapEventStream(t, request), &pb.TapByResourceRequest{}, options)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if writer.String() != "/books\n" {
+			t.Fatalf("Expecting [/books\n], got [%s]", writer.String())
+		}
+	})
+
+	t.Run("Rejects an invalid template", func(t *testing.T) {
+		options := newTapOptions()
+		options.jsonPath = "{.requestInitEvent.path"
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
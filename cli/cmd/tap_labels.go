@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+// labelField is a peer label that `--fields-from-labels` displays as a column.
+type labelField struct {
+	direction string
+	name      string
+}
+
+// parseLabelFields parses a comma-separated list of label names, each
+// optionally prefixed with the peer it's looked up in, `src:` or `dst:`. Labels
+// are looked up in the destination by default.
+func parseLabelFields(value string) ([]labelField, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var fields []labelField
+	for _, name := range strings.Split(value, ",") {
+		field := labelField{direction: "dst", name: strings.TrimSpace(name)}
+		if i := strings.Index(field.name, ":"); i >= 0 {
+			field.direction, field.name = field.name[:i], field.name[i+1:]
+			if field.direction != "src" && field.direction != "dst" {
+				return nil, fmt.Errorf("unknown peer %q; must be src or dst", field.direction)
+			}
+		}
+		if field.name == "" {
+			return nil, errors.New("label names must not be empty")
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// formatLabelFields renders the labels of an event's peers that are displayed
+// as columns, e.g. ` dst_app=books dst_version=v2`. Missing labels are
+// rendered empty.
+func formatLabelFields(event *pb.TapEvent, fields []labelField) string {
+	var s strings.Builder
+	for _, field := range fields {
+		p := dst(event)
+		if field.direction == "src" {
+			p = src(event)
+		}
+		fmt.Fprintf(&s, " %s_%s=%s", p.direction, field.name, p.labels[field.name])
+	}
+	return s.String()
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"github.com/linkerd/linkerd2/pkg/k8s"
+)
+
+func TestParseLabelFields(t *testing.T) {
+	fields, err := parseLabelFields("app, src:version,dst:app.kubernetes.io/part-of")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []labelField{
+		{direction: "dst", name: "app"},
+		{direction: "src", name: "version"},
+		{direction: "dst", name: "app.kubernetes.io/part-of"},
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("Expecting %v, got %v", expected, fields)
+	}
+
+	for _, value := range []string{"app,,version", "peer:app", "src:"} {
+		if _, err := parseLabelFields(value); err == nil {
+			t.Fatalf("Expecting an error for [%s]", value)
+		}
+	}
+}
+
+func TestRenderTapEventLabelFields(t *testing.T) {
+	event := tapTestRequest(1, pb.HttpMethod_GET, "/books")
+	event.SourceMeta = &pb.TapEvent_EndpointMeta{
+		Labels: map[string]string{k8s.Deployment: "web", "app": "web", "version": "v1"},
+	}
+	event.DestinationMeta = &pb.TapEvent_EndpointMeta{
+		Labels: map[string]string{k8s.Deployment: "books-v2", "app": "books"},
+	}
+
+	testCases := []struct {
+		fieldsFromLabels string
+		expected         string
+	}{
+		{"app,version", " dst_res=deploy/books-v2 dst_app=books dst_version="},
+		{"src:version,app", " dst_res=deploy/books-v2 src_version=v1 dst_app=books"},
+	}
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(tc.fieldsFromLabels, func(t *testing.T) {
+			options := newTapOptions()
+			options.output = wideOutput
+			options.fieldsFromLabels = tc.fieldsFromLabels
+			if err := options.validate(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			line := renderTapEvent(event, k8s.Deployment, options)
+			if !strings.HasSuffix(line, tc.expected) {
+				t.Fatalf("Expecting line to end with [%s], got [%s]", tc.expected, line)
+			}
+		})
+	}
+
+	t.Run("Rejects outputs without columns", func(t *testing.T) {
+		options := newTapOptions()
+		options.fieldsFromLabels = "app"
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
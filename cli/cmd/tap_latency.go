@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+// latencyBucketNames are the `--latency-buckets` tags, from fastest to
+// slowest. Every bucket but the last has an upper bound set by
+// `--latency-thresholds`.
+var latencyBucketNames = []string{"fast", "normal", "slow", "very-slow"}
+
+// parseLatencyThresholds parses the comma-separated, increasing upper bounds
+// of the latency buckets.
+func parseLatencyThresholds(thresholds string) ([]time.Duration, error) {
+	parts := strings.Split(thresholds, ",")
+	if len(parts) != len(latencyBucketNames)-1 {
+		return nil, fmt.Errorf("expected %d thresholds, got %d", len(latencyBucketNames)-1, len(parts))
+	}
+
+	bounds := make([]time.Duration, len(parts))
+	for i, part := range parts {
+		bound, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 && bound <= bounds[i-1] {
+			return nil, fmt.Errorf("thresholds must be increasing, got %s after %s", bound, bounds[i-1])
+		}
+		bounds[i] = bound
+	}
+	return bounds, nil
+}
+
+// latencyBucket returns the name of the bucket a latency falls in.
+func latencyBucket(latency time.Duration, bounds []time.Duration) string {
+	for i, bound := range bounds {
+		if latency < bound {
+			return latencyBucketNames[i]
+		}
+	}
+	return latencyBucketNames[len(latencyBucketNames)-1]
+}
+
+// latencyBucketTag returns the ` [bucket]` tag of a response event, based on
+// the time since its request started, or an empty string for other events.
+func latencyBucketTag(event *pb.TapEvent, bounds []time.Duration) string {
+	var sinceRequestInit time.Duration
+	var err error
+	switch ev := event.GetHttp().GetEvent().(type) {
+	case *pb.TapEvent_Http_ResponseInit_:
+		sinceRequestInit, err = ptypes.Duration(ev.ResponseInit.GetSinceRequestInit())
+	case *pb.TapEvent_Http_ResponseEnd_:
+		sinceRequestInit, err = ptypes.Duration(ev.ResponseEnd.GetSinceRequestInit())
+	default:
+		return ""
+	}
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf(" [%s]", latencyBucket(sinceRequestInit, bounds))
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+func TestLatencyBuckets(t *testing.T) {
+	t.Run("Maps latencies to buckets", func(t *testing.T) {
+		bounds, err := parseLatencyThresholds(newTapOptions().latencyThresholds)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		testCases := []struct {
+			latency time.Duration
+			bucket  string
+		}{
+			{0, "fast"},
+			{9 * time.Millisecond, "fast"},
+			{10 * time.Millisecond, "normal"},
+			{99 * time.Millisecond, "normal"},
+			{100 * time.Millisecond, "slow"},
+			{time.Second, "very-slow"},
+			{time.Minute, "very-slow"},
+		}
+		for _, tc := range testCases {
+			if bucket := latencyBucket(tc.latency, bounds); bucket != tc.bucket {
+				t.Fatalf("Expecting %s to be in bucket [%s], got [%s]", tc.latency, tc.bucket, bucket)
+			}
+		}
+	})
+
+	t.Run("Tags response lines", func(t *testing.T) {
+		options := newTapOptions()
+		options.latencyBuckets = true
+		options.latencyThresholds = "1ms, 2ms, 3ms"
+		if err := options.validate(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		events := []*pb.TapEvent{
+			tapTestRequest(1, pb.HttpMethod_GET, "/books"),
+			tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 1500000}),
+			// The end line uses the time since the request started: 999µs.
+			tapTestEnd(1, &pb.Eos{}, 0),
+			tapTestResponse(2, http.StatusOK, &duration.Duration{Seconds: 1}),
+		}
+		lines := strings.Split(strings.TrimSuffix(renderTestTapEvents(t, options, events...), "\n"), "\n")
+		expectedSuffixes := []string{":path=/books", "latency=1500µs [normal]", "response-length=0B [fast]", "latency=1000000µs [very-slow]"}
+		for i, line := range lines {
+			if !strings.HasSuffix(line, expectedSuffixes[i]) {
+				t.Fatalf("Expecting line %d to end with [%s], got [%s]", i, expectedSuffixes[i], line)
+			}
+		}
+	})
+
+	t.Run("Rejects invalid thresholds", func(t *testing.T) {
+		for _, thresholds := range []string{"", "10ms,100ms", "10ms,100ms,1s,10s", "10ms,foo,1s", "10ms,5ms,1s"} {
+			options := newTapOptions()
+			options.latencyThresholds = thresholds
+			if err := options.validate(); err == nil {
+				t.Fatalf("Expecting an error for [%s], got nothing", thresholds)
+			}
+		}
+	})
+}
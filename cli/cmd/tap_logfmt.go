@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+const logfmtOutput = "logfmt"
+
+// renderTapEventLogfmt renders a Public API TapEvent as a logfmt line, e.g.
+// `ts=... type=req id=0:1 proxy=out src=... dst=... method=GET path=/books`.
+func renderTapEventLogfmt(event *pb.TapEvent, _ string, options *tapOptions) string {
+	m := mapTapEventWithOptions(event, options)
+	pairs := [][2]string{
+		{"ts", options.timestamp(options.now()).Format(options.timestampLayout())},
+		{"type", logfmtEventType(event)},
+	}
+	if id := eventStreamID(event); id != nil {
+		pairs = append(pairs, [2]string{"id", fmt.Sprintf("%d:%d", id.GetBase(), id.GetStream())})
+	}
+	pairs = append(pairs,
+		[2]string{"proxy", strings.ToLower(m.ProxyDirection)},
+		[2]string{"src", fmt.Sprintf("%s:%d", m.Source.IP, m.Source.Port)},
+		[2]string{"dst", fmt.Sprintf("%s:%d", m.Destination.IP, m.Destination.Port)},
+		[2]string{"tls", tlsStatus(event)},
+	)
+	if m.TraceID != "" {
+		pairs = append(pairs, [2]string{"trace", m.TraceID})
+	}
+
+	switch {
+	case m.RequestInitEvent != nil:
+		reqI := m.RequestInitEvent
+		pairs = append(pairs,
+			[2]string{"method", reqI.Method},
+			[2]string{"authority", reqI.Authority},
+			[2]string{"path", reqI.Path},
+		)
+		if reqI.GRPCService != "" {
+			pairs = append(pairs,
+				[2]string{"grpc_service", reqI.GRPCService},
+				[2]string{"grpc_method", reqI.GRPCMethod},
+			)
+		}
+		if reqI.Version != "" {
+			pairs = append(pairs, [2]string{"version", reqI.Version})
+		}
+
+	case m.ResponseInitEvent != nil:
+		rspI := m.ResponseInitEvent
+		pairs = append(pairs,
+			[2]string{"status", strconv.FormatUint(uint64(rspI.HTTPStatus), 10)},
+			[2]string{"latency_us", logfmtMicros(rspI.SinceRequestInit)},
+		)
+
+	case m.ResponseEndEvent != nil:
+		rspE := m.ResponseEndEvent
+		switch event.GetHttp().GetResponseEnd().GetEos().GetEnd().(type) {
+		case *pb.Eos_GrpcStatusCode:
+			pairs = append(pairs, [2]string{"grpc_status", strconv.FormatUint(uint64(rspE.GrpcStatusCode), 10)})
+		case *pb.Eos_ResetErrorCode:
+			pairs = append(pairs, [2]string{"reset_error", strconv.FormatUint(uint64(rspE.ResetErrorCode), 10)})
+		}
+		pairs = append(pairs,
+			[2]string{"duration_us", logfmtMicros(rspE.SinceResponseInit)},
+			[2]string{"response_bytes", strconv.FormatUint(rspE.ResponseBytes, 10)},
+		)
+	}
+
+	fields := make([]string, len(pairs))
+	for i, pair := range pairs {
+		fields[i] = fmt.Sprintf("%s=%s", pair[0], logfmtValue(pair[1]))
+	}
+	return strings.Join(fields, " ")
+}
+
+func logfmtEventType(event *pb.TapEvent) string {
+	switch event.GetHttp().GetEvent().(type) {
+	case *pb.TapEvent_Http_RequestInit_:
+		return "req"
+	case *pb.TapEvent_Http_ResponseInit_:
+		return "rsp"
+	case *pb.TapEvent_Http_ResponseEnd_:
+		return "end"
+	default:
+		return "unknown"
+	}
+}
+
+func logfmtMicros(d *duration.Duration) string {
+	return strconv.FormatInt(durationMicros(d), 10)
+}
+
+// logfmtValue quotes values that would otherwise not be parsed back as a
+// single value: empty ones, and those containing spaces, `=` or quotes.
+func logfmtValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " =\"\t\n\\") {
+		return strconv.Quote(value)
+	}
+	return value
+}
@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"google.golang.org/grpc/codes"
+)
+
+// parseLogfmt splits a logfmt line into its key/value pairs, unquoting
+// quoted values.
+func parseLogfmt(t *testing.T, line string) map[string]string {
+	pairs := map[string]string{}
+	for line != "" {
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			t.Fatalf("Expecting a key=value pair, got [%s]", line)
+		}
+		key := line[:eq]
+		line = line[eq+1:]
+
+		value := line
+		if strings.HasPrefix(line, `"`) {
+			end := 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				t.Fatalf("Expecting a closing quote, got [%s]", line)
+			}
+			var err error
+			if value, err = strconv.Unquote(line[:end+1]); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			line = line[end+1:]
+		} else if sp := strings.IndexByte(line, ' '); sp >= 0 {
+			value = line[:sp]
+			line = line[sp:]
+		} else {
+			line = ""
+		}
+		pairs[key] = value
+		line = strings.TrimPrefix(line, " ")
+	}
+	return pairs
+}
+
+func TestRenderTapEventLogfmt(t *testing.T) {
+	common := map[string]string{
+		"id":    "7:1",
+		"proxy": "outbound",
+		"src":   "1.2.3.4:5555",
+		"dst":   "2.3.4.5:6666",
+		"tls":   "",
+	}
+	testCases := []struct {
+		name     string
+		event    *pb.TapEvent
+		expected map[string]string
+	}{
+		{
+			"req",
+			tapTestRequest(1, pb.HttpMethod_GET, "/books?q=a b"),
+			map[string]string{"method": "GET", "authority": "books.default:7000", "path": "/books?q=a b"},
+		},
+		{
+			"rsp",
+			tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 1234000}),
+			map[string]string{"status": "200", "latency_us": "1234"},
+		},
+		{
+			"end",
+			tapTestEnd(1, &pb.Eos{End: &pb.Eos_GrpcStatusCode{GrpcStatusCode: uint32(codes.Unavailable)}}, 42),
+			map[string]string{"grpc_status": "14", "duration_us": "888", "response_bytes": "42"},
+		},
+		{
+			"end",
+			tapTestEnd(1, &pb.Eos{End: &pb.Eos_ResetErrorCode{ResetErrorCode: 2}}, 0),
+			map[string]string{"reset_error": "2", "duration_us": "888", "response_bytes": "0"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(tc.name, func(t *testing.T) {
+			line := renderTapEventLogfmt(tc.event, "", newTapOptions())
+			if !strings.HasPrefix(line, "ts=") || !strings.Contains(line, " type="+tc.name+" ") {
+				t.Fatalf("Expecting the line to start with ts and type, got [%s]", line)
+			}
+			pairs := parseLogfmt(t, line)
+
+			if _, err := time.Parse(time.RFC3339Nano, pairs["ts"]); err != nil {
+				t.Fatalf("Expecting an RFC3339 timestamp, got [%s]", pairs["ts"])
+			}
+			expected := map[string]string{"ts": pairs["ts"], "type": tc.name}
+			for k, v := range common {
+				expected[k] = v
+			}
+			for k, v := range tc.expected {
+				expected[k] = v
+			}
+			for k, v := range expected {
+				if actual, ok := pairs[k]; !ok || actual != v {
+					t.Fatalf("Expecting %s=[%s], got [%s] in [%s]", k, v, actual, line)
+				}
+			}
+			if len(pairs) != len(expected) {
+				t.Fatalf("Expecting %d pairs, got %d in [%s]", len(expected), len(pairs), line)
+			}
+		})
+	}
+
+	t.Run("Reads the timestamp from the clock", func(t *testing.T) {
+		options := newTapOptions()
+		options.utc = true
+		options.now = tapTestClock(time.Date(2019, 10, 1, 12, 30, 15, 123456789, time.UTC), time.Second)
+
+		for _, expected := range []string{"2019-10-01T12:30:15.123456789Z", "2019-10-01T12:30:16.123456789Z"} {
+			pairs := parseLogfmt(t, renderTapEventLogfmt(tapTestRequest(1, pb.HttpMethod_GET, "/books"), "", options))
+			if pairs["ts"] != expected {
+				t.Fatalf("Expecting ts=%s, got ts=%s", expected, pairs["ts"])
+			}
+		}
+	})
+
+	t.Run("Renders timestamps with --time-precision", func(t *testing.T) {
+		testCases := []struct {
+			precision string
+			expected  string
+		}{
+			{"", "2019-10-01T12:30:15.1Z"},
+			{"s", "2019-10-01T12:30:15Z"},
+			{"ms", "2019-10-01T12:30:15.100Z"},
+			{"us", "2019-10-01T12:30:15.100000Z"},
+			{"ns", "2019-10-01T12:30:15.100000000Z"},
+		}
+		for _, tc := range testCases {
+			options := newTapOptions()
+			options.utc = true
+			options.timePrecision = tc.precision
+			options.now = func() time.Time { return time.Date(2019, 10, 1, 12, 30, 15, 100000000, time.UTC) }
+			if err := options.validate(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			pairs := parseLogfmt(t, renderTapEventLogfmt(tapTestRequest(1, pb.HttpMethod_GET, "/books"), "", options))
+			if pairs["ts"] != tc.expected {
+				t.Fatalf("Expecting ts=%s with --time-precision=%q, got ts=%s", tc.expected, tc.precision, pairs["ts"])
+			}
+		}
+	})
+
+	t.Run("Rejects unknown --time-precision", func(t *testing.T) {
+		options := newTapOptions()
+		options.timePrecision = "minutes"
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+
+	t.Run("Quotes values that need it", func(t *testing.T) {
+		testCases := map[string]string{
+			"/books":     "/books",
+			"":           `""`,
+			"a b":        `"a b"`,
+			"a=b":        `"a=b"`,
+			`say "hi"`:   `"say \"hi\""`,
+			"GET /{id}":  `"GET /{id}"`,
+			"µs-latency": "µs-latency",
+		}
+		for value, expected := range testCases {
+			if quoted := logfmtValue(value); quoted != expected {
+				t.Fatalf("Expecting [%s] to be rendered as [%s], got [%s]", value, expected, quoted)
+			}
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+// longRequestWatcher tracks the requests of a tap stream waiting for their
+// response, and writes a `still-open id=7:1 age=12s` notice for each of those
+// open for longer than `--long-request-threshold`, on every tick, so that
+// hung requests are noticed while they hang.
+type longRequestWatcher struct {
+	threshold time.Duration
+	now       func() time.Time
+
+	sync.Mutex
+	open map[streamKey]openRequest
+}
+
+type openRequest struct {
+	id       *pb.TapEvent_Http_StreamId
+	openedAt time.Time
+}
+
+func newLongRequestWatcher(threshold time.Duration, now func() time.Time) *longRequestWatcher {
+	return &longRequestWatcher{
+		threshold: threshold,
+		now:       now,
+		open:      make(map[streamKey]openRequest),
+	}
+}
+
+// add accounts for an event that passed the display filters. Requests are
+// open from their request until their response starts, or ends if it's reset
+// before starting.
+func (l *longRequestWatcher) add(event *pb.TapEvent) {
+	l.Lock()
+	defer l.Unlock()
+
+	key := newStreamKey(event)
+	switch ev := event.GetHttp().GetEvent().(type) {
+	case *pb.TapEvent_Http_RequestInit_:
+		l.open[key] = openRequest{id: ev.RequestInit.GetId(), openedAt: l.now()}
+	case *pb.TapEvent_Http_ResponseInit_, *pb.TapEvent_Http_ResponseEnd_:
+		delete(l.open, key)
+	}
+}
+
+// write writes a notice for each request open for longer than the
+// threshold, from the oldest one.
+func (l *longRequestWatcher) write(w io.Writer) error {
+	l.Lock()
+	now := l.now()
+	var long []openRequest
+	for _, req := range l.open {
+		if now.Sub(req.openedAt) >= l.threshold {
+			long = append(long, req)
+		}
+	}
+	l.Unlock()
+
+	sort.Slice(long, func(i, j int) bool { return long[i].openedAt.Before(long[j].openedAt) })
+	for _, req := range long {
+		_, err := fmt.Fprintf(w, "still-open id=%d:%d age=%s\n",
+			req.id.GetBase(), req.id.GetStream(), now.Sub(req.openedAt).Round(time.Millisecond))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// start writes the notices to w on every tick of the threshold, until the
+// returned function is called.
+func (l *longRequestWatcher) start(w io.Writer) (stop func()) {
+	return writeOnTicks(l.threshold, func() error { return l.write(w) })
+}
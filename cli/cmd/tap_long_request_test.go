@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+// tapTestSettableClock returns a clock reading the time last set, which may be
+// read and set concurrently.
+func tapTestSettableClock(start time.Time) (now func() time.Time, set func(time.Time)) {
+	var lock sync.Mutex
+	current := start
+	now = func() time.Time {
+		lock.Lock()
+		defer lock.Unlock()
+		return current
+	}
+	set = func(t time.Time) {
+		lock.Lock()
+		defer lock.Unlock()
+		current = t
+	}
+	return now, set
+}
+
+func TestLongRequestWatcher(t *testing.T) {
+	start := time.Unix(0, 0)
+	now, setNow := tapTestSettableClock(start)
+	watcher := newLongRequestWatcher(30*time.Second, now)
+	watcher.add(tapTestRequest(1, pb.HttpMethod_GET, "/books"))
+	setNow(start.Add(10 * time.Second))
+	watcher.add(tapTestRequest(2, pb.HttpMethod_GET, "/books"))
+	watcher.add(tapTestRequest(3, pb.HttpMethod_GET, "/books"))
+	watcher.add(tapTestRequest(4, pb.HttpMethod_GET, "/books"))
+	setNow(start.Add(25 * time.Second))
+	watcher.add(tapTestRequest(5, pb.HttpMethod_GET, "/books"))
+	// Requests are no longer open once their response starts, or ends if it
+	// doesn't start.
+	watcher.add(tapTestResponse(3, http.StatusOK, &duration.Duration{Nanos: 1000}))
+	watcher.add(tapTestEnd(4, &pb.Eos{End: &pb.Eos_ResetErrorCode{ResetErrorCode: 2}}, 0))
+
+	testCases := []struct {
+		age      time.Duration
+		expected string
+	}{
+		{20 * time.Second, ""},
+		{30 * time.Second, "still-open id=7:1 age=30s\n"},
+		{45 * time.Second, "still-open id=7:1 age=45s\nstill-open id=7:2 age=35s\n"},
+	}
+	for _, tc := range testCases {
+		setNow(start.Add(tc.age))
+		output := bytes.NewBufferString("")
+		if err := watcher.write(output); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if output.String() != tc.expected {
+			t.Fatalf("After %s: expecting [%s], got [%s]", tc.age, tc.expected, output.String())
+		}
+	}
+}
+
+func TestRenderTapEventsLongRequestThreshold(t *testing.T) {
+	ticks := make(chan time.Time)
+	defer func(newTicker func(time.Duration) (<-chan time.Time, func())) {
+		newSummaryTicker = newTicker
+	}(newSummaryTicker)
+	newSummaryTicker = func(time.Duration) (<-chan time.Time, func()) {
+		return ticks, func() {}
+	}
+
+	encode := func(events ...*pb.TapEvent) []byte {
+		stream, err := ioutil.ReadAll(tapEventStream(t, events...))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return stream
+	}
+	start := time.Unix(0, 0)
+	now, setNow := tapTestSettableClock(start)
+
+	// Events are received and lines rendered through pipes, so that each
+	// line is read as soon as it's written.
+	input, inputWriter := io.Pipe()
+	output, outputWriter := io.Pipe()
+	go func() {
+		options := newTapOptions()
+		options.longThreshold = 30 * time.Second
+		options.now = now
+		err := renderTapEvents(bufio.NewReader(input), outputWriter, renderTapEvent, "", options)
+		outputWriter.CloseWithError(err)
+	}()
+	go inputWriter.Write(encode(
+		tapTestRequest(1, pb.HttpMethod_GET, "/books"),
+		tapTestRequest(2, pb.HttpMethod_GET, "/books"),
+		tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 1000}),
+	))
+
+	lines := bufio.NewScanner(output)
+	expectLine := func(expected string) {
+		if !lines.Scan() {
+			t.Fatalf("Expecting [%s], got the end of the output: %v", expected, lines.Err())
+		}
+		line := lines.Text()
+		if len(line) > len(expected) {
+			line = line[:len(expected)]
+		}
+		if line != expected {
+			t.Fatalf("Expecting [%s], got [%s]", expected, lines.Text())
+		}
+	}
+
+	for _, expected := range []string{"req id=7:1 ", "req id=7:2 ", "rsp id=7:1 "} {
+		expectLine(expected)
+	}
+	// The request of stream 2 is left open past the threshold.
+	setNow(start.Add(45 * time.Second))
+	ticks <- time.Now()
+	expectLine("still-open id=7:2 age=45s")
+
+	go inputWriter.Write(encode(tapTestResponse(2, http.StatusOK, &duration.Duration{Nanos: 1000})))
+	expectLine("rsp id=7:2 ")
+	ticks <- time.Now()
+
+	inputWriter.Close()
+	if lines.Scan() {
+		t.Fatalf("Expecting the output to end, got [%s]", lines.Text())
+	}
+	if err := lines.Err(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+// maxOpenStreamFiles bounds the number of files `--output-dir` keeps open.
+const maxOpenStreamFiles = 64
+
+// streamFileNameReplacer makes the addresses of a stream's peers safe to use
+// in file names.
+var streamFileNameReplacer = strings.NewReplacer(":", "_", "[", "", "]", "")
+
+// streamFileWriter writes the events of each stream to its own file in a
+// directory, named after the stream's source, destination and ID, e.g.
+// `1.2.3.4_5555-2.3.4.5_6666-7-1.ndjson`, as NDJSON, since the IDs of streams
+// tapped from different proxies collide. The directory must not hold stream
+// files already, so that the events of a previous tap aren't mixed with
+// those of this one.
+//
+// A stream's file is closed once its response ends. At most limit files are
+// open at once, so that streams whose response never ends don't exhaust file
+// descriptors; the file of the least recently written stream is closed to
+// make room for another one, and reopened to append to it if the stream
+// gets more events.
+type streamFileWriter struct {
+	dir   string
+	limit int
+	files map[streamKey]*streamFile
+	// order holds the keys of the streams with an open file, least recently
+	// written first.
+	order *list.List
+}
+
+type streamFile struct {
+	file *os.File
+	elem *list.Element
+}
+
+func newStreamFileWriter(dir string, limit int) (*streamFileWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	existing, err := filepath.Glob(filepath.Join(dir, "*.ndjson"))
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 {
+		return nil, fmt.Errorf("%s already holds stream files, such as %s", dir, filepath.Base(existing[0]))
+	}
+	return &streamFileWriter{
+		dir:   dir,
+		limit: limit,
+		files: make(map[streamKey]*streamFile),
+		order: list.New(),
+	}, nil
+}
+
+// write appends the NDJSON line of an event to the file of its stream.
+func (s *streamFileWriter) write(event *pb.TapEvent, line string) error {
+	if eventStreamID(event) == nil {
+		return nil
+	}
+	key := newStreamKey(event)
+
+	f, ok := s.files[key]
+	if ok {
+		s.order.MoveToBack(f.elem)
+	} else {
+		if len(s.files) >= s.limit {
+			oldest := s.order.Front().Value.(streamKey)
+			if err := s.closeFile(oldest); err != nil {
+				return err
+			}
+		}
+		name := fmt.Sprintf("%s-%s-%d-%d.ndjson",
+			streamFileNameReplacer.Replace(key.src), streamFileNameReplacer.Replace(key.dst), key.base, key.stream)
+		path := filepath.Join(s.dir, name)
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		f = &streamFile{file: file, elem: s.order.PushBack(key)}
+		s.files[key] = f
+	}
+
+	if _, err := fmt.Fprintln(f.file, line); err != nil {
+		return err
+	}
+	if event.GetHttp().GetResponseEnd() != nil {
+		return s.closeFile(key)
+	}
+	return nil
+}
+
+func (s *streamFileWriter) closeFile(key streamKey) error {
+	f := s.files[key]
+	s.order.Remove(f.elem)
+	delete(s.files, key)
+	return f.file.Close()
+}
+
+// close closes the files of the streams whose response didn't end.
+func (s *streamFileWriter) close() error {
+	var firstErr error
+	for key := range s.files {
+		if err := s.closeFile(key); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+// readStreamFile returns the event types of each line of a stream's file.
+func readStreamFile(t *testing.T, path string) []string {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var types []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("Expecting NDJSON, got [%s]: %v", content, err)
+		}
+		for _, field := range []string{"requestInitEvent", "responseInitEvent", "responseEndEvent"} {
+			if _, ok := m[field]; ok {
+				types = append(types, field)
+			}
+		}
+	}
+	return types
+}
+
+func TestOutputDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tap-output-dir")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	options := newTapOptions()
+	options.outputDir = filepath.Join(dir, "streams")
+	if err := options.validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	renderTestTapEvents(t, options,
+		tapTestRequest(1, pb.HttpMethod_GET, "/books"),
+		tapTestRequest(2, pb.HttpMethod_GET, "/authors"),
+		tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 1000}),
+		tapTestEnd(1, &pb.Eos{}, 10),
+		// The response of the second stream never ends.
+		tapTestResponse(2, http.StatusOK, &duration.Duration{Nanos: 1000}),
+	)
+
+	expected := map[string][]string{
+		"1.2.3.4_5555-2.3.4.5_6666-7-1.ndjson": {"requestInitEvent", "responseInitEvent", "responseEndEvent"},
+		"1.2.3.4_5555-2.3.4.5_6666-7-2.ndjson": {"requestInitEvent", "responseInitEvent"},
+	}
+	files, err := ioutil.ReadDir(options.outputDir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(files) != len(expected) {
+		t.Fatalf("Expecting %d files, got %d", len(expected), len(files))
+	}
+	for name, types := range expected {
+		if actual := readStreamFile(t, filepath.Join(options.outputDir, name)); !reflect.DeepEqual(actual, types) {
+			t.Fatalf("Expecting %s to hold %v, got %v", name, types, actual)
+		}
+	}
+
+	t.Run("Reopens the files of evicted streams", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "tap-output-dir")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer os.RemoveAll(dir)
+
+		files, err := newStreamFileWriter(dir, 1)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		for _, event := range []*pb.TapEvent{
+			tapTestRequest(1, pb.HttpMethod_GET, "/books"),
+			tapTestRequest(2, pb.HttpMethod_GET, "/authors"),
+			tapTestEnd(1, &pb.Eos{}, 10),
+			tapTestEnd(2, &pb.Eos{}, 10),
+		} {
+			if err := files.write(event, renderTapEventNDJSON(event, "", newTapOptions())); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(files.files) > 1 {
+				t.Fatalf("Expecting at most 1 open file, got %d", len(files.files))
+			}
+		}
+		if err := files.close(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		for _, name := range []string{"1.2.3.4_5555-2.3.4.5_6666-7-1.ndjson", "1.2.3.4_5555-2.3.4.5_6666-7-2.ndjson"} {
+			expected := []string{"requestInitEvent", "responseEndEvent"}
+			if actual := readStreamFile(t, filepath.Join(dir, name)); !reflect.DeepEqual(actual, expected) {
+				t.Fatalf("Expecting %s to hold %v, got %v", name, expected, actual)
+			}
+		}
+	})
+
+	t.Run("Separates the streams of different proxies with the same ID", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "tap-output-dir")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer os.RemoveAll(dir)
+
+		files, err := newStreamFileWriter(dir, maxOpenStreamFiles)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		inbound := tapTestRequest(1, pb.HttpMethod_GET, "/books")
+		inbound.Source.Port = 4444
+		for _, event := range []*pb.TapEvent{tapTestRequest(1, pb.HttpMethod_GET, "/books"), inbound} {
+			if err := files.write(event, renderTapEventNDJSON(event, "", newTapOptions())); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+		if err := files.close(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		for _, name := range []string{"1.2.3.4_5555-2.3.4.5_6666-7-1.ndjson", "1.2.3.4_4444-2.3.4.5_6666-7-1.ndjson"} {
+			expected := []string{"requestInitEvent"}
+			if actual := readStreamFile(t, filepath.Join(dir, name)); !reflect.DeepEqual(actual, expected) {
+				t.Fatalf("Expecting %s to hold %v, got %v", name, expected, actual)
+			}
+		}
+	})
+
+	t.Run("Refuses a directory holding stream files", func(t *testing.T) {
+		if _, err := newStreamFileWriter(options.outputDir, maxOpenStreamFiles); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+
+	t.Run("Rejects --output-dir with HAR output", func(t *testing.T) {
+		options := newTapOptions()
+		options.outputDir = dir
+		options.output = harOutput
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
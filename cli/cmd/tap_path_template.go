@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// pathTemplates maps concrete paths, such as `/users/123`, to the template of
+// the route they belong to, such as `/users/:id`, so that requests to a
+// parameterized route are grouped, and optionally displayed, as one.
+type pathTemplates struct {
+	// templates are split into segments, in the order they were given.
+	templates [][]string
+}
+
+// parsePathTemplates parses a `--path-template` value: a comma-separated list
+// of paths whose segments starting with `:` match any single segment, e.g.
+// `/users/:id,/users/:id/posts/:post`. An empty string yields no templates.
+func parsePathTemplates(value string) (*pathTemplates, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t := &pathTemplates{}
+	for _, template := range strings.Split(value, ",") {
+		template = strings.TrimSpace(template)
+		if !strings.HasPrefix(template, "/") {
+			return nil, fmt.Errorf("templates must start with /, got %q", template)
+		}
+		segments := strings.Split(template, "/")
+		for _, segment := range segments {
+			if segment == ":" {
+				return nil, errors.New("parameters must be named, e.g. :id")
+			}
+		}
+		t.templates = append(t.templates, segments)
+	}
+	return t, nil
+}
+
+// template returns the first template matching the path, ignoring its query
+// string, or the path itself if none does. A template matches paths with the
+// same number of segments, whose segments are equal to those of the template
+// except for its parameters.
+func (t *pathTemplates) template(path string) string {
+	if t == nil {
+		return path
+	}
+	segments := strings.Split(strings.SplitN(path, "?", 2)[0], "/")
+	for _, template := range t.templates {
+		if matchesPathTemplate(template, segments) {
+			return strings.Join(template, "/")
+		}
+	}
+	return path
+}
+
+func matchesPathTemplate(template, segments []string) bool {
+	if len(template) != len(segments) {
+		return false
+	}
+	for i, segment := range template {
+		if strings.HasPrefix(segment, ":") {
+			if segments[i] == "" {
+				return false
+			}
+		} else if segment != segments[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+func TestPathTemplates(t *testing.T) {
+	t.Run("Maps paths to templates", func(t *testing.T) {
+		templates, err := parsePathTemplates("/users/:id, /users/:id/posts/:post,/users/me/posts/:post,/books")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		testCases := []struct {
+			path     string
+			expected string
+		}{
+			{"/users/123", "/users/:id"},
+			{"/users/alice?page=2", "/users/:id"},
+			{"/users/123/posts/7", "/users/:id/posts/:post"},
+			// The first matching template wins.
+			{"/users/me/posts/7", "/users/:id/posts/:post"},
+			{"/books", "/books"},
+			// Paths matching no template are kept as is.
+			{"/users", "/users"},
+			{"/users/", "/users/"},
+			{"/users/123/", "/users/123/"},
+			{"/users/123/comments/7", "/users/123/comments/7"},
+			{"/authors/123", "/authors/123"},
+		}
+		for i, tc := range testCases {
+			tc := tc // pin
+			t.Run(fmt.Sprintf("%d: %s", i, tc.path), func(t *testing.T) {
+				if path := templates.template(tc.path); path != tc.expected {
+					t.Fatalf("Expecting path [%s], got [%s]", tc.expected, path)
+				}
+			})
+		}
+	})
+
+	t.Run("Keeps paths without templates", func(t *testing.T) {
+		templates, err := parsePathTemplates("")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if path := templates.template("/users/123"); path != "/users/123" {
+			t.Fatalf("Expecting path [/users/123], got [%s]", path)
+		}
+	})
+
+	t.Run("Rejects invalid templates", func(t *testing.T) {
+		for _, template := range []string{"users/:id", "/users/:", "/books,"} {
+			if _, err := parsePathTemplates(template); err == nil {
+				t.Fatalf("Expecting an error for [%s]", template)
+			}
+		}
+	})
+}
+
+func TestRenderTapEventsPathTemplate(t *testing.T) {
+	var events []*pb.TapEvent
+	for stream, path := range []string{"/users/1", "/users/2"} {
+		events = append(events,
+			tapTestRequest(uint64(stream), pb.HttpMethod_GET, path),
+			tapTestResponse(uint64(stream), http.StatusOK, &duration.Duration{Nanos: 1000}),
+			tapTestEnd(uint64(stream), &pb.Eos{}, 10),
+		)
+	}
+
+	t.Run("Deduplicates requests to the same template", func(t *testing.T) {
+		options := newTapOptions()
+		options.dedup = true
+		options.pathTemplate = "/users/:id"
+		if err := options.validate(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		output := renderTestTapEvents(t, options, events...)
+		lines := strings.Split(strings.TrimSpace(output), "\n")
+		if len(lines) != 3 || !strings.Contains(lines[0], ":path=/users/1 ") || !strings.HasSuffix(lines[0], "(x2)") {
+			t.Fatalf("Expecting the two requests to be collapsed, got [%s]", output)
+		}
+	})
+
+	t.Run("Displays templates", func(t *testing.T) {
+		options := newTapOptions()
+		options.pathTemplate = "/users/:id"
+		options.showPathTemplate = true
+		if err := options.validate(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		output := renderTestTapEvents(t, options, events...)
+		if strings.Count(output, ":path=/users/:id\n") != 2 || strings.Contains(output, ":path=/users/1") {
+			t.Fatalf("Expecting the template to be displayed, got [%s]", output)
+		}
+	})
+
+	t.Run("Rejects --show-path-template without templates", func(t *testing.T) {
+		options := newTapOptions()
+		options.showPathTemplate = true
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/mattn/go-isatty"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// pauseKey pauses and resumes the output when tap writes to a terminal.
+	pauseKey = ' '
+	// pauseBufferSize is the number of writes held back while the output is
+	// paused; later ones are dropped.
+	pauseBufferSize = 1000
+)
+
+// pausableWriter writes to an underlying writer unless it is paused, in which
+// case up to size writes are held back until it is resumed. While paused, a
+// "PAUSED (N buffered)" indicator is kept up to date on status.
+type pausableWriter struct {
+	sync.Mutex
+	w        io.Writer
+	status   io.Writer
+	size     int
+	paused   bool
+	buffered [][]byte
+	dropped  int
+}
+
+func newPausableWriter(w, status io.Writer, size int) *pausableWriter {
+	return &pausableWriter{w: w, status: status, size: size}
+}
+
+func (p *pausableWriter) Write(b []byte) (int, error) {
+	p.Lock()
+	defer p.Unlock()
+	if !p.paused {
+		return p.w.Write(b)
+	}
+
+	if len(p.buffered) < p.size {
+		p.buffered = append(p.buffered, append([]byte(nil), b...))
+	} else {
+		p.dropped++
+	}
+	p.writeIndicator()
+	return len(b), nil
+}
+
+// toggle pauses or resumes the output.
+func (p *pausableWriter) toggle() error {
+	p.Lock()
+	defer p.Unlock()
+	if p.paused {
+		return p.resumeLocked()
+	}
+	p.paused = true
+	p.writeIndicator()
+	return nil
+}
+
+// resume resumes the output if it is paused.
+func (p *pausableWriter) resume() error {
+	p.Lock()
+	defer p.Unlock()
+	if !p.paused {
+		return nil
+	}
+	return p.resumeLocked()
+}
+
+// resumeLocked writes the writes held back, and notes the number of writes
+// dropped, if any, on status.
+func (p *pausableWriter) resumeLocked() error {
+	p.paused = false
+	// Erase the indicator.
+	fmt.Fprint(p.status, "\r\033[K")
+	buffered, dropped := p.buffered, p.dropped
+	p.buffered, p.dropped = nil, 0
+	for _, b := range buffered {
+		if _, err := p.w.Write(b); err != nil {
+			return err
+		}
+	}
+	if dropped > 0 {
+		fmt.Fprintf(p.status, "%d events dropped while paused\n", dropped)
+	}
+	return nil
+}
+
+func (p *pausableWriter) writeIndicator() {
+	fmt.Fprintf(p.status, "\rPAUSED (%d buffered)", len(p.buffered))
+}
+
+// readPauseKeys toggles p whenever pauseKey is read from r, until done is
+// closed or r fails. Reads from a terminal set up by enableKeyPresses end
+// regularly without input, so that done is checked even if no key is pressed.
+func readPauseKeys(r io.Reader, p *pausableWriter, done <-chan struct{}) {
+	buf := make([]byte, 64)
+	for {
+		n, err := r.Read(buf)
+		for _, c := range buf[:n] {
+			if c == pauseKey {
+				p.toggle()
+			}
+		}
+		if err != nil && err != io.EOF {
+			return
+		}
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
+
+// pausableOutput returns a writer to w that pauseKey pauses and resumes, if
+// both w and stdin are terminals, and a function resuming the output and
+// restoring the terminal once tap ends.
+func pausableOutput(w io.Writer) (io.Writer, func()) {
+	f, ok := w.(*os.File)
+	if !ok || !isatty.IsTerminal(f.Fd()) || !isatty.IsTerminal(os.Stdin.Fd()) {
+		return w, func() {}
+	}
+	restore, err := enableKeyPresses(os.Stdin)
+	if err != nil {
+		log.Debugf("Output can't be paused: %s", err)
+		return w, func() {}
+	}
+	p := newPausableWriter(w, os.Stderr, pauseBufferSize)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		readPauseKeys(os.Stdin, p, done)
+	}()
+	return p, func() {
+		// Stop reading stdin before restoring the terminal, so that no key
+		// pressed after tap ends is consumed.
+		close(done)
+		<-stopped
+		restore()
+		p.resume()
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package cmd
+
+import (
+	"errors"
+	"os"
+)
+
+// enableKeyPresses isn't supported on this platform, so the output can't be
+// paused.
+func enableKeyPresses(in *os.File) (func(), error) {
+	return nil, errors.New("key presses can't be read on this platform")
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPausableWriter(t *testing.T) {
+	t.Run("Holds back writes while paused", func(t *testing.T) {
+		var out, status bytes.Buffer
+		p := newPausableWriter(&out, &status, 3)
+
+		fmt.Fprintln(p, "req 1")
+		p.toggle()
+		fmt.Fprintln(p, "rsp 1")
+		fmt.Fprintln(p, "end 1")
+		if out.String() != "req 1\n" {
+			t.Fatalf("Expecting only the write before pausing, got %q", out.String())
+		}
+		if !strings.HasSuffix(status.String(), "\rPAUSED (2 buffered)") {
+			t.Fatalf("Expecting \"PAUSED (2 buffered)\", got %q", status.String())
+		}
+
+		status.Reset()
+		p.toggle()
+		fmt.Fprintln(p, "req 2")
+		if expected := "req 1\nrsp 1\nend 1\nreq 2\n"; out.String() != expected {
+			t.Fatalf("Expecting %q, got %q", expected, out.String())
+		}
+		if status.String() != "\r\033[K" {
+			t.Fatalf("Expecting the indicator to be erased, got %q", status.String())
+		}
+	})
+
+	t.Run("Drops writes past the buffer size", func(t *testing.T) {
+		var out, status bytes.Buffer
+		p := newPausableWriter(&out, &status, 2)
+
+		p.toggle()
+		for i := 1; i <= 5; i++ {
+			fmt.Fprintf(p, "event %d\n", i)
+		}
+		if !strings.HasSuffix(status.String(), "\rPAUSED (2 buffered)") {
+			t.Fatalf("Expecting \"PAUSED (2 buffered)\", got %q", status.String())
+		}
+
+		status.Reset()
+		p.resume()
+		if expected := "event 1\nevent 2\n"; out.String() != expected {
+			t.Fatalf("Expecting %q, got %q", expected, out.String())
+		}
+		if !strings.HasSuffix(status.String(), "3 events dropped while paused\n") {
+			t.Fatalf("Expecting 3 dropped events to be noted, got %q", status.String())
+		}
+
+		// Pausing again starts from an empty buffer.
+		status.Reset()
+		p.toggle()
+		fmt.Fprintln(p, "event 6")
+		if !strings.HasSuffix(status.String(), "\rPAUSED (1 buffered)") {
+			t.Fatalf("Expecting \"PAUSED (1 buffered)\", got %q", status.String())
+		}
+	})
+
+	t.Run("Toggles on the pause key", func(t *testing.T) {
+		var out, status bytes.Buffer
+		p := newPausableWriter(&out, &status, 3)
+
+		done := make(chan struct{})
+		close(done)
+		readPauseKeys(strings.NewReader("x  q "), p, done)
+		if !p.paused {
+			t.Fatal("Expecting three presses of the pause key to pause the output")
+		}
+	})
+
+	t.Run("Stops reading once done", func(t *testing.T) {
+		var out, status bytes.Buffer
+		p := newPausableWriter(&out, &status, 3)
+
+		// Reads of a terminal without input end without reading anything.
+		done := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			readPauseKeys(idleReader{}, p, done)
+		}()
+		close(done)
+		select {
+		case <-stopped:
+		case <-time.After(10 * time.Second):
+			t.Fatal("Expecting the reader to stop")
+		}
+	})
+
+	t.Run("Rejects --pausable with TUI output", func(t *testing.T) {
+		options := newTapOptions()
+		options.pausable = true
+		options.output = tuiOutput
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+// idleReader reads nothing, like a terminal set up by enableKeyPresses when
+// no key is pressed.
+type idleReader struct{}
+
+func (idleReader) Read([]byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	return 0, io.EOF
+}
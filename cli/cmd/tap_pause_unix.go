@@ -0,0 +1,32 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package cmd
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// enableKeyPresses makes the terminal in reads from deliver each key press as
+// soon as it's typed, without echoing it, rather than line by line. Reads
+// return after a tenth of a second without input, so that readers can stop.
+// It returns a function restoring the terminal's settings.
+func enableKeyPresses(in *os.File) (func(), error) {
+	fd := int(in.Fd())
+	saved, err := unix.IoctlGetTermios(fd, ioctlReadTermios)
+	if err != nil {
+		return nil, err
+	}
+	termios := *saved
+	termios.Lflag &^= unix.ICANON | unix.ECHO
+	termios.Cc[unix.VMIN] = 0
+	termios.Cc[unix.VTIME] = 1
+	if err := unix.IoctlSetTermios(fd, ioctlWriteTermios, &termios); err != nil {
+		return nil, err
+	}
+	return func() {
+		unix.IoctlSetTermios(fd, ioctlWriteTermios, saved)
+	}, nil
+}
@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"github.com/linkerd/linkerd2/pkg/addr"
+	"github.com/linkerd/linkerd2/pkg/k8s"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// defaultResolveTTL is how long the port names looked up by `--resolve-ports`
+// are cached by default.
+const defaultResolveTTL = 5 * time.Minute
+
+// resolveSyncTimeout is how long `--resolve-ports` waits for the endpoints and
+// services of the cluster to be listed before giving up.
+var resolveSyncTimeout = 30 * time.Second
+
+// portNameResolver looks up the names of destination ports, as declared by
+// the endpoints or services they belong to. Endpoints and services are
+// watched in all namespaces by shared informers, so that lookups don't query
+// the API server while events are rendered.
+//
+// Lookups are cached per IP and port for ttl, as pods are recreated and their
+// IPs get reused by others; a ttl of 0 caches them forever. Lookups that
+// found no name are cached, so that the port is rendered without one until
+// they expire. Lookups that returned an error, e.g. because the informers
+// haven't synced, aren't cached, so that they're retried on the next event.
+type portNameResolver struct {
+	endpoints corelisters.EndpointsLister
+	services  corelisters.ServiceLister
+	synced    []cache.InformerSynced
+	ttl       time.Duration
+	now       func() time.Time
+	names     map[string]portName
+	// nextSweep is when the expired names are next evicted; see sweep.
+	nextSweep time.Time
+}
+
+// portName is a cached lookup of the name of a port.
+type portName struct {
+	name    string
+	expires time.Time
+}
+
+// newPortNameResolver returns a resolver whose informers watch endpoints and
+// services until ctx is done. It fails if they can't be listed within
+// resolveSyncTimeout, e.g. as listing them in all namespaces requires
+// cluster-wide permissions, rather than rendering ports without their name.
+func newPortNameResolver(ctx context.Context, client kubernetes.Interface, ttl time.Duration, now func() time.Time) (*portNameResolver, error) {
+	sharedInformers := informers.NewSharedInformerFactory(client, 0)
+	endpoints := sharedInformers.Core().V1().Endpoints()
+	services := sharedInformers.Core().V1().Services()
+	r := &portNameResolver{
+		endpoints: endpoints.Lister(),
+		services:  services.Lister(),
+		synced:    []cache.InformerSynced{endpoints.Informer().HasSynced, services.Informer().HasSynced},
+		ttl:       ttl,
+		now:       now,
+		names:     make(map[string]portName),
+	}
+	sharedInformers.Start(ctx.Done())
+
+	syncCtx, cancel := context.WithTimeout(ctx, resolveSyncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(syncCtx.Done(), r.synced...) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("--resolve-ports failed to list endpoints and services in all namespaces within %s; check that you're allowed to list and watch them cluster-wide", resolveSyncTimeout)
+	}
+	return r, nil
+}
+
+// format renders the destination port of an event, followed by its name in
+// parentheses if it has one, e.g. `8080(http)`.
+func (r *portNameResolver) format(event *pb.TapEvent) string {
+	port := event.GetDestination().GetPort()
+	key := addr.PublicAddressToString(event.GetDestination())
+	cached, ok := r.names[key]
+	if !ok || (r.ttl > 0 && !r.now().Before(cached.expires)) {
+		ip := addr.PublicIPToString(event.GetDestination().GetIp())
+		namespace := event.GetDestinationMeta().GetLabels()[k8s.Namespace]
+		name, err := r.lookup(namespace, ip, port)
+		cached = portName{name: name, expires: r.now().Add(r.ttl)}
+		if err != nil {
+			log.Debugf("failed to resolve the name of port %s: %s", key, err)
+		} else {
+			r.sweep()
+			r.names[key] = cached
+		}
+	}
+	name := cached.name
+
+	if name == "" {
+		return fmt.Sprintf("%d", port)
+	}
+	return fmt.Sprintf("%d(%s)", port, name)
+}
+
+// sweep evicts the expired names, so that names aren't kept forever for IPs
+// no longer observed. It does so at most once per ttl, so that caching many
+// names doesn't take quadratic time.
+func (r *portNameResolver) sweep() {
+	now := r.now()
+	if r.ttl <= 0 || now.Before(r.nextSweep) {
+		return
+	}
+	for key, cached := range r.names {
+		if !now.Before(cached.expires) {
+			delete(r.names, key)
+		}
+	}
+	r.nextSweep = now.Add(r.ttl)
+}
+
+// lookup finds the name of a port among the endpoints of the given namespace,
+// or of all namespaces if it's empty, then among the cluster IPs of services.
+func (r *portNameResolver) lookup(namespace, ip string, port uint32) (string, error) {
+	for _, synced := range r.synced {
+		if !synced() {
+			return "", errors.New("endpoints and services aren't synced yet")
+		}
+	}
+
+	var endpoints []*corev1.Endpoints
+	var err error
+	if namespace == "" {
+		endpoints, err = r.endpoints.List(labels.Everything())
+	} else {
+		endpoints, err = r.endpoints.Endpoints(namespace).List(labels.Everything())
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, ep := range endpoints {
+		for _, subset := range ep.Subsets {
+			if !hasAddress(subset.Addresses, ip) && !hasAddress(subset.NotReadyAddresses, ip) {
+				continue
+			}
+			for _, p := range subset.Ports {
+				if uint32(p.Port) == port && p.Name != "" {
+					return p.Name, nil
+				}
+			}
+		}
+	}
+
+	var services []*corev1.Service
+	if namespace == "" {
+		services, err = r.services.List(labels.Everything())
+	} else {
+		services, err = r.services.Services(namespace).List(labels.Everything())
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, svc := range services {
+		if svc.Spec.ClusterIP != ip {
+			continue
+		}
+		for _, p := range svc.Spec.Ports {
+			if uint32(p.Port) == port && p.Name != "" {
+				return p.Name, nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+func hasAddress(addresses []corev1.EndpointAddress, ip string) bool {
+	for _, address := range addresses {
+		if address.IP == ip {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"github.com/linkerd/linkerd2/pkg/addr"
+	"github.com/linkerd/linkerd2/pkg/k8s"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestPortNameResolver(t *testing.T) {
+	k8sAPI, err := k8s.NewFakeAPI(`
+apiVersion: v1
+kind: Endpoints
+metadata:
+  name: books
+  namespace: default
+subsets:
+- addresses:
+  - ip: 2.3.4.5
+  ports:
+  - name: http
+    port: 6666
+  - name: admin
+    port: 9995
+- addresses:
+  - ip: 2.3.4.6
+  ports:
+  - port: 6666`, `
+apiVersion: v1
+kind: Service
+metadata:
+  name: authors
+  namespace: default
+spec:
+  clusterIP: 10.0.0.1
+  ports:
+  - name: grpc
+    port: 80`,
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	withDst := func(ip *pb.IPAddress, port uint32) *pb.TapEvent {
+		event := tapTestRequest(1, pb.HttpMethod_GET, "/books")
+		event.Destination = &pb.TcpAddress{Ip: ip, Port: port}
+		return event
+	}
+
+	testCases := []struct {
+		name     string
+		event    *pb.TapEvent
+		expected string
+	}{
+		{"endpoint port", withDst(addr.PublicIPV4(2, 3, 4, 5), 6666), "6666(http)"},
+		{"other endpoint port", withDst(addr.PublicIPV4(2, 3, 4, 5), 9995), "9995(admin)"},
+		{"service port", withDst(addr.PublicIPV4(10, 0, 0, 1), 80), "80(grpc)"},
+		{"unnamed port", withDst(addr.PublicIPV4(2, 3, 4, 6), 6666), "6666"},
+		{"unknown port", withDst(addr.PublicIPV4(2, 3, 4, 5), 4191), "4191"},
+		{"unknown IP", withDst(addr.PublicIPV4(3, 4, 5, 6), 6666), "6666"},
+	}
+
+	now := time.Unix(1548000000, 0)
+	clock := func() time.Time { return now }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	resolver, err := newPortNameResolver(ctx, k8sAPI, time.Minute, clock)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(tc.name, func(t *testing.T) {
+			if port := resolver.format(tc.event); port != tc.expected {
+				t.Fatalf("Expecting port [%s], got [%s]", tc.expected, port)
+			}
+		})
+	}
+
+	t.Run("Caches lookups", func(t *testing.T) {
+		err := k8sAPI.CoreV1().Endpoints("default").Delete("books", &metav1.DeleteOptions{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		waitForPortNames(t, resolver, func() bool {
+			_, err := resolver.endpoints.Endpoints("default").Get("books")
+			return err != nil
+		})
+		if port := resolver.format(testCases[0].event); port != "6666(http)" {
+			t.Fatalf("Expecting port [6666(http)], got [%s]", port)
+		}
+	})
+
+	t.Run("Renders the port on each line", func(t *testing.T) {
+		options := newTapOptions()
+		options.portNames = resolver
+		line := renderTapEvent(testCases[0].event, "", options)
+		if !strings.Contains(line, " tls= dst_port=6666(http) :method=GET ") {
+			t.Fatalf("Expecting the port name, got [%s]", line)
+		}
+	})
+
+	t.Run("Looks up expired names again", func(t *testing.T) {
+		now = now.Add(time.Minute)
+		if port := resolver.format(testCases[0].event); port != "6666" {
+			t.Fatalf("Expecting port [6666], got [%s]", port)
+		}
+	})
+
+	t.Run("Evicts expired names", func(t *testing.T) {
+		resolver, err := newPortNameResolver(ctx, k8sAPI, time.Minute, clock)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expired := addr.PublicAddressToString(testCases[3].event.GetDestination())
+		resolver.format(testCases[3].event)
+		now = now.Add(time.Minute)
+		resolver.format(testCases[5].event)
+		if _, ok := resolver.names[expired]; ok || len(resolver.names) != 1 {
+			t.Fatalf("Expecting the expired name of %s to be evicted, got %v", expired, resolver.names)
+		}
+	})
+
+	t.Run("Caches lookups forever without a ttl", func(t *testing.T) {
+		forever, err := newPortNameResolver(ctx, k8sAPI, 0, clock)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if port := forever.format(testCases[2].event); port != "80(grpc)" {
+			t.Fatalf("Expecting port [80(grpc)], got [%s]", port)
+		}
+		err := k8sAPI.CoreV1().Services("default").Delete("authors", &metav1.DeleteOptions{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		waitForPortNames(t, forever, func() bool {
+			_, err := forever.services.Services("default").Get("authors")
+			return err != nil
+		})
+		now = now.Add(24 * time.Hour)
+		if port := forever.format(testCases[2].event); port != "80(grpc)" {
+			t.Fatalf("Expecting port [80(grpc)], got [%s]", port)
+		}
+	})
+
+	t.Run("Doesn't cache failed lookups", func(t *testing.T) {
+		unsynced, err := newPortNameResolver(ctx, k8sAPI, time.Minute, clock)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		synced := unsynced.synced
+		unsynced.synced = []cache.InformerSynced{func() bool { return false }}
+		event := withDst(addr.PublicIPV4(2, 3, 4, 6), 6666)
+		if port := unsynced.format(event); port != "6666" || len(unsynced.names) != 0 {
+			t.Fatalf("Expecting port [6666] without caching it, got [%s] and %v", port, unsynced.names)
+		}
+		unsynced.synced = synced
+		unsynced.format(event)
+		if len(unsynced.names) != 1 {
+			t.Fatalf("Expecting the lookup to be cached once synced, got %v", unsynced.names)
+		}
+	})
+
+	t.Run("Fails if endpoints can't be listed", func(t *testing.T) {
+		defer func(timeout time.Duration) { resolveSyncTimeout = timeout }(resolveSyncTimeout)
+		resolveSyncTimeout = 100 * time.Millisecond
+
+		client := fake.NewSimpleClientset()
+		client.PrependReactor("list", "endpoints", func(k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.New("endpoints is forbidden")
+		})
+		if _, err := newPortNameResolver(ctx, client, time.Minute, clock); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+// waitForPortNames waits for the informers of a resolver to sync, then for
+// done to return true.
+func waitForPortNames(t *testing.T, r *portNameResolver, done func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		synced := true
+		for _, hasSynced := range r.synced {
+			synced = synced && hasSynced()
+		}
+		if synced && done() {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for endpoints and services")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/linkerd/linkerd2/controller/api/util"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"github.com/linkerd/linkerd2/pkg/addr"
+	"github.com/linkerd/linkerd2/pkg/k8s"
+	"github.com/linkerd/linkerd2/pkg/protohttp"
+	"github.com/linkerd/linkerd2/pkg/tap"
+	"github.com/spf13/cobra"
+)
+
+// defaultProbeTimeout is how long `linkerd tap probe` waits for a first event
+// by default.
+const defaultProbeTimeout = 10 * time.Second
+
+// tapStreamOpener opens a tap stream, as tap.Reader does.
+type tapStreamOpener func() (*bufio.Reader, io.ReadCloser, error)
+
+func newCmdTapProbe(options *tapOptions) *cobra.Command {
+	timeout := defaultProbeTimeout
+
+	cmd := &cobra.Command{
+		Use:   "probe [flags] (RESOURCE)",
+		Short: "Check that a resource can be tapped",
+		Long: `Check that a resource can be tapped.
+
+  The stream of the RESOURCE, specified as for "linkerd tap", is opened and
+  its first event waited for, up to --timeout. The probe fails if the stream
+  can't be opened, e.g. because tapping isn't authorized or the resource has
+  no injected pods, and succeeds, reporting that there is no traffic yet,
+  if the stream opens but no event is observed in time.`,
+		Example: `  # check that the web deployment in the default namespace can be tapped
+  linkerd tap probe deploy/web`,
+		Args:      cobra.RangeArgs(1, 2),
+		ValidArgs: util.ValidTargets,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if timeout <= 0 {
+				return errors.New("--timeout must be positive")
+			}
+			req, err := util.BuildTapByResourceRequest(util.TapRequestParams{
+				Resource:  strings.Join(args, "/"),
+				Namespace: options.namespace,
+				MaxRps:    options.maxRps,
+			})
+			if err != nil {
+				return err
+			}
+
+			open := func() (*bufio.Reader, io.ReadCloser, error) {
+				return tap.AddressReader(options.address, req, 0)
+			}
+			if options.address == "" {
+				k8sAPI, err := k8s.NewAPI(kubeconfigPath, kubeContext, impersonate, 0)
+				if err != nil {
+					return err
+				}
+				open = func() (*bufio.Reader, io.ReadCloser, error) {
+					return tap.Reader(k8sAPI, req, 0)
+				}
+			}
+
+			result, err := probeTapStream(open, timeout)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), result)
+			return nil
+		},
+	}
+
+	cmd.Flags().DurationVar(&timeout, "timeout", timeout,
+		"How long to wait for a first event once the stream is open")
+
+	return cmd
+}
+
+// probeTapStream opens a tap stream and waits up to timeout for its first
+// event. It returns what was observed if the stream opened, or an error
+// diagnosing why it didn't; see diagnoseTapError.
+func probeTapStream(open tapStreamOpener, timeout time.Duration) (string, error) {
+	reader, body, err := open()
+	if err != nil {
+		return "", diagnoseTapError(err)
+	}
+	defer body.Close()
+
+	type decoded struct {
+		event *pb.TapEvent
+		err   error
+	}
+	first := make(chan decoded, 1)
+	go func() {
+		event, err := tap.NewDecoder(reader).Decode()
+		first <- decoded{event, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case d := <-first:
+		switch {
+		case d.err == io.EOF:
+			return "", errors.New("connected, but the tap stream ended before any event")
+		case d.err != nil:
+			return "", fmt.Errorf("connected, but the tap stream failed: %v", d.err)
+		}
+		return fmt.Sprintf("connected, observed %s", formatProbedEvent(d.event)), nil
+	case <-timer.C:
+		// The decoder is unblocked once the body is closed.
+		return fmt.Sprintf("connected, no traffic yet after %s", timeout), nil
+	}
+}
+
+// formatProbedEvent describes the first event a probe observed, e.g.
+// `a request from 1.2.3.4:5555 to 2.3.4.5:6666`.
+func formatProbedEvent(event *pb.TapEvent) string {
+	kind := "an event"
+	switch event.GetHttp().GetEvent().(type) {
+	case *pb.TapEvent_Http_RequestInit_:
+		kind = "a request"
+	case *pb.TapEvent_Http_ResponseInit_, *pb.TapEvent_Http_ResponseEnd_:
+		kind = "a response"
+	}
+	return fmt.Sprintf("%s from %s to %s", kind,
+		addr.PublicAddressToString(event.GetSource()), addr.PublicAddressToString(event.GetDestination()))
+}
+
+// diagnoseTapError wraps an error opening a tap stream with the most likely
+// cause of the failure, when it's recognized.
+func diagnoseTapError(err error) error {
+	msg := err.Error()
+	var httpErr protohttp.HTTPError
+	if e, ok := err.(protohttp.HTTPError); ok {
+		httpErr = e
+	}
+	switch {
+	case httpErr.Code == http.StatusUnauthorized || httpErr.Code == http.StatusForbidden || strings.Contains(msg, "tap authorization failed"):
+		return fmt.Errorf("not authorized to tap, visit %s for more information: %s", tap.TapRbacURL, msg)
+	case strings.Contains(msg, "have tapping disabled"):
+		return fmt.Errorf("the resource's pods have tapping disabled: %s", msg)
+	case strings.Contains(msg, "no pods found"):
+		return fmt.Errorf("no injected pods found, check that the resource exists and that its pods are injected with the Linkerd proxy: %s", msg)
+	}
+	return fmt.Errorf("failed to open the tap stream: %s", msg)
+}
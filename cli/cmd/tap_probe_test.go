@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/linkerd/linkerd2/pkg/protohttp"
+)
+
+func TestProbeTapStream(t *testing.T) {
+	opened := func(reader *bufio.Reader, body io.ReadCloser) tapStreamOpener {
+		return func() (*bufio.Reader, io.ReadCloser, error) {
+			return reader, body, nil
+		}
+	}
+	failed := func(err error) tapStreamOpener {
+		return func() (*bufio.Reader, io.ReadCloser, error) {
+			return nil, nil, err
+		}
+	}
+
+	t.Run("Reports the first event", func(t *testing.T) {
+		stream := tapEventStream(t, tapTestRequest(1, 0, "/books"))
+		result, err := probeTapStream(opened(stream, ioutil.NopCloser(stream)), time.Second)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expected := "connected, observed a request from 1.2.3.4:5555 to 2.3.4.5:6666"
+		if result != expected {
+			t.Fatalf("Expecting [%s], got [%s]", expected, result)
+		}
+	})
+
+	t.Run("Reports a stream without traffic", func(t *testing.T) {
+		// The stream stays open without any event until the probe closes it.
+		stalled, writer := io.Pipe()
+		defer writer.Close()
+		result, err := probeTapStream(opened(bufio.NewReader(stalled), stalled), 10*time.Millisecond)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if expected := "connected, no traffic yet after 10ms"; result != expected {
+			t.Fatalf("Expecting [%s], got [%s]", expected, result)
+		}
+	})
+
+	t.Run("Fails on a stream ending before any event", func(t *testing.T) {
+		stream := bufio.NewReader(strings.NewReader(""))
+		if _, err := probeTapStream(opened(stream, ioutil.NopCloser(stream)), time.Second); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+
+	t.Run("Diagnoses errors opening the stream", func(t *testing.T) {
+		testCases := []struct {
+			err      error
+			expected string
+		}{
+			{
+				protohttp.HTTPError{Code: http.StatusForbidden, WrappedError: errors.New("tap authorization failed (not allowed), visit https://linkerd.io/tap-rbac for more information")},
+				"not authorized to tap",
+			},
+			{errors.New("rpc error: code = NotFound desc = no pods found for deployment/web"), "no injected pods found"},
+			{errors.New("rpc error: code = NotFound desc = all pods found for deployment/web have tapping disabled"), "the resource's pods have tapping disabled"},
+			{errors.New("dial tcp: connection refused"), "failed to open the tap stream"},
+		}
+		for _, tc := range testCases {
+			_, err := probeTapStream(failed(tc.err), time.Second)
+			if err == nil || !strings.HasPrefix(err.Error(), tc.expected) || !strings.Contains(err.Error(), tc.err.Error()) {
+				t.Fatalf("Expecting an error starting with [%s] and including [%s], got [%v]", tc.expected, tc.err, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"bufio"
+	"io"
+	"os"
+
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+// dumpRawTapStream returns a reader over tapByteStream that copies every byte
+// read from the stream to w, before any decoding or filtering takes place.
+// The resulting capture can be rendered later on with `--replay`.
+func dumpRawTapStream(tapByteStream *bufio.Reader, w io.Writer) *bufio.Reader {
+	return bufio.NewReader(io.TeeReader(tapByteStream, w))
+}
+
+// replayTapEvents renders a raw capture written by `--dump-raw`. The request
+// is only used to render the target resource of wide output; the filters it
+// holds were applied by the tap API, and so don't apply to the capture.
+func replayTapEvents(w io.Writer, path string, req *pb.TapByResourceRequest, options *tapOptions) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return writeTapEventsToBuffer(w, bufio.NewReader(file), req, options)
+}
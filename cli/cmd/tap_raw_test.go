@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"google.golang.org/grpc/codes"
+)
+
+// dumpAndReplay renders a raw stream while dumping it, then replays the dump,
+// returning the bytes dumped and both renderings.
+func dumpAndReplay(t *testing.T, options *tapOptions, stream []byte) ([]byte, string, string) {
+	file, err := ioutil.TempFile("", "tap-dump-raw")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.Remove(file.Name())
+
+	req := &pb.TapByResourceRequest{}
+	live := bytes.NewBufferString("")
+	err = writeTapEventsToBuffer(live, dumpRawTapStream(bufio.NewReader(bytes.NewReader(stream)), file), req, options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	file.Close()
+
+	dumped, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	replayed := bytes.NewBufferString("")
+	err = replayTapEvents(replayed, file.Name(), req, options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	return dumped, live.String(), replayed.String()
+}
+
+func TestDumpRawAndReplay(t *testing.T) {
+	grpcOK := &pb.Eos{End: &pb.Eos_GrpcStatusCode{GrpcStatusCode: uint32(codes.OK)}}
+	events := []*pb.TapEvent{
+		tapTestRequest(1, pb.HttpMethod_GET, "/books"),
+		tapTestRequest(2, pb.HttpMethod_POST, "/authors"),
+		tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 1000}),
+		tapTestEnd(1, grpcOK, 42),
+		tapTestResponse(2, http.StatusCreated, &duration.Duration{Nanos: 2000}),
+		tapTestEnd(2, grpcOK, 0),
+	}
+	stream, err := ioutil.ReadAll(tapEventStream(t, events...))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, output := range []string{"", jsonOutput} {
+		output := output // pin
+		t.Run("Replays a dump with output \""+output+"\"", func(t *testing.T) {
+			options := newTapOptions()
+			options.output = output
+			dumped, live, replayed := dumpAndReplay(t, options, stream)
+
+			if !bytes.Equal(dumped, stream) {
+				t.Fatalf("Expecting the dump to hold the %d bytes of the stream, got %d different bytes", len(stream), len(dumped))
+			}
+			if live == "" {
+				t.Fatal("Expecting events to be rendered, got nothing")
+			}
+			if replayed != live {
+				t.Fatalf("Expecting the replay to render [%s], got [%s]", live, replayed)
+			}
+		})
+	}
+
+	t.Run("Applies client-side filters to the dump only on replay", func(t *testing.T) {
+		options := newTapOptions()
+		options.srcResource = "deploy/web"
+		if err := options.validate(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		dumped, live, replayed := dumpAndReplay(t, options, stream)
+		if !bytes.Equal(dumped, stream) {
+			t.Fatalf("Expecting filtered out events to be dumped, got %d bytes", len(dumped))
+		}
+		if live != "" || replayed != "" {
+			t.Fatalf("Expecting no events to be rendered, got [%s] and [%s]", live, replayed)
+		}
+	})
+
+	t.Run("Rejects --dump-raw with --replay", func(t *testing.T) {
+		options := newTapOptions()
+		options.dumpRaw = "dump.bin"
+		options.replay = "dump.bin"
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"github.com/linkerd/linkerd2/pkg/addr"
+	"google.golang.org/grpc/codes"
+)
+
+// retryWindow is how long after the response to a request fails an identical
+// request is considered a retry by `--collapse-retries`.
+const retryWindow = time.Second
+
+// retryCollapser collapses the likely retries of a request, as detected by
+// `--collapse-retries`: a request with the same method, path, authority and
+// source as one whose response failed, with a 5xx status, a non-OK gRPC
+// status or a reset, and ended less than window ago. Only the last attempt is
+// rendered, with each of its lines annotated with `retry=N`, N being the
+// number of attempts before it.
+//
+// Failed transactions are therefore held back until window elapses without a
+// retry, or the stream ends; see start. Attempts whose response starts with a
+// status below 500 are unlikely to be retried, so they're rendered right
+// away rather than once their response ends, which may be never for
+// long-lived streams. Should they fail later, e.g. with a gRPC error, the
+// next attempt is still annotated.
+type retryCollapser struct {
+	window time.Duration
+
+	sync.Mutex
+	// streams maps the streams in flight to their attempt.
+	streams map[streamKey]*retryAttempt
+	bound   *streamBound
+	// ended holds the failed attempts whose response ended, oldest first.
+	ended []*retryAttempt
+	seq   uint64
+	// authorities canonicalizes the authorities of requests, so that retries
+	// under a different authority are detected.
+	authorities *authorityCanonicalizer
+	// paths maps the paths of requests to their template.
+	paths *pathTemplates
+}
+
+type retryAttempt struct {
+	seq         uint64
+	fingerprint string
+	lines       []string
+	retries     int
+	failed      bool
+	// released is true once the lines of the attempt were rendered, as its
+	// response started successfully.
+	released bool
+	endedAt  time.Time
+}
+
+func newRetryCollapser(window time.Duration, pairingBuffer int) *retryCollapser {
+	return &retryCollapser{
+		window:  window,
+		streams: make(map[streamKey]*retryAttempt),
+		bound:   newStreamBound(pairingBuffer),
+	}
+}
+
+// add records an event and its rendered line, returning the lines that are
+// ready to be written.
+func (c *retryCollapser) add(event *pb.TapEvent, line string, now time.Time) []string {
+	c.Lock()
+	defer c.Unlock()
+	out := c.expired(now)
+
+	key := newStreamKey(event)
+	switch ev := event.GetHttp().GetEvent().(type) {
+	case *pb.TapEvent_Http_RequestInit_:
+		c.seq++
+		attempt := &retryAttempt{
+			seq:         c.seq,
+			fingerprint: c.fingerprint(event, ev.RequestInit),
+			lines:       []string{line},
+		}
+		for i, previous := range c.ended {
+			if previous.fingerprint == attempt.fingerprint {
+				attempt.retries = previous.retries + 1
+				c.ended = append(c.ended[:i], c.ended[i+1:]...)
+				break
+			}
+		}
+		if oldest, evict := c.bound.track(key); evict {
+			// The evicted attempt is rendered as is, as its response
+			// won't be tracked.
+			out = append(out, c.streams[oldest].render()...)
+			delete(c.streams, oldest)
+		}
+		c.streams[key] = attempt
+		return out
+
+	case *pb.TapEvent_Http_ResponseInit_, *pb.TapEvent_Http_ResponseEnd_:
+		attempt, ok := c.streams[key]
+		if !ok {
+			// The request was missed, so retries can't be detected.
+			return append(out, line)
+		}
+		attempt.lines = append(attempt.lines, line)
+		switch ev := event.GetHttp().GetEvent().(type) {
+		case *pb.TapEvent_Http_ResponseInit_:
+			attempt.failed = ev.ResponseInit.GetHttpStatus() >= 500
+			if !attempt.failed {
+				out = append(out, attempt.render()...)
+				attempt.lines, attempt.released = nil, true
+			}
+		case *pb.TapEvent_Http_ResponseEnd_:
+			switch eos := ev.ResponseEnd.GetEos().GetEnd().(type) {
+			case *pb.Eos_GrpcStatusCode:
+				attempt.failed = attempt.failed || codes.Code(eos.GrpcStatusCode) != codes.OK
+			case *pb.Eos_ResetErrorCode:
+				attempt.failed = true
+			}
+			delete(c.streams, key)
+			c.bound.forget(key)
+			if !attempt.failed || attempt.released {
+				// Successful requests aren't retried, and the other lines
+				// of released attempts were rendered already.
+				out = append(out, attempt.render()...)
+				attempt.lines = nil
+			}
+			if attempt.failed {
+				attempt.endedAt = now
+				c.ended = append(c.ended, attempt)
+			}
+		}
+		return out
+	}
+
+	return append(out, line)
+}
+
+// expired returns the lines of the failed attempts that weren't retried
+// within the window, and forgets them. c must be locked.
+func (c *retryCollapser) expired(now time.Time) []string {
+	var out []string
+	for len(c.ended) > 0 && now.Sub(c.ended[0].endedAt) > c.window {
+		out = append(out, c.ended[0].render()...)
+		c.ended = c.ended[1:]
+	}
+	return out
+}
+
+// start writes the lines of the failed attempts that weren't retried to w on
+// every tick of the window, until the returned function is called, so that
+// they're not held back until another event is received.
+func (c *retryCollapser) start(w io.Writer, now func() time.Time) (stop func()) {
+	return writeOnTicks(c.window, func() error {
+		c.Lock()
+		lines := c.expired(now())
+		c.Unlock()
+		return writeLines(w, lines)
+	})
+}
+
+// flush returns the lines of all pending attempts: those whose response
+// ended, then those still in flight, in the order they started.
+func (c *retryCollapser) flush() []string {
+	c.Lock()
+	defer c.Unlock()
+	var out []string
+	for _, attempt := range c.ended {
+		out = append(out, attempt.render()...)
+	}
+	c.ended = nil
+
+	inFlight := make([]*retryAttempt, 0, len(c.streams))
+	for _, attempt := range c.streams {
+		inFlight = append(inFlight, attempt)
+	}
+	sort.Slice(inFlight, func(i, j int) bool { return inFlight[i].seq < inFlight[j].seq })
+	for _, attempt := range inFlight {
+		out = append(out, attempt.render()...)
+	}
+	c.streams = make(map[streamKey]*retryAttempt)
+	c.bound.reset()
+	return out
+}
+
+// fingerprint identifies a request by the fields retries share. The source
+// port is left out, as retries may be sent over another connection.
+func (c *retryCollapser) fingerprint(event *pb.TapEvent, reqI *pb.TapEvent_Http_RequestInit) string {
+	return fmt.Sprintf("%s %s %s %s",
+		addr.PublicIPToString(event.GetSource().GetIp()),
+		formatMethod(reqI.GetMethod()),
+		c.authorities.canonical(reqI.GetAuthority()),
+		c.paths.template(reqI.GetPath()),
+	)
+}
+
+func (a *retryAttempt) render() []string {
+	if a.retries == 0 {
+		return a.lines
+	}
+	lines := make([]string, len(a.lines))
+	for i, line := range a.lines {
+		lines[i] = fmt.Sprintf("%s retry=%d", line, a.retries)
+	}
+	return lines
+}
@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+// tapTestAttempt returns the events of a transaction on the given stream.
+func tapTestAttempt(stream uint64, path string, status uint32) []*pb.TapEvent {
+	return []*pb.TapEvent{
+		tapTestRequest(stream, pb.HttpMethod_GET, path),
+		tapTestResponse(stream, status, &duration.Duration{Nanos: 1000}),
+		tapTestEnd(stream, &pb.Eos{}, 10),
+	}
+}
+
+func TestRenderTapEventsCollapseRetries(t *testing.T) {
+	var events []*pb.TapEvent
+	// The request to /books is retried twice before it succeeds, while an
+	// unrelated request is in flight.
+	events = append(events, tapTestRequest(4, pb.HttpMethod_GET, "/authors"))
+	events = append(events, tapTestAttempt(1, "/books", http.StatusServiceUnavailable)...)
+	events = append(events, tapTestAttempt(2, "/books", http.StatusServiceUnavailable)...)
+	events = append(events, tapTestAttempt(3, "/books", http.StatusOK)...)
+	events = append(events,
+		tapTestResponse(4, http.StatusOK, &duration.Duration{Nanos: 1000}),
+		tapTestEnd(4, &pb.Eos{}, 10),
+	)
+
+	options := newTapOptions()
+	options.collapseRetries = true
+	if err := options.validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	output := renderTestTapEvents(t, options, events...)
+
+	ids := renderedIDs(output)
+	expectedIDs := []string{
+		"req id=7:3", "rsp id=7:3", "end id=7:3",
+		"req id=7:4", "rsp id=7:4", "end id=7:4",
+	}
+	if fmt.Sprint(ids) != fmt.Sprint(expectedIDs) {
+		t.Fatalf("Expecting %v, got %v", expectedIDs, ids)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		isRetried := strings.Contains(line, "id=7:3 ")
+		if strings.HasSuffix(line, " retry=2") != isRetried {
+			t.Fatalf("Expecting only the lines of stream 3 to end with retry=2, got [%s]", output)
+		}
+	}
+
+	t.Run("Rejects --collapse-retries with JSON output", func(t *testing.T) {
+		options := newTapOptions()
+		options.collapseRetries = true
+		options.output = jsonOutput
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+func TestRetryCollapser(t *testing.T) {
+	render := func(c *retryCollapser, events []*pb.TapEvent, now time.Time) []string {
+		var lines []string
+		for _, event := range events {
+			lines = append(lines, c.add(event, renderTapEvent(event, "", newTapOptions()), now)...)
+		}
+		return lines
+	}
+	start := time.Unix(0, 0)
+
+	t.Run("Doesn't collapse requests past the window", func(t *testing.T) {
+		c := newRetryCollapser(time.Second, 0)
+		if lines := render(c, tapTestAttempt(1, "/books", http.StatusServiceUnavailable), start); len(lines) != 0 {
+			t.Fatalf("Expecting the transaction to be held back, got %v", lines)
+		}
+		lines := render(c, tapTestAttempt(2, "/books", http.StatusOK), start.Add(2*time.Second))
+		ids := renderedIDs(strings.Join(append(lines, c.flush()...), "\n"))
+		expectedIDs := []string{"req id=7:1", "rsp id=7:1", "end id=7:1", "req id=7:2", "rsp id=7:2", "end id=7:2"}
+		if fmt.Sprint(ids) != fmt.Sprint(expectedIDs) {
+			t.Fatalf("Expecting %v, got %v", expectedIDs, ids)
+		}
+	})
+
+	t.Run("Doesn't collapse requests to another path", func(t *testing.T) {
+		c := newRetryCollapser(time.Second, 0)
+		render(c, tapTestAttempt(1, "/books", http.StatusServiceUnavailable), start)
+		render(c, tapTestAttempt(2, "/authors", http.StatusOK), start)
+		output := strings.Join(c.flush(), "\n")
+		if len(renderedIDs(output)) != 6 || strings.Contains(output, "retry=") {
+			t.Fatalf("Expecting both transactions, without retries, got [%s]", output)
+		}
+	})
+
+	t.Run("Doesn't collapse requests following a successful one", func(t *testing.T) {
+		c := newRetryCollapser(time.Second, 0)
+		first := render(c, tapTestAttempt(1, "/books", http.StatusOK), start)
+		second := render(c, tapTestAttempt(2, "/books", http.StatusOK), start)
+		output := strings.Join(append(first, second...), "\n")
+		expectedIDs := []string{"req id=7:1", "rsp id=7:1", "end id=7:1", "req id=7:2", "rsp id=7:2", "end id=7:2"}
+		if ids := renderedIDs(output); fmt.Sprint(ids) != fmt.Sprint(expectedIDs) || strings.Contains(output, "retry=") {
+			t.Fatalf("Expecting both transactions to be rendered right away, without retries, got [%s]", output)
+		}
+	})
+
+	t.Run("Collapses requests following a reset one", func(t *testing.T) {
+		c := newRetryCollapser(time.Second, 0)
+		reset := tapTestAttempt(1, "/books", http.StatusOK)
+		reset[2] = tapTestEnd(1, &pb.Eos{End: &pb.Eos_ResetErrorCode{ResetErrorCode: 2}}, 0)
+		render(c, reset, start)
+		output := strings.Join(render(c, tapTestAttempt(2, "/books", http.StatusOK), start), "\n")
+		expectedIDs := []string{"req id=7:2", "rsp id=7:2", "end id=7:2"}
+		if ids := renderedIDs(output); fmt.Sprint(ids) != fmt.Sprint(expectedIDs) || !strings.HasSuffix(output, " retry=1") {
+			t.Fatalf("Expecting the retry, got [%s]", output)
+		}
+	})
+
+	t.Run("Renders failed requests that aren't retried once the window elapses", func(t *testing.T) {
+		c := newRetryCollapser(time.Second, 0)
+		render(c, tapTestAttempt(1, "/books", http.StatusServiceUnavailable), start)
+		c.Lock()
+		if lines := c.expired(start.Add(time.Second)); len(lines) != 0 {
+			t.Fatalf("Expecting the transaction to be held back, got %v", lines)
+		}
+		lines := c.expired(start.Add(2 * time.Second))
+		c.Unlock()
+		expectedIDs := []string{"req id=7:1", "rsp id=7:1", "end id=7:1"}
+		if ids := renderedIDs(strings.Join(lines, "\n")); fmt.Sprint(ids) != fmt.Sprint(expectedIDs) {
+			t.Fatalf("Expecting %v, got %v", expectedIDs, ids)
+		}
+		if lines := c.flush(); len(lines) != 0 {
+			t.Fatalf("Expecting nothing left to flush, got %v", lines)
+		}
+	})
+
+	t.Run("Renders successful requests whose response never ends", func(t *testing.T) {
+		c := newRetryCollapser(time.Second, 0)
+		lines := render(c, tapTestAttempt(1, "/books.Books/Watch", http.StatusOK)[:2], start)
+		expectedIDs := []string{"req id=7:1", "rsp id=7:1"}
+		if ids := renderedIDs(strings.Join(lines, "\n")); fmt.Sprint(ids) != fmt.Sprint(expectedIDs) {
+			t.Fatalf("Expecting %v, got %v", expectedIDs, ids)
+		}
+		if lines := c.flush(); len(lines) != 0 {
+			t.Fatalf("Expecting nothing left to flush, got %v", lines)
+		}
+	})
+
+	t.Run("Collapses requests following one failing after a successful status", func(t *testing.T) {
+		c := newRetryCollapser(time.Second, 0)
+		failed := tapTestAttempt(1, "/books", http.StatusOK)
+		failed[2] = tapTestEnd(1, &pb.Eos{End: &pb.Eos_GrpcStatusCode{GrpcStatusCode: 14}}, 0)
+		if ids := renderedIDs(strings.Join(render(c, failed, start), "\n")); len(ids) != 3 {
+			t.Fatalf("Expecting the failed transaction to be rendered, got %v", ids)
+		}
+		output := strings.Join(render(c, tapTestAttempt(2, "/books", http.StatusOK), start), "\n")
+		expectedIDs := []string{"req id=7:2", "rsp id=7:2", "end id=7:2"}
+		if ids := renderedIDs(output); fmt.Sprint(ids) != fmt.Sprint(expectedIDs) || !strings.HasSuffix(output, " retry=1") {
+			t.Fatalf("Expecting the retry, got [%s]", output)
+		}
+		if lines := c.flush(); len(lines) != 0 {
+			t.Fatalf("Expecting nothing left to flush, got %v", lines)
+		}
+	})
+
+	t.Run("Renders the requests evicted beyond --pairing-buffer", func(t *testing.T) {
+		c := newRetryCollapser(time.Second, 2)
+		var lines []string
+		for stream := uint64(1); stream <= 3; stream++ {
+			lines = append(lines, render(c, tapTestAttempt(stream, fmt.Sprintf("/books/%d", stream), http.StatusOK)[:1], start)...)
+		}
+		expectedIDs := []string{"req id=7:1"}
+		if ids := renderedIDs(strings.Join(lines, "\n")); fmt.Sprint(ids) != fmt.Sprint(expectedIDs) {
+			t.Fatalf("Expecting %v, got %v", expectedIDs, ids)
+		}
+		if len(c.streams) != 2 || c.bound.evicted != 1 {
+			t.Fatalf("Expecting 2 streams in flight and 1 evicted, got %d and %d", len(c.streams), c.bound.evicted)
+		}
+	})
+
+	t.Run("Renders requests whose response is missing", func(t *testing.T) {
+		c := newRetryCollapser(time.Second, 0)
+		render(c, tapTestAttempt(1, "/books", http.StatusServiceUnavailable), start)
+		render(c, tapTestAttempt(2, "/books", http.StatusServiceUnavailable)[:1], start)
+		output := strings.Join(c.flush(), "\n")
+		expected := []string{"req id=7:2"}
+		if ids := renderedIDs(output); fmt.Sprint(ids) != fmt.Sprint(expected) || !strings.HasSuffix(output, " retry=1") {
+			t.Fatalf("Expecting the retry in flight, got [%s]", output)
+		}
+	})
+}
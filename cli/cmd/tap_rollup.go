@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"google.golang.org/grpc/codes"
+)
+
+// newSummaryTicker returns the channel periodic output, such as
+// `--summary-interval` rollups, is written on each tick of, and a function
+// stopping it.
+var newSummaryTicker = func(d time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(d)
+	return ticker.C, ticker.Stop
+}
+
+// intervalRollup accumulates statistics about the transactions of a tap
+// stream, which are written and reset on every `--summary-interval` tick.
+type intervalRollup struct {
+	interval time.Duration
+	// asciiOnly renders durations as with `--ascii-only`.
+	asciiOnly bool
+
+	sync.Mutex
+	transactionStats
+
+	// statusClasses counts responses by status class, e.g. 2xx, for
+	// `--rps-by-status`, if not nil; see rateStatuses.
+	statusClasses map[string]uint64
+	now           func() time.Time
+	windowStart   time.Time
+}
+
+// transactionStats counts the transactions of a tap stream and their
+// failures, and records the latencies of their responses.
+type transactionStats struct {
+	failed    map[streamKey]bool
+	requests  uint64
+	errors    uint64
+	latencies []time.Duration
+
+	// latencySamples bounds the number of latencies recorded if positive, in
+	// which case they're a uniform sample of the latencies observed.
+	latencySamples int
+	// observedLatencies counts the latencies observed, recorded or not.
+	observedLatencies uint64
+}
+
+func newIntervalRollup(interval time.Duration) *intervalRollup {
+	return &intervalRollup{
+		interval:         interval,
+		transactionStats: newTransactionStats(),
+	}
+}
+
+func newTransactionStats() transactionStats {
+	return transactionStats{failed: make(map[streamKey]bool)}
+}
+
+// rateStatuses enables the `--rps-by-status` breakdown of each interval. Its
+// rates, and the overall rate of requests, are then computed over the time
+// elapsed since the previous interval according to now, rather than the
+// nominal interval, so that they agree.
+func (r *intervalRollup) rateStatuses(now func() time.Time) {
+	r.Lock()
+	defer r.Unlock()
+	r.now = now
+	r.windowStart = now()
+	r.statusClasses = make(map[string]uint64)
+}
+
+// add accounts for an event that passed the display filters.
+func (r *intervalRollup) add(event *pb.TapEvent) {
+	r.Lock()
+	defer r.Unlock()
+	r.transactionStats.add(event)
+	if rspI := event.GetHttp().GetResponseInit(); rspI != nil && r.statusClasses != nil {
+		r.statusClasses[fmt.Sprintf("%dxx", rspI.GetHttpStatus()/100)]++
+	}
+}
+
+// add accounts for an event. Requests are counted once their response ends,
+// and fail if their response has a 5xx status, a non-OK gRPC status or is
+// reset.
+func (s *transactionStats) add(event *pb.TapEvent) {
+	key := newStreamKey(event)
+	switch ev := event.GetHttp().GetEvent().(type) {
+	case *pb.TapEvent_Http_ResponseInit_:
+		if latency, err := ptypes.Duration(ev.ResponseInit.GetSinceRequestInit()); err == nil {
+			s.addLatency(latency)
+		}
+		if ev.ResponseInit.GetHttpStatus() >= 500 {
+			s.failed[key] = true
+		}
+
+	case *pb.TapEvent_Http_ResponseEnd_:
+		failed := s.failed[key]
+		delete(s.failed, key)
+		switch eos := ev.ResponseEnd.GetEos().GetEnd().(type) {
+		case *pb.Eos_GrpcStatusCode:
+			failed = failed || codes.Code(eos.GrpcStatusCode) != codes.OK
+		case *pb.Eos_ResetErrorCode:
+			failed = true
+		}
+		s.requests++
+		if failed {
+			s.errors++
+		}
+	}
+}
+
+// addLatency records a latency, replacing a random one of those recorded with
+// a probability keeping them a uniform sample once latencySamples are.
+func (s *transactionStats) addLatency(latency time.Duration) {
+	s.observedLatencies++
+	if s.latencySamples <= 0 || len(s.latencies) < s.latencySamples {
+		s.latencies = append(s.latencies, latency)
+		return
+	}
+	if i := rand.Int63n(int64(s.observedLatencies)); i < int64(s.latencySamples) {
+		s.latencies[i] = latency
+	}
+}
+
+// latencyPercentile returns the smallest of the sorted latencies greater than
+// or equal to p% of them.
+func latencyPercentile(sorted []time.Duration, p int) time.Duration {
+	return sorted[(len(sorted)*p+99)/100-1]
+}
+
+// write renders the statistics of the interval that just ended, e.g.
+// `summary interval=10s requests=12 rps=1.2 errors=8.3% p99=120ms`, and
+// starts a new interval. With `--rps-by-status`, the line ends with the rate
+// of responses of each status class observed, e.g.
+// `status_rps=2xx:40.0/s,5xx:2.0/s`.
+func (r *intervalRollup) write(w io.Writer) error {
+	r.Lock()
+	requests, errors, latencies := r.requests, r.errors, r.latencies
+	r.requests, r.errors, r.latencies = 0, 0, nil
+	elapsed := r.interval
+	statusRates := ""
+	if r.statusClasses != nil {
+		now := r.now()
+		elapsed = now.Sub(r.windowStart)
+		statusRates = " status_rps=" + formatStatusRates(r.statusClasses, elapsed)
+		r.statusClasses = make(map[string]uint64)
+		r.windowStart = now
+	}
+	r.Unlock()
+
+	errorRate := "-"
+	if requests > 0 {
+		errorRate = fmt.Sprintf("%.1f%%", 100*float64(errors)/float64(requests))
+	}
+	rps := 0.0
+	if elapsed > 0 {
+		rps = float64(requests) / elapsed.Seconds()
+	}
+	p99 := "-"
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		p99 = durationString(latencyPercentile(latencies, 99), r.asciiOnly)
+	}
+
+	_, err := fmt.Fprintf(w, "summary interval=%s requests=%d rps=%.1f errors=%s p99=%s%s\n",
+		durationString(r.interval, r.asciiOnly), requests, rps, errorRate, p99, statusRates)
+	return err
+}
+
+// formatStatusRates renders the rate of responses of each status class over
+// elapsed, by class, or "-" if there were none.
+func formatStatusRates(classes map[string]uint64, elapsed time.Duration) string {
+	if len(classes) == 0 || elapsed <= 0 {
+		return "-"
+	}
+	var names []string
+	for class := range classes {
+		names = append(names, class)
+	}
+	sort.Strings(names)
+	rates := make([]string, len(names))
+	for i, class := range names {
+		rates[i] = fmt.Sprintf("%s:%.1f/s", class, float64(classes[class])/elapsed.Seconds())
+	}
+	return strings.Join(rates, ",")
+}
+
+// start writes the rollup to w on every tick, until the returned function is
+// called.
+func (r *intervalRollup) start(w io.Writer) (stop func()) {
+	return writeOnTicks(r.interval, func() error { return r.write(w) })
+}
+
+// writeOnTicks calls write on every tick of a ticker of the given interval,
+// until write fails or the returned function is called.
+func writeOnTicks(interval time.Duration, write func() error) (stop func()) {
+	ticks, stopTicker := newSummaryTicker(interval)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-ticks:
+				if err := write(); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		stopTicker()
+		close(done)
+		<-stopped
+	}
+}
+
+// syncWriter serializes writes to an underlying writer, so that lines written
+// concurrently don't interleave.
+type syncWriter struct {
+	sync.Mutex
+	w io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.w.Write(p)
+}
@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"google.golang.org/grpc/codes"
+)
+
+func TestIntervalRollup(t *testing.T) {
+	grpcOK := &pb.Eos{End: &pb.Eos_GrpcStatusCode{GrpcStatusCode: uint32(codes.OK)}}
+	grpcUnavailable := &pb.Eos{End: &pb.Eos_GrpcStatusCode{GrpcStatusCode: uint32(codes.Unavailable)}}
+	reset := &pb.Eos{End: &pb.Eos_ResetErrorCode{ResetErrorCode: 2}}
+
+	rollup := newIntervalRollup(2 * time.Second)
+	for stream := uint64(1); stream <= 100; stream++ {
+		status := uint32(http.StatusOK)
+		eos := grpcOK
+		switch stream {
+		case 1:
+			status = http.StatusServiceUnavailable
+		case 2:
+			eos = grpcUnavailable
+		case 3:
+			eos = reset
+		}
+		rollup.add(tapTestRequest(stream, pb.HttpMethod_GET, "/books"))
+		rollup.add(tapTestResponse(stream, status, &duration.Duration{Nanos: int32(stream) * 1000000}))
+		rollup.add(tapTestEnd(stream, eos, 0))
+	}
+	// Requests are counted once their response ends.
+	rollup.add(tapTestRequest(101, pb.HttpMethod_GET, "/books"))
+
+	testCases := []string{
+		"summary interval=2s requests=100 rps=50.0 errors=3.0% p99=99ms\n",
+		// Counters are reset on every interval.
+		"summary interval=2s requests=0 rps=0.0 errors=- p99=-\n",
+	}
+	for _, expected := range testCases {
+		output := bytes.NewBufferString("")
+		if err := rollup.write(output); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if output.String() != expected {
+			t.Fatalf("Expecting [%s], got [%s]", expected, output.String())
+		}
+	}
+}
+
+func TestRenderTapEventsSummaryInterval(t *testing.T) {
+	ticks := make(chan time.Time)
+	defer func(newTicker func(time.Duration) (<-chan time.Time, func())) {
+		newSummaryTicker = newTicker
+	}(newSummaryTicker)
+	newSummaryTicker = func(time.Duration) (<-chan time.Time, func()) {
+		return ticks, func() {}
+	}
+
+	stream, err := ioutil.ReadAll(tapEventStream(t,
+		tapTestRequest(1, pb.HttpMethod_GET, "/books"),
+		tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 1000000}),
+		tapTestEnd(1, &pb.Eos{}, 0),
+		tapTestRequest(2, pb.HttpMethod_GET, "/books"),
+		tapTestResponse(2, http.StatusInternalServerError, &duration.Duration{Nanos: 2000000}),
+		tapTestEnd(2, &pb.Eos{}, 0),
+	))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Events are received and lines rendered through pipes, so that each
+	// line is read as soon as it's written.
+	input, inputWriter := io.Pipe()
+	output, outputWriter := io.Pipe()
+	go func() {
+		options := newTapOptions()
+		options.summaryInterval = 10 * time.Second
+		err := renderTapEvents(bufio.NewReader(input), outputWriter, renderTapEvent, "", options)
+		outputWriter.CloseWithError(err)
+	}()
+	go inputWriter.Write(stream)
+
+	lines := bufio.NewScanner(output)
+	expectLine := func(expected string, prefix bool) {
+		if !lines.Scan() {
+			t.Fatalf("Expecting [%s], got the end of the output: %v", expected, lines.Err())
+		}
+		line := lines.Text()
+		if prefix && len(line) >= len(expected) {
+			line = line[:len(expected)]
+		}
+		if line != expected {
+			t.Fatalf("Expecting [%s], got [%s]", expected, lines.Text())
+		}
+	}
+
+	for _, expected := range []string{"req id=7:1 ", "rsp id=7:1 ", "end id=7:1 ", "req id=7:2 ", "rsp id=7:2 ", "end id=7:2 "} {
+		expectLine(expected, true)
+	}
+	ticks <- time.Now()
+	expectLine("summary interval=10s requests=2 rps=0.2 errors=50.0% p99=2ms", false)
+	ticks <- time.Now()
+	expectLine("summary interval=10s requests=0 rps=0.0 errors=- p99=-", false)
+
+	inputWriter.Close()
+	if lines.Scan() {
+		t.Fatalf("Expecting the output to end, got [%s]", lines.Text())
+	}
+	if err := lines.Err(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestIntervalRollupRPSByStatus(t *testing.T) {
+	now := time.Unix(1548000000, 0)
+	rollup := newIntervalRollup(10 * time.Second)
+	rollup.rateStatuses(func() time.Time { return now })
+
+	respond := func(stream uint64, status uint32) {
+		rollup.add(tapTestRequest(stream, pb.HttpMethod_GET, "/books"))
+		rollup.add(tapTestResponse(stream, status, &duration.Duration{Nanos: 1000000}))
+		rollup.add(tapTestEnd(stream, &pb.Eos{}, 0))
+	}
+
+	for stream := uint64(1); stream <= 40; stream++ {
+		status := uint32(http.StatusOK)
+		switch {
+		case stream <= 2:
+			status = http.StatusServiceUnavailable
+		case stream <= 6:
+			status = http.StatusNotFound
+		}
+		respond(stream, status)
+	}
+	now = now.Add(10 * time.Second)
+
+	expected := "summary interval=10s requests=40 rps=4.0 errors=5.0% p99=1ms status_rps=2xx:3.4/s,4xx:0.4/s,5xx:0.2/s\n"
+	output := bytes.NewBufferString("")
+	if err := rollup.write(output); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output.String() != expected {
+		t.Fatalf("Expecting [%s], got [%s]", expected, output.String())
+	}
+
+	t.Run("Resets counters on every interval", func(t *testing.T) {
+		now = now.Add(10 * time.Second)
+		expected := "summary interval=10s requests=0 rps=0.0 errors=- p99=- status_rps=-\n"
+		output := bytes.NewBufferString("")
+		if err := rollup.write(output); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if output.String() != expected {
+			t.Fatalf("Expecting [%s], got [%s]", expected, output.String())
+		}
+	})
+
+	t.Run("Rates over the time elapsed since the last interval", func(t *testing.T) {
+		respond(41, http.StatusOK)
+		respond(42, http.StatusOK)
+		respond(43, http.StatusInternalServerError)
+		now = now.Add(2 * time.Second)
+		expected := " rps=1.5 errors=33.3% p99=1ms status_rps=2xx:1.0/s,5xx:0.5/s\n"
+		output := bytes.NewBufferString("")
+		if err := rollup.write(output); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !strings.HasSuffix(output.String(), expected) {
+			t.Fatalf("Expecting output to end with [%s], got [%s]", expected, output.String())
+		}
+	})
+
+	t.Run("Rejects --rps-by-status without --summary-interval", func(t *testing.T) {
+		options := newTapOptions()
+		options.rpsByStatus = true
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+func TestRenderTapEventsRPSByStatus(t *testing.T) {
+	ticks := make(chan time.Time)
+	defer func(newTicker func(time.Duration) (<-chan time.Time, func())) {
+		newSummaryTicker = newTicker
+	}(newSummaryTicker)
+	newSummaryTicker = func(time.Duration) (<-chan time.Time, func()) {
+		return ticks, func() {}
+	}
+
+	var clock sync.Mutex
+	now := time.Unix(1548000000, 0)
+	advance := func(d time.Duration) {
+		clock.Lock()
+		defer clock.Unlock()
+		now = now.Add(d)
+	}
+
+	input, inputWriter := io.Pipe()
+	output, outputWriter := io.Pipe()
+	go func() {
+		options := newTapOptions()
+		options.summaryInterval = 10 * time.Second
+		options.rpsByStatus = true
+		options.now = func() time.Time {
+			clock.Lock()
+			defer clock.Unlock()
+			return now
+		}
+		err := renderTapEvents(bufio.NewReader(input), outputWriter, renderTapEvent, "", options)
+		outputWriter.CloseWithError(err)
+	}()
+	send := func(events ...*pb.TapEvent) {
+		stream, err := ioutil.ReadAll(tapEventStream(t, events...))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		go inputWriter.Write(stream)
+	}
+
+	lines := bufio.NewScanner(output)
+	expectLine := func(expected string) {
+		if !lines.Scan() {
+			t.Fatalf("Expecting [%s], got the end of the output: %v", expected, lines.Err())
+		}
+		if line := lines.Text(); !strings.HasPrefix(line, expected) && !strings.HasSuffix(line, expected) {
+			t.Fatalf("Expecting [%s], got [%s]", expected, line)
+		}
+	}
+	// expectRates checks that the rate of requests of the last summary is
+	// the sum of the rates of its status classes, as all the responses of
+	// the interval ended.
+	expectRates := func() {
+		var rps, sum float64
+		for _, field := range strings.Fields(lines.Text()) {
+			switch {
+			case strings.HasPrefix(field, "rps="):
+				fmt.Sscanf(field, "rps=%f", &rps)
+			case strings.HasPrefix(field, "status_rps="):
+				for _, rate := range strings.Split(strings.TrimPrefix(field, "status_rps="), ",") {
+					var classRPS float64
+					fmt.Sscanf(rate[strings.Index(rate, ":")+1:], "%f/s", &classRPS)
+					sum += classRPS
+				}
+			}
+		}
+		if fmt.Sprintf("%.1f", rps) != fmt.Sprintf("%.1f", sum) {
+			t.Fatalf("Expecting rps to be the sum of status_rps, got [%s]", lines.Text())
+		}
+	}
+
+	send(
+		tapTestRequest(1, pb.HttpMethod_GET, "/books"),
+		tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 1000000}),
+		tapTestEnd(1, &pb.Eos{}, 0),
+		tapTestRequest(2, pb.HttpMethod_GET, "/books"),
+		tapTestResponse(2, http.StatusBadGateway, &duration.Duration{Nanos: 1000000}),
+		tapTestEnd(2, &pb.Eos{}, 0),
+	)
+	for _, expected := range []string{"req id=7:1 ", "rsp id=7:1 ", "end id=7:1 ", "req id=7:2 ", "rsp id=7:2 ", "end id=7:2 "} {
+		expectLine(expected)
+	}
+	advance(10 * time.Second)
+	ticks <- time.Now()
+	expectLine(" status_rps=2xx:0.1/s,5xx:0.1/s")
+	expectRates()
+
+	send(
+		tapTestRequest(3, pb.HttpMethod_GET, "/books"),
+		tapTestResponse(3, http.StatusOK, &duration.Duration{Nanos: 1000000}),
+		tapTestEnd(3, &pb.Eos{}, 0),
+	)
+	expectLine("req id=7:3 ")
+	expectLine("rsp id=7:3 ")
+	expectLine("end id=7:3 ")
+	// The interval lasted 5s rather than --summary-interval, and both rates
+	// are computed over it.
+	advance(5 * time.Second)
+	ticks <- time.Now()
+	expectLine("summary interval=10s requests=1 rps=0.2 ")
+	expectRates()
+
+	inputWriter.Close()
+	if lines.Scan() {
+		t.Fatalf("Expecting the output to end, got [%s]", lines.Text())
+	}
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// sanitizeDisplayString escapes the bytes of s that aren't printable UTF-8,
+// so that a request's authority or path can't mess up the terminal it's
+// displayed in. Invalid UTF-8 bytes and non-printable ASCII runes are
+// rendered as `\xNN`, other non-printable runes as `\uNNNN`.
+func sanitizeDisplayString(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			fmt.Fprintf(&b, `\x%02x`, s[i])
+		case unicode.IsPrint(r):
+			b.WriteRune(r)
+		case r < utf8.RuneSelf:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		default:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// escapeNonASCII escapes the runes of s that aren't ASCII as `\uNNNN`, or
+// `\UNNNNNNNN` outside of the Basic Multilingual Plane.
+func escapeNonASCII(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r < utf8.RuneSelf:
+			b.WriteRune(r)
+		case r > 0xffff:
+			fmt.Fprintf(&b, `\U%08x`, r)
+		default:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		}
+	}
+	return b.String()
+}
+
+// displayString returns s as it should be displayed: sanitized, unless
+// `--raw-paths` is set, and escaped down to ASCII with `--ascii-only`.
+func (o *tapOptions) displayString(s string) string {
+	if o.rawPaths {
+		return s
+	}
+	if o.asciiOnly {
+		return escapeNonASCII(sanitizeDisplayString(s))
+	}
+	return sanitizeDisplayString(s)
+}
+
+// truncatePath shortens a displayed path to `--max-path-length` characters,
+// ending it with an ellipsis, so that very long paths don't wrap in the
+// terminal. The ellipsis is `...` with `--ascii-only`, in which case paths
+// shorter than it are cut without one.
+func (o *tapOptions) truncatePath(path string) string {
+	if o.maxPathLength == 0 || utf8.RuneCountInString(path) <= o.maxPathLength {
+		return path
+	}
+	runes := []rune(path)
+	if !o.asciiOnly {
+		return string(runes[:o.maxPathLength-1]) + "…"
+	}
+	if o.maxPathLength < len("...") {
+		return string(runes[:o.maxPathLength])
+	}
+	return string(runes[:o.maxPathLength-len("...")]) + "..."
+}
+
+// microsUnit returns the unit of durations displayed in microseconds: `µs`,
+// or `us` with `--ascii-only`.
+func (o *tapOptions) microsUnit() string {
+	if o.asciiOnly {
+		return "us"
+	}
+	return "µs"
+}
+
+// durationString formats d as time.Duration's String does, with `us` rather
+// than `µs` if asciiOnly is set.
+func durationString(d time.Duration, asciiOnly bool) string {
+	if asciiOnly {
+		return strings.Replace(d.String(), "µs", "us", 1)
+	}
+	return d.String()
+}
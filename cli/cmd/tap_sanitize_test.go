@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+func TestSanitizeDisplayString(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"printable", "/books/1?q=a+b", "/books/1?q=a+b"},
+		{"unicode", "/livres/été", "/livres/été"},
+		{"escape sequence", "/books\x1b[2J", `/books\x1b[2J`},
+		{"control characters", "/a\r\nb\x00\x7f", `/a\x0d\x0ab\x00\x7f`},
+		{"invalid UTF-8", "/books\xff\xfe", `/books\xff\xfe`},
+		{"truncated UTF-8", "/livres/\xc3", `/livres/\xc3`},
+		{"invisible unicode", "/a\u200bb\u0085", `/a\u200bb\u0085`},
+	}
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(tc.name, func(t *testing.T) {
+			if output := sanitizeDisplayString(tc.input); output != tc.expected {
+				t.Fatalf("Expecting [%s], got [%s]", tc.expected, output)
+			}
+		})
+	}
+}
+
+func TestRenderTapEventSanitizesPaths(t *testing.T) {
+	event := tapTestRequest(1, pb.HttpMethod_GET, "/books\x1b[2J\xff")
+	event.GetHttp().GetRequestInit().Authority = "books\r\n.default"
+
+	t.Run("Escapes the authority and path", func(t *testing.T) {
+		line := renderTapEvent(event, "", newTapOptions())
+		expected := ` :authority=books\x0d\x0a.default :path=/books\x1b[2J\xff`
+		if !strings.HasSuffix(line, expected) {
+			t.Fatalf("Expecting line to end with [%s], got [%s]", expected, line)
+		}
+
+		reqI := mapTapEventWithOptions(event, newTapOptions()).RequestInitEvent
+		if reqI.Path != `/books\x1b[2J\xff` || reqI.Authority != `books\x0d\x0a.default` {
+			t.Fatalf("Expecting escaped authority and path, got [%s] [%s]", reqI.Authority, reqI.Path)
+		}
+	})
+
+	t.Run("Keeps the authority and path as is with --raw-paths", func(t *testing.T) {
+		options := newTapOptions()
+		options.rawPaths = true
+		line := renderTapEvent(event, "", options)
+		expected := " :authority=books\r\n.default :path=/books\x1b[2J\xff"
+		if !strings.HasSuffix(line, expected) {
+			t.Fatalf("Expecting line to end with [%q], got [%q]", expected, line)
+		}
+	})
+}
+
+func TestRenderTapEventMaxPathLength(t *testing.T) {
+	event := tapTestRequest(1, pb.HttpMethod_GET, "/books/ééé?token=abcdef")
+
+	testCases := []struct {
+		maxPathLength int
+		expected      string
+	}{
+		{0, ":path=/books/ééé?token=abcdef"},
+		{23, ":path=/books/ééé?token=abcdef"},
+		{22, ":path=/books/ééé?token=abcd…"},
+		{11, ":path=/books/ééé…"},
+		{1, ":path=…"},
+	}
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(fmt.Sprintf("--max-path-length=%d", tc.maxPathLength), func(t *testing.T) {
+			options := newTapOptions()
+			options.maxPathLength = tc.maxPathLength
+			if err := options.validate(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if line := renderTapEvent(event, "", options); !strings.HasSuffix(line, " "+tc.expected) {
+				t.Fatalf("Expecting line to end with [%s], got [%s]", tc.expected, line)
+			}
+
+			var m tapEvent
+			if err := json.Unmarshal([]byte(renderTapEventJSON(event, "", options)), &m); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if m.RequestInitEvent.Path != "/books/ééé?token=abcdef" {
+				t.Fatalf("Expecting the full path in JSON output, got [%s]", m.RequestInitEvent.Path)
+			}
+		})
+	}
+
+	t.Run("Rejects a negative --max-path-length", func(t *testing.T) {
+		options := newTapOptions()
+		options.maxPathLength = -1
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+func TestRenderTapEventsASCIIOnly(t *testing.T) {
+	req := tapTestRequest(1, pb.HttpMethod_GET, "/livres/été/🚀")
+	req.GetHttp().GetRequestInit().Authority = "bücher.default:7000"
+	events := []*pb.TapEvent{
+		req,
+		tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 1000}),
+		tapTestEnd(1, &pb.Eos{}, 10),
+		tapTestEnd(2, &pb.Eos{End: &pb.Eos_GrpcStatusCode{GrpcStatusCode: 0}}, 10),
+		tapTestEnd(3, &pb.Eos{End: &pb.Eos_ResetErrorCode{ResetErrorCode: 2}}, 0),
+	}
+
+	options := newTapOptions()
+	options.asciiOnly = true
+	options.groupBySrcDst = true
+	if err := options.validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	output := renderTestTapEvents(t, options, events...)
+	for i := 0; i < len(output); i++ {
+		if output[i] >= utf8.RuneSelf {
+			t.Fatalf("Expecting only ASCII output, got byte 0x%x at %d in [%s]", output[i], i, output)
+		}
+	}
+	for _, expected := range []string{
+		`:authority=b\u00fccher.default:7000 `,
+		`:path=/livres/\u00e9t\u00e9/\U0001f680`,
+		" latency=1us",
+		" 1us   1us\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Fatalf("Expecting output to contain [%s], got [%s]", expected, output)
+		}
+	}
+
+	t.Run("Truncates paths with an ASCII ellipsis", func(t *testing.T) {
+		testCases := []struct {
+			maxPathLength int
+			expected      string
+		}{
+			{10, ":path=/livres..."},
+			{2, ":path=/l"},
+		}
+		for _, tc := range testCases {
+			options := newTapOptions()
+			options.asciiOnly = true
+			options.maxPathLength = tc.maxPathLength
+			if line := renderTapEvent(req, "", options); !strings.HasSuffix(line, " "+tc.expected) {
+				t.Fatalf("Expecting line to end with [%s], got [%s]", tc.expected, line)
+			}
+		}
+	})
+
+	t.Run("Rejects --ascii-only with --raw-paths", func(t *testing.T) {
+		options := newTapOptions()
+		options.asciiOnly = true
+		options.rawPaths = true
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
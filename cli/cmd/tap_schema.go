@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+const jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// metadataType is the interface implemented by header and trailer entries.
+var metadataType = reflect.TypeOf((*metadata)(nil)).Elem()
+
+// metadataImplementations lists the concrete types a `metadata` can hold, as
+// these can't be discovered through reflection.
+var metadataImplementations = []reflect.Type{
+	reflect.TypeOf(metadataStr{}),
+	reflect.TypeOf(metadataBin{}),
+}
+
+// printTapEventSchema writes a JSON Schema document describing the events
+// rendered by `linkerd tap -o json`. The schema is generated from the JSON
+// struct tags of the display types, so it always matches the output.
+func printTapEventSchema(w io.Writer) error {
+	schema := jsonSchemaForType(reflect.TypeOf(tapEvent{}))
+	schema["$schema"] = jsonSchemaDraft
+	schema["title"] = "tapEvent"
+
+	out, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
+
+func jsonSchemaForType(t reflect.Type) map[string]interface{} {
+	nullable := false
+	if t.Kind() == reflect.Ptr {
+		nullable = true
+		t = t.Elem()
+	}
+
+	var schema map[string]interface{}
+	switch t.Kind() {
+	case reflect.Struct:
+		schema = jsonSchemaForStruct(t)
+	case reflect.Map:
+		nullable = true
+		schema = map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": jsonSchemaForType(t.Elem()),
+		}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// encoding/json renders []byte as a base64 string.
+			schema = map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+			break
+		}
+		nullable = true
+		schema = map[string]interface{}{
+			"type":  "array",
+			"items": jsonSchemaForType(t.Elem()),
+		}
+	case reflect.Interface:
+		if t != metadataType {
+			return map[string]interface{}{}
+		}
+		var oneOf []interface{}
+		for _, impl := range metadataImplementations {
+			oneOf = append(oneOf, jsonSchemaForType(impl))
+		}
+		return map[string]interface{}{"oneOf": oneOf}
+	case reflect.String:
+		schema = map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		schema = map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema = map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		schema = map[string]interface{}{"type": "number"}
+	default:
+		return map[string]interface{}{}
+	}
+
+	if nullable {
+		schema["type"] = []interface{}{schema["type"], "null"}
+	}
+	return schema
+}
+
+func jsonSchemaForStruct(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name, omitEmpty := jsonFieldName(field)
+		if name == "-" {
+			continue
+		}
+		properties[name] = jsonSchemaForType(field.Type)
+		if !omitEmpty {
+			required = append(required, name)
+		}
+	}
+
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+// jsonFieldName returns the name a struct field is marshaled as, and whether
+// it is omitted when empty.
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	tag := strings.Split(field.Tag.Get("json"), ",")
+	name := tag[0]
+	if name == "" {
+		name = field.Name
+	}
+	omitEmpty := false
+	for _, opt := range tag[1:] {
+		if opt == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return name, omitEmpty
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestPrintTapEventSchema(t *testing.T) {
+	writer := bytes.NewBufferString("")
+	err := printTapEventSchema(writer)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var schema struct {
+		Schema     string                            `json:"$schema"`
+		Type       string                            `json:"type"`
+		Required   []string                          `json:"required"`
+		Properties map[string]map[string]interface{} `json:"properties"`
+	}
+	err = json.Unmarshal(writer.Bytes(), &schema)
+	if err != nil {
+		t.Fatalf("Schema is not valid JSON: %v", err)
+	}
+
+	if schema.Schema != jsonSchemaDraft {
+		t.Fatalf("Expecting $schema to be [%s], got [%s]", jsonSchemaDraft, schema.Schema)
+	}
+	if schema.Type != "object" {
+		t.Fatalf("Expecting type to be [object], got [%s]", schema.Type)
+	}
+
+	expectedFields := []string{
+		"source",
+		"destination",
+		"routeMeta",
+		"proxyDirection",
+		"requestInitEvent",
+		"responseInitEvent",
+		"responseEndEvent",
+		"traceId",
+		"reporter",
+		"streamBase",
+		"streamNumber",
+	}
+	for _, field := range expectedFields {
+		if _, ok := schema.Properties[field]; !ok {
+			t.Fatalf("Expecting schema to describe field [%s], got %v", field, schema.Properties)
+		}
+	}
+	if len(schema.Properties) != len(expectedFields) {
+		t.Fatalf("Expecting %d fields, got %d: %v", len(expectedFields), len(schema.Properties), schema.Properties)
+	}
+
+	expectedRequired := []string{"source", "destination", "routeMeta", "proxyDirection"}
+	if len(schema.Required) != len(expectedRequired) {
+		t.Fatalf("Expecting required fields %v, got %v", expectedRequired, schema.Required)
+	}
+	for i, field := range expectedRequired {
+		if schema.Required[i] != field {
+			t.Fatalf("Expecting required fields %v, got %v", expectedRequired, schema.Required)
+		}
+	}
+
+	reqInit := schema.Properties["requestInitEvent"]["properties"].(map[string]interface{})
+	for _, field := range []string{"id", "method", "scheme", "authority", "path", "headers"} {
+		if _, ok := reqInit[field]; !ok {
+			t.Fatalf("Expecting requestInitEvent schema to describe field [%s], got %v", field, reqInit)
+		}
+	}
+}
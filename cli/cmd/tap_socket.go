@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+)
+
+// tapSocket streams events, as NDJSON, to the listener of a Unix domain
+// socket, such as a local agent ingesting tap, instead of stdout.
+type tapSocket struct {
+	path string
+	conn net.Conn
+}
+
+// dialTapSocket connects to the listener of the Unix domain socket at path.
+func dialTapSocket(path string) (*tapSocket, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to --socket %s: %v", path, err)
+	}
+	return &tapSocket{path: path, conn: conn}, nil
+}
+
+// Write writes to the socket. Once the connection is lost, writes fail and tap
+// stops, as events aren't buffered until the listener comes back.
+func (s *tapSocket) Write(p []byte) (int, error) {
+	n, err := s.conn.Write(p)
+	if err != nil {
+		return n, fmt.Errorf("lost the connection to --socket %s: %v", s.path, err)
+	}
+	return n, nil
+}
+
+func (s *tapSocket) Close() error {
+	return s.conn.Close()
+}
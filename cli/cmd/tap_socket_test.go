@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+// listenTapSocket listens on a Unix domain socket in a temporary directory,
+// and returns its path and the listener.
+func listenTapSocket(t *testing.T) (string, net.Listener, func()) {
+	dir, err := ioutil.TempDir("", "tap-socket")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	path := filepath.Join(dir, "tap.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return path, listener, func() {
+		listener.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestTapSocket(t *testing.T) {
+	events := []*pb.TapEvent{
+		tapTestRequest(1, pb.HttpMethod_GET, "/books"),
+		tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 1000}),
+		tapTestEnd(1, &pb.Eos{}, 10),
+	}
+
+	t.Run("Streams events as NDJSON", func(t *testing.T) {
+		path, listener, cleanup := listenTapSocket(t)
+		defer cleanup()
+		lines := make(chan []string)
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				close(lines)
+				return
+			}
+			defer conn.Close()
+			var received []string
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				received = append(received, scanner.Text())
+			}
+			lines <- received
+		}()
+
+		options := newTapOptions()
+		options.socket = path
+		options.applyDefaults()
+		if err := options.validate(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		socket, err := dialTapSocket(path)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		err = writeTapEventsToBuffer(socket, tapEventStream(t, events...), &pb.TapByResourceRequest{}, options)
+		socket.Close()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		received := <-lines
+		if len(received) != len(events) {
+			t.Fatalf("Expecting %d lines, got %d: %v", len(events), len(received), received)
+		}
+		for i, line := range received {
+			var event map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &event); err != nil {
+				t.Fatalf("Expecting line %d to be a JSON object, got [%s]: %v", i, line, err)
+			}
+		}
+		if !strings.Contains(received[0], "requestInitEvent") {
+			t.Fatalf("Expecting a request event, got [%s]", received[0])
+		}
+	})
+
+	t.Run("Stops once the connection is lost", func(t *testing.T) {
+		path, listener, cleanup := listenTapSocket(t)
+		defer cleanup()
+		accepted := make(chan struct{})
+		go func() {
+			if conn, err := listener.Accept(); err == nil {
+				conn.Close()
+			}
+			close(accepted)
+		}()
+
+		options := newTapOptions()
+		options.socket = path
+		options.applyDefaults()
+		if err := options.validate(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		socket, err := dialTapSocket(path)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer socket.Close()
+		<-accepted
+
+		err = writeTapEventsToBuffer(socket, tapEventStream(t, events...), &pb.TapByResourceRequest{}, options)
+		if err == nil || !strings.Contains(err.Error(), "lost the connection to --socket") {
+			t.Fatalf("Expecting the lost connection to be reported, got %v", err)
+		}
+	})
+
+	t.Run("Fails without a listener", func(t *testing.T) {
+		path, _, cleanup := listenTapSocket(t)
+		cleanup()
+		if _, err := dialTapSocket(path); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+
+	t.Run("Defaults to JSON output", func(t *testing.T) {
+		options := newTapOptions()
+		options.socket = "tap.sock"
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+		if options.output != "" {
+			t.Fatalf("Expecting validate to leave the output unset, got %q", options.output)
+		}
+		options.applyDefaults()
+		if err := options.validate(); err != nil || options.output != jsonOutput {
+			t.Fatalf("Expecting \"%s\" output, got %q and %v", jsonOutput, options.output, err)
+		}
+	})
+
+	t.Run("Rejects unsupported options", func(t *testing.T) {
+		testCases := []func(*tapOptions){
+			func(o *tapOptions) { o.output = wideOutput },
+			func(o *tapOptions) { o.jsonPath = "{.source}" },
+			func(o *tapOptions) { o.summaryInterval = 10 },
+			func(o *tapOptions) { o.sizeHistogram = true },
+		}
+		for i, setOption := range testCases {
+			options := newTapOptions()
+			options.socket = "tap.sock"
+			setOption(options)
+			options.applyDefaults()
+			if err := options.validate(); err == nil {
+				t.Fatalf("Case %d: expected error, got nothing", i)
+			}
+		}
+	})
+}
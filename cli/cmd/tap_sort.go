@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+// sortLatency is the `--sort` value rendering transactions by descending
+// response latency.
+const sortLatency = "latency"
+
+// latencySorter buffers the rendered lines of each transaction until the
+// stream ends, then returns them with the slowest transactions first, for
+// `--sort latency`. At most maxEvents events are buffered; later ones are
+// dropped.
+type latencySorter struct {
+	maxEvents int
+	events    int
+	dropped   int
+	// transactions holds every transaction, in the order they started.
+	transactions []*sortedTransaction
+	// open maps streams to their transaction until its response ends.
+	open  map[streamKey]*sortedTransaction
+	bound *streamBound
+}
+
+type sortedTransaction struct {
+	lines []string
+	// latency is the time the response took to start, or to end if its
+	// start was missed. It is unknown while known is false.
+	latency time.Duration
+	known   bool
+}
+
+func newLatencySorter(maxEvents, pairingBuffer int) *latencySorter {
+	return &latencySorter{
+		maxEvents: maxEvents,
+		open:      make(map[streamKey]*sortedTransaction),
+		bound:     newStreamBound(pairingBuffer),
+	}
+}
+
+// add buffers an event and its rendered line.
+func (s *latencySorter) add(event *pb.TapEvent, line string) {
+	if s.events >= s.maxEvents {
+		s.dropped++
+		return
+	}
+	s.events++
+
+	key := newStreamKey(event)
+	tx, ok := s.open[key]
+	if !ok {
+		if oldest, evict := s.bound.track(key); evict {
+			// The evicted transaction is still rendered, with the events
+			// observed so far.
+			delete(s.open, oldest)
+		}
+		tx = &sortedTransaction{}
+		s.open[key] = tx
+		s.transactions = append(s.transactions, tx)
+	}
+	tx.lines = append(tx.lines, line)
+
+	switch ev := event.GetHttp().GetEvent().(type) {
+	case *pb.TapEvent_Http_ResponseInit_:
+		if latency, err := ptypes.Duration(ev.ResponseInit.GetSinceRequestInit()); err == nil {
+			tx.latency, tx.known = latency, true
+		}
+	case *pb.TapEvent_Http_ResponseEnd_:
+		if latency, err := ptypes.Duration(ev.ResponseEnd.GetSinceRequestInit()); err == nil && !tx.known {
+			tx.latency, tx.known = latency, true
+		}
+		delete(s.open, key)
+		s.bound.forget(key)
+	}
+}
+
+// flush returns the lines of the buffered transactions, slowest first.
+// Transactions whose latency is unknown come last, and transactions with the
+// same latency are kept in the order they started. A note is added if events
+// were dropped.
+func (s *latencySorter) flush() []string {
+	sort.SliceStable(s.transactions, func(i, j int) bool {
+		a, b := s.transactions[i], s.transactions[j]
+		if a.known != b.known {
+			return a.known
+		}
+		return a.latency > b.latency
+	})
+
+	var out []string
+	for _, tx := range s.transactions {
+		out = append(out, tx.lines...)
+	}
+	if s.dropped > 0 {
+		out = append(out, fmt.Sprintf("%d events dropped after buffering --max-events=%d", s.dropped, s.maxEvents))
+	}
+	s.transactions = nil
+	s.open = make(map[streamKey]*sortedTransaction)
+	s.bound.reset()
+	return out
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+func TestRenderTapEventsSortLatency(t *testing.T) {
+	latencies := []*duration.Duration{
+		{Nanos: 5000000}, // 5ms
+		{Seconds: 2},
+		{Nanos: 50000000}, // 50ms
+		{Nanos: 5000000},  // 5ms, same as stream 1
+	}
+	var events []*pb.TapEvent
+	for i, latency := range latencies {
+		stream := uint64(i + 1)
+		events = append(events,
+			tapTestRequest(stream, pb.HttpMethod_GET, "/books"),
+			tapTestResponse(stream, http.StatusOK, latency),
+		)
+	}
+	for i := range latencies {
+		events = append(events, tapTestEnd(uint64(i+1), &pb.Eos{}, 0))
+	}
+	// The response of stream 5 never starts, so its latency is unknown.
+	events = append(events, tapTestRequest(5, pb.HttpMethod_GET, "/authors"))
+
+	t.Run("Sorts transactions by descending latency", func(t *testing.T) {
+		options := newTapOptions()
+		options.sort = sortLatency
+		if err := options.validate(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		ids := renderedIDs(renderTestTapEvents(t, options, events...))
+		expectedIDs := []string{
+			"req id=7:2", "rsp id=7:2", "end id=7:2",
+			"req id=7:3", "rsp id=7:3", "end id=7:3",
+			"req id=7:1", "rsp id=7:1", "end id=7:1",
+			"req id=7:4", "rsp id=7:4", "end id=7:4",
+			"req id=7:5",
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(expectedIDs) {
+			t.Fatalf("Expecting %v, got %v", expectedIDs, ids)
+		}
+	})
+
+	t.Run("Drops events after --max-events", func(t *testing.T) {
+		options := newTapOptions()
+		options.sort = sortLatency
+		options.maxEvents = 4
+		output := renderTestTapEvents(t, options, events...)
+		lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+		expectedIDs := []string{
+			"req id=7:2", "rsp id=7:2",
+			"req id=7:1", "rsp id=7:1",
+		}
+		if ids := renderedIDs(strings.Join(lines[:len(lines)-1], "\n")); fmt.Sprint(ids) != fmt.Sprint(expectedIDs) {
+			t.Fatalf("Expecting %v, got %v", expectedIDs, ids)
+		}
+		expectedWarning := "9 events dropped after buffering --max-events=4"
+		if warning := lines[len(lines)-1]; warning != expectedWarning {
+			t.Fatalf("Expecting %q, got %q", expectedWarning, warning)
+		}
+	})
+
+	t.Run("Rejects an unknown --sort", func(t *testing.T) {
+		options := newTapOptions()
+		options.sort = "path"
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+
+	t.Run("Rejects --sort with --dedup", func(t *testing.T) {
+		options := newTapOptions()
+		options.sort = sortLatency
+		options.dedup = true
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
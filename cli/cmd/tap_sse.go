@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+const sseOutput = "sse"
+
+// renderTapEventsSSE writes each event of a tap stream as a Server-Sent
+// Events frame, so that it can be consumed by an `EventSource` in a browser.
+// Each frame carries the JSON rendering of the event, is named after the event
+// type and has an incrementing id.
+func renderTapEventsSSE(tapByteStream *bufio.Reader, w io.Writer, options *tapOptions) error {
+	// Escape sequences would corrupt the JSON payloads.
+	jsonOptions := *options
+	jsonOptions.color = false
+
+	id := uint64(0)
+	return forEachTapEvent(tapByteStream, options, func(event *pb.TapEvent) error {
+		id++
+		frame := formatSSEFrame(id, sseEventType(event), renderTapEventJSON(event, "", &jsonOptions))
+		// Each frame is written at once, so that it is flushed as a whole.
+		_, err := io.WriteString(w, frame)
+		return err
+	})
+}
+
+// sseEventType names an event after its JSON field in `tapEvent`.
+func sseEventType(event *pb.TapEvent) string {
+	switch event.GetHttp().GetEvent().(type) {
+	case *pb.TapEvent_Http_RequestInit_:
+		return "requestInit"
+	case *pb.TapEvent_Http_ResponseInit_:
+		return "responseInit"
+	case *pb.TapEvent_Http_ResponseEnd_:
+		return "responseEnd"
+	default:
+		return "unknown"
+	}
+}
+
+// formatSSEFrame renders an SSE frame. Multi-line data is split across several
+// `data:` fields, which clients join back with newlines.
+func formatSSEFrame(id uint64, eventType, data string) string {
+	var frame strings.Builder
+	fmt.Fprintf(&frame, "id: %d\n", id)
+	fmt.Fprintf(&frame, "event: %s\n", eventType)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&frame, "data: %s\n", line)
+	}
+	frame.WriteString("\n")
+	return frame.String()
+}
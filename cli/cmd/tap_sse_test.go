@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"google.golang.org/grpc/codes"
+)
+
+func TestRenderTapEventsSSE(t *testing.T) {
+	grpcOK := &pb.Eos{End: &pb.Eos_GrpcStatusCode{GrpcStatusCode: uint32(codes.OK)}}
+	events := []*pb.TapEvent{
+		tapTestRequest(1, pb.HttpMethod_GET, "/books"),
+		tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 1000}),
+		tapTestEnd(1, grpcOK, 42),
+	}
+
+	writer := bytes.NewBufferString("")
+	options := newTapOptions()
+	options.color = true
+	err := renderTapEventsSSE(tapEventStream(t, events...), writer, options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	output := writer.String()
+	if !strings.HasSuffix(output, "\n\n") {
+		t.Fatalf("Expecting output to end with a blank line, got [%s]", output)
+	}
+	frames := strings.Split(strings.TrimSuffix(output, "\n\n"), "\n\n")
+	if len(frames) != len(events) {
+		t.Fatalf("Expecting %d frames, got %d: [%s]", len(events), len(frames), output)
+	}
+
+	expectedTypes := []string{"requestInit", "responseInit", "responseEnd"}
+	for i, frame := range frames {
+		lines := strings.Split(frame, "\n")
+		expectedID := fmt.Sprintf("id: %d", i+1)
+		if lines[0] != expectedID {
+			t.Fatalf("Expecting frame %d to start with [%s], got [%s]", i, expectedID, lines[0])
+		}
+		expectedEvent := "event: " + expectedTypes[i]
+		if lines[1] != expectedEvent {
+			t.Fatalf("Expecting frame %d to have [%s], got [%s]", i, expectedEvent, lines[1])
+		}
+
+		var data []string
+		for _, line := range lines[2:] {
+			if !strings.HasPrefix(line, "data: ") {
+				t.Fatalf("Expecting frame %d to only have data fields after the event, got [%s]", i, line)
+			}
+			data = append(data, strings.TrimPrefix(line, "data: "))
+		}
+		expectedData := renderTapEventJSON(events[i], "", newTapOptions())
+		if strings.Join(data, "\n") != expectedData {
+			t.Fatalf("Expecting frame %d data [%s], got [%s]", i, expectedData, strings.Join(data, "\n"))
+		}
+		if !json.Valid([]byte(expectedData)) {
+			t.Fatalf("Expecting frame %d data to be valid JSON, got [%s]", i, expectedData)
+		}
+	}
+}
+
+func TestFormatSSEFrame(t *testing.T) {
+	expected := "id: 3\nevent: requestInit\ndata: {\ndata:   \"a\": 1\ndata: }\n\n"
+	frame := formatSSEFrame(3, "requestInit", "{\n  \"a\": 1\n}")
+	if frame != expected {
+		t.Fatalf("Expecting [%s], got [%s]", expected, frame)
+	}
+}
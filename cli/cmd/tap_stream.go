@@ -0,0 +1,314 @@
+package cmd
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"google.golang.org/grpc/codes"
+)
+
+// streamClassifier decides whether a stream is displayed, given the events of
+// the stream observed so far. It returns false for decided if these aren't
+// enough to tell, in which case it is called again on the next event.
+type streamClassifier func(events []*pb.TapEvent) (keep bool, decided bool)
+
+// streamBound bounds the number of streams a map of the streams in flight
+// tracks, so that streams whose response never ends don't grow it forever:
+// once limit streams are tracked, the oldest one is evicted to make room for
+// a new one. A limit of 0 means no limit.
+type streamBound struct {
+	limit int
+	// order holds the keys of the tracked streams, oldest first.
+	order   *list.List
+	elems   map[streamKey]*list.Element
+	evicted int
+}
+
+func newStreamBound(limit int) *streamBound {
+	return &streamBound{
+		limit: limit,
+		order: list.New(),
+		elems: make(map[streamKey]*list.Element),
+	}
+}
+
+// track starts tracking a stream, unless it's tracked already. If the limit
+// is reached, the oldest stream is evicted and returned with evict set, so
+// that the caller forgets it too.
+func (b *streamBound) track(key streamKey) (oldest streamKey, evict bool) {
+	if _, ok := b.elems[key]; ok {
+		return streamKey{}, false
+	}
+	if b.limit > 0 && len(b.elems) >= b.limit {
+		oldest = b.order.Remove(b.order.Front()).(streamKey)
+		delete(b.elems, oldest)
+		b.evicted++
+		evict = true
+	}
+	b.elems[key] = b.order.PushBack(key)
+	return oldest, evict
+}
+
+// forget stops tracking a stream, once its response ends.
+func (b *streamBound) forget(key streamKey) {
+	if elem, ok := b.elems[key]; ok {
+		b.order.Remove(elem)
+		delete(b.elems, key)
+	}
+}
+
+// reset stops tracking all streams.
+func (b *streamBound) reset() {
+	b.order.Init()
+	b.elems = make(map[streamKey]*list.Element)
+}
+
+// warn tells on stderr how many incomplete requests the named option stopped
+// tracking because of the limit, if any.
+func (b *streamBound) warn(option string) {
+	if b.evicted > 0 {
+		fmt.Fprintf(os.Stderr, "%s stopped tracking %d incomplete requests after reaching --pairing-buffer=%d\n", option, b.evicted, b.limit)
+	}
+}
+
+// streamFilter holds back the events of each stream until its classifier
+// decides whether the stream is displayed, so that a request isn't displayed
+// only for its response to be filtered out. Streams still undecided when
+// their response ends are dropped.
+//
+// The streams tracked are bounded, so that streams whose response never ends
+// don't grow the filter forever; see streamBound.
+type streamFilter struct {
+	classify streamClassifier
+	streams  map[streamKey]*filteredStream
+	bound    *streamBound
+}
+
+type filteredStream struct {
+	decided bool
+	keep    bool
+	pending []*pb.TapEvent
+}
+
+func newStreamFilter(classify streamClassifier, limit int) *streamFilter {
+	return &streamFilter{
+		classify: classify,
+		streams:  make(map[streamKey]*filteredStream),
+		bound:    newStreamBound(limit),
+	}
+}
+
+// add returns the events that can be displayed once the given event has been
+// observed, in the order they were observed.
+func (f *streamFilter) add(event *pb.TapEvent) []*pb.TapEvent {
+	key := newStreamKey(event)
+	stream, ok := f.streams[key]
+	if !ok {
+		if oldest, evict := f.bound.track(key); evict {
+			delete(f.streams, oldest)
+		}
+		stream = &filteredStream{}
+		f.streams[key] = stream
+	}
+	if event.GetHttp().GetResponseEnd() != nil {
+		delete(f.streams, key)
+		f.bound.forget(key)
+	}
+
+	if stream.decided {
+		if stream.keep {
+			return []*pb.TapEvent{event}
+		}
+		return nil
+	}
+
+	stream.pending = append(stream.pending, event)
+	stream.keep, stream.decided = f.classify(stream.pending)
+	if !stream.decided {
+		return nil
+	}
+	events := stream.pending
+	stream.pending = nil
+	if !stream.keep {
+		return nil
+	}
+	return events
+}
+
+// allOf returns a classifier keeping the streams kept by all of the given
+// classifiers. A stream is dropped as soon as one of them drops it.
+func allOf(classifiers []streamClassifier) streamClassifier {
+	return func(events []*pb.TapEvent) (bool, bool) {
+		decided := true
+		for _, classify := range classifiers {
+			keep, ok := classify(events)
+			if ok && !keep {
+				return false, true
+			}
+			decided = decided && ok
+		}
+		return true, decided
+	}
+}
+
+// newStreamFilter returns the stream filter required by the tap options, or
+// nil if streams don't need to be filtered.
+func (o *tapOptions) newStreamFilter() *streamFilter {
+	var classifiers []streamClassifier
+	if o.grpcOnly {
+		classifiers = append(classifiers, classifyGRPC)
+	}
+	if o.httpOnly {
+		classifiers = append(classifiers, func(events []*pb.TapEvent) (bool, bool) {
+			isGRPC, known := classifyGRPC(events)
+			return !isGRPC, known
+		})
+	}
+	if o.schemeRE != nil {
+		classifiers = append(classifiers, classifyScheme(o.schemeRE))
+	}
+	if o.hasAuthority || o.noAuthority {
+		classifiers = append(classifiers, classifyAuthority(o.hasAuthority))
+	}
+	if o.onlyErrors {
+		classifiers = append(classifiers, classifyErrors)
+	}
+	if o.onlyReset {
+		classifiers = append(classifiers, classifyResets)
+	}
+	if o.successTally != nil {
+		classifiers = append(classifiers, o.successTally.classify)
+	}
+	if o.minLatency > 0 || o.maxLatency > 0 {
+		classifiers = append(classifiers, classifyLatency(o.minLatency, o.maxLatency))
+	}
+	if o.filterExpr != nil {
+		classifiers = append(classifiers, classifyFilter(o.filterExpr))
+	}
+
+	if len(classifiers) == 0 {
+		return nil
+	}
+	return newStreamFilter(allOf(classifiers), o.pairingBuffer)
+}
+
+// classifyGRPC tells whether the events belong to a gRPC stream. This is known
+// from the content type when headers are extracted, and otherwise from the
+// presence of a gRPC status when the response ends.
+func classifyGRPC(events []*pb.TapEvent) (isGRPC bool, known bool) {
+	for _, event := range events {
+		switch ev := event.GetHttp().GetEvent().(type) {
+		case *pb.TapEvent_Http_RequestInit_:
+			if hasGRPCContentType(ev.RequestInit.GetHeaders()) {
+				return true, true
+			}
+		case *pb.TapEvent_Http_ResponseInit_:
+			if hasGRPCContentType(ev.ResponseInit.GetHeaders()) {
+				return true, true
+			}
+		case *pb.TapEvent_Http_ResponseEnd_:
+			_, isGRPC := ev.ResponseEnd.GetEos().GetEnd().(*pb.Eos_GrpcStatusCode)
+			return isGRPC, true
+		}
+	}
+	return false, false
+}
+
+// classifyLatency returns a classifier keeping the streams whose response
+// latency is within [min, max], once the response starts. A max of 0 means no
+// upper bound. Streams whose response ends without having started, e.g. reset
+// ones, have no latency and are dropped.
+func classifyLatency(min, max time.Duration) streamClassifier {
+	return func(events []*pb.TapEvent) (bool, bool) {
+		for _, event := range events {
+			if rspI := event.GetHttp().GetResponseInit(); rspI != nil {
+				latency, err := ptypes.Duration(rspI.GetSinceRequestInit())
+				if err != nil {
+					return false, true
+				}
+				return latency >= min && (max == 0 || latency <= max), true
+			}
+		}
+		if events[len(events)-1].GetHttp().GetResponseEnd() != nil {
+			return false, true
+		}
+		return false, false
+	}
+}
+
+// classifyScheme returns a classifier keeping the streams whose lowercase
+// scheme matches re, as soon as their request is observed. Streams whose
+// request was missed are dropped.
+func classifyScheme(re *regexp.Regexp) streamClassifier {
+	return func(events []*pb.TapEvent) (bool, bool) {
+		reqI := events[0].GetHttp().GetRequestInit()
+		if reqI == nil {
+			return false, true
+		}
+		return re.MatchString(strings.ToLower(formatScheme(reqI.GetScheme()))), true
+	}
+}
+
+// classifyAuthority returns a classifier keeping the streams whose request has
+// an authority if present is set, or has none otherwise, as soon as their
+// request is observed. Streams whose request was missed are dropped.
+func classifyAuthority(present bool) streamClassifier {
+	return func(events []*pb.TapEvent) (bool, bool) {
+		reqI := events[0].GetHttp().GetRequestInit()
+		if reqI == nil {
+			return false, true
+		}
+		return (reqI.GetAuthority() != "") == present, true
+	}
+}
+
+// classifyErrors keeps the streams whose HTTP status is 400 or higher, whose
+// gRPC status isn't OK, or which were reset. Streams are classified once their
+// response ends, as a gRPC error or a reset may follow a successful status.
+func classifyErrors(events []*pb.TapEvent) (bool, bool) {
+	end := events[len(events)-1].GetHttp().GetResponseEnd()
+	if end == nil {
+		return false, false
+	}
+	switch eos := end.GetEos().GetEnd().(type) {
+	case *pb.Eos_GrpcStatusCode:
+		if codes.Code(eos.GrpcStatusCode) != codes.OK {
+			return true, true
+		}
+	case *pb.Eos_ResetErrorCode:
+		return true, true
+	}
+	for _, event := range events {
+		if rspI := event.GetHttp().GetResponseInit(); rspI != nil && rspI.GetHttpStatus() >= 400 {
+			return true, true
+		}
+	}
+	return false, true
+}
+
+// classifyResets keeps the streams which were reset, once their response
+// ends.
+func classifyResets(events []*pb.TapEvent) (bool, bool) {
+	end := events[len(events)-1].GetHttp().GetResponseEnd()
+	if end == nil {
+		return false, false
+	}
+	_, isReset := end.GetEos().GetEnd().(*pb.Eos_ResetErrorCode)
+	return isReset, true
+}
+
+func hasGRPCContentType(hs *pb.Headers) bool {
+	for _, h := range hs.GetHeaders() {
+		if strings.ToLower(h.GetName()) == "content-type" &&
+			strings.HasPrefix(h.GetValueStr(), "application/grpc") {
+			return true
+		}
+	}
+	return false
+}
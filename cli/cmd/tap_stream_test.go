@@ -0,0 +1,525 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"google.golang.org/grpc/codes"
+)
+
+// tapTestTransactions returns the events of a gRPC stream (1), a plain HTTP
+// stream (2), a gRPC stream identified by its content type (3) and a stream
+// whose response never ends (4).
+func tapTestTransactions() []*pb.TapEvent {
+	grpcOK := &pb.Eos{End: &pb.Eos_GrpcStatusCode{GrpcStatusCode: uint32(codes.OK)}}
+	latency := &duration.Duration{Nanos: 1000}
+
+	grpcRequest := tapTestRequest(3, pb.HttpMethod_POST, "/books.Books/List")
+	grpcRequest.GetHttp().GetRequestInit().Headers = &pb.Headers{
+		Headers: []*pb.Headers_Header{
+			{Name: "content-type", Value: &pb.Headers_Header_ValueStr{ValueStr: "application/grpc+proto"}},
+		},
+	}
+
+	return []*pb.TapEvent{
+		tapTestRequest(1, pb.HttpMethod_POST, "/books.Books/Get"),
+		tapTestRequest(2, pb.HttpMethod_GET, "/books"),
+		grpcRequest,
+		tapTestResponse(1, http.StatusOK, latency),
+		tapTestResponse(2, http.StatusOK, latency),
+		tapTestRequest(4, pb.HttpMethod_GET, "/authors"),
+		tapTestEnd(2, &pb.Eos{}, 10),
+		tapTestResponse(3, http.StatusOK, latency),
+		tapTestEnd(1, grpcOK, 10),
+		tapTestEnd(3, grpcOK, 10),
+	}
+}
+
+// renderedIDs returns the "req id=7:1"-like prefix of each rendered line.
+func renderedIDs(output string) []string {
+	ids := []string{}
+	for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		ids = append(ids, fields[0]+" "+fields[1])
+	}
+	return ids
+}
+
+func TestRenderTapEventsStreamFilters(t *testing.T) {
+	testCases := []struct {
+		name        string
+		setOptions  func(*tapOptions)
+		expectedIDs []string
+	}{
+		{
+			"--grpc-only",
+			func(o *tapOptions) { o.grpcOnly = true },
+			[]string{
+				"req id=7:3",
+				"rsp id=7:3",
+				// Stream 1 is held back until its gRPC status is known.
+				"req id=7:1", "rsp id=7:1", "end id=7:1",
+				"end id=7:3",
+			},
+		},
+		{
+			"--http-only",
+			func(o *tapOptions) { o.httpOnly = true },
+			// Stream 2 is held back until it is known not to be gRPC.
+			[]string{"req id=7:2", "rsp id=7:2", "end id=7:2"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(tc.name, func(t *testing.T) {
+			options := newTapOptions()
+			tc.setOptions(options)
+			ids := renderedIDs(renderTestTapEvents(t, options, tapTestTransactions()...))
+			if fmt.Sprint(ids) != fmt.Sprint(tc.expectedIDs) {
+				t.Fatalf("Expecting %v, got %v", tc.expectedIDs, ids)
+			}
+		})
+	}
+
+	t.Run("Rejects --grpc-only with --http-only", func(t *testing.T) {
+		options := newTapOptions()
+		options.grpcOnly = true
+		options.httpOnly = true
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+func TestRenderTapEventsLatencyFilters(t *testing.T) {
+	var events []*pb.TapEvent
+	latencies := []*duration.Duration{
+		{Nanos: 5000000},          // 5ms
+		{Nanos: 50000000},         // 50ms
+		{Seconds: 1, Nanos: 5000}, // 1.000005s
+		{Seconds: 3},
+	}
+	for i, latency := range latencies {
+		stream := uint64(i + 1)
+		events = append(events,
+			tapTestRequest(stream, pb.HttpMethod_GET, "/books"),
+			tapTestResponse(stream, http.StatusOK, latency),
+			tapTestEnd(stream, &pb.Eos{}, 0),
+		)
+	}
+
+	testCases := []struct {
+		minLatency  time.Duration
+		maxLatency  time.Duration
+		expectedIDs []string
+	}{
+		{0, 10 * time.Millisecond, []string{"req id=7:1", "rsp id=7:1", "end id=7:1"}},
+		{time.Second, 0, []string{
+			"req id=7:3", "rsp id=7:3", "end id=7:3",
+			"req id=7:4", "rsp id=7:4", "end id=7:4",
+		}},
+		{10 * time.Millisecond, 2 * time.Second, []string{
+			"req id=7:2", "rsp id=7:2", "end id=7:2",
+			"req id=7:3", "rsp id=7:3", "end id=7:3",
+		}},
+		{time.Second, time.Second, []string{}},
+		{3 * time.Second, 3 * time.Second, []string{"req id=7:4", "rsp id=7:4", "end id=7:4"}},
+	}
+
+	for i, tc := range testCases {
+		tc := tc // pin
+		t.Run(fmt.Sprintf("%d: --min-latency=%s --max-latency=%s", i, tc.minLatency, tc.maxLatency), func(t *testing.T) {
+			options := newTapOptions()
+			options.minLatency = tc.minLatency
+			options.maxLatency = tc.maxLatency
+			if err := options.validate(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			ids := renderedIDs(renderTestTapEvents(t, options, events...))
+			if fmt.Sprint(ids) != fmt.Sprint(tc.expectedIDs) {
+				t.Fatalf("Expecting %v, got %v", tc.expectedIDs, ids)
+			}
+		})
+	}
+
+	t.Run("Combines with filters deciding at the end of responses", func(t *testing.T) {
+		var events []*pb.TapEvent
+		for i, tc := range []struct {
+			status  uint32
+			latency *duration.Duration
+		}{
+			{http.StatusOK, &duration.Duration{Seconds: 2}},
+			{http.StatusServiceUnavailable, &duration.Duration{Seconds: 2}},
+			{http.StatusServiceUnavailable, &duration.Duration{Nanos: 5000000}},
+		} {
+			stream := uint64(i + 1)
+			events = append(events,
+				tapTestRequest(stream, pb.HttpMethod_GET, "/books"),
+				tapTestResponse(stream, tc.status, tc.latency),
+				tapTestEnd(stream, &pb.Eos{}, 0),
+			)
+		}
+		events = append(events,
+			// A reset stream without a response has no latency.
+			tapTestRequest(4, pb.HttpMethod_GET, "/books"),
+			tapTestEnd(4, &pb.Eos{End: &pb.Eos_ResetErrorCode{ResetErrorCode: 2}}, 0),
+		)
+
+		for _, setup := range []func(*tapOptions){
+			func(o *tapOptions) { o.onlyErrors = true },
+			func(o *tapOptions) { o.httpOnly = true },
+			func(o *tapOptions) { o.filter = `status =~ "^5"` },
+		} {
+			options := newTapOptions()
+			options.minLatency = time.Second
+			setup(options)
+			if err := options.validate(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			ids := renderedIDs(renderTestTapEvents(t, options, events...))
+			expectedIDs := []string{"req id=7:2", "rsp id=7:2", "end id=7:2"}
+			if options.httpOnly {
+				expectedIDs = []string{
+					"req id=7:1", "rsp id=7:1", "end id=7:1",
+					"req id=7:2", "rsp id=7:2", "end id=7:2",
+				}
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(expectedIDs) {
+				t.Fatalf("Expecting %v, got %v", expectedIDs, ids)
+			}
+		}
+	})
+
+	t.Run("Rejects a --max-latency lower than --min-latency", func(t *testing.T) {
+		options := newTapOptions()
+		options.minLatency = time.Second
+		options.maxLatency = time.Millisecond
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+func TestRenderTapEventsOnlyErrors(t *testing.T) {
+	latency := &duration.Duration{Nanos: 1000}
+	grpcEnd := func(code codes.Code) *pb.Eos {
+		return &pb.Eos{End: &pb.Eos_GrpcStatusCode{GrpcStatusCode: uint32(code)}}
+	}
+	transaction := func(stream uint64, status uint32, eos *pb.Eos) []*pb.TapEvent {
+		return []*pb.TapEvent{
+			tapTestRequest(stream, pb.HttpMethod_GET, "/books"),
+			tapTestResponse(stream, status, latency),
+			tapTestEnd(stream, eos, 0),
+		}
+	}
+
+	var events []*pb.TapEvent
+	events = append(events, transaction(1, http.StatusOK, &pb.Eos{})...)
+	events = append(events, transaction(2, http.StatusServiceUnavailable, &pb.Eos{})...)
+	events = append(events, transaction(3, http.StatusOK, grpcEnd(codes.Unavailable))...)
+	events = append(events, transaction(4, http.StatusOK, grpcEnd(codes.OK))...)
+	events = append(events, transaction(5, http.StatusNotFound, &pb.Eos{})...)
+	events = append(events,
+		// A reset stream may not have a response.
+		tapTestRequest(6, pb.HttpMethod_GET, "/books"),
+		tapTestEnd(6, &pb.Eos{End: &pb.Eos_ResetErrorCode{ResetErrorCode: 2}}, 0),
+	)
+	events = append(events, transaction(7, http.StatusFound, &pb.Eos{})...)
+
+	options := newTapOptions()
+	options.onlyErrors = true
+	ids := renderedIDs(renderTestTapEvents(t, options, events...))
+	expectedIDs := []string{
+		"req id=7:2", "rsp id=7:2", "end id=7:2",
+		"req id=7:3", "rsp id=7:3", "end id=7:3",
+		"req id=7:5", "rsp id=7:5", "end id=7:5",
+		"req id=7:6", "end id=7:6",
+	}
+	if fmt.Sprint(ids) != fmt.Sprint(expectedIDs) {
+		t.Fatalf("Expecting %v, got %v", expectedIDs, ids)
+	}
+}
+
+func TestRenderTapEventsOnlyReset(t *testing.T) {
+	latency := &duration.Duration{Nanos: 1000}
+	reset := &pb.Eos{End: &pb.Eos_ResetErrorCode{ResetErrorCode: 8}}
+	events := []*pb.TapEvent{
+		tapTestRequest(1, pb.HttpMethod_GET, "/books"),
+		tapTestRequest(2, pb.HttpMethod_GET, "/books"),
+		tapTestRequest(3, pb.HttpMethod_GET, "/books"),
+		tapTestRequest(4, pb.HttpMethod_GET, "/books"),
+		tapTestResponse(1, http.StatusOK, latency),
+		tapTestResponse(2, http.StatusOK, latency),
+		tapTestResponse(4, http.StatusServiceUnavailable, latency),
+		tapTestEnd(1, &pb.Eos{}, 0),
+		// Streams may be reset after their response starts, or before.
+		tapTestEnd(2, reset, 0),
+		tapTestEnd(3, reset, 0),
+		tapTestEnd(4, &pb.Eos{End: &pb.Eos_GrpcStatusCode{GrpcStatusCode: uint32(codes.Unavailable)}}, 0),
+	}
+
+	options := newTapOptions()
+	options.onlyReset = true
+	output := renderTestTapEvents(t, options, events...)
+	ids := renderedIDs(output)
+	expectedIDs := []string{"req id=7:2", "rsp id=7:2", "end id=7:2", "req id=7:3", "end id=7:3"}
+	if fmt.Sprint(ids) != fmt.Sprint(expectedIDs) {
+		t.Fatalf("Expecting %v, got %v", expectedIDs, ids)
+	}
+	if strings.Count(output, " reset-error=8 ") != 2 {
+		t.Fatalf("Expecting the reset error codes to be rendered, got [%s]", output)
+	}
+}
+
+func TestRenderTapEventsSchemeRegex(t *testing.T) {
+	latency := &duration.Duration{Nanos: 1000}
+	withScheme := func(stream uint64, scheme *pb.Scheme) []*pb.TapEvent {
+		req := tapTestRequest(stream, pb.HttpMethod_GET, "/books")
+		req.GetHttp().GetRequestInit().Scheme = scheme
+		return []*pb.TapEvent{
+			req,
+			tapTestResponse(stream, http.StatusOK, latency),
+			tapTestEnd(stream, &pb.Eos{}, 0),
+		}
+	}
+	var events []*pb.TapEvent
+	events = append(events, withScheme(1, &pb.Scheme{Type: &pb.Scheme_Registered_{Registered: pb.Scheme_HTTP}})...)
+	events = append(events, withScheme(2, &pb.Scheme{Type: &pb.Scheme_Registered_{Registered: pb.Scheme_HTTPS}})...)
+	events = append(events, withScheme(3, &pb.Scheme{Type: &pb.Scheme_Unregistered{Unregistered: "ws"}})...)
+	// The request of stream 4 was missed, so its scheme is unknown.
+	events = append(events,
+		tapTestResponse(4, http.StatusOK, latency),
+		tapTestEnd(4, &pb.Eos{}, 0),
+	)
+
+	testCases := []struct {
+		schemeRegex string
+		expectedIDs []string
+	}{
+		{"^https?$", []string{
+			"req id=7:1", "rsp id=7:1", "end id=7:1",
+			"req id=7:2", "rsp id=7:2", "end id=7:2",
+		}},
+		{"^https$", []string{"req id=7:2", "rsp id=7:2", "end id=7:2"}},
+		{"^http$", []string{"req id=7:1", "rsp id=7:1", "end id=7:1"}},
+		{"^ws", []string{"req id=7:3", "rsp id=7:3", "end id=7:3"}},
+	}
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(tc.schemeRegex, func(t *testing.T) {
+			options := newTapOptions()
+			options.schemeRegex = tc.schemeRegex
+			if err := options.validate(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			ids := renderedIDs(renderTestTapEvents(t, options, events...))
+			if fmt.Sprint(ids) != fmt.Sprint(tc.expectedIDs) {
+				t.Fatalf("Expecting %v, got %v", tc.expectedIDs, ids)
+			}
+		})
+	}
+
+	t.Run("Rejects an invalid pattern", func(t *testing.T) {
+		options := newTapOptions()
+		options.schemeRegex = "^(http"
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+func TestRenderTapEventsAuthority(t *testing.T) {
+	latency := &duration.Duration{Nanos: 1000}
+	withAuthority := func(stream uint64, authority string) []*pb.TapEvent {
+		req := tapTestRequest(stream, pb.HttpMethod_GET, "/books")
+		req.GetHttp().GetRequestInit().Authority = authority
+		return []*pb.TapEvent{
+			req,
+			tapTestResponse(stream, http.StatusOK, latency),
+			tapTestEnd(stream, &pb.Eos{}, 0),
+		}
+	}
+	var events []*pb.TapEvent
+	events = append(events, withAuthority(1, "books.default:7000")...)
+	events = append(events, withAuthority(2, "")...)
+	// The request of stream 3 was missed, so whether it has an authority is
+	// unknown.
+	events = append(events,
+		tapTestResponse(3, http.StatusOK, latency),
+		tapTestEnd(3, &pb.Eos{}, 0),
+	)
+
+	testCases := []struct {
+		name         string
+		hasAuthority bool
+		noAuthority  bool
+		expectedIDs  []string
+	}{
+		{"--has-authority", true, false, []string{"req id=7:1", "rsp id=7:1", "end id=7:1"}},
+		{"--no-authority", false, true, []string{"req id=7:2", "rsp id=7:2", "end id=7:2"}},
+	}
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(tc.name, func(t *testing.T) {
+			options := newTapOptions()
+			options.hasAuthority = tc.hasAuthority
+			options.noAuthority = tc.noAuthority
+			if err := options.validate(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			ids := renderedIDs(renderTestTapEvents(t, options, events...))
+			if fmt.Sprint(ids) != fmt.Sprint(tc.expectedIDs) {
+				t.Fatalf("Expecting %v, got %v", tc.expectedIDs, ids)
+			}
+		})
+	}
+
+	t.Run("Rejects --has-authority with --no-authority", func(t *testing.T) {
+		options := newTapOptions()
+		options.hasAuthority = true
+		options.noAuthority = true
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+func TestStreamFilterLimit(t *testing.T) {
+	grpcOK := &pb.Eos{End: &pb.Eos_GrpcStatusCode{GrpcStatusCode: uint32(codes.OK)}}
+	latency := &duration.Duration{Nanos: 1000}
+
+	// Streams 1 to 4 start before any of them ends, with a limit of 2.
+	var events []*pb.TapEvent
+	for stream := uint64(1); stream <= 4; stream++ {
+		events = append(events, tapTestRequest(stream, pb.HttpMethod_POST, "/books.Books/Get"))
+	}
+	for stream := uint64(1); stream <= 4; stream++ {
+		events = append(events,
+			tapTestResponse(stream, http.StatusOK, latency),
+			tapTestEnd(stream, grpcOK, 10),
+		)
+	}
+
+	filter := newStreamFilter(classifyGRPC, 2)
+	var ids []string
+	for i, event := range events {
+		for _, e := range filter.add(event) {
+			ids = append(ids, renderedIDs(renderTapEvent(e, "", newTapOptions()))...)
+		}
+		if len(filter.streams) > 2 || filter.bound.order.Len() != len(filter.streams) {
+			t.Fatalf("Event %d: expecting at most 2 tracked streams, got %d (%d in order)", i, len(filter.streams), filter.bound.order.Len())
+		}
+	}
+
+	// The requests of streams 1 and 2 were evicted by those of streams 3 and
+	// 4, and the request of stream 3 by the response of stream 1, which was
+	// tracked as a new stream.
+	expectedIDs := []string{
+		"rsp id=7:1", "end id=7:1",
+		"rsp id=7:2", "end id=7:2",
+		"rsp id=7:3", "end id=7:3",
+		"req id=7:4", "rsp id=7:4", "end id=7:4",
+	}
+	if fmt.Sprint(ids) != fmt.Sprint(expectedIDs) {
+		t.Fatalf("Expecting %v, got %v", expectedIDs, ids)
+	}
+	if filter.bound.evicted != 3 {
+		t.Fatalf("Expecting 3 evicted streams, got %d", filter.bound.evicted)
+	}
+
+	t.Run("Doesn't evict streams without a limit", func(t *testing.T) {
+		options := newTapOptions()
+		options.grpcOnly = true
+		options.pairingBuffer = 0
+		ids := renderedIDs(renderTestTapEvents(t, options, events...))
+		if len(ids) != len(events) {
+			t.Fatalf("Expecting %d events, got %v", len(events), ids)
+		}
+	})
+
+	t.Run("Rejects a negative --pairing-buffer", func(t *testing.T) {
+		options := newTapOptions()
+		options.pairingBuffer = -1
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+func TestPairingBufferBoundsStreams(t *testing.T) {
+	// Requests of 10 streams whose responses never end, with a limit of 3.
+	const limit = 3
+	var requests []*pb.TapEvent
+	for stream := uint64(1); stream <= 10; stream++ {
+		requests = append(requests, tapTestRequest(stream, pb.HttpMethod_GET, fmt.Sprintf("/books/%d", stream)))
+	}
+	now := time.Unix(0, 0)
+
+	dedup := newEventDeduper(dedupRingSize, dedupWindow, limit)
+	retries := newRetryCollapser(retryWindow, limit)
+	directions := newDirectionDeduper(directionsWindow, limit)
+	sorter := newLatencySorter(100, limit)
+	tui := newTUIBuffer(tuiBufferSize, limit)
+	har := newHarRecorder(limit)
+	testCases := []struct {
+		option string
+		add    func(event *pb.TapEvent, line string)
+		// tracked returns the number of streams in the map and its bound.
+		tracked func() (int, *streamBound)
+	}{
+		{
+			"--dedup",
+			func(event *pb.TapEvent, line string) { dedup.add(event, line, now) },
+			func() (int, *streamBound) { return len(dedup.requests), dedup.bound },
+		},
+		{
+			"--collapse-retries",
+			func(event *pb.TapEvent, line string) { retries.add(event, line, now) },
+			func() (int, *streamBound) { return len(retries.streams), retries.bound },
+		},
+		{
+			"--dedup-directions",
+			func(event *pb.TapEvent, line string) { directions.add(event, line, now) },
+			func() (int, *streamBound) { return len(directions.streams), directions.bound },
+		},
+		{
+			"--sort",
+			sorter.add,
+			func() (int, *streamBound) { return len(sorter.open), sorter.bound },
+		},
+		{
+			"-o tui",
+			tui.add,
+			func() (int, *streamBound) { return len(tui.streams), tui.bound },
+		},
+		{
+			"-o har",
+			func(event *pb.TapEvent, _ string) { har.add(event, now) },
+			func() (int, *streamBound) { return len(har.streams), har.bound },
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(fmt.Sprintf("Evicts the oldest streams with %s", tc.option), func(t *testing.T) {
+			for _, event := range requests {
+				tc.add(event, renderTapEvent(event, "", newTapOptions()))
+			}
+			tracked, bound := tc.tracked()
+			if tracked != limit || len(bound.elems) != limit {
+				t.Fatalf("Expecting %d tracked streams, got %d (%d bounded)", limit, tracked, len(bound.elems))
+			}
+			if bound.evicted != len(requests)-limit {
+				t.Fatalf("Expecting %d evicted streams, got %d", len(requests)-limit, bound.evicted)
+			}
+		})
+	}
+}
@@ -0,0 +1,569 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"github.com/linkerd/linkerd2/pkg/addr"
+	"github.com/linkerd/linkerd2/pkg/k8s"
+	"google.golang.org/grpc/codes"
+)
+
+// smallestSizeBucket is the upper bound of the first `--size-histogram`
+// bucket. Each following bucket is four times larger than the previous one.
+const smallestSizeBucket = 1024
+
+// tapSummary accumulates statistics about the events of a tap stream, which
+// are rendered once the stream ends.
+type tapSummary struct {
+	options *tapOptions
+
+	// The sections accumulated, which are all rendered by `--summary-json`.
+	sizeHistogram     bool
+	tlsSummary        bool
+	grpcStatusSummary bool
+
+	// transactions is only accumulated for `--summary-json`.
+	transactions transactionStats
+
+	// sizeBuckets counts response sizes; see sizeBucketBounds.
+	sizeBuckets []uint64
+
+	inboundTLS  tlsCoverage
+	outboundTLS tlsCoverage
+
+	// grpcClasses counts gRPC responses by status class; see grpcStatusClass.
+	grpcClasses map[string]uint64
+
+	// unmeshedPeers holds the peers of connections that aren't mTLS'd, for
+	// `--warn-unmeshed`; see unmeshedPeer.
+	unmeshedPeers map[string]struct{}
+
+	// dstRequests counts requests by destination, for `--dst-weight`; see
+	// workloadName.
+	dstRequests map[string]uint64
+
+	// edges holds the transactions between each pair of source and
+	// destination, for `--group-by-src-dst`.
+	edges map[srcDstEdge]*transactionStats
+
+	// bytes is accumulated for `--count-bytes-both-directions`.
+	bytes byteCounts
+}
+
+// byteCounts totals the bytes transferred in each direction. Request bytes
+// are only known from a content-length header, so the requests without one
+// are counted apart.
+type byteCounts struct {
+	request         uint64
+	response        uint64
+	knownRequests   uint64
+	unknownRequests uint64
+}
+
+// summaryLatencySamples bounds the number of latencies `--summary-json`
+// samples for the whole tap, and `--group-by-src-dst` for each pair of source
+// and destination.
+const summaryLatencySamples = 1000
+
+// srcDstEdge is a pair of source and destination workloads; see workloadName.
+type srcDstEdge struct {
+	src string
+	dst string
+}
+
+// workloadKinds lists the kinds of the workloads `--dst-weight` and
+// `--group-by-src-dst` identify peers by, in order of preference.
+var workloadKinds = []string{k8s.Deployment, k8s.StatefulSet, k8s.DaemonSet, k8s.ReplicationController, k8s.Job, k8s.Pod}
+
+// grpcStatusClasses lists the classes of `--grpc-status-summary` in the
+// order they are rendered.
+var grpcStatusClasses = []string{"ok", "retriable", "fatal"}
+
+// tlsCoverage counts the events observed in a proxy direction, and how many
+// of them were on mTLS connections.
+type tlsCoverage struct {
+	total uint64
+	tls   uint64
+}
+
+func newTapSummary(options *tapOptions) *tapSummary {
+	transactions := newTransactionStats()
+	transactions.latencySamples = summaryLatencySamples
+	return &tapSummary{
+		options:           options,
+		sizeHistogram:     options.sizeHistogram || options.summaryJSON,
+		tlsSummary:        options.tlsSummary || options.summaryJSON,
+		grpcStatusSummary: options.grpcStatusSummary || options.summaryJSON,
+		transactions:      transactions,
+		grpcClasses:       make(map[string]uint64),
+		unmeshedPeers:     make(map[string]struct{}),
+		dstRequests:       make(map[string]uint64),
+		edges:             make(map[srcDstEdge]*transactionStats),
+	}
+}
+
+// add accounts for an event that passed the display filters.
+func (s *tapSummary) add(event *pb.TapEvent) {
+	if s.options.summaryJSON {
+		s.transactions.add(event)
+	}
+	if s.options.warnUnmeshed {
+		if p, ok := unmeshedPeer(event); ok {
+			s.unmeshedPeers[p] = struct{}{}
+		}
+	}
+	if s.tlsSummary {
+		var coverage *tlsCoverage
+		switch event.GetProxyDirection() {
+		case pb.TapEvent_INBOUND:
+			coverage = &s.inboundTLS
+		case pb.TapEvent_OUTBOUND:
+			coverage = &s.outboundTLS
+		}
+		if coverage != nil {
+			coverage.total++
+			if tlsStatus(event) == "true" {
+				coverage.tls++
+			}
+		}
+	}
+
+	if s.options.dstWeight && event.GetHttp().GetRequestInit() != nil {
+		s.dstRequests[workloadName(dst(event))]++
+	}
+	if s.options.groupBySrcDst {
+		edge := srcDstEdge{src: workloadName(src(event)), dst: workloadName(dst(event))}
+		stats, ok := s.edges[edge]
+		if !ok {
+			transactions := newTransactionStats()
+			transactions.latencySamples = summaryLatencySamples
+			stats = &transactions
+			s.edges[edge] = stats
+		}
+		stats.add(event)
+	}
+
+	if s.options.countBytes {
+		if reqI := event.GetHttp().GetRequestInit(); reqI != nil {
+			if size, ok := requestBytes(reqI); ok {
+				s.bytes.request += size
+				s.bytes.knownRequests++
+			} else {
+				s.bytes.unknownRequests++
+			}
+		}
+	}
+
+	end := event.GetHttp().GetResponseEnd()
+	if end != nil && s.options.countBytes {
+		s.bytes.response += end.GetResponseBytes()
+	}
+	if end != nil && s.sizeHistogram {
+		i := sizeBucket(end.GetResponseBytes())
+		for len(s.sizeBuckets) <= i {
+			s.sizeBuckets = append(s.sizeBuckets, 0)
+		}
+		s.sizeBuckets[i]++
+	}
+	if end != nil && s.grpcStatusSummary {
+		if eos, ok := end.GetEos().GetEnd().(*pb.Eos_GrpcStatusCode); ok {
+			s.grpcClasses[grpcStatusClass(codes.Code(eos.GrpcStatusCode))]++
+		}
+	}
+}
+
+// write renders the summary sections enabled by the tap options, or all of
+// them as a JSON object with `--summary-json`.
+func (s *tapSummary) write(w io.Writer) error {
+	if s.options.summaryJSON {
+		return s.writeJSON(w)
+	}
+	if s.options.warnUnmeshed {
+		if err := s.writeUnmeshedPeers(w); err != nil {
+			return err
+		}
+	}
+	if s.options.sizeHistogram {
+		if err := s.writeSizeHistogram(w); err != nil {
+			return err
+		}
+	}
+	if s.options.tlsSummary {
+		if _, err := fmt.Fprintf(w, "\ninbound mTLS: %s, outbound mTLS: %s\n",
+			s.inboundTLS.percentage(), s.outboundTLS.percentage()); err != nil {
+			return err
+		}
+	}
+	if s.options.dstWeight {
+		if err := s.writeDstWeights(w); err != nil {
+			return err
+		}
+	}
+	if s.options.groupBySrcDst {
+		if err := s.writeEdges(w); err != nil {
+			return err
+		}
+	}
+	if s.options.countBytes {
+		if err := s.writeBytes(w); err != nil {
+			return err
+		}
+	}
+	if s.options.grpcStatusSummary {
+		return s.writeGRPCStatusClasses(w)
+	}
+	return nil
+}
+
+// tapSummaryJSON is the summary rendered by `--summary-json`.
+type tapSummaryJSON struct {
+	Requests uint64 `json:"requests"`
+	Errors   uint64 `json:"errors"`
+	// LatencyMs is omitted if no response was observed.
+	LatencyMs         *latencyPercentilesJSON    `json:"latencyMs,omitempty"`
+	SizeHistogram     []sizeBucketJSON           `json:"sizeHistogram"`
+	TLS               map[string]tlsCoverageJSON `json:"tls"`
+	GRPCStatusClasses map[string]uint64          `json:"grpcStatusClasses"`
+	// UnmeshedPeers is only rendered with `--warn-unmeshed`.
+	UnmeshedPeers []string `json:"unmeshedPeers,omitempty"`
+	// DstRequests counts requests by destination, and is only rendered with
+	// `--dst-weight`.
+	DstRequests map[string]uint64 `json:"dstRequests,omitempty"`
+	// Edges is only rendered with `--group-by-src-dst`.
+	Edges []srcDstEdgeJSON `json:"edges,omitempty"`
+	// Bytes is only rendered with `--count-bytes-both-directions`.
+	Bytes *byteCountsJSON `json:"bytes,omitempty"`
+}
+
+type byteCountsJSON struct {
+	// Request and Total are omitted if no request had a known size.
+	Request  *uint64 `json:"request,omitempty"`
+	Response uint64  `json:"response"`
+	Total    *uint64 `json:"total,omitempty"`
+	// UnknownRequests counts the requests without a known size, which
+	// Request and Total don't account for.
+	UnknownRequests uint64 `json:"unknownRequests"`
+}
+
+type latencyPercentilesJSON struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+}
+
+type sizeBucketJSON struct {
+	LowerBytes uint64 `json:"lowerBytes"`
+	UpperBytes uint64 `json:"upperBytes"`
+	Count      uint64 `json:"count"`
+}
+
+type srcDstEdgeJSON struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Requests    uint64 `json:"requests"`
+	Errors      uint64 `json:"errors"`
+	// LatencyMs is omitted if no response was observed.
+	LatencyMs *latencyPercentilesJSON `json:"latencyMs,omitempty"`
+}
+
+type tlsCoverageJSON struct {
+	Events uint64 `json:"events"`
+	TLS    uint64 `json:"tls"`
+}
+
+// toJSON returns the summary as rendered by `--summary-json`.
+func (s *tapSummary) toJSON() tapSummaryJSON {
+	summary := tapSummaryJSON{
+		Requests:      s.transactions.requests,
+		Errors:        s.transactions.errors,
+		SizeHistogram: []sizeBucketJSON{},
+		TLS: map[string]tlsCoverageJSON{
+			"inbound":  {Events: s.inboundTLS.total, TLS: s.inboundTLS.tls},
+			"outbound": {Events: s.outboundTLS.total, TLS: s.outboundTLS.tls},
+		},
+		GRPCStatusClasses: make(map[string]uint64),
+	}
+
+	summary.LatencyMs = latencyPercentilesMs(s.transactions.latencies)
+	for i, count := range s.sizeBuckets {
+		lower, upper := sizeBucketBounds(i)
+		summary.SizeHistogram = append(summary.SizeHistogram, sizeBucketJSON{lower, upper, count})
+	}
+	for _, class := range grpcStatusClasses {
+		summary.GRPCStatusClasses[class] = s.grpcClasses[class]
+	}
+	if s.options.warnUnmeshed {
+		summary.UnmeshedPeers = s.sortedUnmeshedPeers()
+	}
+	if s.options.dstWeight {
+		summary.DstRequests = s.dstRequests
+	}
+	if s.options.groupBySrcDst {
+		summary.Edges = []srcDstEdgeJSON{}
+		for _, edge := range s.sortedEdges() {
+			stats := s.edges[edge]
+			summary.Edges = append(summary.Edges, srcDstEdgeJSON{
+				Source:      edge.src,
+				Destination: edge.dst,
+				Requests:    stats.requests,
+				Errors:      stats.errors,
+				LatencyMs:   latencyPercentilesMs(stats.latencies),
+			})
+		}
+	}
+	if s.options.countBytes {
+		bytes := &byteCountsJSON{Response: s.bytes.response, UnknownRequests: s.bytes.unknownRequests}
+		if s.bytes.hasRequests() {
+			total := s.bytes.request + s.bytes.response
+			bytes.Request = &s.bytes.request
+			bytes.Total = &total
+		}
+		summary.Bytes = bytes
+	}
+	return summary
+}
+
+// latencyPercentilesMs returns the percentiles of the latencies, which are
+// sorted in place, in milliseconds, or nil if there are none.
+func latencyPercentilesMs(latencies []time.Duration) *latencyPercentilesJSON {
+	if len(latencies) == 0 {
+		return nil
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	ms := func(p int) float64 {
+		return float64(latencyPercentile(latencies, p)) / float64(time.Millisecond)
+	}
+	return &latencyPercentilesJSON{P50: ms(50), P95: ms(95), P99: ms(99)}
+}
+
+func (s *tapSummary) writeJSON(w io.Writer) error {
+	b, err := json.MarshalIndent(s.toJSON(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+// workloadName returns the workload a peer belongs to, or its pod or IP if
+// its workload is unknown.
+func workloadName(p peer) string {
+	for _, kind := range workloadKinds {
+		if name, ok := p.resourceName(kind); ok {
+			return name
+		}
+	}
+	return addr.PublicIPToString(p.address.GetIp())
+}
+
+// writeDstWeights renders the share of requests of each destination, from the
+// most requested one.
+func (s *tapSummary) writeDstWeights(w io.Writer) error {
+	var total uint64
+	destinations := []string{}
+	for dst, count := range s.dstRequests {
+		total += count
+		destinations = append(destinations, dst)
+	}
+	sort.Slice(destinations, func(i, j int) bool {
+		ci, cj := s.dstRequests[destinations[i]], s.dstRequests[destinations[j]]
+		if ci != cj {
+			return ci > cj
+		}
+		return destinations[i] < destinations[j]
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, padding, ' ', 0)
+	fmt.Fprintln(tw, "")
+	fmt.Fprintln(tw, "DESTINATION\tREQUESTS\tWEIGHT")
+	for _, dst := range destinations {
+		count := s.dstRequests[dst]
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", dst, count, formatPercentage(count, total))
+	}
+	return tw.Flush()
+}
+
+// writeEdges renders the requests, error rate and latency percentiles between
+// each pair of source and destination, by source then destination.
+func (s *tapSummary) writeEdges(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, padding, ' ', 0)
+	fmt.Fprintln(tw, "")
+	fmt.Fprintln(tw, "SOURCE\tDESTINATION\tREQUESTS\tERRORS\tP50\tP99")
+	for _, edge := range s.sortedEdges() {
+		stats := s.edges[edge]
+		p50, p99 := "-", "-"
+		if latencies := stats.latencies; len(latencies) > 0 {
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			p50 = durationString(latencyPercentile(latencies, 50), s.options.asciiOnly)
+			p99 = durationString(latencyPercentile(latencies, 99), s.options.asciiOnly)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n",
+			edge.src, edge.dst, stats.requests, formatPercentage(stats.errors, stats.requests), p50, p99)
+	}
+	return tw.Flush()
+}
+
+func (s *tapSummary) sortedEdges() []srcDstEdge {
+	edges := []srcDstEdge{}
+	for edge := range s.edges {
+		edges = append(edges, edge)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].src != edges[j].src {
+			return edges[i].src < edges[j].src
+		}
+		return edges[i].dst < edges[j].dst
+	})
+	return edges
+}
+
+// hasRequests tells whether request bytes can be reported, i.e. unless all
+// the requests observed had an unknown size.
+func (c byteCounts) hasRequests() bool {
+	return c.knownRequests > 0 || c.unknownRequests == 0
+}
+
+// writeBytes renders the request and response bytes and their sum, or only
+// the response bytes if no request had a known size.
+func (s *tapSummary) writeBytes(w io.Writer) error {
+	if !s.bytes.hasRequests() {
+		_, err := fmt.Fprintf(w, "\nresponse bytes: %d (request bytes unavailable: no content-length)\n", s.bytes.response)
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "\nrequest bytes: %d, response bytes: %d, total bytes: %d\n",
+		s.bytes.request, s.bytes.response, s.bytes.request+s.bytes.response); err != nil {
+		return err
+	}
+	if s.bytes.unknownRequests > 0 {
+		_, err := fmt.Fprintf(w, "(%d requests without a content-length aren't accounted for)\n", s.bytes.unknownRequests)
+		return err
+	}
+	return nil
+}
+
+func (s *tapSummary) writeUnmeshedPeers(w io.Writer) error {
+	peers := s.sortedUnmeshedPeers()
+	if _, err := fmt.Fprintf(w, "\n%d unmeshed peers\n", len(peers)); err != nil {
+		return err
+	}
+	for _, p := range peers {
+		if _, err := fmt.Fprintf(w, "  %s\n", p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *tapSummary) sortedUnmeshedPeers() []string {
+	peers := []string{}
+	for p := range s.unmeshedPeers {
+		peers = append(peers, p)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// percentage renders the share of events on mTLS connections, or "n/a" if no
+// events were observed.
+func (c tlsCoverage) percentage() string {
+	return formatPercentage(c.tls, c.total)
+}
+
+// formatPercentage renders n as a share of total, or "n/a" if total is 0.
+func formatPercentage(n, total uint64) string {
+	if total == 0 {
+		return "n/a"
+	}
+	pct := fmt.Sprintf("%.1f", 100*float64(n)/float64(total))
+	return strings.TrimSuffix(pct, ".0") + "%"
+}
+
+func (s *tapSummary) writeGRPCStatusClasses(w io.Writer) error {
+	var total uint64
+	for _, count := range s.grpcClasses {
+		total += count
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, padding, ' ', 0)
+	fmt.Fprintln(tw, "")
+	fmt.Fprintln(tw, "GRPC STATUS\tCOUNT\tPERCENT")
+	for _, class := range grpcStatusClasses {
+		count := s.grpcClasses[class]
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", class, count, formatPercentage(count, total))
+	}
+	return tw.Flush()
+}
+
+// grpcStatusClass tells whether a gRPC status is "ok", "retriable" when the
+// call may succeed if retried as is, or "fatal".
+func grpcStatusClass(code codes.Code) string {
+	switch code {
+	case codes.OK:
+		return "ok"
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return "retriable"
+	default:
+		return "fatal"
+	}
+}
+
+func (s *tapSummary) writeSizeHistogram(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, padding, ' ', 0)
+	fmt.Fprintln(tw, "")
+	fmt.Fprintln(tw, "RESPONSE SIZE\tCOUNT")
+	for i, count := range s.sizeBuckets {
+		lower, upper := sizeBucketBounds(i)
+		fmt.Fprintf(tw, "%s-%s\t%d\n", formatBytes(lower), formatBytes(upper), count)
+	}
+	return tw.Flush()
+}
+
+// sizeBucket returns the index of the histogram bucket for a response size.
+func sizeBucket(size uint64) int {
+	i := 0
+	for upper := uint64(smallestSizeBucket); size >= upper; upper *= 4 {
+		i++
+		if upper > math.MaxUint64/4 {
+			// The last bucket holds the sizes whose upper bound would
+			// overflow.
+			break
+		}
+	}
+	return i
+}
+
+// sizeBucketBounds returns the inclusive lower and exclusive upper bounds of
+// the i-th histogram bucket: 0-1KB, 1KB-4KB, 4KB-16KB, and so on. The upper
+// bound of the last bucket is math.MaxUint64, which it includes.
+func sizeBucketBounds(i int) (uint64, uint64) {
+	lower := uint64(0)
+	upper := uint64(smallestSizeBucket)
+	for ; i > 0; i-- {
+		lower = upper
+		if upper > math.MaxUint64/4 {
+			upper = math.MaxUint64
+		} else {
+			upper *= 4
+		}
+	}
+	return lower, upper
+}
+
+// formatBytes renders a byte count using the largest binary unit that
+// divides it evenly.
+func formatBytes(n uint64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	unit := 0
+	for n >= 1024 && n%1024 == 0 && unit < len(units)-1 {
+		n /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%d%s", n, units[unit])
+}
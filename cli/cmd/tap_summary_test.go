@@ -0,0 +1,567 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"google.golang.org/grpc/codes"
+)
+
+func TestSizeHistogram(t *testing.T) {
+	t.Run("Maps sizes to power of four buckets", func(t *testing.T) {
+		testCases := []struct {
+			size   uint64
+			bucket int
+		}{
+			{0, 0},
+			{1023, 0},
+			{1024, 1},
+			{4095, 1},
+			{4096, 2},
+			{16 * 1024, 3},
+			{1024 * 1024, 6},
+			{1<<62 - 1, 26},
+			{1 << 62, 27},
+			{math.MaxUint64, 27},
+		}
+		for _, tc := range testCases {
+			if bucket := sizeBucket(tc.size); bucket != tc.bucket {
+				t.Fatalf("Expecting size %d to be in bucket %d, got %d", tc.size, tc.bucket, bucket)
+			}
+		}
+	})
+
+	t.Run("Bounds the last bucket without overflowing", func(t *testing.T) {
+		if lower, upper := sizeBucketBounds(27); lower != 1<<62 || upper != math.MaxUint64 {
+			t.Fatalf("Expecting the last bucket to span %d-%d, got %d-%d", uint64(1<<62), uint64(math.MaxUint64), lower, upper)
+		}
+	})
+
+	t.Run("Prints bucket counts once the stream ends", func(t *testing.T) {
+		var events []*pb.TapEvent
+		for i, size := range []uint64{0, 10, 1023, 2048, 3000, 20000} {
+			stream := uint64(i)
+			events = append(events,
+				tapTestRequest(stream, pb.HttpMethod_GET, "/books"),
+				tapTestResponse(stream, http.StatusOK, &duration.Duration{Nanos: 1000}),
+				tapTestEnd(stream, &pb.Eos{}, size),
+			)
+		}
+
+		options := newTapOptions()
+		options.sizeHistogram = true
+		output := renderTestTapEvents(t, options, events...)
+
+		expectedHistogram := `
+RESPONSE SIZE   COUNT
+0B-1KB          3
+1KB-4KB         2
+4KB-16KB        0
+16KB-64KB       1
+`
+		if !strings.HasSuffix(output, expectedHistogram) {
+			t.Fatalf("Expecting output to end with [%s], got [%s]", expectedHistogram, output)
+		}
+	})
+
+	t.Run("Prints nothing without --size-histogram", func(t *testing.T) {
+		output := renderTestTapEvents(t, newTapOptions(), tapTestEnd(1, &pb.Eos{}, 10))
+		if strings.Contains(output, "RESPONSE SIZE") {
+			t.Fatalf("Expecting no histogram, got [%s]", output)
+		}
+	})
+}
+
+func TestTLSSummary(t *testing.T) {
+	withTLS := func(direction pb.TapEvent_ProxyDirection, tls string) *pb.TapEvent {
+		event := tapTestRequest(1, pb.HttpMethod_GET, "/books")
+		event.ProxyDirection = direction
+		meta := &pb.TapEvent_EndpointMeta{Labels: map[string]string{"tls": tls}}
+		// Only the meshed end of the connection is relevant to each direction.
+		if direction == pb.TapEvent_INBOUND {
+			event.SourceMeta = meta
+		} else {
+			event.DestinationMeta = meta
+		}
+		return event
+	}
+
+	testCases := []struct {
+		events   []*pb.TapEvent
+		expected string
+	}{
+		{
+			[]*pb.TapEvent{
+				withTLS(pb.TapEvent_INBOUND, "true"),
+				withTLS(pb.TapEvent_INBOUND, "true"),
+				withTLS(pb.TapEvent_INBOUND, "no_identity"),
+				withTLS(pb.TapEvent_OUTBOUND, "true"),
+				withTLS(pb.TapEvent_OUTBOUND, "true"),
+			},
+			"inbound mTLS: 66.7%, outbound mTLS: 100%",
+		},
+		{
+			[]*pb.TapEvent{
+				withTLS(pb.TapEvent_INBOUND, "true"),
+				withTLS(pb.TapEvent_INBOUND, "not_provided_by_remote"),
+				withTLS(pb.TapEvent_OUTBOUND, ""),
+				// Events without a direction are not accounted for.
+				withTLS(pb.TapEvent_UNKNOWN, "true"),
+			},
+			"inbound mTLS: 50%, outbound mTLS: 0%",
+		},
+		{
+			[]*pb.TapEvent{
+				withTLS(pb.TapEvent_OUTBOUND, "true"),
+			},
+			"inbound mTLS: n/a, outbound mTLS: 100%",
+		},
+	}
+
+	for i, tc := range testCases {
+		tc := tc // pin
+		t.Run(fmt.Sprintf("%d: %s", i, tc.expected), func(t *testing.T) {
+			options := newTapOptions()
+			options.tlsSummary = true
+			output := renderTestTapEvents(t, options, tc.events...)
+			if !strings.HasSuffix(output, "\n\n"+tc.expected+"\n") {
+				t.Fatalf("Expecting output to end with [%s], got [%s]", tc.expected, output)
+			}
+		})
+	}
+
+	t.Run("Prints nothing without --tls-summary", func(t *testing.T) {
+		output := renderTestTapEvents(t, newTapOptions(), withTLS(pb.TapEvent_INBOUND, "true"))
+		if strings.Contains(output, "mTLS") {
+			t.Fatalf("Expecting no TLS summary, got [%s]", output)
+		}
+	})
+}
+
+func TestGRPCStatusSummary(t *testing.T) {
+	grpcEnd := func(stream uint64, code codes.Code) *pb.TapEvent {
+		return tapTestEnd(stream, &pb.Eos{End: &pb.Eos_GrpcStatusCode{GrpcStatusCode: uint32(code)}}, 10)
+	}
+
+	options := newTapOptions()
+	options.grpcStatusSummary = true
+	output := renderTestTapEvents(t, options,
+		grpcEnd(1, codes.OK),
+		grpcEnd(2, codes.OK),
+		grpcEnd(3, codes.Unavailable),
+		grpcEnd(4, codes.DeadlineExceeded),
+		grpcEnd(5, codes.InvalidArgument),
+		grpcEnd(6, codes.OK),
+		grpcEnd(7, codes.PermissionDenied),
+		grpcEnd(8, codes.OK),
+		// Non-gRPC responses are not accounted for.
+		tapTestEnd(9, &pb.Eos{}, 10),
+	)
+
+	expected := `
+GRPC STATUS   COUNT   PERCENT
+ok            4       50%
+retriable     2       25%
+fatal         2       25%
+`
+	if !strings.HasSuffix(output, expected) {
+		t.Fatalf("Expecting output to end with [%s], got [%s]", expected, output)
+	}
+
+	t.Run("Prints n/a without gRPC responses", func(t *testing.T) {
+		output := renderTestTapEvents(t, options, tapTestEnd(1, &pb.Eos{}, 10))
+		if !strings.Contains(output, "ok            0       n/a\n") {
+			t.Fatalf("Expecting n/a percentages, got [%s]", output)
+		}
+	})
+
+	t.Run("Prints nothing without --grpc-status-summary", func(t *testing.T) {
+		output := renderTestTapEvents(t, newTapOptions(), grpcEnd(1, codes.OK))
+		if strings.Contains(output, "GRPC STATUS") {
+			t.Fatalf("Expecting no gRPC status summary, got [%s]", output)
+		}
+	})
+}
+
+func TestSummaryJSON(t *testing.T) {
+	grpcEos := func(code codes.Code) *pb.Eos {
+		return &pb.Eos{End: &pb.Eos_GrpcStatusCode{GrpcStatusCode: uint32(code)}}
+	}
+	var events []*pb.TapEvent
+	for i, tc := range []struct {
+		status  uint32
+		latency time.Duration
+		eos     *pb.Eos
+		size    uint64
+	}{
+		{http.StatusOK, 10 * time.Millisecond, &pb.Eos{}, 10},
+		{http.StatusServiceUnavailable, 20 * time.Millisecond, &pb.Eos{}, 20},
+		{http.StatusOK, 30 * time.Millisecond, grpcEos(codes.Unavailable), 2000},
+		{http.StatusOK, 40 * time.Millisecond, grpcEos(codes.OK), 5000},
+	} {
+		stream := uint64(i + 1)
+		events = append(events,
+			tapTestRequest(stream, pb.HttpMethod_GET, "/books"),
+			tapTestResponse(stream, tc.status, &duration.Duration{Nanos: int32(tc.latency)}),
+			tapTestEnd(stream, tc.eos, tc.size),
+		)
+	}
+
+	options := newTapOptions()
+	options.summaryJSON = true
+	output := renderTestTapEvents(t, options, events...)
+	start := strings.Index(output, "\n{\n")
+	if start < 0 {
+		t.Fatalf("Expecting output to end with a JSON object, got [%s]", output)
+	}
+	raw := []byte(output[start+1:])
+
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		t.Fatalf("Expecting a JSON object, got [%s]: %v", raw, err)
+	}
+	for _, field := range []string{"requests", "errors", "latencyMs", "sizeHistogram", "tls", "grpcStatusClasses"} {
+		if _, ok := fields[field]; !ok {
+			t.Fatalf("Expecting a %q field, got [%s]", field, raw)
+		}
+	}
+
+	var summary tapSummaryJSON
+	if err := json.Unmarshal(raw, &summary); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := tapSummaryJSON{
+		Requests:  4,
+		Errors:    2,
+		LatencyMs: &latencyPercentilesJSON{P50: 20, P95: 40, P99: 40},
+		SizeHistogram: []sizeBucketJSON{
+			{LowerBytes: 0, UpperBytes: 1024, Count: 2},
+			{LowerBytes: 1024, UpperBytes: 4096, Count: 1},
+			{LowerBytes: 4096, UpperBytes: 16384, Count: 1},
+		},
+		TLS: map[string]tlsCoverageJSON{
+			"inbound":  {Events: 0, TLS: 0},
+			"outbound": {Events: 12, TLS: 0},
+		},
+		GRPCStatusClasses: map[string]uint64{"ok": 1, "retriable": 1, "fatal": 0},
+	}
+	if !reflect.DeepEqual(summary, expected) {
+		t.Fatalf("Expecting %+v, got %+v", expected, summary)
+	}
+
+	t.Run("Omits latencies without responses", func(t *testing.T) {
+		output := renderTestTapEvents(t, options, tapTestRequest(1, pb.HttpMethod_GET, "/books"))
+		if strings.Contains(output, "latencyMs") || !strings.Contains(output, `"requests": 0`) {
+			t.Fatalf("Expecting an empty summary without latencies, got [%s]", output)
+		}
+	})
+
+	t.Run("Samples a bounded number of latencies", func(t *testing.T) {
+		summary := newTapSummary(options)
+		for stream := uint64(1); stream <= 2*summaryLatencySamples; stream++ {
+			summary.add(tapTestResponse(stream, http.StatusOK, &duration.Duration{Nanos: 1000}))
+		}
+		if len(summary.transactions.latencies) != summaryLatencySamples {
+			t.Fatalf("Expecting %d latencies, got %d", summaryLatencySamples, len(summary.transactions.latencies))
+		}
+		if summary.transactions.observedLatencies != 2*summaryLatencySamples {
+			t.Fatalf("Expecting %d observed latencies, got %d", 2*summaryLatencySamples, summary.transactions.observedLatencies)
+		}
+	})
+
+	t.Run("Rejects --summary-json with HAR output", func(t *testing.T) {
+		options := newTapOptions()
+		options.summaryJSON = true
+		options.output = harOutput
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+func TestWarnUnmeshed(t *testing.T) {
+	withPeer := func(stream uint64, direction pb.TapEvent_ProxyDirection, labels map[string]string) *pb.TapEvent {
+		event := tapTestRequest(stream, pb.HttpMethod_GET, "/books")
+		event.ProxyDirection = direction
+		meta := &pb.TapEvent_EndpointMeta{Labels: labels}
+		if direction == pb.TapEvent_INBOUND {
+			event.SourceMeta = meta
+		} else {
+			event.DestinationMeta = meta
+		}
+		return event
+	}
+
+	options := newTapOptions()
+	options.warnUnmeshed = true
+	if err := options.validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	output := renderTestTapEvents(t, options,
+		withPeer(1, pb.TapEvent_OUTBOUND, map[string]string{"tls": "true", "pod": "books-1", "namespace": "default"}),
+		// The destination isn't meshed, so its pod is unknown.
+		withPeer(2, pb.TapEvent_OUTBOUND, map[string]string{}),
+		withPeer(3, pb.TapEvent_INBOUND, map[string]string{"tls": "no_identity", "pod": "web-1", "namespace": "default"}),
+		withPeer(4, pb.TapEvent_INBOUND, map[string]string{"tls": "no_identity", "pod": "web-1", "namespace": "default"}),
+	)
+
+	lines := strings.Split(output, "\n")
+	for i, expected := range []bool{false, true, true, true} {
+		if strings.HasSuffix(lines[i], " UNMESHED") != expected {
+			t.Fatalf("Expecting line %d to be marked UNMESHED: %t, got [%s]", i, expected, output)
+		}
+	}
+	expectedPeers := `
+2 unmeshed peers
+  2.3.4.5
+  web-1/default
+`
+	if !strings.HasSuffix(output, expectedPeers) {
+		t.Fatalf("Expecting output to end with [%s], got [%s]", expectedPeers, output)
+	}
+
+	t.Run("Rejects --warn-unmeshed with JSON output", func(t *testing.T) {
+		options := newTapOptions()
+		options.warnUnmeshed = true
+		options.output = jsonOutput
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+func TestDstWeight(t *testing.T) {
+	toDst := func(stream uint64, labels map[string]string) *pb.TapEvent {
+		event := tapTestRequest(stream, pb.HttpMethod_GET, "/books")
+		event.DestinationMeta = &pb.TapEvent_EndpointMeta{Labels: labels}
+		return event
+	}
+	v1 := map[string]string{"deployment": "books-v1", "pod": "books-v1-abc", "namespace": "default"}
+	v2 := map[string]string{"deployment": "books-v2", "pod": "books-v2-def", "namespace": "default"}
+
+	var events []*pb.TapEvent
+	for stream := uint64(1); stream <= 10; stream++ {
+		switch {
+		case stream <= 6:
+			events = append(events, toDst(stream, v1))
+		case stream <= 9:
+			events = append(events, toDst(stream, v2))
+		default:
+			// The destination's workload is unknown.
+			events = append(events, toDst(stream, map[string]string{}))
+		}
+		// Only requests are accounted for.
+		events = append(events, tapTestEnd(stream, &pb.Eos{}, 10))
+	}
+
+	options := newTapOptions()
+	options.dstWeight = true
+	if err := options.validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	output := renderTestTapEvents(t, options, events...)
+
+	expected := `
+DESTINATION       REQUESTS   WEIGHT
+deploy/books-v1   6          60%
+deploy/books-v2   3          30%
+2.3.4.5           1          10%
+`
+	if !strings.HasSuffix(output, expected) {
+		t.Fatalf("Expecting output to end with [%s], got [%s]", expected, output)
+	}
+
+	t.Run("Rejects --dst-weight with SSE output", func(t *testing.T) {
+		options := newTapOptions()
+		options.dstWeight = true
+		options.output = sseOutput
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+func TestGroupBySrcDst(t *testing.T) {
+	web := map[string]string{"deployment": "web", "namespace": "default"}
+	books := map[string]string{"deployment": "books", "namespace": "default"}
+	authors := map[string]string{"deployment": "authors", "namespace": "default"}
+	transaction := func(stream uint64, src, dst map[string]string, status uint32, latencyMs int32) []*pb.TapEvent {
+		events := []*pb.TapEvent{
+			tapTestRequest(stream, pb.HttpMethod_GET, "/books"),
+			tapTestResponse(stream, status, &duration.Duration{Nanos: latencyMs * int32(time.Millisecond)}),
+			tapTestEnd(stream, &pb.Eos{}, 10),
+		}
+		for _, event := range events {
+			event.SourceMeta = &pb.TapEvent_EndpointMeta{Labels: src}
+			event.DestinationMeta = &pb.TapEvent_EndpointMeta{Labels: dst}
+		}
+		return events
+	}
+
+	var events []*pb.TapEvent
+	events = append(events, transaction(1, web, books, http.StatusOK, 10)...)
+	events = append(events, transaction(2, web, books, http.StatusOK, 30)...)
+	events = append(events, transaction(3, web, books, http.StatusInternalServerError, 20)...)
+	events = append(events, transaction(4, web, authors, http.StatusOK, 5)...)
+	events = append(events, transaction(5, books, authors, http.StatusOK, 100)...)
+	events = append(events, transaction(6, books, authors, http.StatusServiceUnavailable, 200)...)
+	// The request of an unfinished transaction is accounted for once its
+	// response ends.
+	events = append(events, transaction(7, books, authors, http.StatusOK, 300)[0])
+
+	options := newTapOptions()
+	options.groupBySrcDst = true
+	if err := options.validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	output := renderTestTapEvents(t, options, events...)
+
+	expected := `
+SOURCE         DESTINATION      REQUESTS   ERRORS   P50     P99
+deploy/books   deploy/authors   2          50%      100ms   200ms
+deploy/web     deploy/authors   1          0%       5ms     5ms
+deploy/web     deploy/books     3          33.3%    20ms    30ms
+`
+	if !strings.HasSuffix(output, expected) {
+		t.Fatalf("Expecting output to end with [%s], got [%s]", expected, output)
+	}
+
+	t.Run("Renders edges in --summary-json", func(t *testing.T) {
+		options := newTapOptions()
+		options.groupBySrcDst = true
+		options.summaryJSON = true
+		if err := options.validate(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		summary := newTapSummary(options)
+		for _, event := range events {
+			summary.add(event)
+		}
+		edges := summary.toJSON().Edges
+		expected := []srcDstEdgeJSON{
+			{"deploy/books", "deploy/authors", 2, 1, &latencyPercentilesJSON{100, 200, 200}},
+			{"deploy/web", "deploy/authors", 1, 0, &latencyPercentilesJSON{5, 5, 5}},
+			{"deploy/web", "deploy/books", 3, 1, &latencyPercentilesJSON{20, 30, 30}},
+		}
+		if !reflect.DeepEqual(edges, expected) {
+			t.Fatalf("Expecting edges %+v, got %+v", expected, edges)
+		}
+	})
+
+	t.Run("Samples a bounded number of latencies", func(t *testing.T) {
+		options := newTapOptions()
+		options.groupBySrcDst = true
+		summary := newTapSummary(options)
+		for stream := uint64(1); stream <= 2*summaryLatencySamples; stream++ {
+			for _, event := range transaction(stream, web, books, http.StatusOK, 10)[:2] {
+				summary.add(event)
+			}
+		}
+		stats := summary.edges[srcDstEdge{"deploy/web", "deploy/books"}]
+		if len(stats.latencies) != summaryLatencySamples {
+			t.Fatalf("Expecting %d latencies, got %d", summaryLatencySamples, len(stats.latencies))
+		}
+		if stats.observedLatencies != 2*summaryLatencySamples {
+			t.Fatalf("Expecting %d observed latencies, got %d", 2*summaryLatencySamples, stats.observedLatencies)
+		}
+	})
+
+	t.Run("Rejects --group-by-src-dst with HAR output", func(t *testing.T) {
+		options := newTapOptions()
+		options.groupBySrcDst = true
+		options.output = harOutput
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+func TestCountBytes(t *testing.T) {
+	withContentLength := func(stream uint64, value string) *pb.TapEvent {
+		event := tapTestRequest(stream, pb.HttpMethod_POST, "/books")
+		event.GetHttp().GetRequestInit().Headers = &pb.Headers{
+			Headers: []*pb.Headers_Header{
+				{Name: "Content-Length", Value: &pb.Headers_Header_ValueStr{ValueStr: value}},
+			},
+		}
+		return event
+	}
+
+	options := newTapOptions()
+	options.countBytes = true
+	if err := options.validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	t.Run("Renders request and response bytes and their sum", func(t *testing.T) {
+		output := renderTestTapEvents(t, options,
+			withContentLength(1, "100"), tapTestEnd(1, &pb.Eos{}, 1000),
+			withContentLength(2, "50"), tapTestEnd(2, &pb.Eos{}, 2000),
+		)
+		expected := "\nrequest bytes: 150, response bytes: 3000, total bytes: 3150\n"
+		if !strings.HasSuffix(output, expected) {
+			t.Fatalf("Expecting output to end with [%s], got [%s]", expected, output)
+		}
+	})
+
+	t.Run("Notes requests without a content-length", func(t *testing.T) {
+		output := renderTestTapEvents(t, options,
+			withContentLength(1, "100"), tapTestEnd(1, &pb.Eos{}, 1000),
+			tapTestRequest(2, pb.HttpMethod_GET, "/books"), tapTestEnd(2, &pb.Eos{}, 2000),
+		)
+		expected := "\nrequest bytes: 100, response bytes: 3000, total bytes: 3100\n" +
+			"(1 requests without a content-length aren't accounted for)\n"
+		if !strings.HasSuffix(output, expected) {
+			t.Fatalf("Expecting output to end with [%s], got [%s]", expected, output)
+		}
+	})
+
+	t.Run("Only renders response bytes without request sizes", func(t *testing.T) {
+		output := renderTestTapEvents(t, options,
+			tapTestRequest(1, pb.HttpMethod_GET, "/books"), tapTestEnd(1, &pb.Eos{}, 1000),
+		)
+		expected := "\nresponse bytes: 1000 (request bytes unavailable: no content-length)\n"
+		if !strings.HasSuffix(output, expected) {
+			t.Fatalf("Expecting output to end with [%s], got [%s]", expected, output)
+		}
+	})
+
+	t.Run("Renders bytes in --summary-json", func(t *testing.T) {
+		options := newTapOptions()
+		options.countBytes = true
+		options.summaryJSON = true
+		output := renderTestTapEvents(t, options,
+			withContentLength(1, "100"), tapTestEnd(1, &pb.Eos{}, 1000),
+		)
+		start := strings.Index(output, "\n{\n")
+		if start < 0 {
+			t.Fatalf("Expecting output to end with a JSON object, got [%s]", output)
+		}
+		var summary tapSummaryJSON
+		if err := json.Unmarshal([]byte(output[start+1:]), &summary); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		request, total := uint64(100), uint64(1100)
+		expected := &byteCountsJSON{Request: &request, Response: 1000, Total: &total}
+		if !reflect.DeepEqual(summary.Bytes, expected) {
+			t.Fatalf("Expecting %+v, got %+v", expected, summary.Bytes)
+		}
+	})
+
+	t.Run("Rejects --count-bytes-both-directions with HAR output", func(t *testing.T) {
+		options := newTapOptions()
+		options.countBytes = true
+		options.output = harOutput
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
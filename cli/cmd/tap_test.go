@@ -1,11 +1,19 @@
 package cmd
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/protobuf/ptypes/duration"
 	"github.com/linkerd/linkerd2/controller/api/util"
@@ -88,10 +96,10 @@ func busyTest(t *testing.T, output string) {
 						End: &pb.Eos_GrpcStatusCode{GrpcStatusCode: 666},
 					},
 					SinceRequestInit: &duration.Duration{
-						Seconds: 10,
+						Seconds: 100,
 					},
 					SinceResponseInit: &duration.Duration{
-						Seconds: 100,
+						Seconds: 10,
 					},
 					ResponseBytes: 1337,
 					Trailers: &pb.Headers{
@@ -132,7 +140,7 @@ func busyTest(t *testing.T, output string) {
 	options.output = output
 
 	writer := bytes.NewBufferString("")
-	err = requestTapByResourceFromAPI(writer, kubeAPI, req, options)
+	err = requestTapByResourceFromAPI(context.Background(), writer, kubeAPI, req, options)
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -141,6 +149,8 @@ func busyTest(t *testing.T, output string) {
 	switch options.output {
 	case wideOutput:
 		goldenFilePath = "testdata/tap_busy_output_wide.golden"
+	case widePlusOutput:
+		goldenFilePath = "testdata/tap_busy_output_wide_plus.golden"
 	case jsonOutput:
 		goldenFilePath = "testdata/tap_busy_output_json.golden"
 	default:
@@ -167,6 +177,10 @@ func TestRequestTapByResourceFromAPI(t *testing.T) {
 		busyTest(t, "wide")
 	})
 
+	t.Run("Should render wide-plus busy response if everything went well", func(t *testing.T) {
+		busyTest(t, "wide-plus")
+	})
+
 	t.Run("Should render JSON busy response if everything went well", func(t *testing.T) {
 		busyTest(t, "json")
 	})
@@ -198,7 +212,7 @@ func TestRequestTapByResourceFromAPI(t *testing.T) {
 
 		options := newTapOptions()
 		writer := bytes.NewBufferString("")
-		err = requestTapByResourceFromAPI(writer, kubeAPI, req, options)
+		err = requestTapByResourceFromAPI(context.Background(), writer, kubeAPI, req, options)
 		if err != nil {
 			t.Fatalf("Unexpected error: %v", err)
 		}
@@ -237,13 +251,119 @@ func TestRequestTapByResourceFromAPI(t *testing.T) {
 
 		options := newTapOptions()
 		writer := bytes.NewBufferString("")
-		err = requestTapByResourceFromAPI(writer, kubeAPI, req, options)
+		err = requestTapByResourceFromAPI(context.Background(), writer, kubeAPI, req, options)
 		if err == nil {
 			t.Fatalf("Expecting error, got nothing but output [%s]", writer.String())
 		}
 	})
 }
 
+func TestRequestTapByResourceFromAddress(t *testing.T) {
+	req, err := util.BuildTapByResourceRequest(util.TapRequestParams{Resource: "deploy/web", Namespace: "default"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != protohttp.TapReqToURL(req) {
+				http.NotFound(w, r)
+				return
+			}
+			for _, event := range []*pb.TapEvent{
+				tapTestRequest(1, pb.HttpMethod_GET, "/books"),
+				tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 1000}),
+				tapTestEnd(1, &pb.Eos{}, 10),
+			} {
+				if err := protohttp.WriteProtoToHTTPResponse(w, event); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			}
+		}),
+	)
+	defer ts.Close()
+
+	for _, address := range []string{strings.TrimPrefix(ts.URL, "http://"), ts.URL} {
+		address := address // pin
+		t.Run(address, func(t *testing.T) {
+			options := newTapOptions()
+			options.address = address
+			if err := options.validate(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			writer := bytes.NewBufferString("")
+			err := requestTapByResourceFromAddress(context.Background(), writer, address, req, options)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			ids := renderedIDs(writer.String())
+			expectedIDs := []string{"req id=7:1", "rsp id=7:1", "end id=7:1"}
+			if fmt.Sprint(ids) != fmt.Sprint(expectedIDs) {
+				t.Fatalf("Expecting %v, got %v", expectedIDs, ids)
+			}
+		})
+	}
+
+	t.Run("Rejects --address with a kubeconfig context", func(t *testing.T) {
+		kubeContext = "prod"
+		defer func() { kubeContext = "" }()
+		options := newTapOptions()
+		options.address = "localhost:8001"
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+
+	t.Run("Rejects --address with --resolve-ports", func(t *testing.T) {
+		options := newTapOptions()
+		options.address = "localhost:8001"
+		options.resolvePorts = true
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+func TestPrintTapRequest(t *testing.T) {
+	req, err := util.BuildTapByResourceRequest(util.TapRequestParams{
+		Resource:    "deploy/web",
+		Namespace:   "default",
+		ToResource:  "deploy/books",
+		ToNamespace: "prod",
+		MaxRps:      10,
+		Method:      "POST",
+		Path:        "/books",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := printTapRequest(&out, req); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(out.Bytes(), &fields); err != nil {
+		t.Fatalf("Expecting a JSON document, got [%s]: %v", out.String(), err)
+	}
+	target := fields["target"].(map[string]interface{})["resource"].(map[string]interface{})
+	if target["namespace"] != "default" || target["type"] != "deployment" || target["name"] != "web" {
+		t.Fatalf("Expecting deploy/web in the default namespace as target, got %v", target)
+	}
+	if fields["maxRps"] != float64(10) {
+		t.Fatalf("Expecting maxRps to be 10, got %v", fields["maxRps"])
+	}
+	match, err := json.Marshal(fields["match"])
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, expected := range []string{`"name":"books"`, `"namespace":"prod"`, `"POST"`, `"/books"`} {
+		if !strings.Contains(string(match), expected) {
+			t.Fatalf("Expecting the match to contain %s, got %s", expected, match)
+		}
+	}
+}
+
 func TestEventToString(t *testing.T) {
 	toTapEvent := func(httpEvent *pb.TapEvent_Http) *pb.TapEvent {
 		streamID := &pb.TapEvent_Http_StreamId{
@@ -295,7 +415,40 @@ func TestEventToString(t *testing.T) {
 		})
 
 		expectedOutput := "req id=7:8 proxy=out src=1.2.3.4:5555 dst=2.3.4.5:6666 tls= :method=POST :authority=hello.default:7777 :path=/hello.v1.HelloService/Hello"
-		output := renderTapEvent(event, "")
+		output := renderTapEvent(event, "", newTapOptions())
+		if output != expectedOutput {
+			t.Fatalf("Expecting command output to be [%s], got [%s]", expectedOutput, output)
+		}
+	})
+
+	t.Run("Converts HTTP request init event with route metadata to string", func(t *testing.T) {
+		event := toTapEvent(&pb.TapEvent_Http{
+			Event: &pb.TapEvent_Http_RequestInit_{
+				RequestInit: &pb.TapEvent_Http_RequestInit{
+					Method: &pb.HttpMethod{
+						Type: &pb.HttpMethod_Registered_{
+							Registered: pb.HttpMethod_GET,
+						},
+					},
+					Authority: "books.default:7000",
+					Path:      "/books/1",
+				},
+			},
+		})
+		event.RouteMeta = &pb.TapEvent_RouteMeta{
+			Labels: map[string]string{"route": "GET /books/{id}"},
+		}
+
+		expectedOutput := "req id=7:8 proxy=out src=1.2.3.4:5555 dst=2.3.4.5:6666 tls= :method=GET :authority=books.default:7000 :path=/books/1"
+		output := renderTapEvent(event, "", newTapOptions())
+		if output != expectedOutput {
+			t.Fatalf("Expecting command output to be [%s], got [%s]", expectedOutput, output)
+		}
+
+		options := newTapOptions()
+		options.showRoute = true
+		expectedOutput = "req id=7:8 proxy=out src=1.2.3.4:5555 dst=2.3.4.5:6666 tls= :method=GET :authority=books.default:7000 :path=/books/1 rt_route=GET /books/{id}"
+		output = renderTapEvent(event, "", options)
 		if output != expectedOutput {
 			t.Fatalf("Expecting command output to be [%s], got [%s]", expectedOutput, output)
 		}
@@ -312,7 +465,7 @@ func TestEventToString(t *testing.T) {
 		})
 
 		expectedOutput := "rsp id=7:8 proxy=out src=1.2.3.4:5555 dst=2.3.4.5:6666 tls= :status=200 latency=999µs"
-		output := renderTapEvent(event, "")
+		output := renderTapEvent(event, "", newTapOptions())
 		if output != expectedOutput {
 			t.Fatalf("Expecting command output to be [%s], got [%s]", expectedOutput, output)
 		}
@@ -332,8 +485,8 @@ func TestEventToString(t *testing.T) {
 			},
 		})
 
-		expectedOutput := "end id=7:8 proxy=out src=1.2.3.4:5555 dst=2.3.4.5:6666 tls= grpc-status=OK duration=888µs response-length=111B"
-		output := renderTapEvent(event, "")
+		expectedOutput := "end id=7:8 proxy=out src=1.2.3.4:5555 dst=2.3.4.5:6666 tls= grpc-status=OK duration=888µs total=999µs response-length=111B"
+		output := renderTapEvent(event, "", newTapOptions())
 		if output != expectedOutput {
 			t.Fatalf("Expecting command output to be [%s], got [%s]", expectedOutput, output)
 		}
@@ -353,8 +506,8 @@ func TestEventToString(t *testing.T) {
 			},
 		})
 
-		expectedOutput := "end id=7:8 proxy=out src=1.2.3.4:5555 dst=2.3.4.5:6666 tls= reset-error=123 duration=888µs response-length=111B"
-		output := renderTapEvent(event, "")
+		expectedOutput := "end id=7:8 proxy=out src=1.2.3.4:5555 dst=2.3.4.5:6666 tls= reset-error=123 duration=888µs total=999µs response-length=111B"
+		output := renderTapEvent(event, "", newTapOptions())
 		if output != expectedOutput {
 			t.Fatalf("Expecting command output to be [%s], got [%s]", expectedOutput, output)
 		}
@@ -372,8 +525,8 @@ func TestEventToString(t *testing.T) {
 			},
 		})
 
-		expectedOutput := "end id=7:8 proxy=out src=1.2.3.4:5555 dst=2.3.4.5:6666 tls= duration=888µs response-length=111B"
-		output := renderTapEvent(event, "")
+		expectedOutput := "end id=7:8 proxy=out src=1.2.3.4:5555 dst=2.3.4.5:6666 tls= duration=888µs total=999µs response-length=111B"
+		output := renderTapEvent(event, "", newTapOptions())
 		if output != expectedOutput {
 			t.Fatalf("Expecting command output to be [%s], got [%s]", expectedOutput, output)
 		}
@@ -390,8 +543,8 @@ func TestEventToString(t *testing.T) {
 			},
 		})
 
-		expectedOutput := "end id=7:8 proxy=out src=1.2.3.4:5555 dst=2.3.4.5:6666 tls= duration=888µs response-length=111B"
-		output := renderTapEvent(event, "")
+		expectedOutput := "end id=7:8 proxy=out src=1.2.3.4:5555 dst=2.3.4.5:6666 tls= duration=888µs total=999µs response-length=111B"
+		output := renderTapEvent(event, "", newTapOptions())
 		if output != expectedOutput {
 			t.Fatalf("Expecting command output to be [%s], got [%s]", expectedOutput, output)
 		}
@@ -400,10 +553,1255 @@ func TestEventToString(t *testing.T) {
 	t.Run("Handles unknown event types", func(t *testing.T) {
 		event := toTapEvent(&pb.TapEvent_Http{})
 
-		expectedOutput := "unknown proxy=out src=1.2.3.4:5555 dst=2.3.4.5:6666 tls="
-		output := renderTapEvent(event, "")
+		expectedOutput := "unknown proxy=out src=1.2.3.4:5555 dst=2.3.4.5:6666 tls= protocol=http type=<nil> (not supported by this version of the CLI)"
+		output := renderTapEvent(event, "", newTapOptions())
+		if output != expectedOutput {
+			t.Fatalf("Expecting command output to be [%s], got [%s]", expectedOutput, output)
+		}
+
+		event.Event = nil
+		expectedOutput = "unknown proxy=out src=1.2.3.4:5555 dst=2.3.4.5:6666 tls= protocol=none type=<nil> (not supported by this version of the CLI)"
+		output = renderTapEvent(event, "", newTapOptions())
+		if output != expectedOutput {
+			t.Fatalf("Expecting command output to be [%s], got [%s]", expectedOutput, output)
+		}
+	})
+
+	t.Run("Handles unknown proxy directions", func(t *testing.T) {
+		event := toTapEvent(&pb.TapEvent_Http{
+			Event: &pb.TapEvent_Http_ResponseInit_{
+				ResponseInit: &pb.TapEvent_Http_ResponseInit{
+					SinceRequestInit: &duration.Duration{Nanos: 999000},
+					HttpStatus:       http.StatusOK,
+				},
+			},
+		})
+		event.ProxyDirection = pb.TapEvent_UNKNOWN
+
+		expectedOutput := "rsp id=7:8 proxy=unk src=1.2.3.4:5555 dst=2.3.4.5:6666 tls= :status=200 latency=999µs"
+		output := renderTapEvent(event, "", newTapOptions())
+		if output != expectedOutput {
+			t.Fatalf("Expecting command output to be [%s], got [%s]", expectedOutput, output)
+		}
+
+		m := mapPublicToDisplayTapEvent(event)
+		if m.ProxyDirection != "UNKNOWN" {
+			t.Fatalf("Expecting proxyDirection [UNKNOWN], got [%s]", m.ProxyDirection)
+		}
+	})
+}
+
+func TestHTTPVersion(t *testing.T) {
+	header := func(name, value string) *pb.Headers_Header {
+		return &pb.Headers_Header{
+			Name:  name,
+			Value: &pb.Headers_Header_ValueStr{ValueStr: value},
+		}
+	}
+
+	testCases := []struct {
+		headers  []*pb.Headers_Header
+		expected string
+	}{
+		{nil, httpVersionUnknown},
+		{[]*pb.Headers_Header{header("host", "books.default"), header("accept", "*/*")}, httpVersion11},
+		{[]*pb.Headers_Header{header("accept", "*/*")}, httpVersion2},
+		{[]*pb.Headers_Header{header("content-type", "application/grpc+proto")}, httpVersion2},
+	}
+
+	for i, tc := range testCases {
+		tc := tc // pin
+		t.Run(fmt.Sprintf("%d: %s", i, tc.expected), func(t *testing.T) {
+			reqInit := &pb.TapEvent_Http_RequestInit{
+				Id: &pb.TapEvent_Http_StreamId{
+					Base:   7,
+					Stream: 8,
+				},
+				Method: &pb.HttpMethod{
+					Type: &pb.HttpMethod_Registered_{
+						Registered: pb.HttpMethod_GET,
+					},
+				},
+				Authority: "books.default:7000",
+				Path:      "/books",
+				Headers:   &pb.Headers{Headers: tc.headers},
+			}
+			event := &pb.TapEvent{
+				ProxyDirection: pb.TapEvent_OUTBOUND,
+				Source: &pb.TcpAddress{
+					Ip:   addr.PublicIPV4(1, 2, 3, 4),
+					Port: 5555,
+				},
+				Destination: &pb.TcpAddress{
+					Ip:   addr.PublicIPV4(2, 3, 4, 5),
+					Port: 6666,
+				},
+				Event: &pb.TapEvent_Http_{
+					Http: &pb.TapEvent_Http{
+						Event: &pb.TapEvent_Http_RequestInit_{RequestInit: reqInit},
+					},
+				},
+			}
+
+			options := newTapOptions()
+			options.showVersion = true
+
+			expectedOutput := fmt.Sprintf("req id=7:8 proxy=out src=1.2.3.4:5555 dst=2.3.4.5:6666 tls= :method=GET :authority=books.default:7000 :path=/books version=%s", tc.expected)
+			output := renderTapEvent(event, "", options)
+			if output != expectedOutput {
+				t.Fatalf("Expecting command output to be [%s], got [%s]", expectedOutput, output)
+			}
+
+			expectedJSON := fmt.Sprintf("\"version\": \"%s\"", tc.expected)
+			output = renderTapEventJSON(event, "", options)
+			if !strings.Contains(output, expectedJSON) {
+				t.Fatalf("Expecting JSON output to contain [%s], got [%s]", expectedJSON, output)
+			}
+		})
+	}
+}
+
+// tapTestEvent wraps an HTTP event in an outbound TapEvent between two fixed
+// peers, with the given stream ID.
+func tapTestEvent(stream uint64, httpEvent *pb.TapEvent_Http) *pb.TapEvent {
+	id := &pb.TapEvent_Http_StreamId{
+		Base:   7,
+		Stream: stream,
+	}
+
+	switch httpEvent.Event.(type) {
+	case *pb.TapEvent_Http_RequestInit_:
+		httpEvent.GetRequestInit().Id = id
+	case *pb.TapEvent_Http_ResponseInit_:
+		httpEvent.GetResponseInit().Id = id
+	case *pb.TapEvent_Http_ResponseEnd_:
+		httpEvent.GetResponseEnd().Id = id
+	}
+
+	return &pb.TapEvent{
+		ProxyDirection: pb.TapEvent_OUTBOUND,
+		Source: &pb.TcpAddress{
+			Ip:   addr.PublicIPV4(1, 2, 3, 4),
+			Port: 5555,
+		},
+		Destination: &pb.TcpAddress{
+			Ip:   addr.PublicIPV4(2, 3, 4, 5),
+			Port: 6666,
+		},
+		Event: &pb.TapEvent_Http_{Http: httpEvent},
+	}
+}
+
+func tapTestRequest(stream uint64, method pb.HttpMethod_Registered, path string) *pb.TapEvent {
+	return tapTestEvent(stream, &pb.TapEvent_Http{
+		Event: &pb.TapEvent_Http_RequestInit_{
+			RequestInit: &pb.TapEvent_Http_RequestInit{
+				Method: &pb.HttpMethod{
+					Type: &pb.HttpMethod_Registered_{
+						Registered: method,
+					},
+				},
+				Scheme: &pb.Scheme{
+					Type: &pb.Scheme_Registered_{
+						Registered: pb.Scheme_HTTP,
+					},
+				},
+				Authority: "books.default:7000",
+				Path:      path,
+			},
+		},
+	})
+}
+
+// tapTestWithBase sets the base stream ID of an event, which identifies the
+// connection it belongs to.
+func tapTestWithBase(base uint32, event *pb.TapEvent) *pb.TapEvent {
+	eventStreamID(event).Base = base
+	return event
+}
+
+func tapTestResponse(stream uint64, status uint32, latency *duration.Duration) *pb.TapEvent {
+	return tapTestEvent(stream, &pb.TapEvent_Http{
+		Event: &pb.TapEvent_Http_ResponseInit_{
+			ResponseInit: &pb.TapEvent_Http_ResponseInit{
+				SinceRequestInit: latency,
+				HttpStatus:       status,
+			},
+		},
+	})
+}
+
+func tapTestEnd(stream uint64, eos *pb.Eos, responseBytes uint64) *pb.TapEvent {
+	return tapTestEvent(stream, &pb.TapEvent_Http{
+		Event: &pb.TapEvent_Http_ResponseEnd_{
+			ResponseEnd: &pb.TapEvent_Http_ResponseEnd{
+				SinceRequestInit:  &duration.Duration{Nanos: 999000},
+				SinceResponseInit: &duration.Duration{Nanos: 888000},
+				ResponseBytes:     responseBytes,
+				Eos:               eos,
+			},
+		},
+	})
+}
+
+// tapEventStream serializes events the way the tap APIService streams them.
+func tapEventStream(t *testing.T, events ...*pb.TapEvent) *bufio.Reader {
+	rec := httptest.NewRecorder()
+	for _, event := range events {
+		err := protohttp.WriteProtoToHTTPResponse(rec, event)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	return bufio.NewReader(rec.Body)
+}
+
+// renderTestTapEvents renders events with the default renderer and returns
+// the output.
+func renderTestTapEvents(t *testing.T, options *tapOptions, events ...*pb.TapEvent) string {
+	writer := bytes.NewBufferString("")
+	err := renderTapEvents(tapEventStream(t, events...), writer, renderTapEvent, "", options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return writer.String()
+}
+
+// tapTestClock returns a clock starting at start and advancing by step on
+// every read.
+func tapTestClock(start time.Time, step time.Duration) func() time.Time {
+	now := start
+	return func() time.Time {
+		t := now
+		now = now.Add(step)
+		return t
+	}
+}
+
+func TestRenderTapEventsDedup(t *testing.T) {
+	grpcOK := &pb.Eos{End: &pb.Eos_GrpcStatusCode{GrpcStatusCode: uint32(codes.OK)}}
+	var events []*pb.TapEvent
+	for stream := uint64(1); stream <= 3; stream++ {
+		events = append(events,
+			tapTestRequest(stream, pb.HttpMethod_GET, "/books"),
+			tapTestResponse(stream, http.StatusServiceUnavailable, &duration.Duration{Nanos: 1000}),
+			tapTestEnd(stream, grpcOK, 0),
+		)
+	}
+	events = append(events, tapTestRequest(4, pb.HttpMethod_GET, "/authors"))
+
+	t.Run("Renders every event without --dedup", func(t *testing.T) {
+		output := renderTestTapEvents(t, newTapOptions(), events...)
+		if lines := strings.Count(output, "\n"); lines != len(events) {
+			t.Fatalf("Expecting %d lines, got %d: [%s]", len(events), lines, output)
+		}
+	})
+
+	t.Run("Collapses identical transactions with --dedup", func(t *testing.T) {
+		options := newTapOptions()
+		options.dedup = true
+
+		expectedOutput := `req id=7:1 proxy=out src=1.2.3.4:5555 dst=2.3.4.5:6666 tls= :method=GET :authority=books.default:7000 :path=/books (x3)
+rsp id=7:1 proxy=out src=1.2.3.4:5555 dst=2.3.4.5:6666 tls= :status=503 latency=1µs (x3)
+end id=7:1 proxy=out src=1.2.3.4:5555 dst=2.3.4.5:6666 tls= grpc-status=OK duration=888µs total=999µs response-length=0B (x3)
+req id=7:4 proxy=out src=1.2.3.4:5555 dst=2.3.4.5:6666 tls= :method=GET :authority=books.default:7000 :path=/authors
+`
+		output := renderTestTapEvents(t, options, events...)
 		if output != expectedOutput {
 			t.Fatalf("Expecting command output to be [%s], got [%s]", expectedOutput, output)
 		}
 	})
+
+	t.Run("Doesn't collapse transactions further apart than the window", func(t *testing.T) {
+		options := newTapOptions()
+		options.dedup = true
+		options.now = tapTestClock(time.Unix(1e9, 0), dedupWindow+time.Millisecond)
+
+		output := renderTestTapEvents(t, options, events...)
+		if lines := strings.Count(output, "\n"); lines != len(events) || strings.Contains(output, "(x") {
+			t.Fatalf("Expecting %d lines without counts, got [%s]", len(events), output)
+		}
+	})
+
+	t.Run("Writes expired entries on every tick", func(t *testing.T) {
+		ticks := make(chan time.Time)
+		defer func(newTicker func(time.Duration) (<-chan time.Time, func())) {
+			newSummaryTicker = newTicker
+		}(newSummaryTicker)
+		newSummaryTicker = func(time.Duration) (<-chan time.Time, func()) {
+			return ticks, func() {}
+		}
+
+		start := time.Unix(0, 0)
+		now, setNow := tapTestSettableClock(start)
+		dedup := newEventDeduper(dedupRingSize, dedupWindow, 0)
+		for _, event := range events[:3] {
+			if lines := dedup.add(event, renderTapEvent(event, "", newTapOptions()), now()); len(lines) != 0 {
+				t.Fatalf("Expecting the events to be held back, got %v", lines)
+			}
+		}
+		output := bytes.NewBufferString("")
+		stop := dedup.start(output, now)
+		ticks <- time.Now()
+		setNow(start.Add(dedupWindow + time.Millisecond))
+		ticks <- time.Now()
+		stop()
+
+		expectedIDs := []string{"req id=7:1", "rsp id=7:1", "end id=7:1"}
+		if ids := renderedIDs(output.String()); fmt.Sprint(ids) != fmt.Sprint(expectedIDs) {
+			t.Fatalf("Expecting %v, got %v", expectedIDs, ids)
+		}
+		if lines := dedup.flush(); len(lines) != 0 {
+			t.Fatalf("Expecting nothing left to flush, got %v", lines)
+		}
+	})
+
+	t.Run("Rejects --dedup with JSON output", func(t *testing.T) {
+		options := newTapOptions()
+		options.dedup = true
+		options.output = jsonOutput
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+func TestRenderTapEventsResourceFilters(t *testing.T) {
+	withLabels := func(event *pb.TapEvent, srcDeploy, dstDeploy string) *pb.TapEvent {
+		event.SourceMeta = &pb.TapEvent_EndpointMeta{
+			Labels: map[string]string{k8s.Deployment: srcDeploy, k8s.Namespace: "default"},
+		}
+		event.DestinationMeta = &pb.TapEvent_EndpointMeta{
+			Labels: map[string]string{k8s.Deployment: dstDeploy, k8s.Namespace: "default"},
+		}
+		return event
+	}
+	events := []*pb.TapEvent{
+		withLabels(tapTestRequest(1, pb.HttpMethod_GET, "/books"), "web", "books"),
+		withLabels(tapTestRequest(2, pb.HttpMethod_GET, "/authors"), "web", "authors"),
+		withLabels(tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 1000}), "web", "books"),
+		withLabels(tapTestRequest(3, pb.HttpMethod_GET, "/books"), "traffic", "books"),
+		withLabels(tapTestResponse(2, http.StatusOK, &duration.Duration{Nanos: 1000}), "web", "authors"),
+		withLabels(tapTestResponse(3, http.StatusOK, &duration.Duration{Nanos: 1000}), "traffic", "books"),
+	}
+
+	testCases := []struct {
+		srcResource string
+		dstResource string
+		expectedIDs []string
+	}{
+		{"", "", []string{"req id=7:1", "req id=7:2", "rsp id=7:1", "req id=7:3", "rsp id=7:2", "rsp id=7:3"}},
+		{"deploy/web", "", []string{"req id=7:1", "req id=7:2", "rsp id=7:1", "rsp id=7:2"}},
+		{"", "deploy/books", []string{"req id=7:1", "rsp id=7:1", "req id=7:3", "rsp id=7:3"}},
+		{"deploy/web", "deploy/books", []string{"req id=7:1", "rsp id=7:1"}},
+		{"ns/default", "deploy", []string{"req id=7:1", "req id=7:2", "rsp id=7:1", "req id=7:3", "rsp id=7:2", "rsp id=7:3"}},
+		{"po/web", "", []string{}},
+	}
+
+	for i, tc := range testCases {
+		tc := tc // pin
+		t.Run(fmt.Sprintf("%d: --src-resource=%s --dst-resource=%s", i, tc.srcResource, tc.dstResource), func(t *testing.T) {
+			options := newTapOptions()
+			options.srcResource = tc.srcResource
+			options.dstResource = tc.dstResource
+			if err := options.validate(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			output := renderTestTapEvents(t, options, events...)
+			lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+			if output == "" {
+				lines = []string{}
+			}
+			if len(lines) != len(tc.expectedIDs) {
+				t.Fatalf("Expecting %d lines, got %d: [%s]", len(tc.expectedIDs), len(lines), output)
+			}
+			for j, line := range lines {
+				if !strings.HasPrefix(line, tc.expectedIDs[j]+" ") {
+					t.Fatalf("Expecting line %d to start with [%s], got [%s]", j, tc.expectedIDs[j], line)
+				}
+			}
+		})
+	}
+
+	t.Run("Rejects an invalid resource", func(t *testing.T) {
+		options := newTapOptions()
+		options.srcResource = "foo/web"
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+func TestRenderTapEventsMultipleToResources(t *testing.T) {
+	withDst := func(event *pb.TapEvent, dstDeploy, dstNamespace string) *pb.TapEvent {
+		event.DestinationMeta = &pb.TapEvent_EndpointMeta{
+			Labels: map[string]string{k8s.Deployment: dstDeploy, k8s.Namespace: dstNamespace},
+		}
+		return event
+	}
+	events := []*pb.TapEvent{
+		withDst(tapTestRequest(1, pb.HttpMethod_GET, "/books"), "books", "default"),
+		withDst(tapTestRequest(2, pb.HttpMethod_GET, "/authors"), "authors", "default"),
+		withDst(tapTestRequest(3, pb.HttpMethod_GET, "/books"), "books", "other"),
+		withDst(tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 1000}), "books", "default"),
+		withDst(tapTestRequest(4, pb.HttpMethod_GET, "/webapp"), "webapp", "default"),
+		withDst(tapTestResponse(2, http.StatusOK, &duration.Duration{Nanos: 1000}), "authors", "default"),
+	}
+
+	testCases := []struct {
+		toResource  string
+		toNamespace string
+		expectedIDs []string
+	}{
+		{"deploy/books,deploy/authors", "", []string{"req id=7:1", "req id=7:2", "rsp id=7:1", "rsp id=7:2"}},
+		{"deploy/books,deploy/webapp", "", []string{"req id=7:1", "rsp id=7:1", "req id=7:4"}},
+		{"deploy/books,deploy/authors", "other", []string{"req id=7:3"}},
+	}
+
+	for i, tc := range testCases {
+		tc := tc // pin
+		t.Run(fmt.Sprintf("%d: --to=%s --to-namespace=%s", i, tc.toResource, tc.toNamespace), func(t *testing.T) {
+			options := newTapOptions()
+			options.toResource = tc.toResource
+			options.toNamespace = tc.toNamespace
+			if err := options.validate(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if options.requestedToResource() != "" {
+				t.Fatalf("Expecting no --to resource to be sent to the tap API, got [%s]", options.requestedToResource())
+			}
+
+			output := renderTestTapEvents(t, options, events...)
+			lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+			if len(lines) != len(tc.expectedIDs) {
+				t.Fatalf("Expecting %d lines, got %d: [%s]", len(tc.expectedIDs), len(lines), output)
+			}
+			for j, line := range lines {
+				if !strings.HasPrefix(line, tc.expectedIDs[j]+" ") {
+					t.Fatalf("Expecting line %d to start with [%s], got [%s]", j, tc.expectedIDs[j], line)
+				}
+			}
+		})
+	}
+
+	t.Run("Sends a single --to resource to the tap API", func(t *testing.T) {
+		options := newTapOptions()
+		options.toResource = "deploy/books"
+		if err := options.validate(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if options.requestedToResource() != "deploy/books" {
+			t.Fatalf("Expecting [deploy/books], got [%s]", options.requestedToResource())
+		}
+	})
+
+	t.Run("Rejects an invalid resource", func(t *testing.T) {
+		options := newTapOptions()
+		options.toResource = "deploy/books,foo/authors"
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+func TestRenderTapEventWidePlus(t *testing.T) {
+	event := tapTestRequest(1, pb.HttpMethod_GET, "/books/1")
+	event.SourceMeta = &pb.TapEvent_EndpointMeta{
+		Labels: map[string]string{k8s.Deployment: "web", k8s.Namespace: "default", "serviceaccount": "web"},
+	}
+	event.DestinationMeta = &pb.TapEvent_EndpointMeta{
+		Labels: map[string]string{k8s.Deployment: "books", k8s.Namespace: "default", "serviceaccount": "books", "tls": "true"},
+	}
+	event.RouteMeta = &pb.TapEvent_RouteMeta{Labels: map[string]string{"route": "GET /books/{id}"}}
+
+	expectedOutput := "req id=7:1 proxy=out src=1.2.3.4:5555 dst=2.3.4.5:6666 tls=true :method=GET :authority=books.default:7000 :path=/books/1" +
+		" src_res=deploy/web src_ns=default dst_res=deploy/books dst_ns=default rt_route=GET /books/{id}" +
+		" direction=outbound src_id=web.default.serviceaccount dst_id=books.default.serviceaccount route=GET /books/{id}"
+	output := renderTapEventWidePlus(event, k8s.Deployment, newTapOptions())
+	if output != expectedOutput {
+		t.Fatalf("Expecting command output to be [%s], got [%s]", expectedOutput, output)
+	}
+}
+
+func TestRenderTapEventsMaxEventsPerStream(t *testing.T) {
+	grpcOK := &pb.Eos{End: &pb.Eos_GrpcStatusCode{GrpcStatusCode: uint32(codes.OK)}}
+	events := []*pb.TapEvent{
+		tapTestWithBase(1, tapTestRequest(1, pb.HttpMethod_GET, "/books")),
+		tapTestWithBase(2, tapTestRequest(1, pb.HttpMethod_GET, "/authors")),
+		tapTestWithBase(1, tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 1000})),
+		tapTestWithBase(1, tapTestEnd(1, grpcOK, 0)),
+		tapTestWithBase(1, tapTestRequest(2, pb.HttpMethod_GET, "/books")),
+		tapTestWithBase(2, tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 1000})),
+		tapTestWithBase(1, tapTestResponse(2, http.StatusOK, &duration.Duration{Nanos: 1000})),
+		tapTestWithBase(2, tapTestEnd(1, grpcOK, 0)),
+	}
+
+	options := newTapOptions()
+	options.maxEventsPerStream = 3
+	lines := strings.Split(strings.TrimSuffix(renderTestTapEvents(t, options, events...), "\n"), "\n")
+
+	expectedPrefixes := []string{
+		"req id=1:1 ",
+		"req id=2:1 ",
+		"rsp id=1:1 ",
+		"end id=1:1 ",
+		"stream 1 truncated after 3 events",
+		"rsp id=2:1 ",
+		"end id=2:1 ",
+	}
+	if len(lines) != len(expectedPrefixes) {
+		t.Fatalf("Expecting %d lines, got %d: %v", len(expectedPrefixes), len(lines), lines)
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, expectedPrefixes[i]) {
+			t.Fatalf("Expecting line %d to start with [%s], got [%s]", i, expectedPrefixes[i], line)
+		}
+	}
+
+	t.Run("Rejects a negative limit", func(t *testing.T) {
+		options := newTapOptions()
+		options.maxEventsPerStream = -1
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+func TestRenderTapEventsOnlyNewConnections(t *testing.T) {
+	grpcOK := &pb.Eos{End: &pb.Eos_GrpcStatusCode{GrpcStatusCode: uint32(codes.OK)}}
+	latency := &duration.Duration{Nanos: 1000}
+	events := []*pb.TapEvent{
+		tapTestWithBase(1, tapTestRequest(1, pb.HttpMethod_GET, "/books")),
+		tapTestWithBase(1, tapTestRequest(2, pb.HttpMethod_GET, "/authors")),
+		tapTestWithBase(2, tapTestRequest(5, pb.HttpMethod_GET, "/books")),
+		tapTestWithBase(1, tapTestResponse(2, http.StatusOK, latency)),
+		tapTestWithBase(1, tapTestResponse(1, http.StatusOK, latency)),
+		tapTestWithBase(2, tapTestRequest(6, pb.HttpMethod_GET, "/books")),
+		tapTestWithBase(1, tapTestEnd(1, grpcOK, 0)),
+		tapTestWithBase(1, tapTestEnd(2, grpcOK, 0)),
+		// The first request of connection 3 was missed.
+		tapTestWithBase(3, tapTestResponse(1, http.StatusOK, latency)),
+		tapTestWithBase(3, tapTestRequest(2, pb.HttpMethod_GET, "/books")),
+	}
+
+	options := newTapOptions()
+	options.onlyNewConnections = true
+	ids := renderedIDs(renderTestTapEvents(t, options, events...))
+	expectedIDs := []string{"req id=1:1", "req id=2:5", "rsp id=1:1", "end id=1:1"}
+	if fmt.Sprint(ids) != fmt.Sprint(expectedIDs) {
+		t.Fatalf("Expecting %v, got %v", expectedIDs, ids)
+	}
+}
+
+func TestRenderTapEventsStartOn(t *testing.T) {
+	latency := &duration.Duration{Nanos: 1000}
+	events := []*pb.TapEvent{
+		tapTestRequest(1, pb.HttpMethod_GET, "/books"),
+		tapTestResponse(1, http.StatusOK, latency),
+		// Only requests trigger the output.
+		tapTestResponse(2, http.StatusOK, latency),
+		tapTestRequest(3, pb.HttpMethod_POST, "/trigger/now"),
+		tapTestEnd(1, &pb.Eos{}, 10),
+		tapTestResponse(3, http.StatusOK, latency),
+		tapTestRequest(4, pb.HttpMethod_GET, "/books"),
+		tapTestRequest(5, pb.HttpMethod_POST, "/trigger"),
+	}
+
+	options := newTapOptions()
+	options.startOn = "/trigger"
+	if err := options.validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ids := renderedIDs(renderTestTapEvents(t, options, events...))
+	expectedIDs := []string{"req id=7:3", "end id=7:1", "rsp id=7:3", "req id=7:4", "req id=7:5"}
+	if fmt.Sprint(ids) != fmt.Sprint(expectedIDs) {
+		t.Fatalf("Expecting %v, got %v", expectedIDs, ids)
+	}
+
+	t.Run("Displays nothing until the trigger", func(t *testing.T) {
+		options := newTapOptions()
+		options.startOn = "/missing"
+		if output := renderTestTapEvents(t, options, events...); output != "" {
+			t.Fatalf("Expecting no output, got [%s]", output)
+		}
+	})
+
+	t.Run("Rejects prefixes not starting with /", func(t *testing.T) {
+		options := newTapOptions()
+		options.startOn = "trigger"
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+func TestRenderTapEventsStopOn(t *testing.T) {
+	latency := &duration.Duration{Nanos: 1000}
+	events := []*pb.TapEvent{
+		tapTestRequest(1, pb.HttpMethod_GET, "/stop"),
+		tapTestResponse(1, http.StatusOK, latency),
+		tapTestEnd(1, &pb.Eos{}, 10),
+		tapTestRequest(2, pb.HttpMethod_GET, "/trigger"),
+		tapTestRequest(3, pb.HttpMethod_GET, "/books"),
+		tapTestResponse(2, http.StatusServiceUnavailable, latency),
+		tapTestRequest(4, pb.HttpMethod_GET, "/stop/now"),
+		tapTestResponse(3, http.StatusOK, latency),
+	}
+	stream, err := ioutil.ReadAll(tapEventStream(t, events...))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		stopOn      string
+		expectedIDs []string
+	}{
+		{"503", []string{"req id=7:2", "req id=7:3", "rsp id=7:2"}},
+		{"/stop", []string{"req id=7:2", "req id=7:3", "rsp id=7:2", "req id=7:4"}},
+	}
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(tc.stopOn, func(t *testing.T) {
+			// The stream stalls once the events were written, so that tap only
+			// returns if it stops on its own.
+			reader, writer := io.Pipe()
+			defer writer.Close()
+			go writer.Write(stream)
+
+			options := newTapOptions()
+			options.startOn = "/trigger"
+			options.stopOn = tc.stopOn
+			// The events held back by --dedup are flushed once tap stops.
+			options.dedup = true
+			if err := options.validate(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			output := &bytes.Buffer{}
+			rendered := make(chan error, 1)
+			go func() {
+				rendered <- renderTapEvents(bufio.NewReader(reader), output, renderTapEvent, "", options)
+			}()
+
+			select {
+			case err := <-rendered:
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Expected tap to stop on the matching event, it's still running")
+			}
+			ids := renderedIDs(output.String())
+			if fmt.Sprint(ids) != fmt.Sprint(tc.expectedIDs) {
+				t.Fatalf("Expecting %v, got %v", tc.expectedIDs, ids)
+			}
+		})
+	}
+
+	t.Run("Rejects invalid values", func(t *testing.T) {
+		for _, stopOn := range []string{"stop", "99", "600"} {
+			options := newTapOptions()
+			options.stopOn = stopOn
+			if err := options.validate(); err == nil {
+				t.Fatalf("Expecting an error for [%s]", stopOn)
+			}
+		}
+	})
+}
+
+func TestRenderTapEventProtoJSON(t *testing.T) {
+	event := tapTestRequest(1, pb.HttpMethod_POST, "/books")
+	output := renderTapEventProtoJSON(event, "", newTapOptions())
+
+	var rendered map[string]interface{}
+	if err := json.Unmarshal([]byte(output), &rendered); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if rendered["proxyDirection"] != "OUTBOUND" {
+		t.Fatalf("Expecting proxyDirection [OUTBOUND], got [%v] in [%s]", rendered["proxyDirection"], output)
+	}
+	if _, ok := rendered["proxy_direction"]; ok {
+		t.Fatalf("Expecting field names in camelCase, got [%s]", output)
+	}
+
+	reqI, ok := rendered["http"].(map[string]interface{})["requestInit"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expecting a requestInit object, got [%s]", output)
+	}
+	method := reqI["method"].(map[string]interface{})["registered"]
+	if method != "POST" {
+		t.Fatalf("Expecting method [POST], got [%v] in [%s]", method, output)
+	}
+	scheme := reqI["scheme"].(map[string]interface{})["registered"]
+	if scheme != "HTTP" {
+		t.Fatalf("Expecting scheme [HTTP], got [%v] in [%s]", scheme, output)
+	}
+}
+
+func TestRenderTapEventJSONEscapeHTML(t *testing.T) {
+	event := tapTestRequest(1, pb.HttpMethod_GET, "/search?q=<a>&b")
+
+	testCases := []struct {
+		escapeHTML bool
+		expected   string
+	}{
+		{true, `"path": "/search?q=\u003ca\u003e\u0026b"`},
+		{false, `"path": "/search?q=<a>&b"`},
+	}
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(fmt.Sprintf("--escape-json-html=%t", tc.escapeHTML), func(t *testing.T) {
+			options := newTapOptions()
+			options.escapeJSONHTML = tc.escapeHTML
+			output := renderTapEventJSON(event, "", options)
+			if !strings.Contains(output, tc.expected) {
+				t.Fatalf("Expecting output to contain [%s], got [%s]", tc.expected, output)
+			}
+			if strings.HasSuffix(output, "\n") {
+				t.Fatalf("Expecting no trailing newline, got [%s]", output)
+			}
+		})
+	}
+}
+
+func TestRenderTapEventStrictResource(t *testing.T) {
+	event := tapTestRequest(1, pb.HttpMethod_GET, "/books")
+	event.SourceMeta = &pb.TapEvent_EndpointMeta{
+		Labels: map[string]string{k8s.Pod: "web-dlbvj", k8s.Namespace: "default"},
+	}
+	event.DestinationMeta = &pb.TapEvent_EndpointMeta{
+		Labels: map[string]string{k8s.Deployment: "books", k8s.Pod: "books-x7b2p", k8s.Namespace: "default"},
+	}
+
+	testCases := []struct {
+		strict   bool
+		expected string
+	}{
+		{false, " src_pod=web-dlbvj src_ns=default dst_res=deploy/books dst_ns=default"},
+		{true, " src_res=<none> src_ns=default dst_res=deploy/books dst_ns=default"},
+	}
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(fmt.Sprintf("strict=%t", tc.strict), func(t *testing.T) {
+			options := newTapOptions()
+			options.strictResource = tc.strict
+			output := renderTapEvent(event, k8s.Deployment, options)
+			if !strings.HasSuffix(output, tc.expected) {
+				t.Fatalf("Expecting output to end with [%s], got [%s]", tc.expected, output)
+			}
+		})
+	}
+}
+
+func TestRenderTapEventNoFlow(t *testing.T) {
+	options := newTapOptions()
+	options.noFlow = true
+
+	testCases := []struct {
+		event    *pb.TapEvent
+		expected string
+	}{
+		{
+			tapTestRequest(1, pb.HttpMethod_GET, "/books"),
+			"req id=7:1 :method=GET :authority=books.default:7000 :path=/books",
+		},
+		{
+			tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 1000}),
+			"rsp id=7:1 :status=200 latency=1µs",
+		},
+		{
+			tapTestEnd(1, &pb.Eos{}, 10),
+			"end id=7:1 duration=888µs total=999µs response-length=10B",
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(tc.expected, func(t *testing.T) {
+			output := renderTapEvent(tc.event, "", options)
+			if output != tc.expected {
+				t.Fatalf("Expecting [%s], got [%s]", tc.expected, output)
+			}
+		})
+	}
+
+	t.Run("Keeps the fields enabled by other flags", func(t *testing.T) {
+		options := newTapOptions()
+		options.noFlow = true
+		options.showReporter = true
+		output := renderTapEvent(tapTestRequest(1, pb.HttpMethod_GET, "/books"), "", options)
+		expected := "req id=7:1 reporter=unk :method=GET"
+		if !strings.HasPrefix(output, expected) || strings.Contains(output, "proxy=") {
+			t.Fatalf("Expecting output to start with [%s], got [%s]", expected, output)
+		}
+	})
+}
+
+func TestRenderTapEventFirstByteLatency(t *testing.T) {
+	// The response took over a second to start, then 888µs to complete.
+	rsp := tapTestResponse(1, http.StatusOK, &duration.Duration{Seconds: 1, Nanos: 5000})
+	end := tapTestEnd(1, &pb.Eos{}, 10)
+
+	options := newTapOptions()
+	options.output = jsonOutput
+	options.firstByteLatency = true
+	if err := options.validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	m := mapTapEventWithOptions(rsp, options)
+	if m.ResponseInitEvent.TTFBMicros == nil || *m.ResponseInitEvent.TTFBMicros != 1000005 {
+		t.Fatalf("Expecting TTFBMicros 1000005, got %v", m.ResponseInitEvent.TTFBMicros)
+	}
+
+	t.Run("Renders the time to first byte as the latency of response lines", func(t *testing.T) {
+		output := renderTapEvent(rsp, "", newTapOptions())
+		if !strings.Contains(output, " latency=1000005µs") || strings.Contains(output, "ttfb=") {
+			t.Fatalf("Expecting latency=1000005µs, got [%s]", output)
+		}
+		if output := renderTapEvent(end, "", newTapOptions()); !strings.Contains(output, " duration=888µs") {
+			t.Fatalf("Expecting duration=888µs, got [%s]", output)
+		}
+	})
+
+	t.Run("Renders no TTFBMicros without --first-byte-latency", func(t *testing.T) {
+		if m := mapTapEventWithOptions(rsp, newTapOptions()); m.ResponseInitEvent.TTFBMicros != nil {
+			t.Fatalf("Expecting no TTFBMicros, got %d", *m.ResponseInitEvent.TTFBMicros)
+		}
+	})
+
+	t.Run("Rejects --first-byte-latency with table output", func(t *testing.T) {
+		options := newTapOptions()
+		options.firstByteLatency = true
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+func TestRenderTapEventTotalDuration(t *testing.T) {
+	// The request was sent 1.5s before its response ended, which took
+	// 1.000005s from when it started.
+	end := tapTestEnd(1, &pb.Eos{}, 10)
+	end.GetHttp().GetResponseEnd().SinceRequestInit = &duration.Duration{Seconds: 1, Nanos: 500000000}
+	end.GetHttp().GetResponseEnd().SinceResponseInit = &duration.Duration{Seconds: 1, Nanos: 5000}
+
+	expected := " duration=1000005µs total=1500000µs response-length=10B"
+	if output := renderTapEvent(end, "", newTapOptions()); !strings.Contains(output, expected) {
+		t.Fatalf("Expecting [%s], got [%s]", expected, output)
+	}
+}
+
+func TestRenderTapEventJSONDurationsAsMicros(t *testing.T) {
+	rsp := tapTestResponse(1, http.StatusOK, &duration.Duration{Seconds: 1, Nanos: 5000})
+	end := tapTestEnd(1, &pb.Eos{}, 10)
+	end.GetHttp().GetResponseEnd().SinceRequestInit = &duration.Duration{Seconds: 1, Nanos: 500000000}
+	end.GetHttp().GetResponseEnd().SinceResponseInit = &duration.Duration{Nanos: 888000}
+
+	decode := func(event *pb.TapEvent, options *tapOptions, field string) map[string]interface{} {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(renderTapEventNDJSON(event, "", options)), &m); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		fields, ok := m[field].(map[string]interface{})
+		if !ok {
+			t.Fatalf("Expecting a %s object, got %v", field, m)
+		}
+		return fields
+	}
+
+	options := newTapOptions()
+	options.output = jsonOutput
+	options.durationsAsMicros = true
+	if err := options.validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		event    *pb.TapEvent
+		field    string
+		expected map[string]float64
+	}{
+		{rsp, "responseInitEvent", map[string]float64{"sinceRequestInitMicros": 1000005}},
+		{end, "responseEndEvent", map[string]float64{"sinceRequestInitMicros": 1500000, "sinceResponseInitMicros": 888}},
+	}
+	for _, tc := range testCases {
+		fields := decode(tc.event, options, tc.field)
+		for name, expected := range tc.expected {
+			if micros, ok := fields[name].(float64); !ok || micros != expected {
+				t.Fatalf("Expecting %s.%s to be %v, got %v", tc.field, name, expected, fields[name])
+			}
+		}
+		if _, ok := fields["sinceRequestInit"].(map[string]interface{}); !ok {
+			t.Fatalf("Expecting %s to keep the nested sinceRequestInit, got %v", tc.field, fields)
+		}
+	}
+
+	t.Run("Renders only nested durations without --durations-as-micros", func(t *testing.T) {
+		fields := decode(end, newTapOptions(), "responseEndEvent")
+		for _, name := range []string{"sinceRequestInitMicros", "sinceResponseInitMicros"} {
+			if micros, ok := fields[name]; ok {
+				t.Fatalf("Expecting no %s, got %v", name, micros)
+			}
+		}
+	})
+
+	t.Run("Rejects --durations-as-micros with text output", func(t *testing.T) {
+		options := newTapOptions()
+		options.durationsAsMicros = true
+		options.output = wideOutput
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+func TestRenderTapEventJSONTopLevelStreamID(t *testing.T) {
+	options := newTapOptions()
+	options.output = jsonOutput
+	options.topLevelStreamID = true
+	if err := options.validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	events := []*pb.TapEvent{
+		tapTestWithBase(7, tapTestRequest(3, pb.HttpMethod_GET, "/books")),
+		tapTestWithBase(7, tapTestResponse(3, http.StatusOK, &duration.Duration{Nanos: 1000})),
+		tapTestWithBase(7, tapTestEnd(3, &pb.Eos{}, 10)),
+	}
+	for _, event := range events {
+		var m struct {
+			StreamBase        *uint32 `json:"streamBase"`
+			StreamNumber      *uint64 `json:"streamNumber"`
+			RequestInitEvent  *struct{ ID *streamID }
+			ResponseInitEvent *struct{ ID *streamID }
+			ResponseEndEvent  *struct{ ID *streamID }
+		}
+		if err := json.Unmarshal([]byte(renderTapEventNDJSON(event, "", options)), &m); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var nested *streamID
+		switch {
+		case m.RequestInitEvent != nil:
+			nested = m.RequestInitEvent.ID
+		case m.ResponseInitEvent != nil:
+			nested = m.ResponseInitEvent.ID
+		case m.ResponseEndEvent != nil:
+			nested = m.ResponseEndEvent.ID
+		}
+		if nested == nil || m.StreamBase == nil || m.StreamNumber == nil {
+			t.Fatalf("Expecting nested and top-level stream IDs, got %+v", m)
+		}
+		if *m.StreamBase != nested.Base || *m.StreamNumber != nested.Stream {
+			t.Fatalf("Expecting top-level stream ID %d:%d, got %d:%d", nested.Base, nested.Stream, *m.StreamBase, *m.StreamNumber)
+		}
+		if nested.Base != 7 || nested.Stream != 3 {
+			t.Fatalf("Expecting stream ID 7:3, got %d:%d", nested.Base, nested.Stream)
+		}
+	}
+
+	t.Run("Renders no top-level stream ID without --top-level-stream-id", func(t *testing.T) {
+		output := renderTapEventNDJSON(events[0], "", newTapOptions())
+		if strings.Contains(output, "streamBase") || strings.Contains(output, "streamNumber") {
+			t.Fatalf("Expecting no top-level stream ID, got [%s]", output)
+		}
+	})
+
+	t.Run("Rejects --top-level-stream-id with text output", func(t *testing.T) {
+		options := newTapOptions()
+		options.topLevelStreamID = true
+		options.output = wideOutput
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
+
+func TestIncludeUnknown(t *testing.T) {
+	unknown := tapTestRequest(2, pb.HttpMethod_GET, "/authors")
+	unknown.GetHttp().Event = nil
+	events := []*pb.TapEvent{
+		tapTestRequest(1, pb.HttpMethod_GET, "/books"),
+		unknown,
+		tapTestEnd(1, &pb.Eos{}, 10),
+	}
+
+	t.Run("Hides unknown events by default", func(t *testing.T) {
+		output := renderTestTapEvents(t, newTapOptions(), events...)
+		if strings.Contains(output, "unknown") {
+			t.Fatalf("Expecting no unknown event, got [%s]", output)
+		}
+		if lines := strings.Count(output, "\n"); lines != 2 {
+			t.Fatalf("Expecting 2 lines, got %d: [%s]", lines, output)
+		}
+	})
+
+	t.Run("Describes unknown events with --include-unknown", func(t *testing.T) {
+		options := newTapOptions()
+		options.includeUnknown = true
+		output := renderTestTapEvents(t, options, events...)
+		expected := "\nunknown proxy=out src=1.2.3.4:5555 dst=2.3.4.5:6666 tls= protocol=http type=<nil> (not supported by this version of the CLI)\n"
+		if !strings.Contains(output, expected) {
+			t.Fatalf("Expecting output to contain [%s], got [%s]", expected, output)
+		}
+
+		unknown.Event = nil
+		line := renderTapEvent(unknown, "", options)
+		if !strings.HasSuffix(line, " protocol=none type=<nil> (not supported by this version of the CLI)") {
+			t.Fatalf("Expecting the description of an event without protocol, got [%s]", line)
+		}
+	})
+}
+
+func TestRenderTapEventReporter(t *testing.T) {
+	withMeta := func(direction pb.TapEvent_ProxyDirection) *pb.TapEvent {
+		event := tapTestRequest(1, pb.HttpMethod_GET, "/books")
+		event.ProxyDirection = direction
+		event.SourceMeta = &pb.TapEvent_EndpointMeta{
+			Labels: map[string]string{k8s.Pod: "web-dlbvj", k8s.Namespace: "emojivoto"},
+		}
+		event.DestinationMeta = &pb.TapEvent_EndpointMeta{
+			Labels: map[string]string{k8s.Pod: "books-x7b2p", k8s.Namespace: "default"},
+		}
+		return event
+	}
+	unlabeled := tapTestRequest(1, pb.HttpMethod_GET, "/books")
+
+	testCases := []struct {
+		name     string
+		event    *pb.TapEvent
+		expected string
+	}{
+		{"outbound events are reported by the source", withMeta(pb.TapEvent_OUTBOUND), "web-dlbvj/emojivoto"},
+		{"inbound events are reported by the destination", withMeta(pb.TapEvent_INBOUND), "books-x7b2p/default"},
+		{"the reporter of events without a direction is unknown", withMeta(pb.TapEvent_UNKNOWN), ""},
+		{"the reporter of events without a pod is unknown", unlabeled, ""},
+	}
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(tc.name, func(t *testing.T) {
+			options := newTapOptions()
+			options.showReporter = true
+
+			expectedText := tc.expected
+			if expectedText == "" {
+				expectedText = "unk"
+			}
+			output := renderTapEvent(tc.event, "", options)
+			if !strings.Contains(output, " reporter="+expectedText+" :method=") {
+				t.Fatalf("Expecting reporter=%s, got [%s]", expectedText, output)
+			}
+
+			m := mapTapEventWithOptions(tc.event, options)
+			if m.Reporter != tc.expected {
+				t.Fatalf("Expecting Reporter [%s], got [%s]", tc.expected, m.Reporter)
+			}
+		})
+	}
+
+	t.Run("Displays no reporter without --show-reporter", func(t *testing.T) {
+		output := renderTapEvent(withMeta(pb.TapEvent_OUTBOUND), "", newTapOptions())
+		if strings.Contains(output, "reporter=") {
+			t.Fatalf("Expecting no reporter, got [%s]", output)
+		}
+	})
+}
+
+func TestRequestBytes(t *testing.T) {
+	withContentLength := func(value string) *pb.TapEvent {
+		event := tapTestRequest(1, pb.HttpMethod_POST, "/books")
+		event.GetHttp().GetRequestInit().Headers = &pb.Headers{
+			Headers: []*pb.Headers_Header{
+				{Name: "Content-Length", Value: &pb.Headers_Header_ValueStr{ValueStr: value}},
+			},
+		}
+		return event
+	}
+	options := newTapOptions()
+	options.showRequestBytes = true
+
+	t.Run("Renders the size declared by content-length", func(t *testing.T) {
+		event := withContentLength("1024")
+		line := renderTapEvent(event, "", options)
+		if !strings.HasSuffix(line, " :path=/books req-bytes=1024") {
+			t.Fatalf("Expecting req-bytes=1024, got [%s]", line)
+		}
+
+		reqI := mapTapEventWithOptions(event, options).RequestInitEvent
+		if reqI.RequestBytes == nil || *reqI.RequestBytes != 1024 {
+			t.Fatalf("Expecting requestBytes 1024, got %v", reqI.RequestBytes)
+		}
+		output := renderTapEventJSON(event, "", options)
+		if !strings.Contains(output, `"requestBytes": 1024`) {
+			t.Fatalf("Expecting requestBytes 1024, got [%s]", output)
+		}
+	})
+
+	t.Run("Omits the size when it isn't declared", func(t *testing.T) {
+		for _, event := range []*pb.TapEvent{
+			tapTestRequest(1, pb.HttpMethod_GET, "/books"),
+			withContentLength("invalid"),
+		} {
+			line := renderTapEvent(event, "", options)
+			if strings.Contains(line, "req-bytes") {
+				t.Fatalf("Expecting no req-bytes, got [%s]", line)
+			}
+			output := renderTapEventJSON(event, "", options)
+			if strings.Contains(output, "requestBytes") {
+				t.Fatalf("Expecting no requestBytes, got [%s]", output)
+			}
+		}
+	})
+
+	t.Run("Omits the size without --show-request-bytes", func(t *testing.T) {
+		line := renderTapEvent(withContentLength("1024"), "", newTapOptions())
+		if strings.Contains(line, "req-bytes") {
+			t.Fatalf("Expecting no req-bytes, got [%s]", line)
+		}
+	})
+}
+
+func TestRenderTapEventsMergeStreams(t *testing.T) {
+	grpcOK := &pb.Eos{End: &pb.Eos_GrpcStatusCode{GrpcStatusCode: uint32(codes.OK)}}
+	events := []*pb.TapEvent{
+		tapTestWithBase(1, tapTestRequest(1, pb.HttpMethod_GET, "/books")),
+		tapTestWithBase(1, tapTestRequest(3, pb.HttpMethod_GET, "/authors")),
+		tapTestWithBase(2, tapTestRequest(1, pb.HttpMethod_GET, "/books")),
+		tapTestWithBase(2, tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 1000})),
+		tapTestWithBase(1, tapTestResponse(3, http.StatusOK, &duration.Duration{Nanos: 1000})),
+		tapTestWithBase(1, tapTestEnd(3, grpcOK, 0)),
+	}
+
+	options := newTapOptions()
+	options.mergeStreams = true
+	if err := options.validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(renderTestTapEvents(t, options, events...), "\n"), "\n")
+
+	expectedPrefixes := []string{
+		"conn base=1 1.2.3.4:5555->2.3.4.5:6666",
+		"  req id=1:1 ",
+		"  req id=1:3 ",
+		"conn base=2 1.2.3.4:5555->2.3.4.5:6666",
+		"  req id=2:1 ",
+		"  rsp id=2:1 ",
+		"conn base=1 1.2.3.4:5555->2.3.4.5:6666",
+		"  rsp id=1:3 ",
+		"  end id=1:3 ",
+	}
+	if len(lines) != len(expectedPrefixes) {
+		t.Fatalf("Expecting %d lines, got %d: %v", len(expectedPrefixes), len(lines), lines)
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, expectedPrefixes[i]) {
+			t.Fatalf("Expecting line %d to start with [%s], got [%s]", i, expectedPrefixes[i], line)
+		}
+	}
+
+	t.Run("Rejects unsupported options", func(t *testing.T) {
+		for _, set := range []func(*tapOptions){
+			func(o *tapOptions) { o.output = jsonOutput },
+			func(o *tapOptions) { o.dedup = true },
+			func(o *tapOptions) { o.jsonPath = "{.proxyDirection}" },
+		} {
+			options := newTapOptions()
+			options.mergeStreams = true
+			set(options)
+			if err := options.validate(); err == nil {
+				t.Fatal("Expected error, got nothing")
+			}
+		}
+	})
+}
+
+func TestTimestamp(t *testing.T) {
+	local := time.Local
+	defer func() { time.Local = local }()
+	time.Local = time.FixedZone("UTC+2", 2*60*60)
+
+	instant := time.Date(2019, 7, 1, 10, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		utc      bool
+		expected string
+	}{
+		{false, "2019-07-01T12:00:00+02:00"},
+		{true, "2019-07-01T10:00:00Z"},
+	}
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(fmt.Sprintf("utc=%t", tc.utc), func(t *testing.T) {
+			options := newTapOptions()
+			options.utc = tc.utc
+			if ts := options.timestamp(instant).Format(time.RFC3339Nano); ts != tc.expected {
+				t.Fatalf("Expecting timestamp [%s], got [%s]", tc.expected, ts)
+			}
+
+			line := renderTapEventLogfmt(tapTestRequest(1, pb.HttpMethod_GET, "/books"), "", options)
+			ts := parseLogfmt(t, line)["ts"]
+			if strings.HasSuffix(ts, "Z") != tc.utc {
+				t.Fatalf("Expecting a timestamp in the same time zone as [%s], got [%s]", tc.expected, ts)
+			}
+		})
+	}
+}
+
+func TestRenderTapEventsRouteOnly(t *testing.T) {
+	withRoute := func(event *pb.TapEvent, labels map[string]string) *pb.TapEvent {
+		event.RouteMeta = &pb.TapEvent_RouteMeta{Labels: labels}
+		return event
+	}
+	route := map[string]string{"route": "GET /books/{id}"}
+	events := []*pb.TapEvent{
+		withRoute(tapTestRequest(1, pb.HttpMethod_GET, "/books/1"), route),
+		tapTestRequest(2, pb.HttpMethod_GET, "/authors"),
+		withRoute(tapTestRequest(3, pb.HttpMethod_GET, "/"), map[string]string{"other": "label"}),
+		withRoute(tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 1000}), route),
+		tapTestResponse(2, http.StatusOK, &duration.Duration{Nanos: 1000}),
+	}
+
+	testCases := []struct {
+		routeOnly   bool
+		expectedIDs []string
+	}{
+		{false, []string{"req id=7:1", "req id=7:2", "req id=7:3", "rsp id=7:1", "rsp id=7:2"}},
+		{true, []string{"req id=7:1", "rsp id=7:1"}},
+	}
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(fmt.Sprintf("--route-only=%t", tc.routeOnly), func(t *testing.T) {
+			options := newTapOptions()
+			options.routeOnly = tc.routeOnly
+			ids := renderedIDs(renderTestTapEvents(t, options, events...))
+			if !reflect.DeepEqual(ids, tc.expectedIDs) {
+				t.Fatalf("Expecting %v, got %v", tc.expectedIDs, ids)
+			}
+		})
+	}
+}
+
+func TestRenderTapEventJSONIsDeterministic(t *testing.T) {
+	event := tapTestRequest(1, pb.HttpMethod_GET, "/books")
+	labels := map[string]string{}
+	for i := 0; i < 20; i++ {
+		labels[fmt.Sprintf("label-%02d", 19-i)] = fmt.Sprint(i)
+	}
+	event.SourceMeta = &pb.TapEvent_EndpointMeta{Labels: labels}
+	event.DestinationMeta = &pb.TapEvent_EndpointMeta{Labels: labels}
+
+	for _, flatten := range []bool{false, true} {
+		options := newTapOptions()
+		options.flattenLabels = flatten
+		expected := renderTapEventJSON(event, "", options)
+		// Labels are rendered by key, regardless of the map's iteration
+		// order.
+		if strings.Index(expected, `label-00"`) > strings.Index(expected, `label-19"`) {
+			t.Fatalf("Expecting labels to be sorted, got [%s]", expected)
+		}
+		for i := 0; i < 10; i++ {
+			if output := renderTapEventJSON(event, "", options); output != expected {
+				t.Fatalf("Expecting [%s], got [%s]", expected, output)
+			}
+		}
+	}
 }
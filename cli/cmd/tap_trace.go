@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+// traceID returns the value of the given label, looked up in the route, source
+// and destination metadata of an event, in that order. If none of them has
+// it, a synthetic ID derived from the stream is returned instead, so that all
+// the events of a transaction share the same ID.
+func traceID(event *pb.TapEvent, label string) string {
+	for _, labels := range []map[string]string{
+		event.GetRouteMeta().GetLabels(),
+		event.GetSourceMeta().GetLabels(),
+		event.GetDestinationMeta().GetLabels(),
+	} {
+		if id, ok := labels[label]; ok && id != "" {
+			return id
+		}
+	}
+
+	key := newStreamKey(event)
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s/%s/%d/%d", key.src, key.dst, key.base, key.stream)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+func TestTraceID(t *testing.T) {
+	const label = "l5d-ctx-trace"
+
+	t.Run("Uses the label when present", func(t *testing.T) {
+		event := tapTestRequest(1, pb.HttpMethod_GET, "/books")
+		event.DestinationMeta = &pb.TapEvent_EndpointMeta{Labels: map[string]string{label: "dst-trace"}}
+		if id := traceID(event, label); id != "dst-trace" {
+			t.Fatalf("Expecting [dst-trace], got [%s]", id)
+		}
+
+		// Route labels take precedence over peer labels.
+		event.RouteMeta = &pb.TapEvent_RouteMeta{Labels: map[string]string{label: "route-trace"}}
+		if id := traceID(event, label); id != "route-trace" {
+			t.Fatalf("Expecting [route-trace], got [%s]", id)
+		}
+	})
+
+	t.Run("Derives a synthetic ID from the stream when absent", func(t *testing.T) {
+		req := traceID(tapTestRequest(1, pb.HttpMethod_GET, "/books"), label)
+		rsp := traceID(tapTestResponse(1, http.StatusOK, &duration.Duration{Nanos: 1000}), label)
+		end := traceID(tapTestEnd(1, &pb.Eos{}, 0), label)
+		other := traceID(tapTestRequest(2, pb.HttpMethod_GET, "/books"), label)
+
+		if len(req) != 16 {
+			t.Fatalf("Expecting a 16 characters ID, got [%s]", req)
+		}
+		if req != rsp || req != end {
+			t.Fatalf("Expecting the events of a stream to share an ID, got [%s], [%s] and [%s]", req, rsp, end)
+		}
+		if req == other {
+			t.Fatalf("Expecting streams to have different IDs, got [%s] for both", req)
+		}
+	})
+
+	t.Run("Renders the trace ID on each line and in JSON", func(t *testing.T) {
+		event := tapTestRequest(1, pb.HttpMethod_GET, "/books")
+		event.SourceMeta = &pb.TapEvent_EndpointMeta{Labels: map[string]string{label: "abc123"}}
+		options := newTapOptions()
+		options.traceIDFrom = label
+
+		line := renderTapEvent(event, "", options)
+		if !strings.Contains(line, " tls= trace=abc123 ") {
+			t.Fatalf("Expecting the line to contain [trace=abc123], got [%s]", line)
+		}
+
+		var m tapEvent
+		if err := json.Unmarshal([]byte(renderTapEventJSON(event, "", options)), &m); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if m.TraceID != "abc123" {
+			t.Fatalf("Expecting traceId [abc123], got [%s]", m.TraceID)
+		}
+
+		if line := renderTapEvent(event, "", newTapOptions()); strings.Contains(line, "trace=") {
+			t.Fatalf("Expecting no trace ID without --trace-id-from, got [%s]", line)
+		}
+	})
+}
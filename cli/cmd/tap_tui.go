@@ -0,0 +1,419 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"github.com/mattn/go-isatty"
+	runewidth "github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+)
+
+// tuiOutput is the `-o` value displaying events in an interactive,
+// full-screen view.
+const tuiOutput = "tui"
+
+// tuiBufferSize is the number of most recent events `-o tui` keeps for
+// scrolling back and filtering.
+const tuiBufferSize = 10000
+
+// tuiHelp describes the key bindings of `-o tui`.
+const tuiHelp = "/ filter  space pause  ↑↓ PgUp PgDn Home End scroll  w export  q quit"
+
+// errTUIClosed stops decoding the tap stream once the TUI is closed.
+var errTUIClosed = errors.New("tui closed")
+
+type tuiEntry struct {
+	event *pb.TapEvent
+	line  string
+	// fields are the `--filter` fields of the entry's transaction. They are
+	// shared by the entries of a transaction and updated as its events are
+	// observed, so that a request is displayed once its response matches.
+	fields map[string]string
+}
+
+// tuiBuffer is the model behind `-o tui`: the most recent events, the filter
+// selecting which of them are displayed, and the position of the view. While
+// paused, events are still buffered but the view doesn't change.
+type tuiBuffer struct {
+	size    int
+	entries []tuiEntry
+	// evicted is the number of entries dropped to keep the buffer within
+	// size, so that evicted+i is the position of entries[i] in the stream.
+	evicted int
+	// streams holds the events of in-flight streams and their shared fields.
+	streams map[streamKey]*tuiStream
+	bound   *streamBound
+
+	filter     filterExpr
+	filterText string
+	paused     bool
+	// pausedAt is the position in the stream of the first entry added while
+	// paused.
+	pausedAt int
+	// scroll is the number of matching lines below the view.
+	scroll int
+}
+
+type tuiStream struct {
+	events []*pb.TapEvent
+	fields map[string]string
+}
+
+func newTUIBuffer(size, pairingBuffer int) *tuiBuffer {
+	return &tuiBuffer{
+		size:    size,
+		streams: make(map[streamKey]*tuiStream),
+		bound:   newStreamBound(pairingBuffer),
+	}
+}
+
+// add buffers an event and its rendered line, evicting the oldest entry if
+// the buffer is full.
+func (b *tuiBuffer) add(event *pb.TapEvent, line string) {
+	key := newStreamKey(event)
+	stream, ok := b.streams[key]
+	if !ok {
+		if oldest, evict := b.bound.track(key); evict {
+			delete(b.streams, oldest)
+		}
+		stream = &tuiStream{fields: map[string]string{}}
+		b.streams[key] = stream
+	}
+	stream.events = append(stream.events, event)
+	for k, v := range transactionFields(stream.events) {
+		stream.fields[k] = v
+	}
+	if event.GetHttp().GetResponseEnd() != nil {
+		delete(b.streams, key)
+		b.bound.forget(key)
+	}
+
+	b.entries = append(b.entries, tuiEntry{event: event, line: line, fields: stream.fields})
+	if len(b.entries) > b.size {
+		b.entries = b.entries[1:]
+		b.evicted++
+	}
+}
+
+// total returns the number of events added to the buffer.
+func (b *tuiBuffer) total() int {
+	return b.evicted + len(b.entries)
+}
+
+// setFilter parses a `--filter` expression and displays only the matching
+// events. An empty expression displays all events. If the expression is
+// invalid, the current filter is kept.
+func (b *tuiBuffer) setFilter(text string) error {
+	var expr filterExpr
+	if text != "" {
+		var err error
+		expr, err = parseFilter(text)
+		if err != nil {
+			return err
+		}
+	}
+	b.filter, b.filterText = expr, text
+	b.scroll = 0
+	return nil
+}
+
+// togglePause pauses or resumes the view. Resuming scrolls back to the most
+// recent events.
+func (b *tuiBuffer) togglePause() {
+	b.paused = !b.paused
+	if b.paused {
+		b.pausedAt = b.total()
+	} else {
+		b.scroll = 0
+	}
+}
+
+// scrollBy moves the view n lines back in history, or forward if n is
+// negative. Scrolling back pauses the view, so that it isn't moved by new
+// events.
+func (b *tuiBuffer) scrollBy(n int) {
+	if n > 0 && !b.paused {
+		b.togglePause()
+	}
+	b.scroll += n
+	if b.scroll < 0 {
+		b.scroll = 0
+	}
+}
+
+// buffered returns the number of events added while paused.
+func (b *tuiBuffer) buffered() int {
+	if !b.paused {
+		return 0
+	}
+	return b.total() - b.pausedAt
+}
+
+// matching returns the lines of the displayable entries matching the filter.
+func (b *tuiBuffer) matching() []string {
+	entries := b.entries
+	if b.paused {
+		n := b.pausedAt - b.evicted
+		if n < 0 {
+			n = 0
+		}
+		entries = entries[:n]
+	}
+	lines := []string{}
+	for _, e := range entries {
+		if b.filter == nil || b.filter.eval(e.fields) {
+			lines = append(lines, e.line)
+		}
+	}
+	return lines
+}
+
+// view returns the lines displayed in a view of the given height, oldest
+// first.
+func (b *tuiBuffer) view(height int) []string {
+	lines := b.matching()
+	if max := len(lines) - height; b.scroll > max {
+		b.scroll = max
+	}
+	if b.scroll < 0 {
+		b.scroll = 0
+	}
+	end := len(lines) - b.scroll
+	start := end - height
+	if start < 0 {
+		start = 0
+	}
+	return lines[start:end]
+}
+
+// writeNDJSON writes the buffered events matching the filter, including those
+// added while paused, to w as NDJSON. It returns the number of events written.
+func (b *tuiBuffer) writeNDJSON(w io.Writer, options *tapOptions) (int, error) {
+	n := 0
+	for _, e := range b.entries {
+		if b.filter != nil && !b.filter.eval(e.fields) {
+			continue
+		}
+		if _, err := fmt.Fprintln(w, renderTapEventNDJSON(e.event, "", options)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// status returns the status line displayed below the events.
+func (b *tuiBuffer) status() string {
+	status := fmt.Sprintf("%d/%d events", len(b.matching()), b.total())
+	if b.filterText != "" {
+		status += fmt.Sprintf("  filter: %s", b.filterText)
+	}
+	if b.paused {
+		status += fmt.Sprintf("  PAUSED (%d buffered)", b.buffered())
+	}
+	return status
+}
+
+// tapTUI handles the input of `-o tui` and draws its buffer.
+type tapTUI struct {
+	buffer  *tuiBuffer
+	options *tapOptions
+	// editing is true while a filter is typed in the filter bar.
+	editing bool
+	input   []rune
+	// message is displayed in the filter bar, e.g. when a filter is invalid.
+	message string
+	height  int
+}
+
+func newTapTUI(buffer *tuiBuffer, options *tapOptions) *tapTUI {
+	return &tapTUI{buffer: buffer, options: options, message: tuiHelp}
+}
+
+// handleKey applies a key press, returning true if the TUI is to be closed.
+func (ui *tapTUI) handleKey(ev termbox.Event) bool {
+	if ev.Key == termbox.KeyCtrlC {
+		return true
+	}
+
+	if ui.editing {
+		switch {
+		case ev.Key == termbox.KeyEnter:
+			ui.editing = false
+			ui.message = tuiHelp
+			if err := ui.buffer.setFilter(string(ui.input)); err != nil {
+				ui.message = fmt.Sprintf("invalid filter: %s", err)
+			}
+		case ev.Key == termbox.KeyEsc:
+			ui.editing = false
+		case ev.Key == termbox.KeyBackspace || ev.Key == termbox.KeyBackspace2:
+			if len(ui.input) > 0 {
+				ui.input = ui.input[:len(ui.input)-1]
+			}
+		case ev.Key == termbox.KeySpace:
+			ui.input = append(ui.input, ' ')
+		case ev.Ch != 0:
+			ui.input = append(ui.input, ev.Ch)
+		}
+		return false
+	}
+
+	page := ui.height - 1
+	if page < 1 {
+		page = 1
+	}
+	switch {
+	case ev.Ch == 'q':
+		return true
+	case ev.Ch == '/':
+		ui.editing = true
+		ui.input = []rune(ui.buffer.filterText)
+	case ev.Ch == 'w':
+		ui.message = ui.export(fmt.Sprintf("tap-%s.ndjson", ui.options.now().Format("20060102-150405")))
+	case ev.Key == termbox.KeySpace:
+		ui.buffer.togglePause()
+	case ev.Key == termbox.KeyArrowUp:
+		ui.buffer.scrollBy(1)
+	case ev.Key == termbox.KeyArrowDown:
+		ui.buffer.scrollBy(-1)
+	case ev.Key == termbox.KeyPgup:
+		ui.buffer.scrollBy(page)
+	case ev.Key == termbox.KeyPgdn:
+		ui.buffer.scrollBy(-page)
+	case ev.Key == termbox.KeyHome:
+		ui.buffer.scrollBy(ui.buffer.total())
+	case ev.Key == termbox.KeyEnd:
+		ui.buffer.scrollBy(-ui.buffer.scroll)
+	}
+	return false
+}
+
+// export writes the buffered events matching the filter to a file as NDJSON,
+// so that they can be shared, returning a message describing the outcome.
+func (ui *tapTUI) export(path string) string {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Sprintf("export failed: %s", err)
+	}
+	n, err := ui.buffer.writeNDJSON(file, ui.options)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Sprintf("export failed: %s", err)
+	}
+	return fmt.Sprintf("Wrote %d events to %s", n, path)
+}
+
+// draw renders the events, a status line and the filter bar.
+func (ui *tapTUI) draw() {
+	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	width, height := termbox.Size()
+	ui.height = height - 2
+
+	for y, line := range ui.buffer.view(ui.height) {
+		tuiPrint(0, y, line, termbox.ColorDefault)
+	}
+	status := ui.buffer.status()
+	for runewidth.StringWidth(status) < width {
+		status += " "
+	}
+	tuiPrint(0, height-2, status, termbox.AttrReverse)
+	if ui.editing {
+		tuiPrint(0, height-1, "/"+string(ui.input)+"_", termbox.ColorDefault)
+	} else {
+		tuiPrint(0, height-1, ui.message, termbox.ColorDefault)
+	}
+
+	termbox.Flush()
+}
+
+func tuiPrint(x, y int, msg string, fg termbox.Attribute) {
+	for _, c := range msg {
+		termbox.SetCell(x, y, c, fg, termbox.ColorDefault)
+		x += runewidth.RuneWidth(c)
+	}
+}
+
+type tuiEvent struct {
+	event *pb.TapEvent
+	line  string
+}
+
+// renderTapEventsTUI displays the events of a tap stream in a full-screen
+// view until the user quits. Events are rendered as in "wide" output.
+func renderTapEventsTUI(tapByteStream *bufio.Reader, resource string, options *tapOptions) error {
+	if !isatty.IsTerminal(os.Stdout.Fd()) {
+		return fmt.Errorf("\"%s\" output requires a terminal", tuiOutput)
+	}
+	err := termbox.Init()
+	if err != nil {
+		return err
+	}
+	defer termbox.Close()
+
+	// for event processing:
+	// tapByteStream ->
+	//   forEachTapEvent() ->
+	//     eventCh ->
+	//       ui.buffer
+	eventCh := make(chan tuiEvent)
+	streamErrCh := make(chan error, 1)
+	keyCh := make(chan termbox.Event)
+	closed := make(chan struct{})
+	defer close(closed)
+
+	go func() {
+		streamErrCh <- forEachTapEvent(tapByteStream, options, func(event *pb.TapEvent) error {
+			select {
+			case eventCh <- tuiEvent{event, renderTapEvent(event, resource, options)}:
+				return nil
+			case <-closed:
+				return errTUIClosed
+			}
+		})
+	}()
+	go func() {
+		for {
+			ev := termbox.PollEvent()
+			if ev.Type != termbox.EventKey {
+				continue
+			}
+			select {
+			case keyCh <- ev:
+			case <-closed:
+				return
+			}
+		}
+	}()
+
+	ui := newTapTUI(newTUIBuffer(tuiBufferSize, options.pairingBuffer), options)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case e := <-eventCh:
+			ui.buffer.add(e.event, e.line)
+		case err := <-streamErrCh:
+			if err != nil {
+				return err
+			}
+			// The stream ended; the buffered events can still be browsed.
+			ui.message = "Tap stream terminated; " + tuiHelp
+			streamErrCh = nil
+		case ev := <-keyCh:
+			if ui.handleKey(ev) {
+				return nil
+			}
+			ui.draw()
+		case <-ticker.C:
+			ui.draw()
+		}
+	}
+}
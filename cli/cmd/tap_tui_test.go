@@ -0,0 +1,246 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"github.com/nsf/termbox-go"
+)
+
+// tuiTestBuffer returns a buffer holding a GET /books answered with 200
+// (stream 1), a POST /authors answered with 503 (stream 2) and a GET /books
+// still waiting for its response (stream 3).
+func tuiTestBuffer(size int) *tuiBuffer {
+	latency := &duration.Duration{Nanos: 1000}
+	b := newTUIBuffer(size, 0)
+	for _, event := range []*pb.TapEvent{
+		tapTestRequest(1, pb.HttpMethod_GET, "/books"),
+		tapTestRequest(2, pb.HttpMethod_POST, "/authors"),
+		tapTestResponse(1, http.StatusOK, latency),
+		tapTestResponse(2, http.StatusServiceUnavailable, latency),
+		tapTestEnd(1, &pb.Eos{}, 10),
+		tapTestEnd(2, &pb.Eos{}, 10),
+		tapTestRequest(3, pb.HttpMethod_GET, "/books"),
+	} {
+		b.add(event, renderTapEvent(event, "", newTapOptions()))
+	}
+	return b
+}
+
+func TestTUIBufferFilter(t *testing.T) {
+	testCases := []struct {
+		filter      string
+		expectedIDs []string
+	}{
+		{"", []string{
+			"req id=7:1", "req id=7:2", "rsp id=7:1", "rsp id=7:2", "end id=7:1", "end id=7:2", "req id=7:3",
+		}},
+		{"method == GET", []string{"req id=7:1", "rsp id=7:1", "end id=7:1", "req id=7:3"}},
+		// Requests are displayed once their response matches.
+		{`status =~ "^5"`, []string{"req id=7:2", "rsp id=7:2", "end id=7:2"}},
+		{`path =~ ^/books && status == 200`, []string{"req id=7:1", "rsp id=7:1", "end id=7:1"}},
+	}
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(tc.filter, func(t *testing.T) {
+			b := tuiTestBuffer(tuiBufferSize)
+			if err := b.setFilter(tc.filter); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			ids := renderedIDs(strings.Join(b.view(20), "\n"))
+			if fmt.Sprint(ids) != fmt.Sprint(tc.expectedIDs) {
+				t.Fatalf("Expecting %v, got %v", tc.expectedIDs, ids)
+			}
+		})
+	}
+
+	t.Run("Keeps the current filter if the new one is invalid", func(t *testing.T) {
+		b := tuiTestBuffer(tuiBufferSize)
+		if err := b.setFilter("method == POST"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := b.setFilter("method = GET"); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+		if b.filterText != "method == POST" || len(b.view(20)) != 3 {
+			t.Fatalf("Expecting the \"method == POST\" filter to be kept, got %q", b.filterText)
+		}
+	})
+}
+
+func TestTUIBufferView(t *testing.T) {
+	t.Run("Evicts the oldest events", func(t *testing.T) {
+		b := tuiTestBuffer(3)
+		ids := renderedIDs(strings.Join(b.view(20), "\n"))
+		expectedIDs := []string{"end id=7:1", "end id=7:2", "req id=7:3"}
+		if fmt.Sprint(ids) != fmt.Sprint(expectedIDs) {
+			t.Fatalf("Expecting %v, got %v", expectedIDs, ids)
+		}
+		if b.total() != 7 {
+			t.Fatalf("Expecting 7 events in total, got %d", b.total())
+		}
+	})
+
+	t.Run("Scrolls through history", func(t *testing.T) {
+		b := tuiTestBuffer(tuiBufferSize)
+		steps := []struct {
+			scroll      int
+			expectedIDs []string
+		}{
+			{0, []string{"end id=7:2", "req id=7:3"}},
+			{2, []string{"rsp id=7:2", "end id=7:1"}},
+			// The view stops at the oldest event.
+			{10, []string{"req id=7:1", "req id=7:2"}},
+			{-1, []string{"req id=7:2", "rsp id=7:1"}},
+			{-10, []string{"end id=7:2", "req id=7:3"}},
+		}
+		for i, step := range steps {
+			b.scrollBy(step.scroll)
+			ids := renderedIDs(strings.Join(b.view(2), "\n"))
+			if fmt.Sprint(ids) != fmt.Sprint(step.expectedIDs) {
+				t.Fatalf("Step %d: expecting %v, got %v", i, step.expectedIDs, ids)
+			}
+		}
+	})
+
+	t.Run("Buffers events while paused", func(t *testing.T) {
+		b := tuiTestBuffer(tuiBufferSize)
+		b.togglePause()
+		b.add(tapTestEnd(3, &pb.Eos{}, 10), "end id=7:3")
+
+		ids := renderedIDs(strings.Join(b.view(1), "\n"))
+		if fmt.Sprint(ids) != "[req id=7:3]" {
+			t.Fatalf("Expecting the view not to move while paused, got %v", ids)
+		}
+		if status := b.status(); !strings.HasSuffix(status, "PAUSED (1 buffered)") {
+			t.Fatalf("Expecting the status to show a buffered event, got %q", status)
+		}
+
+		b.togglePause()
+		ids = renderedIDs(strings.Join(b.view(1), "\n"))
+		if fmt.Sprint(ids) != "[end id=7:3]" {
+			t.Fatalf("Expecting the buffered event once resumed, got %v", ids)
+		}
+		if status := b.status(); status != "8/8 events" {
+			t.Fatalf("Expecting \"8/8 events\", got %q", status)
+		}
+	})
+
+	t.Run("Pauses when scrolling back", func(t *testing.T) {
+		b := tuiTestBuffer(tuiBufferSize)
+		b.scrollBy(1)
+		if !b.paused {
+			t.Fatal("Expecting the view to be paused")
+		}
+	})
+}
+
+func TestTapTUIHandleKey(t *testing.T) {
+	ui := newTapTUI(tuiTestBuffer(tuiBufferSize), newTapOptions())
+	typed := func(s string) {
+		for _, c := range s {
+			if c == ' ' {
+				ui.handleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeySpace})
+			} else {
+				ui.handleKey(termbox.Event{Type: termbox.EventKey, Ch: c})
+			}
+		}
+	}
+	enter := termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEnter}
+
+	typed("/method == PUTT")
+	ui.handleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyBackspace2})
+	if !ui.editing || string(ui.input) != "method == PUT" {
+		t.Fatalf("Expecting \"method == PUT\" to be typed, got %q", string(ui.input))
+	}
+	ui.handleKey(enter)
+	if ui.editing || ui.buffer.filterText != "method == PUT" {
+		t.Fatalf("Expecting the \"method == PUT\" filter, got %q", ui.buffer.filterText)
+	}
+
+	typed("/ &&")
+	ui.handleKey(enter)
+	if !strings.HasPrefix(ui.message, "invalid filter") {
+		t.Fatalf("Expecting an invalid filter message, got %q", ui.message)
+	}
+	if ui.buffer.filterText != "method == PUT" {
+		t.Fatalf("Expecting the \"method == PUT\" filter to be kept, got %q", ui.buffer.filterText)
+	}
+
+	typed(" ")
+	if !ui.buffer.paused {
+		t.Fatal("Expecting space to pause the view")
+	}
+	if !ui.handleKey(termbox.Event{Type: termbox.EventKey, Ch: 'q'}) {
+		t.Fatal("Expecting q to close the TUI")
+	}
+}
+
+func TestTUIBufferWriteNDJSON(t *testing.T) {
+	b := tuiTestBuffer(tuiBufferSize)
+	if err := b.setFilter("method == GET"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Events added while paused are exported too.
+	b.togglePause()
+	b.add(tapTestEnd(3, &pb.Eos{}, 10), "end id=7:3")
+
+	var out bytes.Buffer
+	n, err := b.writeNDJSON(&out, newTapOptions())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if n != 5 || len(lines) != 5 {
+		t.Fatalf("Expecting 5 events, got %d in %d lines", n, len(lines))
+	}
+
+	expectedEvents := []string{"requestInitEvent", "responseInitEvent", "responseEndEvent", "requestInitEvent", "responseEndEvent"}
+	for i, line := range lines {
+		event := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("Expecting line %d to be a JSON document, got %q: %v", i, line, err)
+		}
+		if _, ok := event[expectedEvents[i]]; !ok {
+			t.Fatalf("Expecting line %d to hold a %s, got %q", i, expectedEvents[i], line)
+		}
+		id := event[expectedEvents[i]].(map[string]interface{})["id"].(map[string]interface{})
+		if stream := id["stream"]; stream != float64(1) && stream != float64(3) {
+			t.Fatalf("Expecting line %d to belong to a GET, got stream %v", i, stream)
+		}
+	}
+
+	t.Run("Exports to a file", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "tap")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer os.RemoveAll(dir)
+
+		ui := newTapTUI(b, newTapOptions())
+		path := filepath.Join(dir, "tap.ndjson")
+		if message := ui.export(path); message != fmt.Sprintf("Wrote 5 events to %s", path) {
+			t.Fatalf("Unexpected message: %s", message)
+		}
+		exported, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if string(exported) != out.String() {
+			t.Fatalf("Expecting %q, got %q", out.String(), string(exported))
+		}
+
+		if message := ui.export(filepath.Join(dir, "missing", "tap.ndjson")); !strings.HasPrefix(message, "export failed") {
+			t.Fatalf("Expecting the export to fail, got %q", message)
+		}
+	})
+}
@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"github.com/linkerd/linkerd2/pkg/k8s"
+	"github.com/linkerd/linkerd2/pkg/protohttp"
+	"github.com/linkerd/linkerd2/pkg/tap"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// podTapMerger taps each running pod of the resource targeted by `--watch`
+// separately, as pods come and go, and merges the events of these taps into
+// a single tap stream. This way the stream survives rollouts, whose new pods
+// a tap of the resource itself wouldn't see.
+//
+// Each pod's tap keeps the request's settings, so `--max-rps` applies to each
+// pod rather than to the merged stream.
+type podTapMerger struct {
+	req       *pb.TapByResourceRequest
+	watchPods func() (watch.Interface, error)
+	tapPod    func(*pb.TapByResourceRequest) (*bufio.Reader, io.ReadCloser, error)
+
+	reader *io.PipeReader
+	writer *io.PipeWriter
+	// writeMu serializes the events written to writer by each pod's tap.
+	writeMu sync.Mutex
+
+	sync.Mutex
+	// taps holds the open tap stream of each pod, by namespace and name.
+	taps   map[string]io.Closer
+	closed bool
+	done   chan struct{}
+}
+
+func newPodTapMerger(
+	req *pb.TapByResourceRequest,
+	watchPods func() (watch.Interface, error),
+	tapPod func(*pb.TapByResourceRequest) (*bufio.Reader, io.ReadCloser, error),
+) *podTapMerger {
+	reader, writer := io.Pipe()
+	return &podTapMerger{
+		req:       req,
+		watchPods: watchPods,
+		tapPod:    tapPod,
+		reader:    reader,
+		writer:    writer,
+		taps:      make(map[string]io.Closer),
+		done:      make(chan struct{}),
+	}
+}
+
+// watchTapReader returns the tap stream merging the taps of each pod of the
+// resource targeted by req, as returned by tap.Reader.
+func watchTapReader(k8sAPI *k8s.KubernetesAPI, req *pb.TapByResourceRequest) (*bufio.Reader, io.ReadCloser, error) {
+	res := req.GetTarget().GetResource()
+	namespace := res.GetNamespace()
+	var listOptions metav1.ListOptions
+	switch res.GetType() {
+	case k8s.Namespace:
+		// All the pods of the namespace, or of all namespaces if it isn't
+		// named.
+		namespace = res.GetName()
+	case k8s.Pod:
+		listOptions.FieldSelector = fields.OneTermEqualSelector("metadata.name", res.GetName()).String()
+	default:
+		matchLabels, err := getPodSelectorFor(k8sAPI, namespace, res)
+		if err != nil {
+			return nil, nil, err
+		}
+		listOptions.LabelSelector = labels.Set(matchLabels).AsSelector().String()
+	}
+
+	merger := newPodTapMerger(req,
+		func() (watch.Interface, error) {
+			return k8sAPI.CoreV1().Pods(namespace).Watch(listOptions)
+		},
+		func(req *pb.TapByResourceRequest) (*bufio.Reader, io.ReadCloser, error) {
+			return tap.Reader(k8sAPI, req, 0)
+		},
+	)
+	return merger.start()
+}
+
+// start watches the pods and returns the merged tap stream, which ends once
+// it is closed.
+func (m *podTapMerger) start() (*bufio.Reader, io.ReadCloser, error) {
+	w, err := m.watchPods()
+	if err != nil {
+		return nil, nil, err
+	}
+	go m.run(w)
+	return bufio.NewReader(m.reader), m, nil
+}
+
+// run taps pods as they start running and stops tapping them as they stop,
+// until the merger is closed. The watch is re-established whenever the API
+// server ends it.
+func (m *podTapMerger) run(w watch.Interface) {
+	for {
+		select {
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				select {
+				case <-m.done:
+					return
+				default:
+				}
+				var err error
+				if w, err = m.watchPods(); err != nil {
+					m.writer.CloseWithError(fmt.Errorf("failed to watch pods: %s", err))
+					return
+				}
+				continue
+			}
+			if event.Type == watch.Error {
+				// The API server ends the watch after an error, so it is
+				// re-established above.
+				fmt.Fprintf(os.Stderr, "error watching pods: %s\n", apierrors.FromObject(event.Object))
+				continue
+			}
+			pod, ok := event.Object.(*corev1.Pod)
+			if !ok {
+				continue
+			}
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				if pod.Status.Phase == corev1.PodRunning && pod.DeletionTimestamp == nil {
+					m.tap(pod.Namespace, pod.Name)
+				} else {
+					m.untap(pod.Namespace, pod.Name)
+				}
+			case watch.Deleted:
+				m.untap(pod.Namespace, pod.Name)
+			}
+		case <-m.done:
+			w.Stop()
+			return
+		}
+	}
+}
+
+// tap opens a tap stream to a pod, unless one is already open.
+func (m *podTapMerger) tap(namespace, name string) {
+	key := namespace + "/" + name
+	m.Lock()
+	_, tapped := m.taps[key]
+	m.Unlock()
+	if tapped {
+		return
+	}
+
+	req := proto.Clone(m.req).(*pb.TapByResourceRequest)
+	req.Target.Resource = &pb.Resource{
+		Namespace: namespace,
+		Type:      k8s.Pod,
+		Name:      name,
+	}
+	reader, body, err := m.tapPod(req)
+	if err != nil {
+		// The pod is tapped again on its next update, e.g. once its proxy
+		// is ready.
+		fmt.Fprintf(os.Stderr, "failed to tap pod/%s: %s\n", key, err)
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	if m.closed {
+		body.Close()
+		return
+	}
+	log.Debugf("tapping pod/%s", key)
+	m.taps[key] = body
+	go m.forward(key, reader, body)
+}
+
+// untap closes the tap stream of a pod, if any.
+func (m *podTapMerger) untap(namespace, name string) {
+	key := namespace + "/" + name
+	m.Lock()
+	defer m.Unlock()
+	if body, ok := m.taps[key]; ok {
+		log.Debugf("stopped tapping pod/%s", key)
+		body.Close()
+		delete(m.taps, key)
+	}
+}
+
+// forward writes the events of a pod's tap stream to the merged stream until
+// the pod's stream ends.
+func (m *podTapMerger) forward(key string, reader *bufio.Reader, body io.ReadCloser) {
+	defer func() {
+		m.Lock()
+		if m.taps[key] == body {
+			delete(m.taps, key)
+		}
+		m.Unlock()
+		body.Close()
+	}()
+
+	decoder := tap.NewDecoder(reader)
+	for {
+		event, err := decoder.Decode()
+		if err != nil {
+			if err != io.EOF {
+				log.Debugf("tap of pod/%s ended: %s", key, err)
+			}
+			return
+		}
+		b, err := proto.Marshal(event)
+		if err != nil {
+			log.Debugf("failed to marshal an event of pod/%s: %s", key, err)
+			continue
+		}
+
+		m.writeMu.Lock()
+		_, err = m.writer.Write(protohttp.SerializeAsPayload(b))
+		m.writeMu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Close stops watching pods, closes the tap stream of each pod and ends the
+// merged stream.
+func (m *podTapMerger) Close() error {
+	m.Lock()
+	defer m.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	close(m.done)
+	for name, body := range m.taps {
+		body.Close()
+		delete(m.taps, name)
+	}
+	return m.reader.Close()
+}
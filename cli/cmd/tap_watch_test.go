@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"bufio"
+	"io"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"github.com/linkerd/linkerd2/pkg/k8s"
+	"github.com/linkerd/linkerd2/pkg/protohttp"
+	"github.com/linkerd/linkerd2/pkg/tap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestPodTapMerger(t *testing.T) {
+	podIn := func(namespace, name string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Status:     corev1.PodStatus{Phase: phase},
+		}
+	}
+	pod := func(name string, phase corev1.PodPhase) *corev1.Pod {
+		return podIn("default", name, phase)
+	}
+	send := func(w *io.PipeWriter, event *pb.TapEvent) error {
+		b, err := proto.Marshal(event)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		_, err = w.Write(protohttp.SerializeAsPayload(b))
+		return err
+	}
+
+	type podTap struct {
+		req    *pb.TapByResourceRequest
+		writer *io.PipeWriter
+	}
+	taps := make(chan podTap, 10)
+	watcher := watch.NewFake()
+	req := &pb.TapByResourceRequest{
+		Target: &pb.ResourceSelection{
+			Resource: &pb.Resource{Namespace: "default", Type: k8s.Deployment, Name: "books"},
+		},
+		MaxRps: 10,
+	}
+	merger := newPodTapMerger(req,
+		func() (watch.Interface, error) { return watcher, nil },
+		func(req *pb.TapByResourceRequest) (*bufio.Reader, io.ReadCloser, error) {
+			reader, writer := io.Pipe()
+			taps <- podTap{req: req, writer: writer}
+			return bufio.NewReader(reader), reader, nil
+		},
+	)
+	reader, body, err := merger.start()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	decoder := tap.NewDecoder(reader)
+
+	expectTapIn := func(namespace, name string) *io.PipeWriter {
+		opened := <-taps
+		res := opened.req.GetTarget().GetResource()
+		if res.GetType() != k8s.Pod || res.GetName() != name || res.GetNamespace() != namespace {
+			t.Fatalf("Expecting a tap of pod/%s in %s, got %v", name, namespace, res)
+		}
+		if opened.req.GetMaxRps() != 10 {
+			t.Fatalf("Expecting the tap of pod/%s to keep the request's settings, got %v", name, opened.req)
+		}
+		return opened.writer
+	}
+	expectTap := func(name string) *io.PipeWriter {
+		return expectTapIn("default", name)
+	}
+	expectEvent := func(w *io.PipeWriter, path string) {
+		if err := send(w, tapTestRequest(1, pb.HttpMethod_GET, path)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		event, err := decoder.Decode()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if p := event.GetHttp().GetRequestInit().GetPath(); p != path {
+			t.Fatalf("Expecting path [%s], got [%s]", path, p)
+		}
+	}
+
+	watcher.Add(pod("books-1", corev1.PodRunning))
+	books1 := expectTap("books-1")
+	expectEvent(books1, "/books-1")
+
+	t.Run("Taps each pod once", func(t *testing.T) {
+		watcher.Modify(pod("books-1", corev1.PodRunning))
+		watcher.Add(pod("books-2", corev1.PodRunning))
+		books2 := expectTap("books-2")
+		expectEvent(books2, "/books-2")
+		expectEvent(books1, "/books-1")
+	})
+
+	t.Run("Only taps running pods", func(t *testing.T) {
+		watcher.Add(pod("books-3", corev1.PodPending))
+		watcher.Add(pod("books-4", corev1.PodRunning))
+		expectEvent(expectTap("books-4"), "/books-4")
+
+		watcher.Modify(pod("books-3", corev1.PodRunning))
+		expectEvent(expectTap("books-3"), "/books-3")
+	})
+
+	t.Run("Stops tapping deleted pods", func(t *testing.T) {
+		watcher.Delete(pod("books-1", corev1.PodRunning))
+		// The tap of the next pod is opened once the deletion is handled.
+		watcher.Add(pod("books-5", corev1.PodRunning))
+		books5 := expectTap("books-5")
+		if err := send(books1, tapTestRequest(2, pb.HttpMethod_GET, "/books-1")); err == nil {
+			t.Fatal("Expecting the tap of a deleted pod to be closed")
+		}
+		expectEvent(books5, "/books-5")
+	})
+
+	t.Run("Taps recreated pods again", func(t *testing.T) {
+		watcher.Add(pod("books-1", corev1.PodRunning))
+		expectEvent(expectTap("books-1"), "/books-1")
+	})
+
+	t.Run("Taps pods in their own namespace", func(t *testing.T) {
+		// Namespace targets watch the pods of a whole namespace, or of all
+		// of them.
+		watcher.Add(podIn("other", "books-1", corev1.PodRunning))
+		expectEvent(expectTapIn("other", "books-1"), "/other/books-1")
+	})
+
+	t.Run("Keeps tapping pods after watch errors", func(t *testing.T) {
+		watcher.Error(&metav1.Status{Status: metav1.StatusFailure, Message: "too old resource version", Code: 410})
+		watcher.Add(pod("books-6", corev1.PodRunning))
+		expectEvent(expectTap("books-6"), "/books-6")
+	})
+
+	t.Run("Ends the merged stream once closed", func(t *testing.T) {
+		if err := body.Close(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := decoder.Decode(); err == nil {
+			t.Fatal("Expecting the merged stream to end")
+		}
+	})
+}
+
+func TestTapOptionsValidateWatch(t *testing.T) {
+	t.Run("Rejects --watch with --replay", func(t *testing.T) {
+		options := newTapOptions()
+		options.watch = true
+		options.replay = "tap.raw"
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+
+	t.Run("Rejects --watch with --address", func(t *testing.T) {
+		options := newTapOptions()
+		options.watch = true
+		options.address = "localhost:8089"
+		if err := options.validate(); err == nil {
+			t.Fatal("Expected error, got nothing")
+		}
+	})
+}
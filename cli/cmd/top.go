@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"math/rand"
 	"sort"
 	"strconv"
 	"strings"
@@ -34,6 +35,7 @@ type topOptions struct {
 	path        string
 	hideSources bool
 	routes      bool
+	minRequests int
 }
 
 type topRequest struct {
@@ -80,9 +82,19 @@ type tableRow struct {
 	last        time.Duration
 	successes   int
 	failures    int
+	// responseBytes holds a uniform sample of at most responseSizeSamples of
+	// the sizes of the responses; responses counts them all, and totalBytes
+	// is their sum.
+	responseBytes []uint64
+	responses     uint64
+	totalBytes    uint64
+	// inFlight is the number of requests to the destination whose response
+	// hasn't ended yet.
+	inFlight int
 }
 
 func (r tableRow) merge(other tableRow) tableRow {
+	responses := r.responses + other.responses
 	r.count += other.count
 	if other.best.Nanoseconds() < r.best.Nanoseconds() {
 		r.best = other.best
@@ -93,9 +105,48 @@ func (r tableRow) merge(other tableRow) tableRow {
 	r.last = other.last
 	r.successes += other.successes
 	r.failures += other.failures
+	for _, size := range other.responseBytes {
+		r.addResponseSize(size)
+	}
+	// other may have sampled its sizes already.
+	r.responses = responses
+	r.totalBytes += other.totalBytes
 	return r
 }
 
+// addResponseSize records the size of a response, replacing a random one of
+// those recorded with a probability keeping them a uniform sample once
+// responseSizeSamples are, like transactionStats.addLatency.
+func (r *tableRow) addResponseSize(size uint64) {
+	r.responses++
+	if len(r.responseBytes) < responseSizeSamples {
+		r.responseBytes = append(r.responseBytes, size)
+		return
+	}
+	if i := rand.Int63n(int64(r.responses)); i < responseSizeSamples {
+		r.responseBytes[i] = size
+	}
+}
+
+// averageBytes returns the average size of the responses.
+func (r tableRow) averageBytes() uint64 {
+	if r.responses == 0 {
+		return 0
+	}
+	return r.totalBytes / r.responses
+}
+
+// p99Bytes returns the smallest of the sampled response sizes greater than or
+// equal to 99% of them.
+func (r tableRow) p99Bytes() uint64 {
+	if len(r.responseBytes) == 0 {
+		return 0
+	}
+	sorted := append([]uint64(nil), r.responseBytes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[(len(sorted)*99+99)/100-1]
+}
+
 type column int
 
 const (
@@ -109,6 +160,9 @@ const (
 	worstColumn
 	lastColumn
 	successRateColumn
+	averageBytesColumn
+	p99BytesColumn
+	inFlightColumn
 
 	columnCount
 )
@@ -116,10 +170,16 @@ const (
 type topTable struct {
 	columns [columnCount]tableColumn
 	rows    []tableRow
+	// inFlight counts, by destination, the requests whose response hasn't
+	// ended yet.
+	inFlight map[string]int
+	// minRequests is the number of requests under which rows are folded
+	// into a single (other) row when rendered.
+	minRequests int
 }
 
 func newTopTable() *topTable {
-	table := topTable{}
+	table := topTable{inFlight: make(map[string]int)}
 
 	table.columns[sourceColumn] =
 		tableColumn{
@@ -246,12 +306,54 @@ func newTopTable() *topTable {
 			},
 		}
 
+	table.columns[averageBytesColumn] =
+		tableColumn{
+			header:     "Avg Bytes",
+			width:      9,
+			key:        false,
+			display:    true,
+			flexible:   false,
+			rightAlign: true,
+			value: func(r tableRow) string {
+				return formatByteSize(r.averageBytes())
+			},
+		}
+
+	table.columns[p99BytesColumn] =
+		tableColumn{
+			header:     "P99 Bytes",
+			width:      9,
+			key:        false,
+			display:    true,
+			flexible:   false,
+			rightAlign: true,
+			value: func(r tableRow) string {
+				return formatByteSize(r.p99Bytes())
+			},
+		}
+
+	table.columns[inFlightColumn] =
+		tableColumn{
+			header:     "In Flight",
+			width:      9,
+			key:        false,
+			display:    true,
+			flexible:   false,
+			rightAlign: true,
+			value: func(r tableRow) string {
+				return strconv.Itoa(r.inFlight)
+			},
+		}
+
 	return &table
 }
 
 const (
 	headerHeight  = 3
 	columnSpacing = 2
+	// responseSizeSamples bounds the number of response sizes each row
+	// records to compute their p99.
+	responseSizeSamples = 1000
 )
 
 func newTopOptions() *topOptions {
@@ -266,6 +368,7 @@ func newTopOptions() *topOptions {
 		path:        "",
 		hideSources: false,
 		routes:      false,
+		minRequests: 0,
 	}
 }
 
@@ -326,6 +429,11 @@ func newCmdTop() *cobra.Command {
 				table.columns[sourceColumn].display = false
 			}
 
+			if options.minRequests < 0 {
+				return fmt.Errorf("--min-requests must be non-negative, got %d", options.minRequests)
+			}
+			table.minRequests = options.minRequests
+
 			if options.routes {
 				table.columns[methodColumn].key = false
 				table.columns[methodColumn].display = false
@@ -367,6 +475,8 @@ func newCmdTop() *cobra.Command {
 		"Display requests with paths that start with this prefix")
 	cmd.PersistentFlags().BoolVar(&options.hideSources, "hide-sources", options.hideSources, "Hide the source column")
 	cmd.PersistentFlags().BoolVar(&options.routes, "routes", options.routes, "Display data per route instead of per path")
+	cmd.PersistentFlags().IntVar(&options.minRequests, "min-requests", options.minRequests,
+		"Fold the rows with fewer than this many requests into a single (other) row")
 
 	return cmd
 }
@@ -450,10 +560,12 @@ func processEvents(eventCh <-chan pb.TapEvent, requestCh chan<- topRequest, done
 			switch ev := event.GetHttp().GetEvent().(type) {
 			case *pb.TapEvent_Http_RequestInit_:
 				id.stream = ev.RequestInit.GetId().Stream
-				outstandingRequests[id] = topRequest{
+				req := topRequest{
 					event:   &event,
 					reqInit: ev.RequestInit,
 				}
+				outstandingRequests[id] = req
+				requestCh <- req
 
 			case *pb.TapEvent_Http_ResponseInit_:
 				id.stream = ev.ResponseInit.GetId().Stream
@@ -467,6 +579,7 @@ func processEvents(eventCh <-chan pb.TapEvent, requestCh chan<- topRequest, done
 			case *pb.TapEvent_Http_ResponseEnd_:
 				id.stream = ev.ResponseEnd.GetId().Stream
 				if req, ok := outstandingRequests[id]; ok {
+					delete(outstandingRequests, id)
 					req.rspEnd = ev.ResponseEnd
 					requestCh <- req
 				} else {
@@ -519,10 +632,7 @@ func newRow(req topRequest) (tableRow, error) {
 	if pod := req.event.SourceMeta.Labels["pod"]; pod != "" {
 		source = pod
 	}
-	destination := stripPort(addr.PublicAddressToString(req.event.GetDestination()))
-	if pod := req.event.DestinationMeta.Labels["pod"]; pod != "" {
-		destination = pod
-	}
+	destination := requestDestination(req.event)
 
 	latency, err := ptypes.Duration(req.rspEnd.GetSinceRequestInit())
 	if err != nil {
@@ -549,27 +659,52 @@ func newRow(req topRequest) (tableRow, error) {
 		failures = 1
 	}
 
+	size := req.rspEnd.GetResponseBytes()
 	return tableRow{
-		path:        path,
-		method:      method,
-		route:       route,
-		source:      source,
-		destination: destination,
-		best:        latency,
-		worst:       latency,
-		last:        latency,
-		count:       1,
-		successes:   successes,
-		failures:    failures,
+		path:          path,
+		method:        method,
+		route:         route,
+		source:        source,
+		destination:   destination,
+		best:          latency,
+		worst:         latency,
+		last:          latency,
+		count:         1,
+		successes:     successes,
+		failures:      failures,
+		responseBytes: []uint64{size},
+		responses:     1,
+		totalBytes:    size,
 	}, nil
 }
 
+// requestDestination returns the destination pod of a request, or its IP if
+// the pod is unknown.
+func requestDestination(event *pb.TapEvent) string {
+	if pod := event.GetDestinationMeta().GetLabels()["pod"]; pod != "" {
+		return pod
+	}
+	return stripPort(addr.PublicAddressToString(event.GetDestination()))
+}
+
+// insert accounts for a request that started, or whose response ended. Only
+// the latter are merged into the rows of the table.
 func (t *topTable) insert(req topRequest) {
+	destination := requestDestination(req.event)
+	if req.rspEnd == nil {
+		t.setInFlight(destination, t.inFlight[destination]+1)
+		return
+	}
+	if t.inFlight[destination] > 0 {
+		t.setInFlight(destination, t.inFlight[destination]-1)
+	}
+
 	insert, err := newRow(req)
 	if err != nil {
 		log.Error(err.Error())
 		return
 	}
+	insert.inFlight = t.inFlight[destination]
 
 	found := false
 	// Search for a matching row
@@ -595,6 +730,17 @@ func (t *topTable) insert(req topRequest) {
 	}
 }
 
+// setInFlight updates the number of requests in flight to a destination, and
+// the rows of that destination.
+func (t *topTable) setInFlight(destination string, n int) {
+	t.inFlight[destination] = n
+	for i := range t.rows {
+		if t.rows[i].destination == destination {
+			t.rows[i].inFlight = n
+		}
+	}
+}
+
 func stripPort(address string) string {
 	return strings.Split(address, ":")[0]
 }
@@ -616,12 +762,13 @@ func (t *topTable) renderHeaders() {
 }
 
 func (t *topTable) adjustColumnWidths() {
+	rows := t.displayedRows()
 	for i, col := range t.columns {
 		if !col.flexible {
 			continue
 		}
 		t.columns[i].width = runewidth.StringWidth(col.header)
-		for _, row := range t.rows {
+		for _, row := range rows {
 			cellWidth := runewidth.StringWidth(col.value(row))
 			if cellWidth > t.columns[i].width {
 				t.columns[i].width = cellWidth
@@ -630,12 +777,43 @@ func (t *topTable) adjustColumnWidths() {
 	}
 }
 
-func (t *topTable) renderBody() {
+// displayedRows returns the rows of the table by descending number of
+// requests, those with fewer than minRequests folded into a last (other)
+// row.
+func (t *topTable) displayedRows() []tableRow {
 	sort.SliceStable(t.rows, func(i, j int) bool {
 		return t.rows[i].count > t.rows[j].count
 	})
+	n := len(t.rows)
+	for n > 0 && t.rows[n-1].count < t.minRequests {
+		n--
+	}
+	if n == len(t.rows) {
+		return t.rows
+	}
 
-	for i, row := range t.rows {
+	folded := t.rows[n:]
+	other := folded[0]
+	// The sizes sampled are copied, as merge may replace some of them.
+	other.responseBytes = append([]uint64(nil), other.responseBytes...)
+	for _, row := range folded[1:] {
+		other = other.merge(row)
+	}
+	other.path, other.method, other.route = "(other)", "", "(other)"
+	other.source, other.destination = "(other)", "(other)"
+	destinations := map[string]bool{}
+	other.inFlight = 0
+	for _, row := range folded {
+		if !destinations[row.destination] {
+			destinations[row.destination] = true
+			other.inFlight += row.inFlight
+		}
+	}
+	return append(t.rows[:n:n], other)
+}
+
+func (t *topTable) renderBody() {
+	for i, row := range t.displayedRows() {
 		x := 0
 
 		for _, col := range t.columns {
@@ -676,3 +854,19 @@ func formatDuration(d time.Duration) string {
 	}
 	return d.Round(time.Second).String()
 }
+
+// formatByteSize renders a byte count in the largest binary unit it is at
+// least one of, e.g. 512B or 1.5KB.
+func formatByteSize(n uint64) string {
+	units := []string{"B", "KB", "MB", "GB"}
+	size := float64(n)
+	unit := 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%dB", n)
+	}
+	return fmt.Sprintf("%.1f%s", size, units[unit])
+}
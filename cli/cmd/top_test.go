@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/duration"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+)
+
+func TestTopTableInFlight(t *testing.T) {
+	toPod := func(pod string, event *pb.TapEvent) *pb.TapEvent {
+		event.SourceMeta = &pb.TapEvent_EndpointMeta{Labels: map[string]string{"pod": "web"}}
+		event.DestinationMeta = &pb.TapEvent_EndpointMeta{Labels: map[string]string{"pod": pod}}
+		return event
+	}
+	started := func(stream uint64, pod string) topRequest {
+		event := toPod(pod, tapTestRequest(stream, pb.HttpMethod_GET, "/books"))
+		return topRequest{event: event, reqInit: event.GetHttp().GetRequestInit()}
+	}
+	ended := func(req topRequest) topRequest {
+		stream := req.reqInit.GetId().GetStream()
+		req.rspInit = tapTestResponse(stream, http.StatusOK, &duration.Duration{Nanos: 1000}).GetHttp().GetResponseInit()
+		req.rspEnd = tapTestEnd(stream, &pb.Eos{}, 10).GetHttp().GetResponseEnd()
+		return req
+	}
+	rowsInFlight := func(table *topTable) map[string]int {
+		inFlight := map[string]int{}
+		for _, row := range table.rows {
+			inFlight[row.destination] = row.inFlight
+		}
+		return inFlight
+	}
+
+	table := newTopTable()
+	books1 := started(1, "books")
+	books2 := started(2, "books")
+	books3 := started(3, "books")
+	authors := started(4, "authors")
+	steps := []struct {
+		req              topRequest
+		expectedInFlight map[string]int
+		expectedRows     map[string]int
+	}{
+		{books1, map[string]int{"books": 1}, map[string]int{}},
+		{books2, map[string]int{"books": 2}, map[string]int{}},
+		{authors, map[string]int{"books": 2, "authors": 1}, map[string]int{}},
+		{ended(books1), map[string]int{"books": 1, "authors": 1}, map[string]int{"books": 1}},
+		{books3, map[string]int{"books": 2, "authors": 1}, map[string]int{"books": 2}},
+		{ended(authors), map[string]int{"books": 2, "authors": 0}, map[string]int{"books": 2, "authors": 0}},
+		{ended(books3), map[string]int{"books": 1, "authors": 0}, map[string]int{"books": 1, "authors": 0}},
+		{ended(books2), map[string]int{"books": 0, "authors": 0}, map[string]int{"books": 0, "authors": 0}},
+	}
+
+	for i, step := range steps {
+		table.insert(step.req)
+		if fmt.Sprint(table.inFlight) != fmt.Sprint(step.expectedInFlight) {
+			t.Fatalf("Step %d: expecting in-flight requests %v, got %v", i, step.expectedInFlight, table.inFlight)
+		}
+		if rows := rowsInFlight(table); fmt.Sprint(rows) != fmt.Sprint(step.expectedRows) {
+			t.Fatalf("Step %d: expecting in-flight requests in rows %v, got %v", i, step.expectedRows, rows)
+		}
+	}
+
+	if value := table.columns[inFlightColumn].value(table.rows[0]); value != "0" {
+		t.Fatalf("Expecting \"0\" in the In Flight column, got %q", value)
+	}
+}
+
+func TestTopTableResponseBytes(t *testing.T) {
+	ended := func(stream uint64, path string, size uint64) topRequest {
+		event := tapTestRequest(stream, pb.HttpMethod_GET, path)
+		event.SourceMeta = &pb.TapEvent_EndpointMeta{Labels: map[string]string{"pod": "web"}}
+		return topRequest{
+			event:   event,
+			reqInit: event.GetHttp().GetRequestInit(),
+			rspInit: tapTestResponse(stream, http.StatusOK, &duration.Duration{Nanos: 1000}).GetHttp().GetResponseInit(),
+			rspEnd:  tapTestEnd(stream, &pb.Eos{}, size).GetHttp().GetResponseEnd(),
+		}
+	}
+
+	table := newTopTable()
+	stream := uint64(0)
+	for _, size := range []uint64{300, 100, 200} {
+		stream++
+		table.insert(ended(stream, "/books", size))
+	}
+	// A single large response skews the average size of /authors, but not
+	// its p99.
+	for i := 0; i < 99; i++ {
+		stream++
+		table.insert(ended(stream, "/authors", 1024))
+	}
+	stream++
+	table.insert(ended(stream, "/authors", 10*1024*1024))
+
+	testCases := []struct {
+		path            string
+		expectedAverage uint64
+		expectedP99     uint64
+		expectedColumns []string
+	}{
+		{"/books", 200, 300, []string{"200B", "300B"}},
+		{"/authors", (99*1024 + 10*1024*1024) / 100, 1024, []string{"103.4KB", "1.0KB"}},
+	}
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(tc.path, func(t *testing.T) {
+			var row *tableRow
+			for i := range table.rows {
+				if table.rows[i].path == tc.path {
+					row = &table.rows[i]
+				}
+			}
+			if row == nil {
+				t.Fatalf("Expecting a row for %s", tc.path)
+			}
+			if average := row.averageBytes(); average != tc.expectedAverage {
+				t.Fatalf("Expecting an average of %d bytes, got %d", tc.expectedAverage, average)
+			}
+			if p99 := row.p99Bytes(); p99 != tc.expectedP99 {
+				t.Fatalf("Expecting a p99 of %d bytes, got %d", tc.expectedP99, p99)
+			}
+			columns := []string{
+				table.columns[averageBytesColumn].value(*row),
+				table.columns[p99BytesColumn].value(*row),
+			}
+			if fmt.Sprint(columns) != fmt.Sprint(tc.expectedColumns) {
+				t.Fatalf("Expecting columns %v, got %v", tc.expectedColumns, columns)
+			}
+		})
+	}
+
+	t.Run("Bounds the sizes recorded", func(t *testing.T) {
+		table := newTopTable()
+		for i := uint64(1); i <= 3*responseSizeSamples; i++ {
+			table.insert(ended(i, "/books", 100))
+		}
+		row := table.rows[0]
+		if len(row.responseBytes) != responseSizeSamples {
+			t.Fatalf("Expecting %d sizes recorded, got %d", responseSizeSamples, len(row.responseBytes))
+		}
+		if average, p99 := row.averageBytes(), row.p99Bytes(); average != 100 || p99 != 100 {
+			t.Fatalf("Expecting an average and p99 of 100 bytes, got %d and %d", average, p99)
+		}
+	})
+}
+
+func TestTopTableMinRequests(t *testing.T) {
+	ended := func(stream uint64, path string) topRequest {
+		event := tapTestRequest(stream, pb.HttpMethod_GET, path)
+		event.SourceMeta = &pb.TapEvent_EndpointMeta{Labels: map[string]string{"pod": "web"}}
+		return topRequest{
+			event:   event,
+			reqInit: event.GetHttp().GetRequestInit(),
+			rspInit: tapTestResponse(stream, http.StatusOK, &duration.Duration{Nanos: 1000}).GetHttp().GetResponseInit(),
+			rspEnd:  tapTestEnd(stream, &pb.Eos{}, 10).GetHttp().GetResponseEnd(),
+		}
+	}
+
+	table := newTopTable()
+	stream := uint64(0)
+	for path, count := range map[string]int{"/books": 5, "/authors": 3, "/shelves": 2, "/genres": 1} {
+		for i := 0; i < count; i++ {
+			stream++
+			table.insert(ended(stream, path))
+		}
+	}
+	rowCounts := func(rows []tableRow) string {
+		var counts []string
+		for _, row := range rows {
+			counts = append(counts, fmt.Sprintf("%s:%d", row.path, row.count))
+		}
+		return fmt.Sprint(counts)
+	}
+
+	t.Run("Displays all rows by default", func(t *testing.T) {
+		expected := "[/books:5 /authors:3 /shelves:2 /genres:1]"
+		if counts := rowCounts(table.displayedRows()); counts != expected {
+			t.Fatalf("Expecting rows %s, got %s", expected, counts)
+		}
+	})
+
+	t.Run("Folds the rows below the threshold into (other)", func(t *testing.T) {
+		table.minRequests = 3
+		rows := table.displayedRows()
+		expected := "[/books:5 /authors:3 (other):3]"
+		if counts := rowCounts(rows); counts != expected {
+			t.Fatalf("Expecting rows %s, got %s", expected, counts)
+		}
+		other := rows[len(rows)-1]
+		if other.source != "(other)" || other.destination != "(other)" || other.responses != 3 || other.totalBytes != 30 {
+			t.Fatalf("Expecting the (other) row to account for the folded rows, got %+v", other)
+		}
+		if len(table.rows) != 4 {
+			t.Fatalf("Expecting the rows of the table to be kept, got %d", len(table.rows))
+		}
+	})
+}
@@ -19,10 +19,10 @@ const DefaultWeight = 1
 //
 // If Ipv6, the bytes should be ordered big-endian. When formatted as a
 // string, the IP address should be enclosed in square brackets followed by
-// the port.
+// the port. IPv4-mapped IPv6 addresses are formatted as IPv4 addresses.
 func PublicAddressToString(addr *public.TcpAddress) string {
 	var s string
-	if addr.GetIp().GetIpv6() != nil {
+	if ip := publicIP(addr.GetIp()); len(ip) == net.IPv6len && ip.To4() == nil {
 		s = "[%s]:%d"
 	} else {
 		s = "%s:%d"
@@ -30,8 +30,17 @@ func PublicAddressToString(addr *public.TcpAddress) string {
 	return fmt.Sprintf(s, PublicIPToString(addr.GetIp()), addr.GetPort())
 }
 
-// PublicIPToString formats a Public API IPAddress as a string.
+// PublicIPToString formats a Public API IPAddress as a string. IPv4-mapped
+// IPv6 addresses, such as ::ffff:10.0.0.1, are formatted in their IPv4 form.
 func PublicIPToString(ip *public.IPAddress) string {
+	b := publicIP(ip)
+	if v4 := b.To4(); v4 != nil {
+		b = v4
+	}
+	return b.String()
+}
+
+func publicIP(ip *public.IPAddress) net.IP {
 	var b []byte
 	if ip.GetIpv6() != nil {
 		b = make([]byte, 16)
@@ -41,7 +50,7 @@ func PublicIPToString(ip *public.IPAddress) string {
 		b = make([]byte, 4)
 		binary.BigEndian.PutUint32(b, ip.GetIpv4())
 	}
-	return net.IP(b).String()
+	return net.IP(b)
 }
 
 // ProxyAddressToString formats a Proxy API TCPAddress as a string.
@@ -67,3 +67,36 @@ func TestNetToPublic(t *testing.T) {
 		})
 	}
 }
+
+func TestPublicAddressToString(t *testing.T) {
+	ipv6 := func(first, last uint64) *public.IPAddress {
+		return &public.IPAddress{
+			Ip: &public.IPAddress_Ipv6{
+				Ipv6: &public.IPv6{First: first, Last: last},
+			},
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		ip       *public.IPAddress
+		expected string
+	}{
+		{"IPv4", PublicIPV4(10, 0, 0, 1), "10.0.0.1:8080"},
+		// ::ffff:10.0.0.1
+		{"IPv4-mapped IPv6", ipv6(0, 0xffff0a000001), "10.0.0.1:8080"},
+		// 2001:db8::1
+		{"IPv6", ipv6(0x20010db800000000, 1), "[2001:db8::1]:8080"},
+		// ::10.0.0.1 isn't mapped, it's a deprecated IPv4-compatible address
+		{"IPv4-compatible IPv6", ipv6(0, 0x0a000001), "[::a00:1]:8080"},
+	}
+	for _, tc := range testCases {
+		tc := tc // pin
+		t.Run(tc.name, func(t *testing.T) {
+			addr := &public.TcpAddress{Ip: tc.ip, Port: 8080}
+			if s := PublicAddressToString(addr); s != tc.expected {
+				t.Fatalf("Expecting %s, got %s", tc.expected, s)
+			}
+		})
+	}
+}
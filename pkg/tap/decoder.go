@@ -0,0 +1,41 @@
+package tap
+
+import (
+	"bufio"
+	"io"
+
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"github.com/linkerd/linkerd2/pkg/protohttp"
+)
+
+// Decoder reads TapEvents from a byte stream, such as the one returned by
+// Reader.
+type Decoder struct {
+	reader *bufio.Reader
+}
+
+// NewDecoder returns a Decoder reading from r. If r is already a
+// *bufio.Reader, it is used as-is.
+func NewDecoder(r io.Reader) *Decoder {
+	reader, ok := r.(*bufio.Reader)
+	if !ok {
+		reader = bufio.NewReader(r)
+	}
+	return &Decoder{reader: reader}
+}
+
+// Decode reads the next TapEvent from the stream. It returns io.EOF once the
+// stream ends cleanly between two events; a stream ending partway through an
+// event results in a different error.
+func (d *Decoder) Decode() (*pb.TapEvent, error) {
+	if _, err := d.reader.Peek(1); err != nil {
+		return nil, err
+	}
+
+	event := pb.TapEvent{}
+	err := protohttp.FromByteStreamToProtocolBuffers(d.reader, &event)
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
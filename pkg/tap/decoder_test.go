@@ -0,0 +1,109 @@
+package tap
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"github.com/linkerd/linkerd2/pkg/protohttp"
+)
+
+func encodeEvents(t *testing.T, events ...*pb.TapEvent) []byte {
+	rec := httptest.NewRecorder()
+	for _, event := range events {
+		err := protohttp.WriteProtoToHTTPResponse(rec, event)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	return rec.Body.Bytes()
+}
+
+func TestDecoder(t *testing.T) {
+	events := []*pb.TapEvent{
+		{ProxyDirection: pb.TapEvent_INBOUND},
+		{ProxyDirection: pb.TapEvent_OUTBOUND},
+		{
+			ProxyDirection: pb.TapEvent_OUTBOUND,
+			Event: &pb.TapEvent_Http_{
+				Http: &pb.TapEvent_Http{
+					Event: &pb.TapEvent_Http_RequestInit_{
+						RequestInit: &pb.TapEvent_Http_RequestInit{
+							Id:        &pb.TapEvent_Http_StreamId{Base: 1, Stream: 2},
+							Authority: "books.default:7000",
+							Path:      "/books",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	t.Run("Decodes a multi-event stream", func(t *testing.T) {
+		decoder := NewDecoder(bytes.NewReader(encodeEvents(t, events...)))
+		for i, expected := range events {
+			event, err := decoder.Decode()
+			if err != nil {
+				t.Fatalf("Unexpected error decoding event %d: %v", i, err)
+			}
+			if !proto.Equal(event, expected) {
+				t.Fatalf("Expecting event %d to be [%v], got [%v]", i, expected, event)
+			}
+		}
+		if _, err := decoder.Decode(); err != io.EOF {
+			t.Fatalf("Expecting io.EOF, got [%v]", err)
+		}
+	})
+
+	t.Run("Returns io.EOF for an empty stream", func(t *testing.T) {
+		decoder := NewDecoder(bytes.NewReader(nil))
+		if _, err := decoder.Decode(); err != io.EOF {
+			t.Fatalf("Expecting io.EOF, got [%v]", err)
+		}
+	})
+
+	t.Run("Returns an error for a truncated stream", func(t *testing.T) {
+		stream := encodeEvents(t, events...)
+		decoder := NewDecoder(bytes.NewReader(stream[:len(stream)-1]))
+		for i := 0; i < len(events)-1; i++ {
+			if _, err := decoder.Decode(); err != nil {
+				t.Fatalf("Unexpected error decoding event %d: %v", i, err)
+			}
+		}
+		_, err := decoder.Decode()
+		if err == nil || err == io.EOF {
+			t.Fatalf("Expecting a decoding error, got [%v]", err)
+		}
+	})
+
+	t.Run("Decodes events larger than the read buffer", func(t *testing.T) {
+		// bufio.Reader's default buffer is 4096 bytes; frames are read with
+		// io.ReadFull, so they aren't bounded by it.
+		large := &pb.TapEvent{
+			Event: &pb.TapEvent_Http_{
+				Http: &pb.TapEvent_Http{
+					Event: &pb.TapEvent_Http_RequestInit_{
+						RequestInit: &pb.TapEvent_Http_RequestInit{
+							Path: "/" + strings.Repeat("a", 64*1024),
+						},
+					},
+				},
+			},
+		}
+		decoder := NewDecoder(bufio.NewReader(bytes.NewReader(encodeEvents(t, large, events[0]))))
+		for i, expected := range []*pb.TapEvent{large, events[0]} {
+			event, err := decoder.Decode()
+			if err != nil {
+				t.Fatalf("Unexpected error decoding event %d: %v", i, err)
+			}
+			if !proto.Equal(event, expected) {
+				t.Fatalf("Expecting event %d to be decoded as is", i)
+			}
+		}
+	})
+}
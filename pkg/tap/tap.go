@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/golang/protobuf/proto"
@@ -28,12 +29,28 @@ func Reader(k8sAPI *k8s.KubernetesAPI, req *pb.TapByResourceRequest, timeout tim
 	}
 	client.Timeout = timeout
 
+	return reader(client, k8sAPI.Host, req)
+}
+
+// AddressReader initiates a TapByResourceRequest directly against the tap
+// APIService at address, bypassing the Kubernetes API, e.g. through
+// `kubectl proxy` or a port-forward to an endpoint that authenticates tap
+// requests. address is a URL, or a host:port reached over plain HTTP. It is
+// the caller's responsibility to call Close() on the io.ReadCloser.
+func AddressReader(address string, req *pb.TapByResourceRequest, timeout time.Duration) (*bufio.Reader, io.ReadCloser, error) {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	return reader(&http.Client{Timeout: timeout}, address, req)
+}
+
+func reader(client *http.Client, host string, req *pb.TapByResourceRequest) (*bufio.Reader, io.ReadCloser, error) {
 	reqBytes, err := proto.Marshal(req)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	url, err := url.Parse(k8sAPI.Host)
+	url, err := url.Parse(host)
 	if err != nil {
 		return nil, nil, err
 	}